@@ -7,20 +7,39 @@ import (
 	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
 )
 
+const (
+	// AudioTypeVol volcengine websocket asr
+	AudioTypeVol = "vol"
+	// AudioTypeWhisper server which serves openai /v1/audio/transcriptions, such as whisper.cpp and faster-whisper
+	AudioTypeWhisper = "whisper"
+)
+
 var (
+	AudioType    *string
 	AudioAppID   *string
 	AudioToken   *string
 	AudioCluster *string
+
+	WhisperUrl   *string
+	WhisperToken *string
+	WhisperModel *string
 )
 
 func InitAudioConf() {
+	AudioType = flag.String("audio_type", "", "speech-to-text backend: vol or whisper, it's chosen by credential if empty")
 	AudioAppID = flag.String("audio_app_id", "", "audio app id")
 	AudioToken = flag.String("audio_token", "", "audio token")
 	AudioCluster = flag.String("audio_cluster", "", "audio cluster")
 
+	WhisperUrl = flag.String("whisper_url", "", "base url of whisper server, e.g. http://127.0.0.1:8000/v1")
+	WhisperToken = flag.String("whisper_token", "", "token of whisper server")
+	WhisperModel = flag.String("whisper_model", "whisper-1", "model of whisper server")
 }
 
 func EnvAudioConf() {
+	if os.Getenv("AUDIO_TYPE") != "" {
+		*AudioType = os.Getenv("AUDIO_TYPE")
+	}
 	if os.Getenv("AUDIO_APP_ID") != "" {
 		*AudioAppID = os.Getenv("AUDIO_APP_ID")
 	}
@@ -30,8 +49,35 @@ func EnvAudioConf() {
 	if os.Getenv("AUDIO_CLUSTER") != "" {
 		*AudioCluster = os.Getenv("AUDIO_CLUSTER")
 	}
+	if os.Getenv("WHISPER_URL") != "" {
+		*WhisperUrl = os.Getenv("WHISPER_URL")
+	}
+	if os.Getenv("WHISPER_TOKEN") != "" {
+		*WhisperToken = os.Getenv("WHISPER_TOKEN")
+	}
+	if os.Getenv("WHISPER_MODEL") != "" {
+		*WhisperModel = os.Getenv("WHISPER_MODEL")
+	}
 
+	logger.Info("AUDIO_CONF", "AUDIO_TYPE", *AudioType)
 	logger.Info("AUDIO_CONF", "AUDIO_APP_ID", *AudioAppID)
 	logger.Info("AUDIO_CONF", "AUDIO_TOKEN", *AudioToken)
 	logger.Info("AUDIO_CONF", "AUDIO_CLUSTER", *AudioCluster)
+	logger.Info("AUDIO_CONF", "WHISPER_URL", *WhisperUrl)
+	logger.Info("AUDIO_CONF", "WHISPER_TOKEN", *WhisperToken)
+	logger.Info("AUDIO_CONF", "WHISPER_MODEL", *WhisperModel)
+}
+
+// GetAudioType get speech-to-text backend, it's volcengine if AUDIO_APP_ID is set, or whisper if WHISPER_URL is set.
+// empty means voice isn't recognized.
+func GetAudioType() string {
+	switch {
+	case *AudioType != "":
+		return *AudioType
+	case *AudioAppID != "":
+		return AudioTypeVol
+	case *WhisperUrl != "":
+		return AudioTypeWhisper
+	}
+	return ""
 }
@@ -0,0 +1,32 @@
+package conf
+
+import (
+	"flag"
+	"os"
+	"strconv"
+
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+)
+
+var (
+	ResponseCacheTTL        *int
+	ResponseCacheSimilarity *float64
+)
+
+func InitCacheConf() {
+	ResponseCacheTTL = flag.Int("response_cache_ttl", 0, "minutes answers of prompts without history are cached, 0 means no cache")
+	ResponseCacheSimilarity = flag.Float64("response_cache_similarity", 0.95, "min embedding similarity of cached prompt, used when embedding is configured, 0 means exact match only")
+}
+
+func EnvCacheConf() {
+	if os.Getenv("RESPONSE_CACHE_TTL") != "" {
+		*ResponseCacheTTL, _ = strconv.Atoi(os.Getenv("RESPONSE_CACHE_TTL"))
+	}
+
+	if os.Getenv("RESPONSE_CACHE_SIMILARITY") != "" {
+		*ResponseCacheSimilarity, _ = strconv.ParseFloat(os.Getenv("RESPONSE_CACHE_SIMILARITY"), 64)
+	}
+
+	logger.Info("CACHE_CONF", "ResponseCacheTTL", *ResponseCacheTTL)
+	logger.Info("CACHE_CONF", "ResponseCacheSimilarity", *ResponseCacheSimilarity)
+}
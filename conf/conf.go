@@ -8,6 +8,7 @@ import (
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+	"github.com/yincongcyincong/telegram-deepseek-bot/param"
 )
 
 var (
@@ -17,24 +18,28 @@ var (
 	GeminiToken     *string
 	OpenRouterToken *string
 	VolToken        *string
+	AnthropicToken  *string
 	ErnieAK         *string
 	ErnieSK         *string
 
-	Type          *string // simple complex
-	CustomUrl     *string
-	VolcAK        *string
-	VolcSK        *string
-	DBType        *string
-	DBConf        *string
-	DeepseekProxy *string
-	TelegramProxy *string
-	Lang          *string
-	TokenPerUser  *int
-	NeedATBOt     *bool
-	MaxUserChat   *int
-	VideoToken    *string
-	HTTPPort      *int
-	UseTools      *bool
+	Type               *string // simple complex
+	CustomUrl          *string
+	VolcAK             *string
+	VolcSK             *string
+	DBType             *string
+	DBConf             *string
+	DeepseekProxy      *string
+	TelegramProxy      *string
+	Lang               *string
+	TokenPerUser       *int
+	NeedATBOt          *bool
+	MaxUserChat        *int
+	SharedGroupHistory *bool
+	VideoToken         *string
+	HTTPPort           *int
+	UseTools           *bool
+	FallbackChain      *string
+	FallbackTimeout    *int
 
 	AllowedTelegramUserIds  = make(map[int64]bool)
 	AllowedTelegramGroupIds = make(map[int64]bool)
@@ -52,13 +57,16 @@ func InitConf() {
 	GeminiToken = flag.String("gemini_token", "", "gemini auth token")
 	OpenRouterToken = flag.String("openrouter_token", "", "openrouter.ai auth token")
 	VolToken = flag.String("vol_token", "", "vol auth token")
+	AnthropicToken = flag.String("anthropic_token", "", "anthropic auth token")
 	ErnieAK = flag.String("ernie_ak", "", "ernie ak")
 	ErnieSK = flag.String("ernie_sk", "", "ernie sk")
 	VolcAK = flag.String("volc_ak", "", "volc ak")
 	VolcSK = flag.String("volc_sk", "", "volc sk")
 
 	CustomUrl = flag.String("custom_url", "https://api.deepseek.com/", "deepseek custom url")
-	Type = flag.String("type", "deepseek", "llm type: deepseek gemini openai openrouter")
+	Type = flag.String("type", "deepseek", "llm type: deepseek gemini openai openrouter vol ollama anthropic")
+	FallbackChain = flag.String("fallback_chain", "", "llm used in order when request fails, e.g. deepseek:deepseek-chat->openrouter:deepseek/deepseek-chat->openai:gpt-4o-mini")
+	FallbackTimeout = flag.Int("fallback_timeout", 60, "seconds waiting for first token before falling back to next llm, 0 means no limit")
 	DBType = flag.String("db_type", "sqlite3", "db type")
 	DBConf = flag.String("db_conf", "./data/telegram_bot.db", "db conf")
 	DeepseekProxy = flag.String("deepseek_proxy", "", "db conf")
@@ -67,6 +75,7 @@ func InitConf() {
 	TokenPerUser = flag.Int("token_per_user", 10000, "token per user")
 	NeedATBOt = flag.Bool("need_at_bot", false, "need at bot")
 	MaxUserChat = flag.Int("max_user_chat", 2, "max chat per user")
+	SharedGroupHistory = flag.Bool("shared_group_history", false, "group members share one conversation history")
 	VideoToken = flag.String("video_token", "", "video token")
 	HTTPPort = flag.Int("http_port", 36060, "http server port")
 	UseTools = flag.Bool("use_tools", true, "use tools")
@@ -79,8 +88,14 @@ func InitConf() {
 	InitPhotoConf()
 	InitVideoConf()
 	InitAudioConf()
+	InitTTSConf()
 	InitToolsConf()
 	InitRagConf()
+	InitOpenAICompatibleConf()
+	InitOllamaConf()
+	InitModelConf()
+	InitPriceConf()
+	InitCacheConf()
 	flag.Parse()
 
 	if os.Getenv("TELEGRAM_BOT_TOKEN") != "" {
@@ -98,6 +113,18 @@ func InitConf() {
 	if os.Getenv("TYPE") != "" {
 		*Type = os.Getenv("TYPE")
 	}
+	// deepseek-ollama is old name of ollama
+	if *Type == param.DeepSeekLlava {
+		*Type = param.Ollama
+	}
+
+	if os.Getenv("FALLBACK_CHAIN") != "" {
+		*FallbackChain = os.Getenv("FALLBACK_CHAIN")
+	}
+
+	if os.Getenv("FALLBACK_TIMEOUT") != "" {
+		*FallbackTimeout, _ = strconv.Atoi(os.Getenv("FALLBACK_TIMEOUT"))
+	}
 
 	if os.Getenv("VOLC_AK") != "" {
 		*VolcAK = os.Getenv("VOLC_AK")
@@ -151,6 +178,10 @@ func InitConf() {
 		*MaxUserChat, _ = strconv.Atoi(os.Getenv("MAX_USER_CHAT"))
 	}
 
+	if os.Getenv("SHARED_GROUP_HISTORY") != "" {
+		*SharedGroupHistory, _ = strconv.ParseBool(os.Getenv("SHARED_GROUP_HISTORY"))
+	}
+
 	if os.Getenv("VIDEO_TOKEN") != "" {
 		*VideoToken = os.Getenv("VIDEO_TOKEN")
 	}
@@ -175,6 +206,10 @@ func InitConf() {
 		*VolToken = os.Getenv("VOL_TOKEN")
 	}
 
+	if os.Getenv("ANTHROPIC_TOKEN") != "" {
+		*AnthropicToken = os.Getenv("ANTHROPIC_TOKEN")
+	}
+
 	if os.Getenv("ERNIE_AK") != "" {
 		*ErnieAK = os.Getenv("ERNIE_AK")
 	}
@@ -218,6 +253,8 @@ func InitConf() {
 	logger.Info("CONF", "DeepseekToken", *DeepseekToken)
 	logger.Info("CONF", "CustomUrl", *CustomUrl)
 	logger.Info("CONF", "Type", *Type)
+	logger.Info("CONF", "FallbackChain", *FallbackChain)
+	logger.Info("CONF", "FallbackTimeout", *FallbackTimeout)
 	logger.Info("CONF", "VolcAK", *VolcAK)
 	logger.Info("CONF", "VolcSK", *VolcSK)
 	logger.Info("CONF", "DBType", *DBType)
@@ -231,6 +268,7 @@ func InitConf() {
 	logger.Info("CONF", "AdminUserIds", *adminUserIds)
 	logger.Info("CONF", "NeedATBOt", *NeedATBOt)
 	logger.Info("CONF", "MaxUserChat", *MaxUserChat)
+	logger.Info("CONF", "SharedGroupHistory", *SharedGroupHistory)
 	logger.Info("CONF", "VideoToken", *VideoToken)
 	logger.Info("CONF", "HTTPPort", *HTTPPort)
 	logger.Info("CONF", "OpenAIToken", *OpenAIToken)
@@ -239,13 +277,20 @@ func InitConf() {
 	logger.Info("CONF", "ErnieAK", *ErnieAK)
 	logger.Info("CONF", "ErnieSK", *ErnieSK)
 	logger.Info("CONF", "VolToken", *VolToken)
+	logger.Info("CONF", "AnthropicToken", *AnthropicToken)
 
 	EnvAudioConf()
+	EnvTTSConf()
 	EnvRagConf()
 	EnvDeepseekConf()
 	EnvPhotoConf()
 	EnvToolsConf()
 	EnvVideoConf()
+	EnvOpenAICompatibleConf()
+	EnvOllamaConf()
+	EnvModelConf()
+	EnvPriceConf()
+	EnvCacheConf()
 
 	if *BotToken == "" {
 		panic("Bot token and llm token are required")
@@ -2,6 +2,7 @@ package conf
 
 import (
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -9,8 +10,16 @@ func TestInitConf_AllEnvVars(t *testing.T) {
 	// 准备环境变量
 	os.Setenv("TELEGRAM_BOT_TOKEN", "test_bot_token")
 	os.Setenv("DEEPSEEK_TOKEN", "test_deepseek_token")
+	os.Setenv("ANTHROPIC_TOKEN", "test_anthropic_token")
 	os.Setenv("CUSTOM_URL", "https://example.com")
 	os.Setenv("TYPE", "pro")
+	os.Setenv("FALLBACK_CHAIN", "deepseek:deepseek-chat->openai:gpt-4o-mini")
+	os.Setenv("FALLBACK_TIMEOUT", "30")
+	os.Setenv("MODEL_REFRESH_INTERVAL", "60")
+	os.Setenv("MODEL_ALLOW_LIST", "openai:gpt-4o*, gemini:gemini-2.5-pro")
+	os.Setenv("MODEL_DENY_LIST", "*preview*")
+	os.Setenv("RESPONSE_CACHE_TTL", "120")
+	os.Setenv("RESPONSE_CACHE_SIMILARITY", "0.9")
 	os.Setenv("VOLC_AK", "volc-ak")
 	os.Setenv("VOLC_SK", "volc-sk")
 	os.Setenv("DB_TYPE", "mysql")
@@ -24,6 +33,7 @@ func TestInitConf_AllEnvVars(t *testing.T) {
 	os.Setenv("ADMIN_USER_IDS", "9999,8888")
 	os.Setenv("NEED_AT_BOT", "true")
 	os.Setenv("MAX_USER_CHAT", "10")
+	os.Setenv("SHARED_GROUP_HISTORY", "true")
 	os.Setenv("VIDEO_TOKEN", "video_token_abc")
 	os.Setenv("HTTP_PORT", "8888")
 	os.Setenv("USE_TOOLS", "false")
@@ -35,6 +45,10 @@ func TestInitConf_AllEnvVars(t *testing.T) {
 	os.Setenv("AUDIO_APP_ID", "test-audio-app-id")
 	os.Setenv("AUDIO_TOKEN", "test-audio-token")
 	os.Setenv("AUDIO_CLUSTER", "test-cluster")
+	os.Setenv("WHISPER_URL", "http://127.0.0.1:8000/v1")
+	os.Setenv("TTS_URL", "http://127.0.0.1:8880/v1")
+	os.Setenv("TTS_VOICE", "nova")
+	os.Setenv("TTS_SPEED", "1.25")
 
 	os.Setenv("TELEGRAM_BOT_TOKEN", "test_bot_token")
 	os.Setenv("DEEPSEEK_TOKEN", "test_deepseek_token")
@@ -46,6 +60,9 @@ func TestInitConf_AllEnvVars(t *testing.T) {
 	os.Setenv("STOP", "stop-sequence")
 	os.Setenv("LOG_PROBS", "true")
 	os.Setenv("TOP_LOG_PROBS", "5")
+	os.Setenv("SYSTEM_PROMPT", "You are a helpful assistant.")
+	os.Setenv("CONTEXT_LIMIT", "8192")
+	os.Setenv("MODEL_CONTEXT_LIMITS", "gpt-4o:128000,llava:latest:4096")
 
 	os.Setenv("TELEGRAM_BOT_TOKEN", "test_bot_token")
 	os.Setenv("DEEPSEEK_TOKEN", "test_deepseek_token")
@@ -65,6 +82,9 @@ func TestInitConf_AllEnvVars(t *testing.T) {
 	os.Setenv("Language", "1")
 	os.Setenv("Opacity", "0.75")
 	os.Setenv("LogoTextContent", "Test Logo")
+	os.Setenv("IMAGE_TYPE", "openai")
+	os.Setenv("MAX_IMAGE_NUM", "6")
+	os.Setenv("SD_URL", "http://127.0.0.1:7860")
 
 	os.Setenv("TELEGRAM_BOT_TOKEN", "test_bot_token")
 	os.Setenv("DEEPSEEK_TOKEN", "test_deepseek_token")
@@ -93,8 +113,17 @@ func TestInitConf_AllEnvVars(t *testing.T) {
 	// 断言检查
 	assertEqual(t, *BotToken, "test_bot_token", "BotToken")
 	assertEqual(t, *DeepseekToken, "test_deepseek_token", "DeepseekToken")
+	assertEqual(t, *AnthropicToken, "test_anthropic_token", "AnthropicToken")
 	assertEqual(t, *CustomUrl, "https://example.com", "CustomUrl")
 	assertEqual(t, *Type, "pro", "Type")
+	assertEqual(t, *FallbackChain, "deepseek:deepseek-chat->openai:gpt-4o-mini", "FallbackChain")
+	assertInt(t, *FallbackTimeout, 30, "FallbackTimeout")
+	assertInt(t, *ModelRefreshInterval, 60, "ModelRefreshInterval")
+	assertInt(t, len(ModelAllowList), 2, "ModelAllowList")
+	assertEqual(t, ModelAllowList[1], "gemini:gemini-2.5-pro", "ModelAllowList")
+	assertEqual(t, ModelDenyList[0], "*preview*", "ModelDenyList")
+	assertInt(t, *ResponseCacheTTL, 120, "ResponseCacheTTL")
+	assertBool(t, *ResponseCacheSimilarity == 0.9, true, "ResponseCacheSimilarity")
 	assertEqual(t, *VolcAK, "volc-ak", "VolcAK")
 	assertEqual(t, *VolcSK, "volc-sk", "VolcSK")
 	assertEqual(t, *DBType, "mysql", "DBType")
@@ -105,6 +134,7 @@ func TestInitConf_AllEnvVars(t *testing.T) {
 	assertInt(t, *TokenPerUser, 888, "TokenPerUser")
 	assertBool(t, *NeedATBOt, true, "NeedATBOt")
 	assertInt(t, *MaxUserChat, 10, "MaxUserChat")
+	assertBool(t, *SharedGroupHistory, true, "SharedGroupHistory")
 	assertEqual(t, *VideoToken, "video_token_abc", "VideoToken")
 	assertInt(t, *HTTPPort, 8888, "HTTPPort")
 	assertBool(t, *UseTools, false, "UseTools")
@@ -116,6 +146,14 @@ func TestInitConf_AllEnvVars(t *testing.T) {
 	assertEqual(t, *AudioAppID, "test-audio-app-id", "AudioAppID")
 	assertEqual(t, *AudioToken, "test-audio-token", "AudioToken")
 	assertEqual(t, *AudioCluster, "test-cluster", "AudioCluster")
+	assertEqual(t, *WhisperUrl, "http://127.0.0.1:8000/v1", "WhisperUrl")
+	assertEqual(t, *WhisperModel, "whisper-1", "WhisperModel")
+	assertEqual(t, GetAudioType(), AudioTypeVol, "GetAudioType")
+
+	assertEqual(t, *TTSUrl, "http://127.0.0.1:8880/v1", "TTSUrl")
+	assertEqual(t, *TTSModel, "tts-1", "TTSModel")
+	assertEqual(t, *TTSVoice, "nova", "TTSVoice")
+	assertFloatEqual(t, *TTSSpeed, 1.25, "TTSSpeed")
 
 	assertFloatEqual(t, *FrequencyPenalty, 0.5, "FrequencyPenalty")
 	assertInt(t, *MaxTokens, 2048, "MaxTokens")
@@ -124,6 +162,10 @@ func TestInitConf_AllEnvVars(t *testing.T) {
 	assertFloatEqual(t, *TopP, 0.8, "TopP")
 	assertBool(t, *LogProbs, true, "LogProbs")
 	assertInt(t, *TopLogProbs, 5, "TopLogProbs")
+	assertEqual(t, *SystemPrompt, "You are a helpful assistant.", "SystemPrompt")
+	assertInt(t, *ContextLimit, 8192, "ContextLimit")
+	assertInt(t, ModelContextLimits["gpt-4o"], 128000, "ModelContextLimits gpt-4o")
+	assertInt(t, ModelContextLimits["llava:latest"], 4096, "ModelContextLimits llava:latest")
 
 	assertEqual(t, *ReqKey, "test-req-key", "ReqKey")
 	assertEqual(t, *ModelVersion, "v2.1", "ModelVersion")
@@ -141,6 +183,12 @@ func TestInitConf_AllEnvVars(t *testing.T) {
 	assertInt(t, *Language, 1, "Language")
 	assertFloatEqual(t, *Opacity, 0.75, "Opacity")
 	assertEqual(t, *LogoTextContent, "Test Logo", "LogoTextContent")
+	assertEqual(t, *ImageType, "openai", "ImageType")
+	assertInt(t, *ImageNum, 1, "ImageNum")
+	assertInt(t, *MaxImageNum, 6, "MaxImageNum")
+	assertEqual(t, *OpenAIImageModel, "dall-e-3", "OpenAIImageModel")
+	assertEqual(t, *SDUrl, "http://127.0.0.1:7860", "SDUrl")
+	assertEqual(t, strings.Join(GetImageTypes(), ","), "openai,vol,gemini,sd", "GetImageTypes")
 
 	assertEqual(t, *EmbeddingType, "openai", "EmbeddingType")
 	assertEqual(t, *KnowledgePath, "/data/knowledge", "KnowledgePath")
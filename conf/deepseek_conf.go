@@ -18,8 +18,14 @@ var (
 	Stop             []string
 	LogProbs         *bool
 	TopLogProbs      *int
+	SystemPrompt     *string
+	ContextLimit     *int
 
-	stop *string
+	// ModelContextLimits context window of each model, model not in map uses ContextLimit or default of provider
+	ModelContextLimits = make(map[string]int)
+
+	stop               *string
+	modelContextLimits *string
 )
 
 func InitDeepseekConf() {
@@ -30,6 +36,9 @@ func InitDeepseekConf() {
 	TopP = flag.Float64("top_p", 0.9, "top p")
 	LogProbs = flag.Bool("log_probs", false, "log probs")
 	TopLogProbs = flag.Int("top_log_probs", 0, "number of top log probs to return")
+	SystemPrompt = flag.String("system_prompt", "", "default system prompt, used when no persona is selected")
+	ContextLimit = flag.Int("context_limit", 0, "default context window tokens of model, 0 means default of provider")
+	modelContextLimits = flag.String("model_context_limits", "", "context window tokens of each model, e.g. gpt-4o:128000,deepseek-chat:64000")
 
 	stop = flag.String("stop", "", "stop sequence")
 }
@@ -67,6 +76,32 @@ func EnvDeepseekConf() {
 		*TopLogProbs, _ = strconv.Atoi(os.Getenv("TOP_LOG_PROBS"))
 	}
 
+	if os.Getenv("SYSTEM_PROMPT") != "" {
+		*SystemPrompt = os.Getenv("SYSTEM_PROMPT")
+	}
+
+	if os.Getenv("CONTEXT_LIMIT") != "" {
+		*ContextLimit, _ = strconv.Atoi(os.Getenv("CONTEXT_LIMIT"))
+	}
+
+	if os.Getenv("MODEL_CONTEXT_LIMITS") != "" {
+		*modelContextLimits = os.Getenv("MODEL_CONTEXT_LIMITS")
+	}
+
+	for _, s := range strings.Split(*modelContextLimits, ",") {
+		// model name may contain ':', such as llava:latest
+		idx := strings.LastIndex(s, ":")
+		if idx <= 0 {
+			continue
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(s[idx+1:]))
+		if err != nil {
+			logger.Warn("parse model context limit fail", "limit", s, "err", err)
+			continue
+		}
+		ModelContextLimits[strings.TrimSpace(s[:idx])] = limit
+	}
+
 	for _, s := range strings.Split(*stop, ",") {
 		if s != "" {
 			Stop = append(Stop, s)
@@ -82,4 +117,7 @@ func EnvDeepseekConf() {
 	logger.Info("DEEPSEEK_CONF", "Stop", *stop)
 	logger.Info("DEEPSEEK_CONF", "LogProbs", *LogProbs)
 	logger.Info("DEEPSEEK_CONF", "TopLogProbs", *TopLogProbs)
+	logger.Info("DEEPSEEK_CONF", "SystemPrompt", *SystemPrompt)
+	logger.Info("DEEPSEEK_CONF", "ContextLimit", *ContextLimit)
+	logger.Info("DEEPSEEK_CONF", "ModelContextLimits", *modelContextLimits)
 }
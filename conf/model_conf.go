@@ -0,0 +1,60 @@
+package conf
+
+import (
+	"flag"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+)
+
+var (
+	ModelRefreshInterval *int
+
+	// ModelAllowList entries like openai:gpt-4o*, only these models of the type can be chosen
+	ModelAllowList []string
+	// ModelDenyList entries like openai:*audio* or *preview*, these models can't be chosen
+	ModelDenyList []string
+
+	modelAllowList *string
+	modelDenyList  *string
+)
+
+func InitModelConf() {
+	ModelRefreshInterval = flag.Int("model_refresh_interval", 360, "minutes between refreshing model lists of llm, 0 means only refresh when bot starts")
+	modelAllowList = flag.String("model_allow_list", "", "models can be chosen, split by comma, e.g. openai:gpt-4o*,gemini:gemini-2.5*")
+	modelDenyList = flag.String("model_deny_list", "", "models can't be chosen, split by comma, e.g. *preview*,openai:*audio*")
+}
+
+func EnvModelConf() {
+	if os.Getenv("MODEL_REFRESH_INTERVAL") != "" {
+		*ModelRefreshInterval, _ = strconv.Atoi(os.Getenv("MODEL_REFRESH_INTERVAL"))
+	}
+
+	if os.Getenv("MODEL_ALLOW_LIST") != "" {
+		*modelAllowList = os.Getenv("MODEL_ALLOW_LIST")
+	}
+
+	if os.Getenv("MODEL_DENY_LIST") != "" {
+		*modelDenyList = os.Getenv("MODEL_DENY_LIST")
+	}
+
+	ModelAllowList = splitModelList(*modelAllowList)
+	ModelDenyList = splitModelList(*modelDenyList)
+
+	logger.Info("MODEL_CONF", "ModelRefreshInterval", *ModelRefreshInterval)
+	logger.Info("MODEL_CONF", "ModelAllowList", *modelAllowList)
+	logger.Info("MODEL_CONF", "ModelDenyList", *modelDenyList)
+}
+
+func splitModelList(list string) []string {
+	res := make([]string, 0)
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s != "" {
+			res = append(res, s)
+		}
+	}
+	return res
+}
@@ -0,0 +1,46 @@
+package conf
+
+import (
+	"flag"
+	"os"
+	"strconv"
+
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+)
+
+var (
+	OllamaHost      *string
+	OllamaModel     *string
+	OllamaKeepAlive *string
+	OllamaNumCtx    *int
+)
+
+func InitOllamaConf() {
+	OllamaHost = flag.String("ollama_host", "", "ollama host, e.g. http://127.0.0.1:11434")
+	OllamaModel = flag.String("ollama_model", "llava:latest", "default ollama model, used when user doesn't choose one")
+	OllamaKeepAlive = flag.String("ollama_keep_alive", "", "how long model stays loaded after request, e.g. 5m, negative value keeps it loaded")
+	OllamaNumCtx = flag.Int("ollama_num_ctx", 0, "context window tokens of ollama model, 0 means default of model")
+}
+
+func EnvOllamaConf() {
+	if os.Getenv("OLLAMA_HOST") != "" {
+		*OllamaHost = os.Getenv("OLLAMA_HOST")
+	}
+
+	if os.Getenv("OLLAMA_MODEL") != "" {
+		*OllamaModel = os.Getenv("OLLAMA_MODEL")
+	}
+
+	if os.Getenv("OLLAMA_KEEP_ALIVE") != "" {
+		*OllamaKeepAlive = os.Getenv("OLLAMA_KEEP_ALIVE")
+	}
+
+	if os.Getenv("OLLAMA_NUM_CTX") != "" {
+		*OllamaNumCtx, _ = strconv.Atoi(os.Getenv("OLLAMA_NUM_CTX"))
+	}
+
+	logger.Info("OLLAMA_CONF", "OllamaHost", *OllamaHost)
+	logger.Info("OLLAMA_CONF", "OllamaModel", *OllamaModel)
+	logger.Info("OLLAMA_CONF", "OllamaKeepAlive", *OllamaKeepAlive)
+	logger.Info("OLLAMA_CONF", "OllamaNumCtx", *OllamaNumCtx)
+}
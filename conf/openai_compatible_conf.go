@@ -0,0 +1,115 @@
+package conf
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"slices"
+
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+	"github.com/yincongcyincong/telegram-deepseek-bot/param"
+)
+
+// OpenAICompatibleProfile endpoint which serves openai api, such as vLLM, LM Studio and llama.cpp server
+type OpenAICompatibleProfile struct {
+	Name     string            `json:"name"`
+	BaseUrl  string            `json:"base_url"`
+	Token    string            `json:"token"`
+	Models   []string          `json:"models"`
+	Headers  map[string]string `json:"headers"`
+	UseTools bool              `json:"use_tools"`
+	Vision   bool              `json:"vision"` // models accept image input
+}
+
+var (
+	OpenAICompatibleConfPath *string
+
+	// OpenAICompatibleProfiles profiles in order of conf file, name of profile is used as llm type
+	OpenAICompatibleProfiles = make([]*OpenAICompatibleProfile, 0)
+)
+
+func InitOpenAICompatibleConf() {
+	OpenAICompatibleConfPath = flag.String("openai_compatible_conf_path", "", "conf path of openai compatible endpoints")
+}
+
+func EnvOpenAICompatibleConf() {
+	if os.Getenv("OPENAI_COMPATIBLE_CONF_PATH") != "" {
+		*OpenAICompatibleConfPath = os.Getenv("OPENAI_COMPATIBLE_CONF_PATH")
+	}
+
+	logger.Info("OPENAI_COMPATIBLE_CONF", "OpenAICompatibleConfPath", *OpenAICompatibleConfPath)
+
+	if *OpenAICompatibleConfPath == "" {
+		return
+	}
+
+	data, err := os.ReadFile(*OpenAICompatibleConfPath)
+	if err != nil {
+		logger.Error("read openai compatible conf fail", "err", err)
+		return
+	}
+
+	profiles, err := ParseOpenAICompatibleProfiles(data)
+	if err != nil {
+		logger.Error("parse openai compatible conf fail", "err", err)
+		return
+	}
+	OpenAICompatibleProfiles = profiles
+
+	for _, profile := range OpenAICompatibleProfiles {
+		logger.Info("OPENAI_COMPATIBLE_CONF", "name", profile.Name, "baseUrl", profile.BaseUrl,
+			"models", profile.Models, "useTools", profile.UseTools, "vision", profile.Vision)
+	}
+}
+
+// ParseOpenAICompatibleProfiles parse profiles from json array, token and headers can refer to env like ${VLLM_TOKEN}.
+// profile without name, base url or models, or whose name is same as builtin llm type is skipped.
+func ParseOpenAICompatibleProfiles(data []byte) ([]*OpenAICompatibleProfile, error) {
+	profiles := make([]*OpenAICompatibleProfile, 0)
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, err
+	}
+
+	builtinTypes := []string{param.DeepSeek, param.DeepSeekLlava, param.Ollama, param.Gemini, param.OpenAi, param.OpenRouter, param.Vol, param.Anthropic}
+	names := make(map[string]bool)
+	res := make([]*OpenAICompatibleProfile, 0, len(profiles))
+	for _, profile := range profiles {
+		if profile.Name == "" || profile.BaseUrl == "" || len(profile.Models) == 0 {
+			logger.Warn("openai compatible profile needs name, base_url and models", "name", profile.Name)
+			continue
+		}
+		if slices.Contains(builtinTypes, profile.Name) || names[profile.Name] {
+			logger.Warn("openai compatible profile name is duplicated", "name", profile.Name)
+			continue
+		}
+		names[profile.Name] = true
+
+		profile.Token = os.ExpandEnv(profile.Token)
+		for k, v := range profile.Headers {
+			profile.Headers[k] = os.ExpandEnv(v)
+		}
+		res = append(res, profile)
+	}
+
+	return res, nil
+}
+
+// GetOpenAICompatibleProfile get profile by name, return nil if it doesn't exist
+func GetOpenAICompatibleProfile(name string) *OpenAICompatibleProfile {
+	for _, profile := range OpenAICompatibleProfiles {
+		if profile.Name == name {
+			return profile
+		}
+	}
+	return nil
+}
+
+// IsOpenAICompatibleModel check whether model belongs to any profile
+func IsOpenAICompatibleModel(model string) bool {
+	for _, profile := range OpenAICompatibleProfiles {
+		if slices.Contains(profile.Models, model) {
+			return true
+		}
+	}
+	return false
+}
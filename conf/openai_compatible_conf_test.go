@@ -0,0 +1,44 @@
+package conf
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseOpenAICompatibleProfiles(t *testing.T) {
+	os.Setenv("VLLM_TOKEN", "vllm-token")
+	data := []byte(`[
+		{"name": "vllm", "base_url": "http://127.0.0.1:8000/v1", "token": "${VLLM_TOKEN}", "models": ["qwen"],
+			"headers": {"X-Team": "bot"}, "use_tools": true},
+		{"name": "lmstudio", "base_url": "http://127.0.0.1:1234/v1", "models": ["llama"]},
+		{"name": "vllm", "base_url": "http://127.0.0.1:8001/v1", "models": ["qwen"]},
+		{"name": "openai", "base_url": "http://127.0.0.1:8002/v1", "models": ["gpt"]},
+		{"name": "empty", "base_url": "http://127.0.0.1:8003/v1"}
+	]`)
+
+	profiles, err := ParseOpenAICompatibleProfiles(data)
+	if err != nil {
+		t.Fatalf("parse profiles fail: %v", err)
+	}
+	if len(profiles) != 2 {
+		t.Fatalf("%s expected %d, got %d", "profiles number", 2, len(profiles))
+	}
+	assertEqual(t, profiles[0].Name, "vllm", "Name")
+	assertEqual(t, profiles[0].Token, "vllm-token", "Token")
+	assertEqual(t, profiles[0].Headers["X-Team"], "bot", "Headers")
+	assertBool(t, profiles[0].UseTools, true, "UseTools")
+	assertEqual(t, profiles[1].Name, "lmstudio", "Name")
+
+	OpenAICompatibleProfiles = profiles
+	defer func() {
+		OpenAICompatibleProfiles = nil
+	}()
+	assertBool(t, GetOpenAICompatibleProfile("lmstudio") != nil, true, "GetOpenAICompatibleProfile")
+	assertBool(t, GetOpenAICompatibleProfile("openai") == nil, true, "GetOpenAICompatibleProfile")
+	assertBool(t, IsOpenAICompatibleModel("llama"), true, "IsOpenAICompatibleModel")
+	assertBool(t, IsOpenAICompatibleModel("gpt"), false, "IsOpenAICompatibleModel")
+
+	if _, err = ParseOpenAICompatibleProfiles([]byte("{")); err == nil {
+		t.Errorf("Expected invalid json to fail")
+	}
+}
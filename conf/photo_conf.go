@@ -8,7 +8,29 @@ import (
 	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
 )
 
+const (
+	// ImageTypeVol volcengine visual CVProcess
+	ImageTypeVol = "vol"
+	// ImageTypeOpenAI openai images api
+	ImageTypeOpenAI = "openai"
+	// ImageTypeGemini gemini imagen
+	ImageTypeGemini = "gemini"
+	// ImageTypeSD stable diffusion webui /sdapi/v1/txt2img
+	ImageTypeSD = "sd"
+	// ImageTypeComfyUI comfyui workflow in api format
+	ImageTypeComfyUI = "comfyui"
+)
+
 var (
+	ImageType        *string
+	ImageNum         *int
+	MaxImageNum      *int
+	OpenAIImageModel *string
+	GeminiImageModel *string
+	SDUrl            *string
+	ComfyUIUrl       *string
+	ComfyUIWorkflow  *string
+
 	ReqKey          *string
 	ModelVersion    *string
 	ReqScheduleConf *string
@@ -28,6 +50,15 @@ var (
 )
 
 func InitPhotoConf() {
+	ImageType = flag.String("image_type", "", "default image backend: vol, openai, gemini, sd or comfyui, it's chosen by credential if empty")
+	ImageNum = flag.Int("image_num", 1, "default number of images of /photo")
+	MaxImageNum = flag.Int("max_image_num", 4, "max number of images of /photo")
+	OpenAIImageModel = flag.String("openai_image_model", "dall-e-3", "image model of openai")
+	GeminiImageModel = flag.String("gemini_image_model", "imagen-3.0-generate-002", "imagen model of gemini")
+	SDUrl = flag.String("sd_url", "", "url of stable diffusion webui, e.g. http://127.0.0.1:7860")
+	ComfyUIUrl = flag.String("comfyui_url", "", "url of comfyui, e.g. http://127.0.0.1:8188")
+	ComfyUIWorkflow = flag.String("comfyui_workflow", "", "path of comfyui workflow in api format")
+
 	ReqKey = flag.String("req_key", "high_aes_general_v21_L", "request key")
 	ModelVersion = flag.String("model_version", "general_v2.1_L", "model version")
 	ReqScheduleConf = flag.String("req_schedule_conf", "general_v20_9B_pe", "request schedule conf")
@@ -47,6 +78,38 @@ func InitPhotoConf() {
 }
 
 func EnvPhotoConf() {
+	if os.Getenv("IMAGE_TYPE") != "" {
+		*ImageType = os.Getenv("IMAGE_TYPE")
+	}
+
+	if os.Getenv("IMAGE_NUM") != "" {
+		*ImageNum, _ = strconv.Atoi(os.Getenv("IMAGE_NUM"))
+	}
+
+	if os.Getenv("MAX_IMAGE_NUM") != "" {
+		*MaxImageNum, _ = strconv.Atoi(os.Getenv("MAX_IMAGE_NUM"))
+	}
+
+	if os.Getenv("OPENAI_IMAGE_MODEL") != "" {
+		*OpenAIImageModel = os.Getenv("OPENAI_IMAGE_MODEL")
+	}
+
+	if os.Getenv("GEMINI_IMAGE_MODEL") != "" {
+		*GeminiImageModel = os.Getenv("GEMINI_IMAGE_MODEL")
+	}
+
+	if os.Getenv("SD_URL") != "" {
+		*SDUrl = os.Getenv("SD_URL")
+	}
+
+	if os.Getenv("COMFYUI_URL") != "" {
+		*ComfyUIUrl = os.Getenv("COMFYUI_URL")
+	}
+
+	if os.Getenv("COMFYUI_WORKFLOW") != "" {
+		*ComfyUIWorkflow = os.Getenv("COMFYUI_WORKFLOW")
+	}
+
 	if os.Getenv("REQ_KEY") != "" {
 		*ReqKey = os.Getenv("REQ_KEY")
 	}
@@ -111,6 +174,14 @@ func EnvPhotoConf() {
 		*LogoTextContent = os.Getenv("LogoTextContent")
 	}
 
+	logger.Info("PHOTO_CONF", "ImageType", *ImageType)
+	logger.Info("PHOTO_CONF", "ImageNum", *ImageNum)
+	logger.Info("PHOTO_CONF", "MaxImageNum", *MaxImageNum)
+	logger.Info("PHOTO_CONF", "OpenAIImageModel", *OpenAIImageModel)
+	logger.Info("PHOTO_CONF", "GeminiImageModel", *GeminiImageModel)
+	logger.Info("PHOTO_CONF", "SDUrl", *SDUrl)
+	logger.Info("PHOTO_CONF", "ComfyUIUrl", *ComfyUIUrl)
+	logger.Info("PHOTO_CONF", "ComfyUIWorkflow", *ComfyUIWorkflow)
 	logger.Info("PHOTO_CONF", "ReqKey", *ReqKey)
 	logger.Info("PHOTO_CONF", "ModelVersion", *ModelVersion)
 	logger.Info("PHOTO_CONF", "ReqScheduleConf", *ReqScheduleConf)
@@ -128,3 +199,28 @@ func EnvPhotoConf() {
 	logger.Info("PHOTO_CONF", "Opacity", *Opacity)
 	logger.Info("PHOTO_CONF", "LogoTextContent", *LogoTextContent)
 }
+
+// GetImageTypes get image backends which are configured, the default one goes first.
+// backend is available if its credential or url is set.
+func GetImageTypes() []string {
+	available := []struct {
+		imageType string
+		ok        bool
+	}{
+		{ImageTypeVol, *VolcAK != "" && *VolcSK != ""},
+		{ImageTypeOpenAI, *OpenAIToken != ""},
+		{ImageTypeGemini, *GeminiToken != ""},
+		{ImageTypeSD, *SDUrl != ""},
+		{ImageTypeComfyUI, *ComfyUIUrl != "" && *ComfyUIWorkflow != ""},
+	}
+
+	types := make([]string, 0)
+	for _, a := range available {
+		if a.ok && a.imageType == *ImageType {
+			types = append([]string{a.imageType}, types...)
+		} else if a.ok {
+			types = append(types, a.imageType)
+		}
+	}
+	return types
+}
@@ -0,0 +1,71 @@
+package conf
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+)
+
+// ModelPrice price of model in USD per 1M tokens, cached price is same as prompt price if it's 0
+type ModelPrice struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+	Cached     float64 `json:"cached"`
+}
+
+var (
+	PriceConfPath *string
+
+	// ModelPrices key is model, or llm type and model like openrouter:openai/gpt-4o
+	ModelPrices = make(map[string]*ModelPrice)
+)
+
+func InitPriceConf() {
+	PriceConfPath = flag.String("price_conf_path", "", "conf path of model prices, USD per 1M tokens")
+}
+
+func EnvPriceConf() {
+	if os.Getenv("PRICE_CONF_PATH") != "" {
+		*PriceConfPath = os.Getenv("PRICE_CONF_PATH")
+	}
+
+	logger.Info("PRICE_CONF", "PriceConfPath", *PriceConfPath)
+
+	if *PriceConfPath == "" {
+		return
+	}
+
+	data, err := os.ReadFile(*PriceConfPath)
+	if err != nil {
+		logger.Error("read price conf fail", "err", err)
+		return
+	}
+
+	prices, err := ParseModelPrices(data)
+	if err != nil {
+		logger.Error("parse price conf fail", "err", err)
+		return
+	}
+	ModelPrices = prices
+
+	for model, price := range ModelPrices {
+		logger.Info("PRICE_CONF", "model", model, "prompt", price.Prompt, "completion", price.Completion, "cached", price.Cached)
+	}
+}
+
+// ParseModelPrices parse prices from json object like {"deepseek-chat": {"prompt": 0.27, "completion": 1.1, "cached": 0.07}}
+func ParseModelPrices(data []byte) (map[string]*ModelPrice, error) {
+	prices := make(map[string]*ModelPrice)
+	if err := json.Unmarshal(data, &prices); err != nil {
+		return nil, err
+	}
+
+	for model, price := range prices {
+		if price == nil {
+			delete(prices, model)
+		}
+	}
+	return prices, nil
+}
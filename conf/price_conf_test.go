@@ -0,0 +1,27 @@
+package conf
+
+import (
+	"testing"
+)
+
+func TestParseModelPrices(t *testing.T) {
+	data := []byte(`{
+		"deepseek-chat": {"prompt": 0.27, "completion": 1.1, "cached": 0.07},
+		"openrouter:openai/gpt-4o": {"prompt": 2.5, "completion": 10},
+		"empty": null
+	}`)
+
+	prices, err := ParseModelPrices(data)
+	if err != nil {
+		t.Fatalf("parse prices fail: %v", err)
+	}
+	if len(prices) != 2 {
+		t.Fatalf("%s expected %d, got %d", "prices number", 2, len(prices))
+	}
+	assertBool(t, prices["deepseek-chat"].Cached == 0.07, true, "Cached")
+	assertBool(t, prices["openrouter:openai/gpt-4o"].Completion == 10, true, "Completion")
+
+	if _, err = ParseModelPrices([]byte("[")); err == nil {
+		t.Errorf("Expected invalid json to fail")
+	}
+}
@@ -7,12 +7,14 @@ import (
 	"time"
 
 	"github.com/cohesion-org/deepseek-go"
+	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/revrost/go-openrouter"
 	"github.com/sashabaranov/go-openai"
 	"github.com/volcengine/volcengine-go-sdk/service/arkruntime/model"
 	"github.com/yincongcyincong/mcp-client-go/clients"
 	"github.com/yincongcyincong/mcp-client-go/utils"
 	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+	"github.com/yincongcyincong/telegram-deepseek-bot/param"
 	"google.golang.org/genai"
 )
 
@@ -25,6 +27,7 @@ type AgentInfo struct {
 	OpenAITools     []openai.Tool
 	GeminiTools     []*genai.Tool
 	OpenRouterTools []openrouter.Tool
+	AnthropicTools  []param.AnthropicTool
 }
 
 var (
@@ -35,6 +38,7 @@ var (
 	OpenAITools     = make([]openai.Tool, 0)
 	GeminiTools     = make([]*genai.Tool, 0)
 	OpenRouterTools = make([]openrouter.Tool, 0)
+	AnthropicTools  = make([]param.AnthropicTool, 0)
 
 	TaskTools = map[string]*AgentInfo{}
 )
@@ -89,6 +93,7 @@ func InsertTools(clientName string) {
 		oaTools := utils.TransToolsToChatGPTFunctionCall(c.Tools)
 		gmTools := utils.TransToolsToGeminiFunctionCall(c.Tools)
 		orTools := utils.TransToolsToOpenRouterFunctionCall(c.Tools)
+		antTools := TransToolsToAnthropicTools(c.Tools)
 
 		if *UseTools {
 			DeepseekTools = append(DeepseekTools, dpTools...)
@@ -96,6 +101,7 @@ func InsertTools(clientName string) {
 			OpenAITools = append(OpenAITools, oaTools...)
 			GeminiTools = append(GeminiTools, gmTools...)
 			OpenRouterTools = append(OpenRouterTools, orTools...)
+			AnthropicTools = append(AnthropicTools, antTools...)
 		}
 
 		if c.Conf.Description != "" {
@@ -106,8 +112,31 @@ func InsertTools(clientName string) {
 				GeminiTools:     gmTools,
 				OpenAITools:     oaTools,
 				OpenRouterTools: orTools,
+				AnthropicTools:  antTools,
 				ToolsName:       []string{clientName},
 			}
 		}
 	}
 }
+
+// TransToolsToAnthropicTools transfer mcp tools to anthropic tools, mcp-client-go doesn't support anthropic
+func TransToolsToAnthropicTools(tools []mcp.Tool) []param.AnthropicTool {
+	anthropicTools := make([]param.AnthropicTool, 0, len(tools))
+	for _, tool := range tools {
+		properties := tool.InputSchema.Properties
+		if properties == nil {
+			properties = make(map[string]interface{})
+		}
+		anthropicTools = append(anthropicTools, param.AnthropicTool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: param.AnthropicInputSchema{
+				Type:       "object",
+				Properties: properties,
+				Required:   tool.InputSchema.Required,
+			},
+		})
+	}
+
+	return anthropicTools
+}
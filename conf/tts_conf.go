@@ -0,0 +1,49 @@
+package conf
+
+import (
+	"flag"
+	"os"
+	"strconv"
+
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+)
+
+var (
+	TTSUrl   *string
+	TTSToken *string
+	TTSModel *string
+	TTSVoice *string
+	TTSSpeed *float64
+)
+
+func InitTTSConf() {
+	TTSUrl = flag.String("tts_url", "", "base url of server which serves openai /v1/audio/speech, e.g. https://api.openai.com/v1")
+	TTSToken = flag.String("tts_token", "", "token of tts server")
+	TTSModel = flag.String("tts_model", "tts-1", "model of tts server")
+	TTSVoice = flag.String("tts_voice", "alloy", "voice of answer")
+	TTSSpeed = flag.Float64("tts_speed", 1.0, "speed of voice, from 0.25 to 4.0")
+}
+
+func EnvTTSConf() {
+	if os.Getenv("TTS_URL") != "" {
+		*TTSUrl = os.Getenv("TTS_URL")
+	}
+	if os.Getenv("TTS_TOKEN") != "" {
+		*TTSToken = os.Getenv("TTS_TOKEN")
+	}
+	if os.Getenv("TTS_MODEL") != "" {
+		*TTSModel = os.Getenv("TTS_MODEL")
+	}
+	if os.Getenv("TTS_VOICE") != "" {
+		*TTSVoice = os.Getenv("TTS_VOICE")
+	}
+	if os.Getenv("TTS_SPEED") != "" {
+		*TTSSpeed, _ = strconv.ParseFloat(os.Getenv("TTS_SPEED"), 64)
+	}
+
+	logger.Info("TTS_CONF", "TTS_URL", *TTSUrl)
+	logger.Info("TTS_CONF", "TTS_TOKEN", *TTSToken)
+	logger.Info("TTS_CONF", "TTS_MODEL", *TTSModel)
+	logger.Info("TTS_CONF", "TTS_VOICE", *TTSVoice)
+	logger.Info("TTS_CONF", "TTS_SPEED", *TTSSpeed)
+}
@@ -20,17 +20,33 @@ const (
 				mode VARCHAR(100) NOT NULL DEFAULT '',
 				updatetime int(10) NOT NULL DEFAULT '0',
 				token int(10) NOT NULL DEFAULT '0',
-				avail_token int(10) NOT NULL DEFAULT 0
+				avail_token int(10) NOT NULL DEFAULT 0,
+				show_reasoning int(10) NOT NULL DEFAULT '0',
+				llm_type VARCHAR(100) NOT NULL DEFAULT '',
+				reply_mode VARCHAR(20) NOT NULL DEFAULT ''
 			);
 			CREATE TABLE records (
 				id INTEGER PRIMARY KEY AUTOINCREMENT,
 				user_id int(11) NOT NULL DEFAULT '0',
+				chat_id int(11) NOT NULL DEFAULT '0',
+				session_id int(11) NOT NULL DEFAULT '0',
+				question_msg_id int(11) NOT NULL DEFAULT '0',
+				answer_msg_id int(11) NOT NULL DEFAULT '0',
+				answer_msg_ids VARCHAR(1024) NOT NULL DEFAULT '',
 				question TEXT NOT NULL,
 				answer TEXT NOT NULL,
 				content TEXT NOT NULL,
+				reasoning TEXT NOT NULL,
 				create_time int(10) NOT NULL DEFAULT '0',
 				is_deleted int(10) NOT NULL DEFAULT '0',
-				token int(10) NOT NULL DEFAULT 0
+				token int(10) NOT NULL DEFAULT 0,
+				reasoning_token int(10) NOT NULL DEFAULT 0,
+				prompt_token int(10) NOT NULL DEFAULT 0,
+				completion_token int(10) NOT NULL DEFAULT 0,
+				cached_token int(10) NOT NULL DEFAULT 0,
+				llm_type VARCHAR(100) NOT NULL DEFAULT '',
+				model VARCHAR(255) NOT NULL DEFAULT '',
+				cost DOUBLE NOT NULL DEFAULT 0
 			);
 			CREATE TABLE rag_files (
 				id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -41,6 +57,7 @@ const (
 				is_deleted int(10) NOT NULL DEFAULT '0'
 			);
 			CREATE INDEX idx_records_user_id ON records(user_id);
+			CREATE INDEX idx_records_chat_id ON records(chat_id);
 			CREATE INDEX idx_records_create_time ON records(create_time);`
 
 	mysqlCreateUsersSQL = `
@@ -50,19 +67,35 @@ const (
 				mode VARCHAR(100) NOT NULL DEFAULT '',
 				updatetime INT(10) NOT NULL DEFAULT 0,
 				token int(10) NOT NULL DEFAULT 0,
-				avail_token int(10) NOT NULL DEFAULT 0
+				avail_token int(10) NOT NULL DEFAULT 0,
+				show_reasoning int(10) NOT NULL DEFAULT 0,
+				llm_type VARCHAR(100) NOT NULL DEFAULT '',
+				reply_mode VARCHAR(20) NOT NULL DEFAULT ''
 			);`
 
 	mysqlCreateRecordsSQL = `
 			CREATE TABLE IF NOT EXISTS records (
 				id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
 				user_id BIGINT(20) NOT NULL DEFAULT 0,
+				chat_id BIGINT(20) NOT NULL DEFAULT 0,
+				session_id INT NOT NULL DEFAULT 0,
+				question_msg_id INT NOT NULL DEFAULT 0,
+				answer_msg_id INT NOT NULL DEFAULT 0,
+				answer_msg_ids VARCHAR(1024) NOT NULL DEFAULT '',
 				question TEXT NOT NULL,
 				answer TEXT NOT NULL,
 				content TEXT NOT NULL,
+				reasoning TEXT NOT NULL,
 				create_time int(10) NOT NULL DEFAULT '0',
 				is_deleted int(10) NOT NULL DEFAULT '0',
-				token int(10) NOT NULL DEFAULT 0
+				token int(10) NOT NULL DEFAULT 0,
+				reasoning_token int(10) NOT NULL DEFAULT 0,
+				prompt_token int(10) NOT NULL DEFAULT 0,
+				completion_token int(10) NOT NULL DEFAULT 0,
+				cached_token int(10) NOT NULL DEFAULT 0,
+				llm_type VARCHAR(100) NOT NULL DEFAULT '',
+				model VARCHAR(255) NOT NULL DEFAULT '',
+				cost DOUBLE NOT NULL DEFAULT 0
 			);`
 
 	mysqlCreateRagFileSQL = `CREATE TABLE IF NOT EXISTS rag_files (
@@ -74,8 +107,172 @@ const (
 				is_deleted int(10) NOT NULL DEFAULT '0'
 			);`
 
-	mysqlCreateIndexSQL   = `CREATE INDEX idx_records_user_id ON records(user_id);`
-	mysqlCreateCTIndexSQL = `CREATE INDEX idx_records_create_time ON records(create_time);`
+	sqlite3CreatePersonasSQL = `
+			CREATE TABLE IF NOT EXISTS personas (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name VARCHAR(100) NOT NULL DEFAULT '' UNIQUE,
+				prompt TEXT NOT NULL,
+				user_id int(11) NOT NULL DEFAULT '0',
+				create_time int(10) NOT NULL DEFAULT '0',
+				update_time int(10) NOT NULL DEFAULT '0',
+				is_deleted int(10) NOT NULL DEFAULT '0'
+			);`
+
+	sqlite3CreateChatPersonasSQL = `
+			CREATE TABLE IF NOT EXISTS chat_personas (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				chat_id int(11) NOT NULL DEFAULT '0',
+				user_id int(11) NOT NULL DEFAULT '0',
+				persona_id int(11) NOT NULL DEFAULT '0',
+				update_time int(10) NOT NULL DEFAULT '0'
+			);
+			CREATE UNIQUE INDEX IF NOT EXISTS uk_chat_personas_chat_user ON chat_personas(chat_id, user_id);`
+
+	sqlite3CreateSummariesSQL = `
+			CREATE TABLE IF NOT EXISTS summaries (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				chat_id int(11) NOT NULL DEFAULT '0',
+				user_id int(11) NOT NULL DEFAULT '0',
+				session_id int(11) NOT NULL DEFAULT '0',
+				summary TEXT NOT NULL,
+				last_record_id int(11) NOT NULL DEFAULT '0',
+				update_time int(10) NOT NULL DEFAULT '0'
+			);
+			CREATE UNIQUE INDEX IF NOT EXISTS uk_summaries_chat_user_session ON summaries(chat_id, user_id, session_id);`
+
+	sqlite3CreateSessionsSQL = `
+			CREATE TABLE IF NOT EXISTS sessions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				chat_id int(11) NOT NULL DEFAULT '0',
+				user_id int(11) NOT NULL DEFAULT '0',
+				title VARCHAR(255) NOT NULL DEFAULT '',
+				is_active int(10) NOT NULL DEFAULT '0',
+				create_time int(10) NOT NULL DEFAULT '0',
+				update_time int(10) NOT NULL DEFAULT '0',
+				is_deleted int(10) NOT NULL DEFAULT '0'
+			);
+			CREATE INDEX IF NOT EXISTS idx_sessions_chat_user ON sessions(chat_id, user_id);`
+
+	sqlite3CreateLLMModelsSQL = `
+			CREATE TABLE IF NOT EXISTS llm_models (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				llm_type VARCHAR(100) NOT NULL DEFAULT '',
+				model VARCHAR(255) NOT NULL DEFAULT '',
+				update_time int(10) NOT NULL DEFAULT '0'
+			);
+			CREATE INDEX IF NOT EXISTS idx_llm_models_llm_type ON llm_models(llm_type);`
+
+	sqlite3CreateResponseCacheSQL = `
+			CREATE TABLE IF NOT EXISTS response_cache (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				cache_key VARCHAR(64) NOT NULL DEFAULT '',
+				llm_type VARCHAR(100) NOT NULL DEFAULT '',
+				model VARCHAR(255) NOT NULL DEFAULT '',
+				persona_key VARCHAR(64) NOT NULL DEFAULT '',
+				prompt TEXT NOT NULL,
+				answer TEXT NOT NULL,
+				embedding TEXT NOT NULL,
+				hit_num int(10) NOT NULL DEFAULT '0',
+				create_time int(10) NOT NULL DEFAULT '0'
+			);
+			CREATE INDEX IF NOT EXISTS idx_response_cache_key ON response_cache(cache_key);
+			CREATE INDEX IF NOT EXISTS idx_response_cache_model ON response_cache(llm_type, model, persona_key);`
+
+	sqlite3CreateSamplingParamsSQL = `
+			CREATE TABLE IF NOT EXISTS sampling_params (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				scope VARCHAR(20) NOT NULL DEFAULT '',
+				scope_id int(11) NOT NULL DEFAULT '0',
+				params TEXT NOT NULL,
+				update_time int(10) NOT NULL DEFAULT '0'
+			);
+			CREATE UNIQUE INDEX IF NOT EXISTS uk_sampling_params_scope ON sampling_params(scope, scope_id);`
+
+	mysqlCreatePersonasSQL = `
+			CREATE TABLE IF NOT EXISTS personas (
+				id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+				name VARCHAR(100) NOT NULL DEFAULT '',
+				prompt TEXT NOT NULL,
+				user_id BIGINT(20) NOT NULL DEFAULT 0,
+				create_time int(10) NOT NULL DEFAULT '0',
+				update_time int(10) NOT NULL DEFAULT '0',
+				is_deleted int(10) NOT NULL DEFAULT '0',
+				UNIQUE KEY uk_name (name)
+			);`
+
+	mysqlCreateChatPersonasSQL = `
+			CREATE TABLE IF NOT EXISTS chat_personas (
+				id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+				chat_id BIGINT(20) NOT NULL DEFAULT 0,
+				user_id BIGINT(20) NOT NULL DEFAULT 0,
+				persona_id INT NOT NULL DEFAULT 0,
+				update_time int(10) NOT NULL DEFAULT '0',
+				UNIQUE KEY uk_chat_user (chat_id, user_id)
+			);`
+
+	mysqlCreateSummariesSQL = `
+			CREATE TABLE IF NOT EXISTS summaries (
+				id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+				chat_id BIGINT(20) NOT NULL DEFAULT 0,
+				user_id BIGINT(20) NOT NULL DEFAULT 0,
+				session_id INT NOT NULL DEFAULT 0,
+				summary TEXT NOT NULL,
+				last_record_id BIGINT NOT NULL DEFAULT 0,
+				update_time int(10) NOT NULL DEFAULT '0',
+				UNIQUE KEY uk_chat_user_session (chat_id, user_id, session_id)
+			);`
+
+	mysqlCreateSessionsSQL = `
+			CREATE TABLE IF NOT EXISTS sessions (
+				id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+				chat_id BIGINT(20) NOT NULL DEFAULT 0,
+				user_id BIGINT(20) NOT NULL DEFAULT 0,
+				title VARCHAR(255) NOT NULL DEFAULT '',
+				is_active int(10) NOT NULL DEFAULT '0',
+				create_time int(10) NOT NULL DEFAULT '0',
+				update_time int(10) NOT NULL DEFAULT '0',
+				is_deleted int(10) NOT NULL DEFAULT '0',
+				KEY idx_chat_user (chat_id, user_id)
+			);`
+
+	mysqlCreateLLMModelsSQL = `
+			CREATE TABLE IF NOT EXISTS llm_models (
+				id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+				llm_type VARCHAR(100) NOT NULL DEFAULT '',
+				model VARCHAR(255) NOT NULL DEFAULT '',
+				update_time int(10) NOT NULL DEFAULT '0',
+				KEY idx_llm_type (llm_type)
+			);`
+
+	mysqlCreateResponseCacheSQL = `
+			CREATE TABLE IF NOT EXISTS response_cache (
+				id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+				cache_key VARCHAR(64) NOT NULL DEFAULT '',
+				llm_type VARCHAR(100) NOT NULL DEFAULT '',
+				model VARCHAR(255) NOT NULL DEFAULT '',
+				persona_key VARCHAR(64) NOT NULL DEFAULT '',
+				prompt TEXT NOT NULL,
+				answer MEDIUMTEXT NOT NULL,
+				embedding MEDIUMTEXT NOT NULL,
+				hit_num int(10) NOT NULL DEFAULT '0',
+				create_time int(10) NOT NULL DEFAULT '0',
+				KEY idx_cache_key (cache_key),
+				KEY idx_model (llm_type, model, persona_key)
+			);`
+
+	mysqlCreateSamplingParamsSQL = `
+			CREATE TABLE IF NOT EXISTS sampling_params (
+				id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+				scope VARCHAR(20) NOT NULL DEFAULT '',
+				scope_id BIGINT(20) NOT NULL DEFAULT 0,
+				params TEXT NOT NULL,
+				update_time int(10) NOT NULL DEFAULT '0',
+				UNIQUE KEY uk_scope (scope, scope_id)
+			);`
+
+	mysqlCreateIndexSQL       = `CREATE INDEX idx_records_user_id ON records(user_id);`
+	mysqlCreateCTIndexSQL     = `CREATE INDEX idx_records_create_time ON records(create_time);`
+	mysqlCreateChatIdIndexSQL = `CREATE INDEX idx_records_chat_id ON records(chat_id);`
 )
 
 var (
@@ -106,6 +303,14 @@ func InitTable() {
 		if err != nil {
 			logger.Fatal("create sqlite table fail", "err", err)
 		}
+
+		// tables added after the first release
+		for _, createSQL := range []string{sqlite3CreatePersonasSQL, sqlite3CreateChatPersonasSQL, sqlite3CreateSummariesSQL, sqlite3CreateSessionsSQL,
+			sqlite3CreateLLMModelsSQL, sqlite3CreateResponseCacheSQL, sqlite3CreateSamplingParamsSQL} {
+			if _, err = DB.Exec(createSQL); err != nil {
+				logger.Fatal("create sqlite table fail", "err", err)
+			}
+		}
 	case "mysql":
 		// 检查并创建表
 		if err := initializeMysqlTable(DB, "users", mysqlCreateUsersSQL); err != nil {
@@ -119,6 +324,38 @@ func InitTable() {
 		if err := initializeMysqlTable(DB, "rag_files", mysqlCreateRagFileSQL); err != nil {
 			logger.Fatal("create mysql table fail", "err", err)
 		}
+
+		if err := initializeMysqlTable(DB, "personas", mysqlCreatePersonasSQL); err != nil {
+			logger.Fatal("create mysql table fail", "err", err)
+		}
+
+		if err := initializeMysqlTable(DB, "chat_personas", mysqlCreateChatPersonasSQL); err != nil {
+			logger.Fatal("create mysql table fail", "err", err)
+		}
+
+		if err := initializeMysqlTable(DB, "summaries", mysqlCreateSummariesSQL); err != nil {
+			logger.Fatal("create mysql table fail", "err", err)
+		}
+
+		if err := initializeMysqlTable(DB, "sessions", mysqlCreateSessionsSQL); err != nil {
+			logger.Fatal("create mysql table fail", "err", err)
+		}
+
+		if err := initializeMysqlTable(DB, "llm_models", mysqlCreateLLMModelsSQL); err != nil {
+			logger.Fatal("create mysql table fail", "err", err)
+		}
+
+		if err := initializeMysqlTable(DB, "response_cache", mysqlCreateResponseCacheSQL); err != nil {
+			logger.Fatal("create mysql table fail", "err", err)
+		}
+
+		if err := initializeMysqlTable(DB, "sampling_params", mysqlCreateSamplingParamsSQL); err != nil {
+			logger.Fatal("create mysql table fail", "err", err)
+		}
+	}
+
+	if err = migrateTable(DB, *conf.DBType); err != nil {
+		logger.Fatal("migrate table fail", "err", err)
 	}
 
 	logger.Info("db initialize successfully")
@@ -148,6 +385,10 @@ func initializeMysqlTable(db *sql.DB, tableName string, createSQL string) error
 			if err != nil {
 				logger.Fatal("Create index failed", "err", err)
 			}
+			_, err = db.Exec(mysqlCreateChatIdIndexSQL)
+			if err != nil {
+				logger.Fatal("Create index failed", "err", err)
+			}
 		}
 	} else if err != nil {
 		return fmt.Errorf("search table failed: %v", err)
@@ -182,3 +423,107 @@ func initializeSqlite3Table(db *sql.DB, tableName string) error {
 
 	return nil
 }
+
+// migrateTable add columns which are introduced after the table is created.
+func migrateTable(db *sql.DB, dbType string) error {
+	added, err := addColumnIfNotExist(db, dbType, "records", "chat_id", "BIGINT NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	if added {
+		// old records belong to private chat, whose chat id is same as user id.
+		if _, err = db.Exec(`UPDATE records SET chat_id = user_id WHERE chat_id = 0`); err != nil {
+			return fmt.Errorf("fill records chat_id fail: %v", err)
+		}
+		if _, err = db.Exec(`CREATE INDEX idx_records_chat_id ON records(chat_id)`); err != nil {
+			return fmt.Errorf("create records chat_id index fail: %v", err)
+		}
+	}
+
+	// old records belong to default session 0
+	_, err = addColumnIfNotExist(db, dbType, "records", "session_id", "INT NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+
+	// telegram message ids of question and answer, used to regenerate answer when question is edited
+	for _, column := range []string{"question_msg_id", "answer_msg_id"} {
+		_, err = addColumnIfNotExist(db, dbType, "records", column, "INT NOT NULL DEFAULT 0")
+		if err != nil {
+			return err
+		}
+	}
+	if _, err = addColumnIfNotExist(db, dbType, "records", "answer_msg_ids", "VARCHAR(1024) NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	// reasoning of reasoning models is stored apart from answer
+	reasoningDef := "TEXT NOT NULL DEFAULT ''"
+	if dbType == "mysql" {
+		// mysql text column can't have default value
+		reasoningDef = "TEXT NOT NULL"
+	}
+	if _, err = addColumnIfNotExist(db, dbType, "records", "reasoning", reasoningDef); err != nil {
+		return err
+	}
+	if _, err = addColumnIfNotExist(db, dbType, "records", "reasoning_token", "INT NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	// usage of every request, cost is calculated by price of model when record is inserted
+	for _, column := range []string{"prompt_token", "completion_token", "cached_token"} {
+		if _, err = addColumnIfNotExist(db, dbType, "records", column, "INT NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+	}
+	if _, err = addColumnIfNotExist(db, dbType, "records", "llm_type", "VARCHAR(100) NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if _, err = addColumnIfNotExist(db, dbType, "records", "model", "VARCHAR(255) NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if _, err = addColumnIfNotExist(db, dbType, "records", "cost", "DOUBLE NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	// users can turn reasoning message on
+	if _, err = addColumnIfNotExist(db, dbType, "users", "show_reasoning", "INT NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	// llm type chosen by user, mode is model of the type
+	if _, err = addColumnIfNotExist(db, dbType, "users", "llm_type", "VARCHAR(100) NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	// users can get answer in voice
+	_, err = addColumnIfNotExist(db, dbType, "users", "reply_mode", "VARCHAR(20) NOT NULL DEFAULT ''")
+	return err
+}
+
+// addColumnIfNotExist add column to table if column not exist, return true if column is added.
+func addColumnIfNotExist(db *sql.DB, dbType, tableName, columnName, columnDef string) (bool, error) {
+	var query string
+	switch dbType {
+	case "mysql":
+		query = `SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`
+	default:
+		query = `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`
+	}
+
+	var num int
+	if err := db.QueryRow(query, tableName, columnName).Scan(&num); err != nil {
+		return false, fmt.Errorf("search column fail: %v", err)
+	}
+	if num > 0 {
+		return false, nil
+	}
+
+	logger.Info("column not exist, adding...", "tableName", tableName, "columnName", columnName)
+	_, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", tableName, columnName, columnDef))
+	if err != nil {
+		return false, fmt.Errorf("add column fail: %v", err)
+	}
+
+	return true, nil
+}
@@ -31,3 +31,83 @@ func TestInitializeSqlite3Table(t *testing.T) {
 		t.Errorf("Expected table name 'users', got '%s'", name)
 	}
 }
+
+func TestMigrateTable(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open sqlite memory DB: %v", err)
+	}
+	defer db.Close()
+
+	// records table created by old version, without chat_id
+	_, err = db.Exec(`CREATE TABLE records (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id int(11) NOT NULL DEFAULT '0',
+				question TEXT NOT NULL,
+				answer TEXT NOT NULL,
+				content TEXT NOT NULL,
+				create_time int(10) NOT NULL DEFAULT '0',
+				is_deleted int(10) NOT NULL DEFAULT '0',
+				token int(10) NOT NULL DEFAULT 0
+			);`)
+	if err != nil {
+		t.Fatalf("Failed to create old records table: %v", err)
+	}
+	_, err = db.Exec(`CREATE TABLE users (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id int(11) NOT NULL DEFAULT '0',
+				mode VARCHAR(100) NOT NULL DEFAULT '',
+				updatetime int(10) NOT NULL DEFAULT '0',
+				token int(10) NOT NULL DEFAULT '0',
+				avail_token int(10) NOT NULL DEFAULT 0
+			);`)
+	if err != nil {
+		t.Fatalf("Failed to create old users table: %v", err)
+	}
+	_, err = db.Exec(`INSERT INTO records (user_id, question, answer, content) VALUES (123, 'q', 'a', '')`)
+	if err != nil {
+		t.Fatalf("Failed to insert old record: %v", err)
+	}
+
+	if err = migrateTable(db, "sqlite3"); err != nil {
+		t.Fatalf("migrateTable failed: %v", err)
+	}
+	// migrate twice should do nothing
+	if err = migrateTable(db, "sqlite3"); err != nil {
+		t.Fatalf("migrateTable again failed: %v", err)
+	}
+
+	var chatId, sessionId, questionMsgId int64
+	err = db.QueryRow(`SELECT chat_id, session_id, question_msg_id FROM records WHERE user_id = 123`).Scan(&chatId, &sessionId, &questionMsgId)
+	if err != nil {
+		t.Fatalf("Failed to query chat_id: %v", err)
+	}
+	if chatId != 123 {
+		t.Errorf("Expected old record chat_id 123, got %d", chatId)
+	}
+	if sessionId != 0 {
+		t.Errorf("Expected old record in default session, got %d", sessionId)
+	}
+	if questionMsgId != 0 {
+		t.Errorf("Expected old record without question msg id, got %d", questionMsgId)
+	}
+
+	var reasoning string
+	err = db.QueryRow(`SELECT reasoning FROM records WHERE user_id = 123`).Scan(&reasoning)
+	if err != nil {
+		t.Fatalf("Failed to query reasoning: %v", err)
+	}
+	if reasoning != "" {
+		t.Errorf("Expected old record without reasoning, got %s", reasoning)
+	}
+
+	var llmType string
+	var cost float64
+	err = db.QueryRow(`SELECT llm_type, cost FROM records WHERE user_id = 123`).Scan(&llmType, &cost)
+	if err != nil {
+		t.Fatalf("Failed to query cost: %v", err)
+	}
+	if llmType != "" || cost != 0 {
+		t.Errorf("Expected old record without usage, got %s %f", llmType, cost)
+	}
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"time"
+)
+
+// ReplaceLLMModels replace cached models of llm type with models fetched from list endpoint
+func ReplaceLLMModels(llmType string, models []string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(`DELETE FROM llm_models WHERE llm_type = ?`, llmType); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, model := range models {
+		_, err = tx.Exec(`INSERT INTO llm_models (llm_type, model, update_time) VALUES (?, ?, ?)`, llmType, model, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetLLMModels get cached models of every llm type
+func GetLLMModels() (map[string][]string, error) {
+	rows, err := DB.Query(`SELECT llm_type, model FROM llm_models order by id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	models := make(map[string][]string)
+	for rows.Next() {
+		var llmType, model string
+		if err = rows.Scan(&llmType, &model); err != nil {
+			return nil, err
+		}
+		models[llmType] = append(models[llmType], model)
+	}
+
+	return models, rows.Err()
+}
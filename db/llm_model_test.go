@@ -0,0 +1,20 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLLMModels(t *testing.T) {
+	assert.Nil(t, ReplaceLLMModels("test_openai", []string{"gpt-4o", "gpt-4o-mini"}))
+	assert.Nil(t, ReplaceLLMModels("test_gemini", []string{"gemini-2.5-pro"}))
+
+	// refresh replaces old models of same type only
+	assert.Nil(t, ReplaceLLMModels("test_openai", []string{"gpt-4.1"}))
+
+	models, err := GetLLMModels()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"gpt-4.1"}, models["test_openai"])
+	assert.Equal(t, []string{"gemini-2.5-pro"}, models["test_gemini"])
+}
@@ -2,44 +2,145 @@ package db
 
 import (
 	"database/sql"
-	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/cohesion-org/deepseek-go"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
 	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
 	"github.com/yincongcyincong/telegram-deepseek-bot/metrics"
 )
 
-const MaxQAPair = 10
-
 type MsgRecordInfo struct {
 	AQs        []*AQ
 	updateTime int64
 }
 
 type AQ struct {
-	Question string
-	Answer   string
-	Content  string
-	Token    int
+	RecordId      int64 // id of record in db, 0 if it isn't stored
+	UserId        int64
+	Question      string
+	Answer        string
+	Content       string
+	Token         int
+	CreateTime    int64
+	QuestionMsgId int
+	AnswerMsgId   int
+	// other telegram messages of answer, such as reasoning and parts of long answer
+	AnswerMsgIds []int
+
+	// reasoning of reasoning models, it's only stored in db and never used as context
+	Reasoning      string
+	ReasoningToken int
+
+	Usage
 }
 
 type Record struct {
-	ID        int
+	ID            int
+	UserId        int64
+	ChatId        int64
+	SessionId     int64
+	QuestionMsgId int
+	AnswerMsgId   int
+	AnswerMsgIds  string // comma separated
+	Question      string
+	Answer        string
+	Content       string
+	Token         int
+	CreateTime    int64
+	IsDeleted     int
+
+	Reasoning      string
+	ReasoningToken int
+
+	Usage
+}
+
+// Usage llm, tokens and cost of one request. prompt token includes cached token,
+// completion token includes reasoning token.
+type Usage struct {
+	LLMType         string
+	Model           string
+	PromptToken     int
+	CompletionToken int
+	CachedToken     int
+	Cost            float64 // USD
+}
+
+// CostStat tokens and cost summed by user or by model
+type CostStat struct {
+	UserId  int64
+	LLMType string
+	Model   string
+	Token   int
+	Cost    float64
+}
+
+// RecordKey identify a conversation thread. UserId is 0 when the thread is shared by the whole group.
+// SessionId is 0 for the default session.
+type RecordKey struct {
+	ChatId    int64
 	UserId    int64
-	Question  string
-	Answer    string
-	Content   string
-	Token     int
-	IsDeleted int
+	SessionId int64
+}
+
+// NewChatKey get the key of user in chat without session, sessions and personas belong to it.
+func NewChatKey(chatId, userId int64) RecordKey {
+	// group chat id is negative
+	if chatId < 0 && *conf.SharedGroupHistory {
+		return RecordKey{ChatId: chatId}
+	}
+	return RecordKey{ChatId: chatId, UserId: userId}
+}
+
+// NewRecordKey get the thread key of active session of user in chat.
+func NewRecordKey(chatId, userId int64) RecordKey {
+	key := NewChatKey(chatId, userId)
+	key.SessionId = GetActiveSessionId(key)
+	return key
+}
+
+// ChatKey get the key of chat which thread belongs to
+func (k RecordKey) ChatKey() RecordKey {
+	return RecordKey{ChatId: k.ChatId, UserId: k.UserId}
 }
 
 var MsgRecord = sync.Map{}
 
-func InsertMsgRecord(userId int64, aq *AQ, insertDB bool) {
+func InsertMsgRecord(key RecordKey, aq *AQ, insertDB bool) {
+	if aq.CreateTime == 0 {
+		aq.CreateTime = time.Now().Unix()
+	}
+
+	// record is inserted before AQ is kept in memory, so AQ carries its record id
+	if insertDB {
+		record := &Record{
+			UserId:        aq.UserId,
+			ChatId:        key.ChatId,
+			SessionId:     key.SessionId,
+			Question:      aq.Question,
+			Answer:        aq.Answer,
+			Content:       aq.Content,
+			Token:         aq.Token,
+			CreateTime:    aq.CreateTime,
+			QuestionMsgId: aq.QuestionMsgId,
+			AnswerMsgId:   aq.AnswerMsgId,
+
+			Reasoning:      aq.Reasoning,
+			ReasoningToken: aq.ReasoningToken,
+			Usage:          aq.Usage,
+		}
+		InsertRecordInfo(record)
+		aq.RecordId = int64(record.ID)
+	}
+	// reasoning isn't context of conversation, don't keep it in memory
+	aq.Reasoning = ""
+
 	var msgRecord *MsgRecordInfo
-	msgRecordInter, ok := MsgRecord.Load(userId)
+	msgRecordInter, ok := MsgRecord.Load(key)
 	if !ok {
 		msgRecord = &MsgRecordInfo{
 			AQs:        []*AQ{aq},
@@ -47,39 +148,106 @@ func InsertMsgRecord(userId int64, aq *AQ, insertDB bool) {
 		}
 	} else {
 		msgRecord = msgRecordInter.(*MsgRecordInfo)
+		// AQs aren't limited by count, llm keeps the ones which fit context window and summarizes the others
 		msgRecord.AQs = append(msgRecord.AQs, aq)
-		if len(msgRecord.AQs) > MaxQAPair {
-			msgRecord.AQs = msgRecord.AQs[1:]
-		}
 		msgRecord.updateTime = time.Now().Unix()
 	}
-	MsgRecord.Store(userId, msgRecord)
-
-	if insertDB {
-		go InsertRecordInfo(&Record{
-			UserId:   userId,
-			Question: aq.Question,
-			Answer:   aq.Answer,
-			Content:  aq.Content,
-			Token:    aq.Token,
-		})
-	}
+	MsgRecord.Store(key, msgRecord)
 }
 
-func GetMsgRecord(userId int64) *MsgRecordInfo {
-	msgRecord, ok := MsgRecord.Load(userId)
+func GetMsgRecord(key RecordKey) *MsgRecordInfo {
+	msgRecord, ok := MsgRecord.Load(key)
 	if !ok {
 		return nil
 	}
 	return msgRecord.(*MsgRecordInfo)
 }
 
-func DeleteMsgRecord(userId int64) {
-	MsgRecord.Delete(userId)
-	err := DeleteRecord(userId)
+func DeleteMsgRecord(key RecordKey) {
+	MsgRecord.Delete(key)
+	err := DeleteRecord(key)
 	if err != nil {
 		logger.Error("Error deleting record", "err", err)
 	}
+	err = DeleteSummary(key)
+	if err != nil {
+		logger.Error("Error deleting summary", "err", err)
+	}
+}
+
+// DeleteMsgRecordFrom remove AQ of question message and AQs after it from thread, return the removed AQ.
+// return nil if question isn't in memory any more.
+func DeleteMsgRecordFrom(key RecordKey, questionMsgId int) *AQ {
+	msgRecord := GetMsgRecord(key)
+	if msgRecord == nil || questionMsgId == 0 {
+		return nil
+	}
+
+	for i, aq := range msgRecord.AQs {
+		if aq.QuestionMsgId == questionMsgId {
+			msgRecord.AQs = msgRecord.AQs[:i]
+			if err := deleteRecordsFrom(key, questionMsgId); err != nil {
+				logger.Error("Error deleting record", "err", err)
+			}
+			return aq
+		}
+	}
+
+	return nil
+}
+
+// UpdateAnswerMsgIds keep other telegram messages of answer whose first message is answerMsgId,
+// they are deleted when the answer is regenerated.
+func UpdateAnswerMsgIds(chatId int64, answerMsgId int, answerMsgIds []int) error {
+	MsgRecord.Range(func(k, v interface{}) bool {
+		if k.(RecordKey).ChatId != chatId {
+			return true
+		}
+		for _, aq := range v.(*MsgRecordInfo).AQs {
+			if aq.AnswerMsgId == answerMsgId {
+				aq.AnswerMsgIds = answerMsgIds
+			}
+		}
+		return true
+	})
+
+	_, err := DB.Exec(`UPDATE records SET answer_msg_ids = ? WHERE chat_id = ? and answer_msg_id = ? and is_deleted = 0`,
+		joinMsgIds(answerMsgIds), chatId, answerMsgId)
+	return err
+}
+
+func joinMsgIds(msgIds []int) string {
+	ids := make([]string, 0, len(msgIds))
+	for _, msgId := range msgIds {
+		ids = append(ids, strconv.Itoa(msgId))
+	}
+	return strings.Join(ids, ",")
+}
+
+func parseMsgIds(msgIds string) []int {
+	ids := make([]int, 0)
+	for _, msgId := range strings.Split(msgIds, ",") {
+		if id, err := strconv.Atoi(msgId); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// DeleteMsgRecordBefore remove AQs whose record id isn't after lastRecordId from memory, they are kept in summary
+func DeleteMsgRecordBefore(key RecordKey, lastRecordId int64) {
+	msgRecord := GetMsgRecord(key)
+	if msgRecord == nil {
+		return
+	}
+
+	aqs := make([]*AQ, 0, len(msgRecord.AQs))
+	for _, aq := range msgRecord.AQs {
+		if aq.RecordId > lastRecordId {
+			aqs = append(aqs, aq)
+		}
+	}
+	msgRecord.AQs = aqs
 }
 
 func UpdateUserTime() {
@@ -103,12 +271,17 @@ func UpdateDBData() {
 	timeUserPair := make(map[int64][]int64)
 	MsgRecord.Range(func(k, v interface{}) bool {
 		msgRecord := v.(*MsgRecordInfo)
+		key := k.(RecordKey)
+		totalNum++
+		// shared group thread doesn't belong to any user
+		if key.UserId == 0 {
+			return true
+		}
 		if _, ok := timeUserPair[msgRecord.updateTime]; !ok {
 			timeUserPair[msgRecord.updateTime] = make([]int64, 0)
 		}
-		timeUserPair[msgRecord.updateTime] = append(timeUserPair[msgRecord.updateTime], k.(int64))
-		UpdateUserInfo(k.(int64), msgRecord.updateTime)
-		totalNum++
+		timeUserPair[msgRecord.updateTime] = append(timeUserPair[msgRecord.updateTime], key.UserId)
+		UpdateUserInfo(key.UserId, msgRecord.updateTime)
 		return true
 	})
 }
@@ -127,18 +300,45 @@ func InsertRecord() {
 	}
 
 	for _, user := range users {
-		records, err := getRecordsByUserId(user.UserId)
+		keys, err := getRecordKeysByUserId(user.UserId)
 		if err != nil {
-			logger.Error("InsertRecord GetUsers err", "err", err)
+			logger.Error("InsertRecord getRecordKeysByUserId err", "err", err)
 		}
-		for i := len(records) - 1; i >= 0; i-- {
-			record := records[i]
-			InsertMsgRecord(user.UserId, &AQ{
-				Question: record.Question,
-				Answer:   record.Answer,
-				Content:  record.Content,
-			}, false)
-			metrics.TotalRecords.Inc()
+		for _, key := range keys {
+			// shared group thread may be loaded by other member
+			if _, ok := MsgRecord.Load(key); ok {
+				continue
+			}
+
+			// records in summary don't need to be loaded
+			var lastRecordId int64
+			summary, err := GetSummary(key)
+			if err != nil {
+				logger.Error("InsertRecord GetSummary err", "err", err)
+			}
+			if summary != nil {
+				lastRecordId = summary.LastRecordId
+			}
+
+			records, err := getRecordsByKey(key, lastRecordId)
+			if err != nil {
+				logger.Error("InsertRecord getRecordsByKey err", "err", err)
+			}
+			for i := len(records) - 1; i >= 0; i-- {
+				record := records[i]
+				InsertMsgRecord(key, &AQ{
+					RecordId:      int64(record.ID),
+					UserId:        record.UserId,
+					Question:      record.Question,
+					Answer:        record.Answer,
+					Content:       record.Content,
+					CreateTime:    record.CreateTime,
+					QuestionMsgId: record.QuestionMsgId,
+					AnswerMsgId:   record.AnswerMsgId,
+					AnswerMsgIds:  parseMsgIds(record.AnswerMsgIds),
+				}, false)
+				metrics.TotalRecords.Inc()
+			}
 		}
 	}
 
@@ -146,13 +346,40 @@ func InsertRecord() {
 
 }
 
-// getRecordsByUserId get latest 10 records by user_id
-func getRecordsByUserId(userId int64) ([]Record, error) {
+// getRecordKeysByUserId get threads which user has records in
+func getRecordKeysByUserId(userId int64) ([]RecordKey, error) {
+	rows, err := DB.Query("SELECT DISTINCT chat_id, session_id FROM records WHERE user_id = ? and is_deleted = 0", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []RecordKey
+	for rows.Next() {
+		var chatId, sessionId int64
+		if err := rows.Scan(&chatId, &sessionId); err != nil {
+			return nil, err
+		}
+		key := NewChatKey(chatId, userId)
+		key.SessionId = sessionId
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// getRecordsByKey get records of thread whose id is after lastRecordId, they aren't summarized yet
+func getRecordsByKey(key RecordKey, lastRecordId int64) ([]Record, error) {
 	// construct SQL statements
-	query := fmt.Sprintf("SELECT id, user_id, question, answer, content FROM records WHERE user_id =  ? and is_deleted = 0 order by create_time desc limit 10")
+	query := "SELECT id, user_id, chat_id, session_id, question_msg_id, answer_msg_id, answer_msg_ids, question, answer, content, create_time FROM records WHERE chat_id = ? and session_id = ? and id > ? and is_deleted = 0 order by create_time desc, id desc"
+	args := []interface{}{key.ChatId, key.SessionId, lastRecordId}
+	if key.UserId != 0 {
+		query = "SELECT id, user_id, chat_id, session_id, question_msg_id, answer_msg_id, answer_msg_ids, question, answer, content, create_time FROM records WHERE chat_id = ? and user_id = ? and session_id = ? and id > ? and is_deleted = 0 order by create_time desc, id desc"
+		args = []interface{}{key.ChatId, key.UserId, key.SessionId, lastRecordId}
+	}
 
 	// execute query
-	rows, err := DB.Query(query, userId)
+	rows, err := DB.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -161,7 +388,8 @@ func getRecordsByUserId(userId int64) ([]Record, error) {
 	var records []Record
 	for rows.Next() {
 		var record Record
-		err := rows.Scan(&record.ID, &record.UserId, &record.Question, &record.Answer, &record.Content)
+		err := rows.Scan(&record.ID, &record.UserId, &record.ChatId, &record.SessionId, &record.QuestionMsgId, &record.AnswerMsgId,
+			&record.AnswerMsgIds, &record.Question, &record.Answer, &record.Content, &record.CreateTime)
 		if err != nil {
 			return nil, err
 		}
@@ -171,13 +399,58 @@ func getRecordsByUserId(userId int64) ([]Record, error) {
 	return records, nil
 }
 
-// InsertRecordInfo insert record
+// GetThreadRecords get all records of thread order by create time
+func GetThreadRecords(key RecordKey) ([]Record, error) {
+	query := "SELECT id, user_id, chat_id, session_id, question, answer, content, token, create_time, is_deleted FROM records WHERE chat_id = ? and session_id = ? and is_deleted = 0 order by create_time, id"
+	args := []interface{}{key.ChatId, key.SessionId}
+	if key.UserId != 0 {
+		query = "SELECT id, user_id, chat_id, session_id, question, answer, content, token, create_time, is_deleted FROM records WHERE chat_id = ? and user_id = ? and session_id = ? and is_deleted = 0 order by create_time, id"
+		args = []interface{}{key.ChatId, key.UserId, key.SessionId}
+	}
+
+	return queryRecords(query, args...)
+}
+
+// GetRecordsByUserIdAndTime get all records of user in every chat, including deleted ones, for audit
+func GetRecordsByUserIdAndTime(userId int64, start, end int64) ([]Record, error) {
+	query := "SELECT id, user_id, chat_id, session_id, question, answer, content, token, create_time, is_deleted FROM records WHERE user_id = ? and create_time >= ? and create_time <= ? order by create_time, id"
+	return queryRecords(query, userId, start, end)
+}
+
+func queryRecords(query string, args ...interface{}) ([]Record, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		var record Record
+		err := rows.Scan(&record.ID, &record.UserId, &record.ChatId, &record.SessionId, &record.Question, &record.Answer,
+			&record.Content, &record.Token, &record.CreateTime, &record.IsDeleted)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// InsertRecordInfo insert record, ID of record is set after it's inserted
 func InsertRecordInfo(record *Record) {
-	query := `INSERT INTO records (user_id, question, answer, content, token, create_time, is_deleted) VALUES (?, ?, ?, ?, ?, ?, ?)`
-	_, err := DB.Exec(query, record.UserId, record.Question, record.Answer, record.Content, record.Token, time.Now().Unix(), record.IsDeleted)
+	query := `INSERT INTO records (user_id, chat_id, session_id, question_msg_id, answer_msg_id, question, answer, content, reasoning, token, reasoning_token, prompt_token, completion_token, cached_token, llm_type, model, cost, create_time, is_deleted) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	if record.CreateTime == 0 {
+		record.CreateTime = time.Now().Unix()
+	}
+	res, err := DB.Exec(query, record.UserId, record.ChatId, record.SessionId, record.QuestionMsgId, record.AnswerMsgId, record.Question, record.Answer, record.Content, record.Reasoning, record.Token, record.ReasoningToken,
+		record.PromptToken, record.CompletionToken, record.CachedToken, record.LLMType, record.Model, record.Cost, record.CreateTime, record.IsDeleted)
 	metrics.TotalRecords.Inc()
 	if err != nil {
 		logger.Error("insertRecord err", "err", err)
+	} else if id, err := res.LastInsertId(); err == nil {
+		record.ID = int(id)
 	}
 
 	user, err := GetUserByID(record.UserId)
@@ -198,13 +471,107 @@ func InsertRecordInfo(record *Record) {
 	}
 }
 
-// DeleteRecord delete record
-func DeleteRecord(userId int64) error {
-	query := `UPDATE records set is_deleted = 1 WHERE user_id = ?`
-	_, err := DB.Exec(query, userId)
+// DeleteRecord delete records of thread
+func DeleteRecord(key RecordKey) error {
+	if key.UserId == 0 {
+		_, err := DB.Exec(`UPDATE records set is_deleted = 1 WHERE chat_id = ? and session_id = ?`, key.ChatId, key.SessionId)
+		return err
+	}
+
+	query := `UPDATE records set is_deleted = 1 WHERE chat_id = ? and user_id = ? and session_id = ?`
+	_, err := DB.Exec(query, key.ChatId, key.UserId, key.SessionId)
+	return err
+}
+
+// deleteRecordsFrom delete record of question message and records after it in thread
+func deleteRecordsFrom(key RecordKey, questionMsgId int) error {
+	var id int64
+	err := DB.QueryRow(`SELECT id FROM records WHERE chat_id = ? and question_msg_id = ? and is_deleted = 0 order by id desc limit 1`,
+		key.ChatId, questionMsgId).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+
+	if key.UserId == 0 {
+		_, err = DB.Exec(`UPDATE records set is_deleted = 1 WHERE chat_id = ? and session_id = ? and id >= ?`,
+			key.ChatId, key.SessionId, id)
+		return err
+	}
+
+	_, err = DB.Exec(`UPDATE records set is_deleted = 1 WHERE chat_id = ? and user_id = ? and session_id = ? and id >= ?`,
+		key.ChatId, key.UserId, key.SessionId, id)
 	return err
 }
 
+// GetReasoningTokenByUserIdAndTime get reasoning token of user, it's part of token
+func GetReasoningTokenByUserIdAndTime(userId int64, start, end int64) (int, error) {
+	querySQL := `SELECT COALESCE(sum(reasoning_token), 0) FROM records WHERE user_id = ? and create_time >= ? and create_time <= ?`
+
+	var token int
+	err := DB.QueryRow(querySQL, userId, start, end).Scan(&token)
+	if err != nil {
+		return 0, err
+	}
+	return token, nil
+}
+
+// GetCostByUserIdAndTime get cost of user in USD
+func GetCostByUserIdAndTime(userId int64, start, end int64) (float64, error) {
+	querySQL := `SELECT COALESCE(sum(cost), 0) FROM records WHERE user_id = ? and create_time >= ? and create_time <= ?`
+
+	var cost float64
+	err := DB.QueryRow(querySQL, userId, start, end).Scan(&cost)
+	if err != nil {
+		return 0, err
+	}
+	return cost, nil
+}
+
+// GetCostGroupByUser get token and cost of every user, order by cost
+func GetCostGroupByUser(start, end int64, limit int) ([]*CostStat, error) {
+	querySQL := `SELECT user_id, COALESCE(sum(token), 0), COALESCE(sum(cost), 0) FROM records WHERE create_time >= ? and create_time <= ? group by user_id order by sum(cost) desc, sum(token) desc limit ?`
+	rows, err := DB.Query(querySQL, start, end, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make([]*CostStat, 0)
+	for rows.Next() {
+		stat := new(CostStat)
+		if err = rows.Scan(&stat.UserId, &stat.Token, &stat.Cost); err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+
+	return stats, rows.Err()
+}
+
+// GetCostGroupByModel get token and cost of every model, order by cost
+func GetCostGroupByModel(start, end int64, limit int) ([]*CostStat, error) {
+	querySQL := `SELECT llm_type, model, COALESCE(sum(token), 0), COALESCE(sum(cost), 0) FROM records WHERE create_time >= ? and create_time <= ? group by llm_type, model order by sum(cost) desc, sum(token) desc limit ?`
+	rows, err := DB.Query(querySQL, start, end, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make([]*CostStat, 0)
+	for rows.Next() {
+		stat := new(CostStat)
+		if err = rows.Scan(&stat.LLMType, &stat.Model, &stat.Token, &stat.Cost); err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+
+	return stats, rows.Err()
+}
+
 func GetTokenByUserIdAndTime(userId int64, start, end int64) (int, error) {
 	querySQL := `SELECT sum(token) FROM records WHERE user_id = ? and create_time >= ? and create_time <= ?`
 	row := DB.QueryRow(querySQL, userId, start, end)
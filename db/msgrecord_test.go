@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
@@ -26,7 +27,7 @@ func setup() {
 }
 
 func TestInsertMsgRecord(t *testing.T) {
-	userId := int64(1)
+	userId := NewRecordKey(1, 1)
 	MsgRecord = sync.Map{} // 清理数据
 
 	aq := &AQ{Question: "What is Go?", Answer: "A programming language."}
@@ -38,22 +39,23 @@ func TestInsertMsgRecord(t *testing.T) {
 	assert.Equal(t, "What is Go?", record.AQs[0].Question)
 }
 
-func TestInsertMsgRecord_ExceedLimit(t *testing.T) {
-	userId := int64(1)
+func TestInsertMsgRecord_NoCountLimit(t *testing.T) {
+	userId := NewRecordKey(1, 1)
 	MsgRecord = sync.Map{}
 
-	for i := 0; i < MaxQAPair+5; i++ {
+	for i := 0; i < 100; i++ {
 		aq := &AQ{Question: "Q" + strconv.Itoa(i), Answer: "A" + strconv.Itoa(i)}
 		InsertMsgRecord(userId, aq, false)
 	}
 
 	record := GetMsgRecord(userId)
 	assert.NotNil(t, record, "Record should not be nil")
-	assert.Equal(t, MaxQAPair, len(record.AQs), "Should keep max limit AQ pairs")
+	assert.Equal(t, 100, len(record.AQs), "AQs should be trimmed by context window of model, not by count")
+	assert.Equal(t, "Q0", record.AQs[0].Question)
 }
 
 func TestDeleteMsgRecord(t *testing.T) {
-	userId := int64(1)
+	userId := NewRecordKey(1, 1)
 	MsgRecord = sync.Map{} // 清理数据
 
 	aq := &AQ{Question: "Test Q", Answer: "Test A"}
@@ -71,6 +73,7 @@ func TestInsertRecordInfoAndGetRecords(t *testing.T) {
 
 	record := &Record{
 		UserId:    userId,
+		ChatId:    userId,
 		Question:  "What is AI?",
 		Answer:    "AI is Artificial Intelligence.",
 		Content:   "extra",
@@ -79,9 +82,9 @@ func TestInsertRecordInfoAndGetRecords(t *testing.T) {
 	}
 	InsertRecordInfo(record)
 
-	records, err := getRecordsByUserId(userId)
+	records, err := getRecordsByKey(NewRecordKey(userId, userId), 0)
 	if err != nil {
-		t.Fatalf("getRecordsByUserId failed: %v", err)
+		t.Fatalf("getRecordsByKey failed: %v", err)
 	}
 	if len(records) != 1 {
 		t.Fatalf("expected 1 record, got %d", len(records))
@@ -90,7 +93,7 @@ func TestInsertRecordInfoAndGetRecords(t *testing.T) {
 		t.Errorf("unexpected question: %s", records[0].Question)
 	}
 
-	DeleteMsgRecord(userId)
+	DeleteMsgRecord(NewRecordKey(userId, userId))
 }
 
 func TestDeleteRecord(t *testing.T) {
@@ -101,6 +104,7 @@ func TestDeleteRecord(t *testing.T) {
 	// 插入未删除记录
 	record := &Record{
 		UserId:    userId,
+		ChatId:    userId,
 		Question:  "Delete me?",
 		Answer:    "Yes",
 		Content:   "data",
@@ -110,7 +114,7 @@ func TestDeleteRecord(t *testing.T) {
 	InsertRecordInfo(record)
 
 	// 删除
-	err := DeleteRecord(userId)
+	err := DeleteRecord(NewRecordKey(userId, userId))
 	if err != nil {
 		t.Fatalf("DeleteRecord failed: %v", err)
 	}
@@ -127,3 +131,112 @@ func TestDeleteRecord(t *testing.T) {
 		}
 	}
 }
+
+func TestMsgRecordScopedByChat(t *testing.T) {
+	userId := int64(789)
+	groupId := int64(-100789)
+	MsgRecord = sync.Map{}
+
+	InsertMsgRecord(NewRecordKey(userId, userId), &AQ{Question: "private Q", Answer: "private A"}, false)
+	InsertMsgRecord(NewRecordKey(groupId, userId), &AQ{Question: "group Q", Answer: "group A"}, false)
+
+	record := GetMsgRecord(NewRecordKey(groupId, userId))
+	assert.NotNil(t, record, "Record should not be nil")
+	assert.Equal(t, 1, len(record.AQs), "Group thread should not contain private chat")
+	assert.Equal(t, "group Q", record.AQs[0].Question)
+
+	DeleteMsgRecord(NewRecordKey(groupId, userId))
+	assert.Nil(t, GetMsgRecord(NewRecordKey(groupId, userId)), "Group thread should be deleted")
+	assert.NotNil(t, GetMsgRecord(NewRecordKey(userId, userId)), "Private thread should be kept")
+}
+
+func TestNewRecordKey_SharedGroupHistory(t *testing.T) {
+	*conf.SharedGroupHistory = true
+	defer func() { *conf.SharedGroupHistory = false }()
+
+	assert.Equal(t, NewRecordKey(-100, 1), NewRecordKey(-100, 2), "Group members should share one thread")
+	assert.NotEqual(t, NewRecordKey(1, 1), NewRecordKey(2, 2), "Private chats should not be shared")
+}
+
+func TestDeleteMsgRecordFrom(t *testing.T) {
+	key := NewRecordKey(4001, 4001)
+	DeleteMsgRecord(key)
+	for i := 1; i <= 3; i++ {
+		InsertMsgRecord(key, &AQ{UserId: 4001, Question: "Q", Answer: "A", QuestionMsgId: i, AnswerMsgId: i + 100}, true)
+	}
+	assert.NotZero(t, GetMsgRecord(key).AQs[2].RecordId, "Record should be inserted before AQ is kept in memory")
+
+	assert.Nil(t, UpdateAnswerMsgIds(4001, 102, []int{103, 104}))
+	assert.Equal(t, []int{103, 104}, GetMsgRecord(key).AQs[1].AnswerMsgIds)
+
+	assert.Nil(t, DeleteMsgRecordFrom(key, 99), "Unknown question should not be deleted")
+
+	aq := DeleteMsgRecordFrom(key, 2)
+	assert.NotNil(t, aq)
+	assert.Equal(t, 102, aq.AnswerMsgId)
+	assert.Equal(t, []int{103, 104}, aq.AnswerMsgIds)
+	assert.Equal(t, 1, len(GetMsgRecord(key).AQs), "Edited question and later history should be removed")
+
+	records, err := getRecordsByKey(key, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, 1, records[0].QuestionMsgId)
+
+	DeleteMsgRecord(key)
+}
+
+func TestParseMsgIds(t *testing.T) {
+	assert.Equal(t, "", joinMsgIds(nil))
+	assert.Equal(t, "1,22", joinMsgIds([]int{1, 22}))
+	assert.Equal(t, []int{}, parseMsgIds(""))
+	assert.Equal(t, []int{1, 22}, parseMsgIds("1,22"))
+}
+
+func TestInsertMsgRecordWithReasoning(t *testing.T) {
+	key := NewRecordKey(4002, 4002)
+	aq := &AQ{UserId: 4002, Question: "Q", Answer: "A", Token: 30, Reasoning: "think", ReasoningToken: 20}
+	InsertMsgRecord(key, aq, true)
+	assert.Equal(t, "", GetMsgRecord(key).AQs[0].Reasoning, "Reasoning should not be kept as context")
+
+	assert.Eventually(t, func() bool {
+		token, err := GetReasoningTokenByUserIdAndTime(4002, 0, time.Now().Unix())
+		return err == nil && token == 20
+	}, time.Second, 10*time.Millisecond)
+
+	var reasoning string
+	err := DB.QueryRow(`SELECT reasoning FROM records WHERE user_id = ?`, 4002).Scan(&reasoning)
+	assert.Nil(t, err)
+	assert.Equal(t, "think", reasoning)
+
+	DeleteMsgRecord(key)
+}
+
+func TestGetCost(t *testing.T) {
+	userId := int64(2024)
+	InsertUser(userId, "default")
+
+	// records of long ago, other tests don't insert records in this time range
+	InsertRecordInfo(&Record{UserId: userId, ChatId: userId, Question: "q1", Token: 100, CreateTime: 1000,
+		Usage: Usage{LLMType: "openai", Model: "gpt-4o", PromptToken: 80, CompletionToken: 20, CachedToken: 10, Cost: 0.5}})
+	InsertRecordInfo(&Record{UserId: userId, ChatId: userId, Question: "q2", Token: 50, CreateTime: 1100,
+		Usage: Usage{LLMType: "deepseek", Model: "deepseek-chat", PromptToken: 40, CompletionToken: 10, Cost: 0.25}})
+	InsertRecordInfo(&Record{UserId: userId + 1, ChatId: userId + 1, Question: "q3", Token: 10, CreateTime: 1200,
+		Usage: Usage{LLMType: "openai", Model: "gpt-4o", PromptToken: 5, CompletionToken: 5, Cost: 1}})
+
+	cost, err := GetCostByUserIdAndTime(userId, 0, 2000)
+	assert.Nil(t, err)
+	assert.InDelta(t, 0.75, cost, 1e-9)
+
+	userStats, err := GetCostGroupByUser(0, 2000, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(userStats))
+	assert.Equal(t, userId+1, userStats[0].UserId)
+	assert.Equal(t, 150, userStats[1].Token)
+
+	modelStats, err := GetCostGroupByModel(0, 2000, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(modelStats))
+	assert.Equal(t, "gpt-4o", modelStats[0].Model)
+	assert.Equal(t, 110, modelStats[0].Token)
+	assert.InDelta(t, 1.5, modelStats[0].Cost, 1e-9)
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const (
+	// ParamsScopeUser params set by user, scope id is user id
+	ParamsScopeUser = "user"
+	// ParamsScopeGroup params set by group admin, scope id is chat id
+	ParamsScopeGroup = "group"
+	// ParamsScopeUserLimit max params which user can set, scope id is 0
+	ParamsScopeUserLimit = "user_limit"
+	// ParamsScopeGroupLimit max params which group admin can set, scope id is 0
+	ParamsScopeGroupLimit = "group_limit"
+)
+
+// SamplingParams sampling params of llm request, nil field isn't set and falls back to process-wide flag
+type SamplingParams struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"top_p,omitempty"`
+	MaxTokens        *int     `json:"max_tokens,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+}
+
+// GetSamplingParams get params of scope, return empty params if scope doesn't have one
+func GetSamplingParams(scope string, scopeId int64) (*SamplingParams, error) {
+	var data string
+	err := DB.QueryRow(`SELECT params FROM sampling_params WHERE scope = ? and scope_id = ?`, scope, scopeId).Scan(&data)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return new(SamplingParams), nil
+		}
+		return nil, err
+	}
+
+	params := new(SamplingParams)
+	if err = json.Unmarshal([]byte(data), params); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// SaveSamplingParams insert or update params of scope
+func SaveSamplingParams(scope string, scopeId int64, params *SamplingParams) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	var num int
+	err = DB.QueryRow(`SELECT COUNT(*) FROM sampling_params WHERE scope = ? and scope_id = ?`, scope, scopeId).Scan(&num)
+	if err != nil {
+		return err
+	}
+
+	if num > 0 {
+		_, err = DB.Exec(`UPDATE sampling_params SET params = ?, update_time = ? WHERE scope = ? and scope_id = ?`,
+			string(data), time.Now().Unix(), scope, scopeId)
+		return err
+	}
+
+	_, err = DB.Exec(`INSERT INTO sampling_params (scope, scope_id, params, update_time) VALUES (?, ?, ?, ?)`,
+		scope, scopeId, string(data), time.Now().Unix())
+	return err
+}
+
+// DeleteSamplingParams delete params of scope, process-wide flags are used again
+func DeleteSamplingParams(scope string, scopeId int64) error {
+	_, err := DB.Exec(`DELETE FROM sampling_params WHERE scope = ? and scope_id = ?`, scope, scopeId)
+	return err
+}
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSamplingParams(t *testing.T) {
+	params, err := GetSamplingParams(ParamsScopeUser, 9001)
+	assert.Nil(t, err)
+	assert.Nil(t, params.Temperature)
+
+	temperature, maxTokens := 0.3, 1024
+	assert.Nil(t, SaveSamplingParams(ParamsScopeUser, 9001, &SamplingParams{Temperature: &temperature}))
+	assert.Nil(t, SaveSamplingParams(ParamsScopeUser, 9001, &SamplingParams{Temperature: &temperature,
+		MaxTokens: &maxTokens, Stop: []string{"END"}}))
+
+	params, err = GetSamplingParams(ParamsScopeUser, 9001)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.3, *params.Temperature)
+	assert.Equal(t, 1024, *params.MaxTokens)
+	assert.Nil(t, params.TopP)
+	assert.Equal(t, []string{"END"}, params.Stop)
+
+	// same id in other scope is independent
+	params, err = GetSamplingParams(ParamsScopeGroup, 9001)
+	assert.Nil(t, err)
+	assert.Nil(t, params.Temperature)
+
+	assert.Nil(t, DeleteSamplingParams(ParamsScopeUser, 9001))
+	params, err = GetSamplingParams(ParamsScopeUser, 9001)
+	assert.Nil(t, err)
+	assert.Nil(t, params.MaxTokens)
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Persona struct {
+	ID         int64  `json:"id"`
+	Name       string `json:"name"`
+	Prompt     string `json:"prompt"`
+	UserId     int64  `json:"user_id"`
+	CreateTime int64  `json:"create_time"`
+	UpdateTime int64  `json:"update_time"`
+}
+
+// InsertPersona insert persona, userId is the creator
+func InsertPersona(name, prompt string, userId int64) (int64, error) {
+	insertSQL := `INSERT INTO personas (name, prompt, user_id, create_time, update_time) VALUES (?, ?, ?, ?, ?)`
+	result, err := DB.Exec(insertSQL, name, prompt, userId, time.Now().Unix(), time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// UpdatePersonaPrompt update prompt of persona
+func UpdatePersonaPrompt(id int64, prompt string) error {
+	updateSQL := `UPDATE personas SET prompt = ?, update_time = ? WHERE id = ?`
+	_, err := DB.Exec(updateSQL, prompt, time.Now().Unix(), id)
+	return err
+}
+
+// GetPersonaByName get persona by name
+func GetPersonaByName(name string) (*Persona, error) {
+	querySQL := `SELECT id, name, prompt, user_id, create_time, update_time FROM personas WHERE name = ? and is_deleted = 0`
+	return scanPersona(DB.QueryRow(querySQL, name))
+}
+
+// GetPersonaByID get persona by id
+func GetPersonaByID(id int64) (*Persona, error) {
+	querySQL := `SELECT id, name, prompt, user_id, create_time, update_time FROM personas WHERE id = ? and is_deleted = 0`
+	return scanPersona(DB.QueryRow(querySQL, id))
+}
+
+// GetPersonas get 100 personas order by name
+func GetPersonas() ([]*Persona, error) {
+	rows, err := DB.Query(`SELECT id, name, prompt, user_id, create_time, update_time FROM personas WHERE is_deleted = 0 order by name limit 100`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var personas []*Persona
+	for rows.Next() {
+		persona := new(Persona)
+		err := rows.Scan(&persona.ID, &persona.Name, &persona.Prompt, &persona.UserId, &persona.CreateTime, &persona.UpdateTime)
+		if err != nil {
+			return nil, err
+		}
+		personas = append(personas, persona)
+	}
+
+	return personas, rows.Err()
+}
+
+// GetChatPersona get persona selected by thread, return nil if thread doesn't select one
+func GetChatPersona(key RecordKey) (*Persona, error) {
+	querySQL := `SELECT p.id, p.name, p.prompt, p.user_id, p.create_time, p.update_time FROM chat_personas c
+		INNER JOIN personas p ON c.persona_id = p.id WHERE c.chat_id = ? and c.user_id = ? and p.is_deleted = 0`
+	return scanPersona(DB.QueryRow(querySQL, key.ChatId, key.UserId))
+}
+
+// SetChatPersona select persona for thread
+func SetChatPersona(key RecordKey, personaId int64) error {
+	var num int
+	err := DB.QueryRow(`SELECT COUNT(*) FROM chat_personas WHERE chat_id = ? and user_id = ?`, key.ChatId, key.UserId).Scan(&num)
+	if err != nil {
+		return err
+	}
+
+	if num > 0 {
+		updateSQL := `UPDATE chat_personas SET persona_id = ?, update_time = ? WHERE chat_id = ? and user_id = ?`
+		_, err = DB.Exec(updateSQL, personaId, time.Now().Unix(), key.ChatId, key.UserId)
+		return err
+	}
+
+	insertSQL := `INSERT INTO chat_personas (chat_id, user_id, persona_id, update_time) VALUES (?, ?, ?, ?)`
+	_, err = DB.Exec(insertSQL, key.ChatId, key.UserId, personaId, time.Now().Unix())
+	return err
+}
+
+// DeleteChatPersona reset thread to default system prompt
+func DeleteChatPersona(key RecordKey) error {
+	_, err := DB.Exec(`DELETE FROM chat_personas WHERE chat_id = ? and user_id = ?`, key.ChatId, key.UserId)
+	return err
+}
+
+func scanPersona(row *sql.Row) (*Persona, error) {
+	persona := new(Persona)
+	err := row.Scan(&persona.ID, &persona.Name, &persona.Prompt, &persona.UserId, &persona.CreateTime, &persona.UpdateTime)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return persona, nil
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChatPersona(t *testing.T) {
+	key := NewRecordKey(-1001, 1001)
+
+	id, err := InsertPersona("test_teacher", "You are a patient teacher.", 1001)
+	if err != nil {
+		t.Fatalf("InsertPersona failed: %v", err)
+	}
+
+	persona, err := GetPersonaByName("test_teacher")
+	assert.Nil(t, err)
+	assert.NotNil(t, persona, "Persona should exist")
+	assert.Equal(t, id, persona.ID)
+
+	// select twice should keep one selection
+	assert.Nil(t, SetChatPersona(key, id))
+	assert.Nil(t, SetChatPersona(key, id))
+
+	selected, err := GetChatPersona(key)
+	assert.Nil(t, err)
+	assert.NotNil(t, selected, "Chat should select persona")
+	assert.Equal(t, "You are a patient teacher.", selected.Prompt)
+
+	assert.Nil(t, UpdatePersonaPrompt(id, "You are a strict teacher."))
+	selected, _ = GetChatPersona(key)
+	assert.Equal(t, "You are a strict teacher.", selected.Prompt)
+
+	// other chat is not affected
+	other, err := GetChatPersona(NewRecordKey(1001, 1001))
+	assert.Nil(t, err)
+	assert.Nil(t, other, "Private chat should not select persona")
+
+	assert.Nil(t, DeleteChatPersona(key))
+	selected, err = GetChatPersona(key)
+	assert.Nil(t, err)
+	assert.Nil(t, selected, "Persona should be reset")
+
+	DB.Exec("DELETE FROM personas WHERE id = ?", id)
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// ResponseCache answer of prompt which is asked without history, it's returned when same prompt is asked again
+type ResponseCache struct {
+	ID         int64
+	CacheKey   string // hash of llm type, model, persona and normalized prompt
+	LLMType    string
+	Model      string
+	PersonaKey string // hash of system prompt
+	Prompt     string // normalized prompt
+	Answer     string
+	Embedding  []float32 // embedding of prompt, it's empty if embedder isn't configured
+	HitNum     int
+	CreateTime int64
+}
+
+// InsertResponseCache insert answer into cache
+func InsertResponseCache(cache *ResponseCache) error {
+	embedding := ""
+	if len(cache.Embedding) > 0 {
+		data, err := json.Marshal(cache.Embedding)
+		if err != nil {
+			return err
+		}
+		embedding = string(data)
+	}
+	if cache.CreateTime == 0 {
+		cache.CreateTime = time.Now().Unix()
+	}
+
+	insertSQL := `INSERT INTO response_cache (cache_key, llm_type, model, persona_key, prompt, answer, embedding, hit_num, create_time) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := DB.Exec(insertSQL, cache.CacheKey, cache.LLMType, cache.Model, cache.PersonaKey, cache.Prompt, cache.Answer,
+		embedding, cache.HitNum, cache.CreateTime)
+	if err != nil {
+		return err
+	}
+	cache.ID, err = res.LastInsertId()
+	return err
+}
+
+// GetResponseCache get latest cache of key which is created after time, return nil if it doesn't exist
+func GetResponseCache(cacheKey string, after int64) (*ResponseCache, error) {
+	querySQL := `SELECT id, cache_key, llm_type, model, persona_key, prompt, answer, embedding, hit_num, create_time FROM response_cache WHERE cache_key = ? and create_time > ? order by id desc limit 1`
+	caches, err := queryResponseCaches(querySQL, cacheKey, after)
+	if err != nil {
+		return nil, err
+	}
+	if len(caches) == 0 {
+		return nil, nil
+	}
+	return caches[0], nil
+}
+
+// GetResponseCachesWithEmbedding get latest caches of llm, model and persona which have embedding, they are compared by similarity
+func GetResponseCachesWithEmbedding(llmType, model, personaKey string, after int64, limit int) ([]*ResponseCache, error) {
+	querySQL := `SELECT id, cache_key, llm_type, model, persona_key, prompt, answer, embedding, hit_num, create_time FROM response_cache WHERE llm_type = ? and model = ? and persona_key = ? and embedding != '' and create_time > ? order by id desc limit ?`
+	return queryResponseCaches(querySQL, llmType, model, personaKey, after, limit)
+}
+
+func queryResponseCaches(query string, args ...interface{}) ([]*ResponseCache, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	caches := make([]*ResponseCache, 0)
+	for rows.Next() {
+		cache := new(ResponseCache)
+		var embedding string
+		err = rows.Scan(&cache.ID, &cache.CacheKey, &cache.LLMType, &cache.Model, &cache.PersonaKey, &cache.Prompt,
+			&cache.Answer, &embedding, &cache.HitNum, &cache.CreateTime)
+		if err != nil {
+			return nil, err
+		}
+		if embedding != "" {
+			if err = json.Unmarshal([]byte(embedding), &cache.Embedding); err != nil {
+				return nil, err
+			}
+		}
+		caches = append(caches, cache)
+	}
+
+	return caches, rows.Err()
+}
+
+// AddResponseCacheHit add hit number of cache
+func AddResponseCacheHit(id int64) error {
+	_, err := DB.Exec(`UPDATE response_cache SET hit_num = hit_num + 1 WHERE id = ?`, id)
+	return err
+}
+
+// CountResponseCache get number and hit number of caches created after time
+func CountResponseCache(after int64) (int, int, error) {
+	var num, hitNum int
+	err := DB.QueryRow(`SELECT COUNT(*), COALESCE(sum(hit_num), 0) FROM response_cache WHERE create_time > ?`, after).Scan(&num, &hitNum)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, 0, err
+	}
+	return num, hitNum, nil
+}
+
+// DeleteResponseCache delete caches of normalized prompt in every llm and model, all caches are deleted if prompt is empty
+func DeleteResponseCache(prompt string) (int64, error) {
+	var res sql.Result
+	var err error
+	if prompt == "" {
+		res, err = DB.Exec(`DELETE FROM response_cache`)
+	} else {
+		res, err = DB.Exec(`DELETE FROM response_cache WHERE prompt = ?`, prompt)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteResponseCacheBefore delete expired caches
+func DeleteResponseCacheBefore(before int64) error {
+	_, err := DB.Exec(`DELETE FROM response_cache WHERE create_time <= ?`, before)
+	return err
+}
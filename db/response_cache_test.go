@@ -0,0 +1,46 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseCache(t *testing.T) {
+	defer DeleteResponseCache("")
+
+	cache := &ResponseCache{CacheKey: "key1", LLMType: "openai", Model: "gpt-4o", PersonaKey: "p1",
+		Prompt: "what is go", Answer: "a language", Embedding: []float32{0.1, 0.2}}
+	assert.Nil(t, InsertResponseCache(cache))
+	assert.NotEqual(t, int64(0), cache.ID)
+	assert.Nil(t, InsertResponseCache(&ResponseCache{CacheKey: "key2", LLMType: "openai", Model: "gpt-4o", PersonaKey: "p1",
+		Prompt: "what is rust", Answer: "another language", CreateTime: 100}))
+
+	res, err := GetResponseCache("key1", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "a language", res.Answer)
+	assert.Equal(t, []float32{0.1, 0.2}, res.Embedding)
+
+	// expired cache isn't returned
+	res, err = GetResponseCache("key2", 100)
+	assert.Nil(t, err)
+	assert.Nil(t, res)
+
+	caches, err := GetResponseCachesWithEmbedding("openai", "gpt-4o", "p1", 0, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(caches))
+
+	assert.Nil(t, AddResponseCacheHit(cache.ID))
+	num, hitNum, err := CountResponseCache(0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, num)
+	assert.Equal(t, 1, hitNum)
+
+	assert.Nil(t, DeleteResponseCacheBefore(100))
+	deleted, err := DeleteResponseCache("what is go")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), deleted)
+	num, _, err = CountResponseCache(0)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, num)
+}
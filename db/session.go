@@ -0,0 +1,116 @@
+package db
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+)
+
+// Session named conversation thread of user in chat, session 0 is the default session which has no row.
+type Session struct {
+	ID         int64  `json:"id"`
+	ChatId     int64  `json:"chat_id"`
+	UserId     int64  `json:"user_id"`
+	Title      string `json:"title"`
+	IsActive   int    `json:"is_active"`
+	CreateTime int64  `json:"create_time"`
+	UpdateTime int64  `json:"update_time"`
+}
+
+// activeSessions cache active session id of chat key
+var activeSessions = sync.Map{}
+
+// InsertSession create session in chat
+func InsertSession(chatKey RecordKey, title string) (int64, error) {
+	insertSQL := `INSERT INTO sessions (chat_id, user_id, title, create_time, update_time) VALUES (?, ?, ?, ?, ?)`
+	result, err := DB.Exec(insertSQL, chatKey.ChatId, chatKey.UserId, title, time.Now().Unix(), time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// GetSessions get latest 50 sessions in chat
+func GetSessions(chatKey RecordKey) ([]*Session, error) {
+	querySQL := `SELECT id, chat_id, user_id, title, is_active, create_time, update_time FROM sessions
+		WHERE chat_id = ? and user_id = ? and is_deleted = 0 order by id desc limit 50`
+	rows, err := DB.Query(querySQL, chatKey.ChatId, chatKey.UserId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		session := new(Session)
+		err := rows.Scan(&session.ID, &session.ChatId, &session.UserId, &session.Title, &session.IsActive,
+			&session.CreateTime, &session.UpdateTime)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// GetSessionByID get session by id, return nil if session doesn't belong to chat key
+func GetSessionByID(chatKey RecordKey, id int64) (*Session, error) {
+	querySQL := `SELECT id, chat_id, user_id, title, is_active, create_time, update_time FROM sessions
+		WHERE id = ? and chat_id = ? and user_id = ? and is_deleted = 0`
+	session := new(Session)
+	err := DB.QueryRow(querySQL, id, chatKey.ChatId, chatKey.UserId).Scan(&session.ID, &session.ChatId,
+		&session.UserId, &session.Title, &session.IsActive, &session.CreateTime, &session.UpdateTime)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return session, nil
+}
+
+// UpdateSessionTitle update title of session
+func UpdateSessionTitle(id int64, title string) error {
+	_, err := DB.Exec(`UPDATE sessions SET title = ?, update_time = ? WHERE id = ?`, title, time.Now().Unix(), id)
+	return err
+}
+
+// SetActiveSession switch chat key to session, 0 means the default session
+func SetActiveSession(chatKey RecordKey, id int64) error {
+	_, err := DB.Exec(`UPDATE sessions SET is_active = 0 WHERE chat_id = ? and user_id = ?`, chatKey.ChatId, chatKey.UserId)
+	if err != nil {
+		return err
+	}
+
+	if id != 0 {
+		_, err = DB.Exec(`UPDATE sessions SET is_active = 1, update_time = ? WHERE id = ?`, time.Now().Unix(), id)
+		if err != nil {
+			return err
+		}
+	}
+
+	activeSessions.Store(chatKey, id)
+	return nil
+}
+
+// GetActiveSessionId get active session id of chat key, return 0 if user doesn't switch to any session
+func GetActiveSessionId(chatKey RecordKey) int64 {
+	if id, ok := activeSessions.Load(chatKey); ok {
+		return id.(int64)
+	}
+
+	var id int64
+	err := DB.QueryRow(`SELECT id FROM sessions WHERE chat_id = ? and user_id = ? and is_active = 1 and is_deleted = 0`,
+		chatKey.ChatId, chatKey.UserId).Scan(&id)
+	if err != nil && err != sql.ErrNoRows {
+		logger.Error("get active session fail", "err", err)
+		return 0
+	}
+
+	activeSessions.Store(chatKey, id)
+	return id
+}
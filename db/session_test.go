@@ -0,0 +1,71 @@
+package db
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSession(t *testing.T) {
+	chatKey := NewChatKey(3001, 3001)
+	assert.Equal(t, int64(0), NewRecordKey(3001, 3001).SessionId, "Default session should be active")
+
+	id, err := InsertSession(chatKey, "")
+	if err != nil {
+		t.Fatalf("InsertSession failed: %v", err)
+	}
+	assert.Nil(t, SetActiveSession(chatKey, id))
+	assert.Equal(t, id, NewRecordKey(3001, 3001).SessionId)
+
+	// active session is loaded from db after restart
+	activeSessions = sync.Map{}
+	assert.Equal(t, id, GetActiveSessionId(chatKey))
+
+	assert.Nil(t, UpdateSessionTitle(id, "golang"))
+	session, err := GetSessionByID(chatKey, id)
+	assert.Nil(t, err)
+	assert.Equal(t, "golang", session.Title)
+
+	// session of other user is invisible
+	session, err = GetSessionByID(NewChatKey(3002, 3002), id)
+	assert.Nil(t, err)
+	assert.Nil(t, session)
+
+	sessions, err := GetSessions(chatKey)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, sessions)
+	assert.Equal(t, id, sessions[0].ID, "Latest session should be listed first")
+
+	assert.Nil(t, SetActiveSession(chatKey, 0))
+	activeSessions = sync.Map{}
+	assert.Equal(t, int64(0), GetActiveSessionId(chatKey))
+}
+
+func TestMsgRecordScopedBySession(t *testing.T) {
+	defaultKey := NewChatKey(3003, 3003)
+	sessionKey := defaultKey
+	sessionKey.SessionId = 1
+
+	InsertMsgRecord(defaultKey, &AQ{UserId: 3003, Question: "default Q", Answer: "A"}, true)
+	InsertMsgRecord(sessionKey, &AQ{UserId: 3003, Question: "session Q", Answer: "A"}, true)
+
+	assert.Equal(t, "default Q", GetMsgRecord(defaultKey).AQs[0].Question)
+	assert.Equal(t, "session Q", GetMsgRecord(sessionKey).AQs[0].Question)
+
+	for _, key := range []RecordKey{defaultKey, sessionKey} {
+		assert.Eventually(t, func() bool {
+			records, err := getRecordsByKey(key, 0)
+			return err == nil && len(records) == 1 && records[0].Question == GetMsgRecord(key).AQs[0].Question
+		}, time.Second, 10*time.Millisecond)
+	}
+
+	// clear only affects active session
+	DeleteMsgRecord(sessionKey)
+	records, err := getRecordsByKey(defaultKey, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(records))
+
+	DeleteMsgRecord(defaultKey)
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Summary compressed memory of the oldest AQs in thread
+type Summary struct {
+	ChatId       int64  `json:"chat_id"`
+	UserId       int64  `json:"user_id"`
+	SessionId    int64  `json:"session_id"`
+	Summary      string `json:"summary"`
+	LastRecordId int64  `json:"last_record_id"` // record id of the latest AQ in summary
+	UpdateTime   int64  `json:"update_time"`
+}
+
+// GetSummary get summary of thread, return nil if thread doesn't have one
+func GetSummary(key RecordKey) (*Summary, error) {
+	querySQL := `SELECT chat_id, user_id, session_id, summary, last_record_id, update_time FROM summaries WHERE chat_id = ? and user_id = ? and session_id = ?`
+	summary := new(Summary)
+	err := DB.QueryRow(querySQL, key.ChatId, key.UserId, key.SessionId).Scan(&summary.ChatId, &summary.UserId,
+		&summary.SessionId, &summary.Summary, &summary.LastRecordId, &summary.UpdateTime)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return summary, nil
+}
+
+// SaveSummary insert or update summary of thread
+func SaveSummary(key RecordKey, summary string, lastRecordId int64) error {
+	var num int
+	err := DB.QueryRow(`SELECT COUNT(*) FROM summaries WHERE chat_id = ? and user_id = ? and session_id = ?`,
+		key.ChatId, key.UserId, key.SessionId).Scan(&num)
+	if err != nil {
+		return err
+	}
+
+	if num > 0 {
+		updateSQL := `UPDATE summaries SET summary = ?, last_record_id = ?, update_time = ? WHERE chat_id = ? and user_id = ? and session_id = ?`
+		_, err = DB.Exec(updateSQL, summary, lastRecordId, time.Now().Unix(), key.ChatId, key.UserId, key.SessionId)
+		return err
+	}
+
+	insertSQL := `INSERT INTO summaries (chat_id, user_id, session_id, summary, last_record_id, update_time) VALUES (?, ?, ?, ?, ?, ?)`
+	_, err = DB.Exec(insertSQL, key.ChatId, key.UserId, key.SessionId, summary, lastRecordId, time.Now().Unix())
+	return err
+}
+
+// DeleteSummary delete summary of thread
+func DeleteSummary(key RecordKey) error {
+	_, err := DB.Exec(`DELETE FROM summaries WHERE chat_id = ? and user_id = ? and session_id = ?`, key.ChatId, key.UserId, key.SessionId)
+	return err
+}
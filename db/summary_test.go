@@ -0,0 +1,72 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummary(t *testing.T) {
+	key := NewRecordKey(2002, 2002)
+
+	summary, err := GetSummary(key)
+	assert.Nil(t, err)
+	assert.Nil(t, summary, "Thread should not have summary")
+
+	// save twice should keep one summary
+	assert.Nil(t, SaveSummary(key, "user likes go", 100))
+	assert.Nil(t, SaveSummary(key, "user likes go and rust", 200))
+
+	summary, err = GetSummary(key)
+	assert.Nil(t, err)
+	assert.NotNil(t, summary, "Thread should have summary")
+	assert.Equal(t, "user likes go and rust", summary.Summary)
+	assert.Equal(t, int64(200), summary.LastRecordId)
+
+	assert.Nil(t, DeleteSummary(key))
+	summary, err = GetSummary(key)
+	assert.Nil(t, err)
+	assert.Nil(t, summary, "Summary should be deleted")
+}
+
+func TestDeleteMsgRecordBefore(t *testing.T) {
+	key := NewRecordKey(2003, 2003)
+	// AQs in the same second are told apart by record id
+	aqs := make([]*AQ, 0, 3)
+	for i := 0; i < 3; i++ {
+		aq := &AQ{UserId: 2003, Question: "Q", Answer: "A", CreateTime: 100}
+		InsertMsgRecord(key, aq, true)
+		aqs = append(aqs, aq)
+	}
+	assert.Greater(t, aqs[2].RecordId, aqs[1].RecordId)
+
+	DeleteMsgRecordBefore(key, aqs[1].RecordId)
+	record := GetMsgRecord(key)
+	assert.Equal(t, 1, len(record.AQs))
+	assert.Equal(t, aqs[2].RecordId, record.AQs[0].RecordId)
+
+	// records in summary aren't loaded from db
+	records, err := getRecordsByKey(key, aqs[1].RecordId)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(records))
+
+	DeleteMsgRecord(key)
+}
+
+func TestGetRecordsByKey_AllUnsummarized(t *testing.T) {
+	key := NewRecordKey(2004, 2004)
+	aqs := make([]*AQ, 0, 50)
+	for i := 0; i < 50; i++ {
+		aq := &AQ{UserId: 2004, Question: "Q", Answer: "A"}
+		InsertMsgRecord(key, aq, true)
+		aqs = append(aqs, aq)
+	}
+	assert.Equal(t, 50, len(GetMsgRecord(key).AQs), "Old AQs should stay until they are summarized")
+
+	// every record after summary is loaded after restart, so it can be summarized later
+	records, err := getRecordsByKey(key, aqs[4].RecordId)
+	assert.Nil(t, err)
+	assert.Equal(t, 45, len(records))
+
+	DeleteMsgRecord(key)
+}
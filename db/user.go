@@ -15,8 +15,18 @@ type User struct {
 	Token      int    `json:"token"`
 	Updatetime int64  `json:"updatetime"`
 	AvailToken int    `json:"avail_token"`
+
+	ShowReasoning int    `json:"show_reasoning"`
+	LLMType       string `json:"llm_type"`
+	ReplyMode     string `json:"reply_mode"`
 }
 
+const (
+	ReplyModeText  = "text"
+	ReplyModeVoice = "voice"
+	ReplyModeBoth  = "both"
+)
+
 // InsertUser insert user data
 func InsertUser(userId int64, mode string) (int64, error) {
 	// insert data
@@ -38,12 +48,12 @@ func InsertUser(userId int64, mode string) (int64, error) {
 // GetUserByID get user by userId
 func GetUserByID(userId int64) (*User, error) {
 	// select one use base on name
-	querySQL := `SELECT id, user_id, mode, token, avail_token, updatetime FROM users WHERE user_id = ?`
+	querySQL := `SELECT id, user_id, mode, token, avail_token, updatetime, show_reasoning, llm_type, reply_mode FROM users WHERE user_id = ?`
 	row := DB.QueryRow(querySQL, userId)
 
 	// scan row get result
 	var user User
-	err := row.Scan(&user.ID, &user.UserId, &user.Mode, &user.Token, &user.AvailToken, &user.Updatetime)
+	err := row.Scan(&user.ID, &user.UserId, &user.Mode, &user.Token, &user.AvailToken, &user.Updatetime, &user.ShowReasoning, &user.LLMType, &user.ReplyMode)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// 如果没有找到数据，返回 nil
@@ -85,6 +95,27 @@ func UpdateUserMode(userId int64, mode string) error {
 	return err
 }
 
+// UpdateUserLLMType update llm type of user, mode is reset to default model of the type
+func UpdateUserLLMType(userId int64, llmType string) error {
+	updateSQL := `UPDATE users SET llm_type = ?, mode = '' WHERE user_id = ?`
+	_, err := DB.Exec(updateSQL, llmType, userId)
+	return err
+}
+
+// UpdateUserShowReasoning turn reasoning message of user on or off
+func UpdateUserShowReasoning(userId int64, showReasoning int) error {
+	updateSQL := `UPDATE users SET show_reasoning = ? WHERE user_id = ?`
+	_, err := DB.Exec(updateSQL, showReasoning, userId)
+	return err
+}
+
+// UpdateUserReplyMode set whether answer of user is sent in text, voice or both
+func UpdateUserReplyMode(userId int64, replyMode string) error {
+	updateSQL := `UPDATE users SET reply_mode = ? WHERE user_id = ?`
+	_, err := DB.Exec(updateSQL, replyMode, userId)
+	return err
+}
+
 // UpdateUserUpdateTime update user updateTime
 func UpdateUserUpdateTime(userId int64, updateTime int64) error {
 	updateSQL := `UPDATE users SET updatetime = ? WHERE user_id = ?`
@@ -22,7 +22,10 @@ func setupTestDB(t *testing.T) {
 		mode TEXT,
 		token INTEGER DEFAULT 0,
 		updatetime INTEGER,
-		avail_token INTEGER DEFAULT 0
+		avail_token INTEGER DEFAULT 0,
+		show_reasoning INTEGER DEFAULT 0,
+		llm_type TEXT DEFAULT '',
+		reply_mode TEXT DEFAULT ''
 	);`
 	_, err = DB.Exec(createTableSQL)
 	if err != nil {
@@ -100,4 +103,29 @@ func TestInsertAndGetUser(t *testing.T) {
 		t.Errorf("unexpected user data: %+v", user)
 	}
 
+	err = UpdateUserLLMType(user.UserId, "gemini")
+	if err != nil {
+		t.Fatalf("UpdateUserLLMType failed: %v", err)
+	}
+
+	user, err = GetUserByID(userId)
+	if err != nil {
+		t.Fatalf("GetUserByID failed: %v", err)
+	}
+	if user.LLMType != "gemini" || user.Mode != "" {
+		t.Errorf("unexpected user llm type: %+v", user)
+	}
+
+	err = UpdateUserReplyMode(user.UserId, ReplyModeVoice)
+	if err != nil {
+		t.Fatalf("UpdateUserReplyMode failed: %v", err)
+	}
+
+	user, err = GetUserByID(userId)
+	if err != nil {
+		t.Fatalf("GetUserByID failed: %v", err)
+	}
+	if user.ReplyMode != ReplyModeVoice {
+		t.Errorf("unexpected user reply mode: %+v", user)
+	}
 }
@@ -32,7 +32,8 @@ type AIRouterReq struct {
 func (d *AIRouterReq) CallLLMAPI(ctx context.Context, prompt string, l *LLM) error {
 	_, _, userId := utils.GetChatIdAndMsgIdAndUserID(l.Update)
 
-	d.GetMessages(userId, prompt)
+	d.GetModel(l)
+	d.GetMessages(l, prompt)
 
 	logger.Info("msg receive", "userID", userId, "prompt", prompt)
 
@@ -40,75 +41,104 @@ func (d *AIRouterReq) CallLLMAPI(ctx context.Context, prompt string, l *LLM) err
 }
 
 func (d *AIRouterReq) GetModel(l *LLM) {
+	if l.useForceModel() {
+		return
+	}
 	_, _, userId := utils.GetChatIdAndMsgIdAndUserID(l.Update)
 	l.Model = param.DeepseekDeepseekR1_0528Free
 	userInfo, err := db.GetUserByID(userId)
 	if err != nil {
 		logger.Error("Error getting user info", "err", err)
 	}
-	if userInfo != nil && userInfo.Mode != "" && param.OpenRouterModels[userInfo.Mode] {
+	if userInfo != nil && userInfo.Mode != "" && IsModelOf(param.OpenRouter, userInfo.Mode) {
 		logger.Info("User info", "userID", userInfo.UserId, "mode", userInfo.Mode)
 		l.Model = userInfo.Mode
 	}
 }
 
-func (d *AIRouterReq) GetMessages(userId int64, prompt string) {
+func (d *AIRouterReq) GetMessages(l *LLM, prompt string) {
+	key := l.RecordKey
 	messages := make([]openrouter.ChatCompletionMessage, 0)
 
-	msgRecords := db.GetMsgRecord(userId)
-	if msgRecords != nil {
-		aqs := msgRecords.AQs
-		if len(aqs) > 10 {
-			aqs = aqs[len(aqs)-10:]
-		}
+	systemPrompt := getSystemPrompt(key)
+	if systemPrompt != "" {
+		messages = append(messages, openrouter.ChatCompletionMessage{
+			Role: constants.ChatMessageRoleSystem,
+			Content: openrouter.Content{
+				Multi: []openrouter.ChatMessagePart{
+					{
+						Type: openrouter.ChatMessagePartTypeText,
+						Text: systemPrompt,
+					},
+				},
+			},
+		})
+	}
+
+	summary, aqs := l.getContext(key, l.OpenRouterTools, systemPrompt, prompt)
+	if summary != "" {
+		messages = append(messages, openrouter.ChatCompletionMessage{
+			Role: constants.ChatMessageRoleSystem,
+			Content: openrouter.Content{
+				Multi: []openrouter.ChatMessagePart{
+					{
+						Type: openrouter.ChatMessagePartTypeText,
+						Text: getMemoryPrompt(summary),
+					},
+				},
+			},
+		})
+	}
 
-		for i, record := range aqs {
-			if record.Answer != "" && record.Question != "" {
-				logger.Info("context content", "dialog", i, "question:", record.Question,
-					"toolContent", record.Content, "answer:", record.Answer)
-				messages = append(messages, openrouter.ChatCompletionMessage{
-					Role: constants.ChatMessageRoleUser,
-					Content: openrouter.Content{
-						Multi: []openrouter.ChatMessagePart{
-							{
-								Type: openrouter.ChatMessagePartTypeText,
-								Text: record.Question,
-							},
+	for i, record := range aqs {
+		if record.Answer != "" && record.Question != "" {
+			logger.Info("context content", "dialog", i, "question:", record.Question,
+				"toolContent", record.Content, "answer:", record.Answer)
+			messages = append(messages, openrouter.ChatCompletionMessage{
+				Role: constants.ChatMessageRoleUser,
+				Content: openrouter.Content{
+					Multi: []openrouter.ChatMessagePart{
+						{
+							Type: openrouter.ChatMessagePartTypeText,
+							Text: record.Question,
 						},
 					},
-				})
-				if record.Content != "" {
-					toolsMsgs := make([]openrouter.ChatCompletionMessage, 0)
-					err := json.Unmarshal([]byte(record.Content), &toolsMsgs)
-					if err != nil {
-						logger.Error("Error unmarshalling tools json", "err", err)
-					} else {
-						messages = append(messages, toolsMsgs...)
-					}
-				}
-				messages = append(messages, openrouter.ChatCompletionMessage{
-					Role: constants.ChatMessageRoleAssistant,
-					Content: openrouter.Content{
-						Multi: []openrouter.ChatMessagePart{
-							{
-								Type: openrouter.ChatMessagePartTypeText,
-								Text: record.Answer,
-							},
+				},
+			})
+			messages = append(messages, toOpenRouterMessages(unmarshalToolMessages(record.Content))...)
+			messages = append(messages, openrouter.ChatCompletionMessage{
+				Role: constants.ChatMessageRoleAssistant,
+				Content: openrouter.Content{
+					Multi: []openrouter.ChatMessagePart{
+						{
+							Type: openrouter.ChatMessagePartTypeText,
+							Text: record.Answer,
 						},
 					},
-				})
-			}
+				},
+			})
 		}
 	}
+
+	// images of question are only sent in this turn
+	parts := []openrouter.ChatMessagePart{
+		{
+			Type: openrouter.ChatMessagePartTypeText,
+			Text: prompt,
+		},
+	}
+	for _, image := range l.getRequestImages() {
+		parts = append(parts, openrouter.ChatMessagePart{
+			Type: openrouter.ChatMessagePartTypeImageURL,
+			ImageURL: &openrouter.ChatMessageImageURL{
+				URL: imageDataUrl(image),
+			},
+		})
+	}
 	messages = append(messages, openrouter.ChatCompletionMessage{
 		Role: constants.ChatMessageRoleUser,
 		Content: openrouter.Content{
-			Multi: []openrouter.ChatMessagePart{
-				{
-					Type: openrouter.ChatMessagePartTypeText,
-					Text: prompt,
-				},
-			},
+			Multi: parts,
 		},
 	})
 
@@ -129,20 +159,21 @@ func (d *AIRouterReq) Send(ctx context.Context, l *LLM) error {
 	config.HTTPClient = utils.GetDeepseekProxyClient()
 	client := openrouter.NewClientWithConfig(*config)
 
+	params := l.getParams()
 	request := openrouter.ChatCompletionRequest{
 		Model:  l.Model,
 		Stream: true,
 		StreamOptions: &openrouter.StreamOptions{
 			IncludeUsage: true,
 		},
-		MaxTokens:        *conf.MaxTokens,
-		TopP:             float32(*conf.TopP),
-		FrequencyPenalty: float32(*conf.FrequencyPenalty),
+		MaxTokens:        params.MaxTokens,
+		TopP:             float32(params.TopP),
+		FrequencyPenalty: float32(params.FrequencyPenalty),
 		TopLogProbs:      *conf.TopLogProbs,
 		LogProbs:         *conf.LogProbs,
-		Stop:             conf.Stop,
-		PresencePenalty:  float32(*conf.PresencePenalty),
-		Temperature:      float32(*conf.Temperature),
+		Stop:             params.Stop,
+		PresencePenalty:  float32(params.PresencePenalty),
+		Temperature:      float32(params.Temperature),
 		Tools:            l.OpenRouterTools,
 	}
 
@@ -158,6 +189,8 @@ func (d *AIRouterReq) Send(ctx context.Context, l *LLM) error {
 		SendLen: FirstSendLen,
 	}
 
+	// openrouter usage has no reasoning token, estimate it by reasoning of this round
+	reasoningLen := len(l.ReasoningContent)
 	hasTools := false
 	for {
 		response, err := stream.Recv()
@@ -167,11 +200,16 @@ func (d *AIRouterReq) Send(ctx context.Context, l *LLM) error {
 		}
 		if err != nil {
 			logger.Warn("Stream error", "updateMsgID", updateMsgID, "err", err)
+			// nothing is answered, let next llm of fallback chain take over
+			if l.canFallback(ctx) {
+				return err
+			}
 			break
 		}
 		for _, choice := range response.Choices {
 			if len(choice.Delta.ToolCalls) > 0 {
 				hasTools = true
+				l.answered.Store(true)
 				err = d.requestToolsCall(ctx, choice)
 				if err != nil {
 					if errors.Is(err, ToolsJsonErr) {
@@ -182,6 +220,12 @@ func (d *AIRouterReq) Send(ctx context.Context, l *LLM) error {
 				}
 			}
 
+			if choice.Delta.Reasoning != nil && len(*choice.Delta.Reasoning) > 0 {
+				l.sendReasoningMsg(*choice.Delta.Reasoning)
+			} else if len(choice.Delta.ReasoningContent) > 0 {
+				l.sendReasoningMsg(choice.Delta.ReasoningContent)
+			}
+
 			if len(choice.Delta.Content) > 0 {
 				msgInfoContent = l.sendMsg(msgInfoContent, choice.Delta.Content)
 			}
@@ -189,19 +233,30 @@ func (d *AIRouterReq) Send(ctx context.Context, l *LLM) error {
 
 		if response.Usage != nil {
 			l.Token += response.Usage.TotalTokens
+			l.addUsage(response.Usage.PromptTokens, response.Usage.CompletionTokens, 0)
 			metrics.TotalTokens.Add(float64(l.Token))
 		}
 	}
 
+	l.ReasoningToken += utils.EstimateToken(l.ReasoningContent[reasoningLen:])
+	l.flushReasoningMsg()
+	msgInfoContent = l.appendStopNote(ctx, msgInfoContent)
 	if len(strings.TrimRightFunc(msgInfoContent.Content, unicode.IsSpace)) > 0 {
 		l.MessageChan <- msgInfoContent
 	}
 
-	if !hasTools || len(d.CurrentToolMessage) == 0 {
-		db.InsertMsgRecord(userId, &db.AQ{
-			Question: l.Content,
-			Answer:   l.WholeContent,
-			Token:    l.Token,
+	if !hasTools || len(d.CurrentToolMessage) == 0 || utils.IsRequestStopped(ctx) {
+		db.InsertMsgRecord(l.RecordKey, &db.AQ{
+			UserId:         userId,
+			Question:       l.Content,
+			Answer:         l.WholeContent,
+			Content:        marshalToolMessages(fromOpenRouterMessages(d.ToolMessage)),
+			Token:          l.Token,
+			QuestionMsgId:  updateMsgID,
+			AnswerMsgId:    l.AnswerMsgId,
+			Reasoning:      l.ReasoningContent,
+			ReasoningToken: l.ReasoningToken,
+			Usage:          l.getUsage(),
 		}, true)
 	} else {
 		d.CurrentToolMessage = append([]openrouter.ChatCompletionMessage{
@@ -282,16 +337,17 @@ func (d *AIRouterReq) SyncSend(ctx context.Context, l *LLM) (string, error) {
 	config.HTTPClient = utils.GetDeepseekProxyClient()
 	client := openrouter.NewClientWithConfig(*config)
 
+	params := l.getParams()
 	request := openrouter.ChatCompletionRequest{
 		Model:            l.Model,
-		MaxTokens:        *conf.MaxTokens,
-		TopP:             float32(*conf.TopP),
-		FrequencyPenalty: float32(*conf.FrequencyPenalty),
+		MaxTokens:        params.MaxTokens,
+		TopP:             float32(params.TopP),
+		FrequencyPenalty: float32(params.FrequencyPenalty),
 		TopLogProbs:      *conf.TopLogProbs,
 		LogProbs:         *conf.LogProbs,
-		Stop:             conf.Stop,
-		PresencePenalty:  float32(*conf.PresencePenalty),
-		Temperature:      float32(*conf.Temperature),
+		Stop:             params.Stop,
+		PresencePenalty:  float32(params.PresencePenalty),
+		Temperature:      float32(params.Temperature),
 		Tools:            l.OpenRouterTools,
 		Messages:         d.OpenRouterMsgs,
 	}
@@ -309,6 +365,7 @@ func (d *AIRouterReq) SyncSend(ctx context.Context, l *LLM) (string, error) {
 	}
 
 	l.Token += response.Usage.TotalTokens
+	l.addUsage(response.Usage.PromptTokens, response.Usage.CompletionTokens, 0)
 	if len(response.Choices[0].Message.ToolCalls) > 0 {
 		d.GetAssistantMessage("")
 		d.OpenRouterMsgs[len(d.OpenRouterMsgs)-1].ToolCalls = response.Choices[0].Message.ToolCalls
@@ -404,3 +461,56 @@ func (d *AIRouterReq) requestToolsCall(ctx context.Context, choice openrouter.Ch
 
 	return nil
 }
+
+// fromOpenRouterMessages transfer openrouter tool messages to messages of records
+func fromOpenRouterMessages(msgs []openrouter.ChatCompletionMessage) []*param.ToolMessage {
+	res := make([]*param.ToolMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		toolMsg := &param.ToolMessage{
+			Role:       msg.Role,
+			Content:    msg.Content.Text,
+			ToolCallID: msg.ToolCallID,
+		}
+		for _, part := range msg.Content.Multi {
+			toolMsg.Content += part.Text
+		}
+		for _, toolCall := range msg.ToolCalls {
+			toolMsg.ToolCalls = append(toolMsg.ToolCalls, &param.ToolCall{
+				ID:   toolCall.ID,
+				Type: string(toolCall.Type),
+				Function: param.ToolCallFunction{
+					Name:      toolCall.Function.Name,
+					Arguments: toolCall.Function.Arguments,
+				},
+			})
+		}
+		res = append(res, toolMsg)
+	}
+	return res
+}
+
+// toOpenRouterMessages transfer tool messages of records to openrouter messages
+func toOpenRouterMessages(msgs []*param.ToolMessage) []openrouter.ChatCompletionMessage {
+	res := make([]openrouter.ChatCompletionMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		orMsg := openrouter.ChatCompletionMessage{
+			Role: msg.Role,
+			Content: openrouter.Content{
+				Text: msg.Content,
+			},
+			ToolCallID: msg.ToolCallID,
+		}
+		for _, toolCall := range msg.ToolCalls {
+			orMsg.ToolCalls = append(orMsg.ToolCalls, openrouter.ToolCall{
+				ID:   toolCall.ID,
+				Type: openrouter.ToolType(toolCall.Type),
+				Function: openrouter.FunctionCall{
+					Name:      toolCall.Function.Name,
+					Arguments: toolCall.Function.Arguments,
+				},
+			})
+		}
+		res = append(res, orMsg)
+	}
+	return res
+}
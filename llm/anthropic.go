@@ -0,0 +1,594 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/cohesion-org/deepseek-go/constants"
+	"github.com/yincongcyincong/mcp-client-go/clients"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/db"
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+	"github.com/yincongcyincong/telegram-deepseek-bot/metrics"
+	"github.com/yincongcyincong/telegram-deepseek-bot/param"
+	"github.com/yincongcyincong/telegram-deepseek-bot/utils"
+)
+
+const (
+	AnthropicUrl     = "https://api.anthropic.com/"
+	AnthropicVersion = "2023-06-01"
+)
+
+type AnthropicReq struct {
+	ToolCall           []*AnthropicContent
+	ToolMessage        []*AnthropicMessage
+	CurrentToolMessage []*AnthropicContent
+
+	System        string
+	AnthropicMsgs []*AnthropicMessage
+}
+
+// AnthropicMessage message of anthropic messages api, content is made of blocks
+type AnthropicMessage struct {
+	Role    string              `json:"role"`
+	Content []*AnthropicContent `json:"content"`
+}
+
+// AnthropicContent content block: text, tool_use or tool_result
+type AnthropicContent struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+
+	Source *AnthropicImageSource `json:"source,omitempty"`
+
+	// PartialJson input of tool_use arrives in pieces while streaming
+	PartialJson string `json:"-"`
+}
+
+// AnthropicImageSource image of image content, it's sent in base64
+type AnthropicImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type AnthropicRequest struct {
+	Model         string                `json:"model"`
+	MaxTokens     int                   `json:"max_tokens"`
+	System        string                `json:"system,omitempty"`
+	Messages      []*AnthropicMessage   `json:"messages"`
+	Tools         []param.AnthropicTool `json:"tools,omitempty"`
+	Stream        bool                  `json:"stream,omitempty"`
+	Temperature   float64               `json:"temperature"`
+	StopSequences []string              `json:"stop_sequences,omitempty"`
+}
+
+// AnthropicUsage input tokens don't include tokens read from or written to prompt cache
+type AnthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+type AnthropicResponse struct {
+	Content []*AnthropicContent `json:"content"`
+	Usage   AnthropicUsage      `json:"usage"`
+}
+
+// AnthropicEvent data of server-sent event
+type AnthropicEvent struct {
+	Type         string             `json:"type"`
+	Index        int                `json:"index"`
+	Message      *AnthropicResponse `json:"message"`
+	ContentBlock *AnthropicContent  `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJson string `json:"partial_json"`
+	} `json:"delta"`
+	Usage *AnthropicUsage `json:"usage"`
+	Error *AnthropicError `json:"error"`
+}
+
+type AnthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// CallLLMAPI request anthropic API and get response
+func (d *AnthropicReq) CallLLMAPI(ctx context.Context, prompt string, l *LLM) error {
+	_, _, userId := utils.GetChatIdAndMsgIdAndUserID(l.Update)
+
+	d.GetModel(l)
+	d.GetMessages(l, prompt)
+
+	logger.Info("msg receive", "userID", userId, "prompt", prompt)
+
+	return d.Send(ctx, l)
+}
+
+func (d *AnthropicReq) GetModel(l *LLM) {
+	if l.useForceModel() {
+		return
+	}
+	_, _, userId := utils.GetChatIdAndMsgIdAndUserID(l.Update)
+
+	l.Model = param.ModelClaudeSonnet45
+	userInfo, err := db.GetUserByID(userId)
+	if err != nil {
+		logger.Error("Error getting user info", "err", err)
+	}
+	if userInfo != nil && userInfo.Mode != "" && IsModelOf(param.Anthropic, userInfo.Mode) {
+		logger.Info("User info", "userID", userInfo.UserId, "mode", userInfo.Mode)
+		l.Model = userInfo.Mode
+	}
+}
+
+// GetMessages anthropic doesn't accept system role in messages, system prompt and memory are sent as system
+func (d *AnthropicReq) GetMessages(l *LLM, prompt string) {
+	key := l.RecordKey
+	messages := make([]*AnthropicMessage, 0)
+
+	systemPrompt := getSystemPrompt(key)
+	systems := make([]string, 0)
+	if systemPrompt != "" {
+		systems = append(systems, systemPrompt)
+	}
+
+	summary, aqs := l.getContext(key, l.AnthropicTools, systemPrompt, prompt)
+	if summary != "" {
+		systems = append(systems, getMemoryPrompt(summary))
+	}
+
+	for i, record := range aqs {
+		if record.Answer != "" && record.Question != "" {
+			logger.Info("context content", "dialog", i, "question:", record.Question,
+				"toolContent", record.Content, "answer:", record.Answer)
+			messages = append(messages, newAnthropicTextMessage(constants.ChatMessageRoleUser, record.Question))
+			messages = append(messages, toAnthropicMessages(unmarshalToolMessages(record.Content))...)
+			messages = append(messages, newAnthropicTextMessage(constants.ChatMessageRoleAssistant, record.Answer))
+		}
+	}
+
+	// images of question are only sent in this turn
+	message := newAnthropicTextMessage(constants.ChatMessageRoleUser, prompt)
+	for _, image := range l.getRequestImages() {
+		message.Content = append(message.Content, &AnthropicContent{
+			Type: "image",
+			Source: &AnthropicImageSource{
+				Type:      "base64",
+				MediaType: imageMimeType(image),
+				Data:      base64.StdEncoding.EncodeToString(image),
+			},
+		})
+	}
+	messages = append(messages, message)
+
+	d.System = strings.Join(systems, "\n\n")
+	d.AnthropicMsgs = messages
+}
+
+func (d *AnthropicReq) Send(ctx context.Context, l *LLM) error {
+	if l.OverLoop() {
+		return errors.New("too many loops")
+	}
+
+	start := time.Now()
+	_, updateMsgID, userId := utils.GetChatIdAndMsgIdAndUserID(l.Update)
+	d.GetModel(l)
+
+	hasTools, err := d.stream(ctx, l)
+	if err != nil {
+		logger.Error("ChatCompletionStream error", "updateMsgID", updateMsgID, "err", err)
+		return err
+	}
+
+	if !hasTools || len(d.CurrentToolMessage) == 0 || utils.IsRequestStopped(ctx) {
+		db.InsertMsgRecord(l.RecordKey, &db.AQ{
+			UserId:        userId,
+			Question:      l.Content,
+			Answer:        l.WholeContent,
+			Content:       marshalToolMessages(fromAnthropicMessages(d.ToolMessage)),
+			Token:         l.Token,
+			QuestionMsgId: updateMsgID,
+			AnswerMsgId:   l.AnswerMsgId,
+			Usage:         l.getUsage(),
+		}, true)
+	} else {
+		currentToolMessage := []*AnthropicMessage{
+			{
+				Role:    constants.ChatMessageRoleAssistant,
+				Content: d.ToolCall,
+			},
+			{
+				Role:    constants.ChatMessageRoleUser,
+				Content: d.CurrentToolMessage,
+			},
+		}
+		if l.WholeContent != "" {
+			currentToolMessage[0].Content = append([]*AnthropicContent{{Type: "text", Text: l.WholeContent}}, d.ToolCall...)
+		}
+
+		d.ToolMessage = append(d.ToolMessage, currentToolMessage...)
+		d.AnthropicMsgs = append(d.AnthropicMsgs, currentToolMessage...)
+		d.CurrentToolMessage = make([]*AnthropicContent, 0)
+		d.ToolCall = make([]*AnthropicContent, 0)
+		return d.Send(ctx, l)
+	}
+
+	// record time costing in dialog
+	totalDuration := time.Since(start).Seconds()
+	metrics.ConversationDuration.Observe(totalDuration)
+	return nil
+}
+
+// stream request /v1/messages and parse server-sent events, it returns whether tools are called
+func (d *AnthropicReq) stream(ctx context.Context, l *LLM) (bool, error) {
+	_, updateMsgID, _ := utils.GetChatIdAndMsgIdAndUserID(l.Update)
+
+	resp, err := d.request(ctx, l, true)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	msgInfoContent := &param.MsgInfo{
+		SendLen: FirstSendLen,
+	}
+
+	hasTools := false
+	blocks := make(map[int]*AnthropicContent)
+	usage := AnthropicUsage{}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		event := new(AnthropicEvent)
+		err = json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), event)
+		if err != nil {
+			logger.Warn("unmarshal event fail", "updateMsgID", updateMsgID, "line", line, "err", err)
+			continue
+		}
+
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				usage = event.Message.Usage
+			}
+		case "content_block_start":
+			if event.ContentBlock != nil {
+				blocks[event.Index] = event.ContentBlock
+				if event.ContentBlock.Type == "tool_use" {
+					hasTools = true
+					l.answered.Store(true)
+				}
+			}
+		case "content_block_delta":
+			switch event.Delta.Type {
+			case "text_delta":
+				msgInfoContent = l.sendMsg(msgInfoContent, event.Delta.Text)
+			case "input_json_delta":
+				if block, ok := blocks[event.Index]; ok {
+					block.PartialJson += event.Delta.PartialJson
+				}
+			}
+		case "content_block_stop":
+			if block, ok := blocks[event.Index]; ok && block.Type == "tool_use" {
+				d.requestToolsCall(ctx, block)
+			}
+		case "message_delta":
+			if event.Usage != nil {
+				usage.OutputTokens = event.Usage.OutputTokens
+			}
+		case "error":
+			if event.Error != nil {
+				err = errors.New(event.Error.Message)
+			}
+		}
+	}
+	if err == nil {
+		err = scanner.Err()
+	}
+
+	l.addAnthropicUsage(usage)
+	metrics.TotalTokens.Add(float64(l.Token))
+
+	if err != nil {
+		logger.Warn("Stream error", "updateMsgID", updateMsgID, "err", err)
+		// nothing is answered, let next llm of fallback chain take over
+		if l.canFallback(ctx) {
+			return false, err
+		}
+	}
+
+	msgInfoContent = l.appendStopNote(ctx, msgInfoContent)
+	if len(strings.TrimRightFunc(msgInfoContent.Content, unicode.IsSpace)) > 0 {
+		l.MessageChan <- msgInfoContent
+	}
+
+	return hasTools, nil
+}
+
+// request post /v1/messages, error message of anthropic is returned when status isn't ok
+func (d *AnthropicReq) request(ctx context.Context, l *LLM, stream bool) (*http.Response, error) {
+	params := l.getParams()
+	body := &AnthropicRequest{
+		Model:         l.Model,
+		MaxTokens:     params.MaxTokens,
+		System:        d.System,
+		Messages:      d.AnthropicMsgs,
+		Tools:         l.AnthropicTools,
+		Stream:        stream,
+		Temperature:   min(params.Temperature, 1),
+		StopSequences: params.Stop,
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimRight(getCustomUrl(param.Anthropic, AnthropicUrl), "/")+"/v1/messages", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", *conf.AnthropicToken)
+	req.Header.Set("anthropic-version", AnthropicVersion)
+
+	resp, err := utils.GetDeepseekProxyClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		errResp := &struct {
+			Error *AnthropicError `json:"error"`
+		}{}
+		if json.Unmarshal(respBody, errResp) == nil && errResp.Error != nil {
+			return nil, errors.New(errResp.Error.Message)
+		}
+		return nil, fmt.Errorf("anthropic status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	return resp, nil
+}
+
+func (d *AnthropicReq) GetUserMessage(msg string) {
+	d.GetMessage(constants.ChatMessageRoleUser, msg)
+}
+
+func (d *AnthropicReq) GetAssistantMessage(msg string) {
+	d.GetMessage(constants.ChatMessageRoleAssistant, msg)
+}
+
+func (d *AnthropicReq) AppendMessages(client LLMClient) {
+	if len(d.AnthropicMsgs) == 0 {
+		d.AnthropicMsgs = make([]*AnthropicMessage, 0)
+	}
+
+	d.AnthropicMsgs = append(d.AnthropicMsgs, client.(*AnthropicReq).AnthropicMsgs...)
+}
+
+// GetMessage anthropic rejects empty text block, so empty message is skipped
+func (d *AnthropicReq) GetMessage(role, msg string) {
+	if msg == "" {
+		return
+	}
+	d.AnthropicMsgs = append(d.AnthropicMsgs, newAnthropicTextMessage(role, msg))
+}
+
+func (d *AnthropicReq) SyncSend(ctx context.Context, l *LLM) (string, error) {
+	_, updateMsgID, _ := utils.GetChatIdAndMsgIdAndUserID(l.Update)
+
+	d.GetModel(l)
+
+	resp, err := d.request(ctx, l, false)
+	if err != nil {
+		logger.Error("ChatCompletionStream error", "updateMsgID", updateMsgID, "err", err)
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	response := new(AnthropicResponse)
+	if err = json.NewDecoder(resp.Body).Decode(response); err != nil {
+		logger.Error("decode response fail", "updateMsgID", updateMsgID, "err", err)
+		return "", err
+	}
+
+	l.addAnthropicUsage(response.Usage)
+
+	content := ""
+	toolUses := make([]*AnthropicContent, 0)
+	for _, block := range response.Content {
+		switch block.Type {
+		case "text":
+			content += block.Text
+		case "tool_use":
+			toolUses = append(toolUses, block)
+		}
+	}
+
+	if len(toolUses) > 0 {
+		d.AnthropicMsgs = append(d.AnthropicMsgs, &AnthropicMessage{
+			Role:    constants.ChatMessageRoleAssistant,
+			Content: response.Content,
+		})
+		for _, toolUse := range toolUses {
+			d.requestToolsCall(ctx, toolUse)
+		}
+		d.AnthropicMsgs = append(d.AnthropicMsgs, &AnthropicMessage{
+			Role:    constants.ChatMessageRoleUser,
+			Content: d.CurrentToolMessage,
+		})
+		d.CurrentToolMessage = make([]*AnthropicContent, 0)
+		d.ToolCall = make([]*AnthropicContent, 0)
+	}
+
+	return content, nil
+}
+
+// requestToolsCall execute tool when its input is complete, every tool_use must be answered by tool_result,
+// so failure is sent back as result too
+func (d *AnthropicReq) requestToolsCall(ctx context.Context, toolUse *AnthropicContent) {
+	if toolUse.PartialJson != "" {
+		toolUse.Input = json.RawMessage(toolUse.PartialJson)
+	}
+	if len(toolUse.Input) == 0 {
+		toolUse.Input = json.RawMessage("{}")
+	}
+	d.ToolCall = append(d.ToolCall, toolUse)
+
+	toolsData, err := d.execTool(ctx, toolUse)
+	if err != nil {
+		logger.Warn("exec tools fail", "err", err, "function", toolUse.Name,
+			"toolCall", toolUse.ID, "argument", string(toolUse.Input))
+		toolsData = err.Error()
+	}
+
+	d.CurrentToolMessage = append(d.CurrentToolMessage, &AnthropicContent{
+		Type:      "tool_result",
+		ToolUseID: toolUse.ID,
+		Content:   toolsData,
+	})
+	logger.Info("send tool request", "function", toolUse.Name,
+		"toolCall", toolUse.ID, "argument", string(toolUse.Input), "res", toolsData)
+}
+
+func (d *AnthropicReq) execTool(ctx context.Context, toolUse *AnthropicContent) (string, error) {
+	property := make(map[string]interface{})
+	err := json.Unmarshal(toolUse.Input, &property)
+	if err != nil {
+		return "", ToolsJsonErr
+	}
+
+	mc, err := clients.GetMCPClientByToolName(toolUse.Name)
+	if err != nil {
+		return "", err
+	}
+
+	return mc.ExecTools(ctx, toolUse.Name, property)
+}
+
+// addAnthropicUsage prompt token includes tokens of prompt cache, like other llm
+func (l *LLM) addAnthropicUsage(usage AnthropicUsage) {
+	promptToken := usage.InputTokens + usage.CacheCreationInputTokens + usage.CacheReadInputTokens
+	l.Token += promptToken + usage.OutputTokens
+	l.addUsage(promptToken, usage.OutputTokens, usage.CacheReadInputTokens)
+}
+
+// fromAnthropicMessages transfer anthropic tool messages to messages of records,
+// every tool_result block becomes a tool message
+func fromAnthropicMessages(msgs []*AnthropicMessage) []*param.ToolMessage {
+	res := make([]*param.ToolMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		toolMsg := &param.ToolMessage{
+			Role: msg.Role,
+		}
+		for _, block := range msg.Content {
+			switch block.Type {
+			case "text":
+				toolMsg.Content += block.Text
+			case "tool_use":
+				toolMsg.ToolCalls = append(toolMsg.ToolCalls, &param.ToolCall{
+					ID:   block.ID,
+					Type: "function",
+					Function: param.ToolCallFunction{
+						Name:      block.Name,
+						Arguments: string(block.Input),
+					},
+				})
+			case "tool_result":
+				res = append(res, &param.ToolMessage{
+					Role:       constants.ChatMessageRoleTool,
+					Content:    block.Content,
+					ToolCallID: block.ToolUseID,
+				})
+			}
+		}
+		if msg.Role == constants.ChatMessageRoleAssistant {
+			res = append(res, toolMsg)
+		}
+	}
+	return res
+}
+
+// toAnthropicMessages transfer tool messages of records to anthropic messages,
+// tool messages in a row are sent as tool_result blocks of one user message
+func toAnthropicMessages(msgs []*param.ToolMessage) []*AnthropicMessage {
+	res := make([]*AnthropicMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.Role == constants.ChatMessageRoleTool {
+			result := &AnthropicContent{
+				Type:      "tool_result",
+				ToolUseID: msg.ToolCallID,
+				Content:   msg.Content,
+			}
+			if len(res) > 0 && res[len(res)-1].Role == constants.ChatMessageRoleUser {
+				res[len(res)-1].Content = append(res[len(res)-1].Content, result)
+			} else {
+				res = append(res, &AnthropicMessage{
+					Role:    constants.ChatMessageRoleUser,
+					Content: []*AnthropicContent{result},
+				})
+			}
+			continue
+		}
+
+		antMsg := &AnthropicMessage{
+			Role:    constants.ChatMessageRoleAssistant,
+			Content: make([]*AnthropicContent, 0, len(msg.ToolCalls)+1),
+		}
+		if msg.Content != "" {
+			antMsg.Content = append(antMsg.Content, &AnthropicContent{Type: "text", Text: msg.Content})
+		}
+		for _, toolCall := range msg.ToolCalls {
+			input := json.RawMessage("{}")
+			if json.Valid([]byte(toolCall.Function.Arguments)) {
+				input = json.RawMessage(toolCall.Function.Arguments)
+			}
+			antMsg.Content = append(antMsg.Content, &AnthropicContent{
+				Type:  "tool_use",
+				ID:    toolCall.ID,
+				Name:  toolCall.Function.Name,
+				Input: input,
+			})
+		}
+		res = append(res, antMsg)
+	}
+	return res
+}
+
+func newAnthropicTextMessage(role, msg string) *AnthropicMessage {
+	return &AnthropicMessage{
+		Role: role,
+		Content: []*AnthropicContent{
+			{
+				Type: "text",
+				Text: msg,
+			},
+		},
+	}
+}
@@ -0,0 +1,105 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/param"
+)
+
+// newAnthropicServer start a local stand-in of anthropic which answers "Hello" and calls search tool when streaming
+func newAnthropicServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/messages", r.URL.Path)
+		assert.Equal(t, "ant-token", r.Header.Get("x-api-key"))
+		assert.Equal(t, AnthropicVersion, r.Header.Get("anthropic-version"))
+
+		request := new(AnthropicRequest)
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(request))
+		assert.Equal(t, param.ModelClaudeHaiku45, request.Model)
+		assert.Equal(t, "be brief", request.System)
+		assert.Equal(t, 1.0, request.Temperature)
+
+		if !request.Stream {
+			_, _ = w.Write([]byte(`{"content":[{"type":"text","text":"ok"}],"usage":{"input_tokens":3,"output_tokens":1}}`))
+			return
+		}
+
+		assert.Equal(t, "search", request.Tools[0].Name)
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("event: message_start\n" +
+			`data: {"type":"message_start","message":{"content":[],"usage":{"input_tokens":5,"output_tokens":1}}}` + "\n\n" +
+			`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}` + "\n\n" +
+			`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hel"}}` + "\n\n" +
+			`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"lo"}}` + "\n\n" +
+			`data: {"type":"content_block_stop","index":0}` + "\n\n" +
+			`data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"search","input":{}}}` + "\n\n" +
+			`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"q\":"}}` + "\n\n" +
+			`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"go\"}"}}` + "\n\n" +
+			`data: {"type":"content_block_stop","index":1}` + "\n\n" +
+			`data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":2}}` + "\n\n" +
+			`data: {"type":"message_stop"}` + "\n\n"))
+	}))
+}
+
+func setAnthropicConf(host string) {
+	llmType, token, stop := param.Anthropic, "ant-token", []string(nil)
+	maxTokens, temperature, zero := 100, 1.5, 0.0
+	conf.Type, conf.CustomUrl, conf.AnthropicToken = &llmType, &host, &token
+	conf.MaxTokens, conf.Temperature, conf.Stop = &maxTokens, &temperature, stop
+	conf.TopP, conf.FrequencyPenalty, conf.PresencePenalty = &zero, &zero, &zero
+	empty := ""
+	conf.DeepseekProxy = &empty
+}
+
+func TestAnthropicStream(t *testing.T) {
+	server := newAnthropicServer(t)
+	defer server.Close()
+	setAnthropicConf(server.URL)
+
+	l := &LLM{
+		MessageChan:    make(chan *param.MsgInfo, 10),
+		ForceModel:     param.ModelClaudeHaiku45,
+		AnthropicTools: []param.AnthropicTool{{Name: "search", InputSchema: param.AnthropicInputSchema{Type: "object"}}},
+	}
+	l.useForceModel()
+	d := &AnthropicReq{System: "be brief", AnthropicMsgs: []*AnthropicMessage{newAnthropicTextMessage("user", "hi")}}
+
+	hasTools, err := d.stream(context.Background(), l)
+	assert.NoError(t, err)
+	assert.True(t, hasTools)
+	assert.Equal(t, "Hello", l.WholeContent)
+	assert.Equal(t, 7, l.Token)
+	msg := <-l.MessageChan
+	assert.Equal(t, "Hello", msg.Content)
+
+	// input of tool is joined from pieces, every tool_use is answered by tool_result even if it fails
+	assert.Equal(t, "search", d.ToolCall[0].Name)
+	assert.JSONEq(t, `{"q":"go"}`, string(d.ToolCall[0].Input))
+	assert.Equal(t, "tool_result", d.CurrentToolMessage[0].Type)
+	assert.Equal(t, "toolu_1", d.CurrentToolMessage[0].ToolUseID)
+
+	answer, err := d.SyncSend(context.Background(), l)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", answer)
+	assert.Equal(t, 11, l.Token)
+}
+
+func TestAnthropicStreamFail(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`))
+	}))
+	defer server.Close()
+	setAnthropicConf(server.URL)
+
+	// nothing is answered, error is returned so fallback chain can take over
+	l := &LLM{MessageChan: make(chan *param.MsgInfo, 10), Model: param.ModelClaudeHaiku45}
+	_, err := (&AnthropicReq{}).stream(context.Background(), l)
+	assert.EqualError(t, err, "invalid x-api-key")
+}
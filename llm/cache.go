@@ -0,0 +1,214 @@
+package llm
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/db"
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+	"github.com/yincongcyincong/telegram-deepseek-bot/metrics"
+	"github.com/yincongcyincong/telegram-deepseek-bot/param"
+	"github.com/yincongcyincong/telegram-deepseek-bot/utils"
+)
+
+const (
+	// maxSimilarCaches latest caches which are compared with prompt by embedding similarity
+	maxSimilarCaches = 500
+
+	// cacheChunkLen cached answer is sent chunk by chunk, like answer of stream
+	cacheChunkLen = 100
+)
+
+// cacheRequest prompt of request which can use response cache
+type cacheRequest struct {
+	prompt     string // normalized prompt
+	personaKey string // system prompt and sampling params, answer is shaped by both
+	embedding  []float32
+}
+
+// newCacheRequest return nil if cache is off, or request has images, tools or history,
+// because answer of them doesn't only depend on prompt.
+func (l *LLM) newCacheRequest(prompt string) *cacheRequest {
+	if *conf.ResponseCacheTTL <= 0 || len(l.Images) > 0 || l.hasTools() || l.hasHistory() {
+		return nil
+	}
+
+	prompt = NormalizePrompt(prompt)
+	if prompt == "" {
+		return nil
+	}
+
+	return &cacheRequest{
+		prompt:     prompt,
+		personaKey: utils.MD5(getSystemPrompt(l.RecordKey) + "\n" + getParamsKey(l.getParams())),
+	}
+}
+
+// hasTools check whether tools are sent with request, answer of tools may change every time
+func (l *LLM) hasTools() bool {
+	return *conf.UseTools || len(l.DeepseekTools) > 0 || len(l.VolTools) > 0 || len(l.OpenAITools) > 0 ||
+		len(l.GeminiTools) > 0 || len(l.OpenRouterTools) > 0 || len(l.AnthropicTools) > 0
+}
+
+// hasHistory check whether thread has dialogs in memory or summary
+func (l *LLM) hasHistory() bool {
+	key := l.RecordKey
+	if msgRecord := db.GetMsgRecord(key); msgRecord != nil && len(msgRecord.AQs) > 0 {
+		return true
+	}
+
+	summary, err := db.GetSummary(key)
+	if err != nil {
+		logger.Error("get summary fail", "err", err)
+		return true
+	}
+	return summary != nil
+}
+
+// answerFromCache send cached answer of prompt, return false if prompt isn't cached
+func (l *LLM) answerFromCache(ctx context.Context, req *cacheRequest) bool {
+	l.LLMClient.GetModel(l)
+	cache := l.getResponseCache(ctx, req)
+	if cache == nil {
+		metrics.ResponseCacheCount.WithLabelValues("miss").Inc()
+		return false
+	}
+	metrics.ResponseCacheCount.WithLabelValues("hit").Inc()
+	logger.Info("answer from cache", "cacheId", cache.ID, "llmType", l.Type, "model", l.Model)
+
+	msgInfoContent := &param.MsgInfo{
+		SendLen: FirstSendLen,
+	}
+	answer := []rune(cache.Answer)
+	for i := 0; i < len(answer); i += cacheChunkLen {
+		msgInfoContent = l.sendMsg(msgInfoContent, string(answer[i:min(i+cacheChunkLen, len(answer))]))
+	}
+	if len(strings.TrimRightFunc(msgInfoContent.Content, unicode.IsSpace)) > 0 {
+		l.MessageChan <- msgInfoContent
+	}
+
+	// cached answer doesn't cost token
+	_, updateMsgID, userId := utils.GetChatIdAndMsgIdAndUserID(l.Update)
+	db.InsertMsgRecord(l.RecordKey, &db.AQ{
+		UserId:        userId,
+		Question:      l.Content,
+		Answer:        l.WholeContent,
+		QuestionMsgId: updateMsgID,
+		AnswerMsgId:   l.AnswerMsgId,
+		Usage:         l.getUsage(),
+	}, true)
+
+	if err := db.AddResponseCacheHit(cache.ID); err != nil {
+		logger.Error("add cache hit fail", "err", err)
+	}
+	return true
+}
+
+// getResponseCache get cache of same prompt, or cache whose prompt is similar enough if embedder is configured
+func (l *LLM) getResponseCache(ctx context.Context, req *cacheRequest) *db.ResponseCache {
+	after := time.Now().Add(-time.Duration(*conf.ResponseCacheTTL) * time.Minute).Unix()
+	cache, err := db.GetResponseCache(getCacheKey(l.Type, l.Model, req.personaKey, req.prompt), after)
+	if err != nil {
+		logger.Error("get response cache fail", "err", err)
+		return nil
+	}
+	if cache != nil || conf.Embedder == nil || *conf.ResponseCacheSimilarity <= 0 {
+		return cache
+	}
+
+	req.embedding, err = conf.Embedder.EmbedQuery(ctx, req.prompt)
+	if err != nil {
+		logger.Warn("embed prompt fail", "err", err)
+		return nil
+	}
+
+	caches, err := db.GetResponseCachesWithEmbedding(l.Type, l.Model, req.personaKey, after, maxSimilarCaches)
+	if err != nil {
+		logger.Error("get response caches fail", "err", err)
+		return nil
+	}
+
+	bestSimilarity := *conf.ResponseCacheSimilarity
+	for _, c := range caches {
+		if similarity := cosineSimilarity(req.embedding, c.Embedding); similarity >= bestSimilarity {
+			cache, bestSimilarity = c, similarity
+		}
+	}
+	return cache
+}
+
+// saveResponseCache cache answer, answer which calls tools isn't cached because it may change next time
+func (l *LLM) saveResponseCache(ctx context.Context, req *cacheRequest) {
+	// Send is called again after tools are called
+	if l.LoopNum > 1 || l.WholeContent == "" || utils.IsRequestStopped(ctx) {
+		return
+	}
+
+	now := time.Now()
+	err := db.InsertResponseCache(&db.ResponseCache{
+		CacheKey:   getCacheKey(l.Type, l.Model, req.personaKey, req.prompt),
+		LLMType:    l.Type,
+		Model:      l.Model,
+		PersonaKey: req.personaKey,
+		Prompt:     req.prompt,
+		Answer:     l.WholeContent,
+		Embedding:  req.embedding,
+		CreateTime: now.Unix(),
+	})
+	if err != nil {
+		logger.Error("insert response cache fail", "err", err)
+		return
+	}
+
+	err = db.DeleteResponseCacheBefore(now.Add(-time.Duration(*conf.ResponseCacheTTL) * time.Minute).Unix())
+	if err != nil {
+		logger.Error("delete expired response cache fail", "err", err)
+	}
+}
+
+// ClearResponseCache delete caches of prompt, all caches are deleted if prompt is empty
+func ClearResponseCache(prompt string) (int64, error) {
+	return db.DeleteResponseCache(NormalizePrompt(prompt))
+}
+
+// NormalizePrompt prompts which only differ in case, spaces and ending punctuation are same
+func NormalizePrompt(prompt string) string {
+	prompt = strings.Join(strings.Fields(strings.ToLower(prompt)), " ")
+	return strings.TrimRightFunc(prompt, func(r rune) bool {
+		return unicode.IsPunct(r) || unicode.IsSpace(r)
+	})
+}
+
+// getParamsKey sampling params in fixed order, answer of one user's params isn't served to others
+func getParamsKey(params *Params) string {
+	values := make([]string, 0, len(ParamNames))
+	for _, name := range ParamNames {
+		values = append(values, name+"="+params.Value(name))
+	}
+	return strings.Join(values, "&")
+}
+
+func getCacheKey(llmType, model, personaKey, prompt string) string {
+	return utils.MD5(strings.Join([]string{llmType, model, personaKey, prompt}, "\n"))
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
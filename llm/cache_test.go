@@ -0,0 +1,62 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+)
+
+func TestNormalizePrompt(t *testing.T) {
+	assert.Equal(t, "what is go", NormalizePrompt("  What   is\nGo?? "))
+	assert.Equal(t, "什么是 go", NormalizePrompt("什么是 Go？"))
+	assert.Equal(t, "", NormalizePrompt(" ?! "))
+
+	assert.Equal(t, getCacheKey("openai", "gpt-4o", "p", NormalizePrompt("What is Go?")),
+		getCacheKey("openai", "gpt-4o", "p", NormalizePrompt("what is go")))
+	assert.NotEqual(t, getCacheKey("openai", "gpt-4o", "p", "what is go"),
+		getCacheKey("openai", "gpt-4o-mini", "p", "what is go"))
+}
+
+func TestGetParamsKey(t *testing.T) {
+	params := &Params{Temperature: 0.7, TopP: 0.9, MaxTokens: 2048}
+	userParams := &Params{Temperature: 0.7, TopP: 0.9, MaxTokens: 50}
+	stopParams := &Params{Temperature: 0.7, TopP: 0.9, MaxTokens: 2048, Stop: []string{"END"}}
+
+	assert.Equal(t, getParamsKey(params), getParamsKey(&Params{Temperature: 0.7, TopP: 0.9, MaxTokens: 2048}))
+	assert.NotEqual(t, getParamsKey(params), getParamsKey(userParams))
+	assert.NotEqual(t, getParamsKey(params), getParamsKey(stopParams))
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	assert.InDelta(t, 1, cosineSimilarity([]float32{1, 2, 3}, []float32{2, 4, 6}), 1e-9)
+	assert.InDelta(t, 0, cosineSimilarity([]float32{1, 0}, []float32{0, 1}), 1e-9)
+	assert.Equal(t, float64(0), cosineSimilarity([]float32{1, 0}, []float32{1, 0, 0}))
+	assert.Equal(t, float64(0), cosineSimilarity([]float32{0, 0}, []float32{1, 0}))
+}
+
+func TestNewCacheRequest(t *testing.T) {
+	oldTTL, oldUseTools := conf.ResponseCacheTTL, conf.UseTools
+	t.Cleanup(func() {
+		conf.ResponseCacheTTL, conf.UseTools = oldTTL, oldUseTools
+	})
+
+	ttl := 0
+	conf.ResponseCacheTTL = &ttl
+	l := &LLM{}
+	assert.Nil(t, l.newCacheRequest("what is go"), "cache is off")
+
+	ttl = 60
+	l.Images = [][]byte{[]byte("image")}
+	assert.Nil(t, l.newCacheRequest("what is go"), "request with image can't use cache")
+
+	useTools := false
+	conf.UseTools = &useTools
+	l = &LLM{OpenAITools: []openai.Tool{{Type: openai.ToolTypeFunction}}}
+	assert.Nil(t, l.newCacheRequest("weather now"), "request with tools can't use cache")
+
+	useTools = true
+	l = &LLM{}
+	assert.Nil(t, l.newCacheRequest("weather now"), "request can't use cache when tools are on")
+}
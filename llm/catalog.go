@@ -0,0 +1,384 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	godeepseek "github.com/cohesion-org/deepseek-go"
+	openrouter "github.com/revrost/go-openrouter"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/db"
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+	"github.com/yincongcyincong/telegram-deepseek-bot/param"
+	"github.com/yincongcyincong/telegram-deepseek-bot/utils"
+	"google.golang.org/genai"
+)
+
+type modelLister func(ctx context.Context) ([]string, error)
+
+var (
+	// modelCatalog models fetched from list endpoint of llm, key is llm type
+	modelCatalog     = make(map[string][]string)
+	modelCatalogLock sync.RWMutex
+
+	// nonChatModelKeywords list endpoints also return models which can't chat
+	nonChatModelKeywords = []string{"embedding", "tts", "whisper", "dall-e", "moderation", "davinci", "babbage",
+		"transcribe", "realtime", "audio", "image", "imagen", "veo", "aqa"}
+)
+
+// modelListers llm which has list endpoint, vol only has builtin models
+func modelListers() map[string]struct {
+	token  *string
+	lister modelLister
+} {
+	return map[string]struct {
+		token  *string
+		lister modelLister
+	}{
+		param.DeepSeek:   {conf.DeepseekToken, listDeepseekModels},
+		param.OpenAi:     {conf.OpenAIToken, listOpenAIModels},
+		param.Gemini:     {conf.GeminiToken, listGeminiModels},
+		param.OpenRouter: {conf.OpenRouterToken, listOpenRouterModels},
+		param.Anthropic:  {conf.AnthropicToken, listAnthropicModels},
+	}
+}
+
+// builtinModels models known when bot is built, they are used until list endpoint answers
+func builtinModels(llmType string) map[string]bool {
+	switch llmType {
+	case param.DeepSeek:
+		return param.DeepseekModels
+	case param.OpenAi:
+		return param.OpenAIModels
+	case param.Gemini:
+		return param.GeminiModels
+	case param.OpenRouter:
+		return param.OpenRouterModels
+	case param.Vol:
+		return param.VolModels
+	case param.Anthropic:
+		return param.AnthropicModels
+	}
+	return nil
+}
+
+// InitModelCatalog load models cached in db, then refresh them from list endpoints periodically
+func InitModelCatalog() {
+	models, err := db.GetLLMModels()
+	if err != nil {
+		logger.Error("get cached models fail", "err", err)
+	}
+	modelCatalogLock.Lock()
+	for llmType, typeModels := range models {
+		modelCatalog[llmType] = typeModels
+	}
+	modelCatalogLock.Unlock()
+
+	go func() {
+		RefreshModelCatalog(context.Background())
+		if *conf.ModelRefreshInterval <= 0 {
+			return
+		}
+
+		ticker := time.NewTicker(time.Duration(*conf.ModelRefreshInterval) * time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			RefreshModelCatalog(context.Background())
+		}
+	}()
+}
+
+// RefreshModelCatalog fetch models of llm which has token, models stay unchanged if endpoint fails
+func RefreshModelCatalog(ctx context.Context) {
+	for llmType, l := range modelListers() {
+		if l.token == nil || *l.token == "" {
+			continue
+		}
+
+		listCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		models, err := l.lister(listCtx)
+		cancel()
+		if err != nil {
+			logger.Warn("list models fail", "llmType", llmType, "err", err)
+			continue
+		}
+		if len(models) == 0 {
+			continue
+		}
+
+		setCatalogModels(llmType, models)
+		if db.DB != nil {
+			if err = db.ReplaceLLMModels(llmType, models); err != nil {
+				logger.Error("cache models fail", "llmType", llmType, "err", err)
+			}
+		}
+		logger.Info("refresh models", "llmType", llmType, "num", len(models))
+	}
+}
+
+func setCatalogModels(llmType string, models []string) {
+	modelCatalogLock.Lock()
+	defer modelCatalogLock.Unlock()
+	modelCatalog[llmType] = models
+}
+
+// GetModels get models of llm type which can be chosen: live models or builtin models,
+// merged with MODEL_ALLOW_LIST and MODEL_DENY_LIST
+func GetModels(llmType string) []string {
+	modelCatalogLock.RLock()
+	models := slices.Clone(modelCatalog[llmType])
+	modelCatalogLock.RUnlock()
+
+	if len(models) == 0 {
+		for model := range builtinModels(llmType) {
+			models = append(models, model)
+		}
+	}
+
+	// exact allow entries are added even if list endpoint doesn't return them, such as fine-tuned models
+	allowPatterns := make([]string, 0)
+	for _, entry := range conf.ModelAllowList {
+		entryType, pattern, ok := strings.Cut(entry, ":")
+		if !ok || entryType != llmType {
+			continue
+		}
+		allowPatterns = append(allowPatterns, pattern)
+		if !strings.Contains(pattern, "*") && !slices.Contains(models, pattern) {
+			models = append(models, pattern)
+		}
+	}
+
+	res := make([]string, 0, len(models))
+	for _, model := range models {
+		if len(allowPatterns) > 0 && !slices.ContainsFunc(allowPatterns, func(pattern string) bool {
+			return matchModel(pattern, model)
+		}) {
+			continue
+		}
+		if isDeniedModel(llmType, model) {
+			continue
+		}
+		res = append(res, model)
+	}
+
+	slices.Sort(res)
+	return res
+}
+
+// IsModelOf check whether model can be chosen for llm type
+func IsModelOf(llmType, model string) bool {
+	return model != "" && slices.Contains(GetModels(llmType), model)
+}
+
+// IsCatalogModel check whether model can be chosen for any llm type which has model list
+func IsCatalogModel(model string) bool {
+	for _, llmType := range []string{param.DeepSeek, param.OpenAi, param.Gemini, param.OpenRouter, param.Vol, param.Anthropic} {
+		if IsModelOf(llmType, model) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetOpenRouterModelTypes get vendors of openrouter models, such as google of google/gemini-2.5-pro
+func GetOpenRouterModelTypes() []string {
+	types := make([]string, 0)
+	for _, model := range GetModels(param.OpenRouter) {
+		vendor, _, ok := strings.Cut(model, "/")
+		if ok && !slices.Contains(types, vendor) {
+			types = append(types, vendor)
+		}
+	}
+	return types
+}
+
+// isDeniedModel deny entry like openai:gpt-3.5* belongs to one llm type, entry like *preview* belongs to all
+func isDeniedModel(llmType, model string) bool {
+	for _, entry := range conf.ModelDenyList {
+		if entryType, pattern, ok := strings.Cut(entry, ":"); ok && entryType == llmType {
+			if matchModel(pattern, model) {
+				return true
+			}
+			continue
+		}
+		if matchModel(entry, model) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchModel match model with pattern, * matches any characters
+func matchModel(pattern, model string) bool {
+	if !strings.Contains(pattern, "*") {
+		return pattern == model
+	}
+	quoted := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	matched, _ := regexp.MatchString("^"+quoted+"$", model)
+	return matched
+}
+
+// isChatModel list endpoints also return embedding, audio and image models
+func isChatModel(model string) bool {
+	model = strings.ToLower(model)
+	return !slices.ContainsFunc(nonChatModelKeywords, func(keyword string) bool {
+		return strings.Contains(model, keyword)
+	})
+}
+
+func listDeepseekModels(ctx context.Context) ([]string, error) {
+	client, err := godeepseek.NewClientWithOptions(*conf.DeepseekToken,
+		godeepseek.WithHTTPClient(utils.GetDeepseekProxyClient()))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := godeepseek.ListAllModels(client, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]string, 0, len(resp.Data))
+	for _, model := range resp.Data {
+		models = append(models, model.ID)
+	}
+	return models, nil
+}
+
+func listOpenAIModels(ctx context.Context) ([]string, error) {
+	resp, err := (&OpenAIReq{}).getClient().ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]string, 0, len(resp.Models))
+	for _, model := range resp.Models {
+		if isChatModel(model.ID) {
+			models = append(models, model.ID)
+		}
+	}
+	return models, nil
+}
+
+func listGeminiModels(ctx context.Context) ([]string, error) {
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		HTTPClient: utils.GetDeepseekProxyClient(),
+		APIKey:     *conf.GeminiToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]string, 0)
+	for model, err := range client.Models.All(ctx) {
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimPrefix(model.Name, "models/")
+		if slices.Contains(model.SupportedActions, "generateContent") && isChatModel(name) {
+			models = append(models, name)
+		}
+	}
+	return models, nil
+}
+
+func listOpenRouterModels(ctx context.Context) ([]string, error) {
+	config := openrouter.DefaultConfig(*conf.OpenRouterToken)
+	resp := &struct {
+		Data []struct {
+			ID      string `json:"id"`
+			Pricing struct {
+				Prompt         string `json:"prompt"`
+				Completion     string `json:"completion"`
+				InputCacheRead string `json:"input_cache_read"`
+			} `json:"pricing"`
+		} `json:"data"`
+	}{}
+	err := getModelList(ctx, config.BaseURL+"/models", map[string]string{
+		"Authorization": "Bearer " + *conf.OpenRouterToken,
+	}, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	// prices come along with models, they are used to calculate cost of openrouter requests
+	models := make([]string, 0, len(resp.Data))
+	prices := make(map[string]*conf.ModelPrice, len(resp.Data))
+	for _, model := range resp.Data {
+		models = append(models, model.ID)
+		prices[model.ID] = &conf.ModelPrice{
+			Prompt:     parseTokenPrice(model.Pricing.Prompt),
+			Completion: parseTokenPrice(model.Pricing.Completion),
+			Cached:     parseTokenPrice(model.Pricing.InputCacheRead),
+		}
+	}
+	setOpenRouterPrices(prices)
+	return models, nil
+}
+
+// listAnthropicModels anthropic returns models page by page
+func listAnthropicModels(ctx context.Context) ([]string, error) {
+	models := make([]string, 0)
+	afterId := ""
+	for {
+		resp := &struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+			HasMore bool   `json:"has_more"`
+			LastId  string `json:"last_id"`
+		}{}
+		url := strings.TrimRight(getCustomUrl(param.Anthropic, AnthropicUrl), "/") + "/v1/models?limit=1000"
+		if afterId != "" {
+			url += "&after_id=" + afterId
+		}
+		err := getModelList(ctx, url, map[string]string{
+			"x-api-key":         *conf.AnthropicToken,
+			"anthropic-version": AnthropicVersion,
+		}, resp)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, model := range resp.Data {
+			models = append(models, model.ID)
+		}
+		if !resp.HasMore || resp.LastId == "" {
+			return models, nil
+		}
+		afterId = resp.LastId
+	}
+}
+
+func getModelList(ctx context.Context, url string, headers map[string]string, resp interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	httpResp, err := utils.GetDeepseekProxyClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("list models status %d: %s", httpResp.StatusCode, string(body))
+	}
+	return json.Unmarshal(body, resp)
+}
@@ -0,0 +1,59 @@
+package llm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/param"
+)
+
+func TestGetModels(t *testing.T) {
+	defer func() {
+		conf.ModelAllowList, conf.ModelDenyList = nil, nil
+		setCatalogModels(param.OpenAi, nil)
+		setCatalogModels(param.OpenRouter, nil)
+	}()
+
+	// builtin models are used before list endpoint answers
+	assert.True(t, IsModelOf(param.Gemini, param.ModelGemini25Pro))
+
+	setCatalogModels(param.OpenAi, []string{"gpt-5", "gpt-4o", "gpt-4o-mini", "gpt-4o-audio-preview"})
+	assert.Equal(t, []string{"gpt-4o", "gpt-4o-audio-preview", "gpt-4o-mini", "gpt-5"}, GetModels(param.OpenAi))
+	assert.False(t, IsModelOf(param.OpenAi, "gpt-3.5-turbo"))
+	assert.True(t, IsCatalogModel("gpt-5"))
+
+	// allow list only works for its llm type, exact entry is added
+	conf.ModelAllowList = []string{"openai:gpt-4o*", "openai:ft:gpt-4o:team"}
+	conf.ModelDenyList = []string{"*preview*", "openai:*mini"}
+	assert.Equal(t, []string{"ft:gpt-4o:team", "gpt-4o"}, GetModels(param.OpenAi))
+	assert.True(t, IsModelOf(param.Gemini, param.ModelGemini25Pro))
+	assert.False(t, IsModelOf(param.Gemini, "gemini-2.5-flash-preview-05-20"))
+
+	setCatalogModels(param.OpenRouter, []string{"google/gemini-2.5-pro", "openai/gpt-4o", "google/gemma-3-4b-it"})
+	assert.Equal(t, []string{"google", "openai"}, GetOpenRouterModelTypes())
+}
+
+func TestListAnthropicModels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/models", r.URL.Path)
+		assert.Equal(t, "ant-token", r.Header.Get("x-api-key"))
+		if r.URL.Query().Get("after_id") == "" {
+			_, _ = w.Write([]byte(`{"data":[{"id":"claude-sonnet-4-5"}],"has_more":true,"last_id":"claude-sonnet-4-5"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":[{"id":"claude-haiku-4-5"}],"has_more":false}`))
+	}))
+	defer server.Close()
+	setAnthropicConf(server.URL)
+
+	models, err := listAnthropicModels(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"claude-sonnet-4-5", "claude-haiku-4-5"}, models)
+
+	assert.True(t, isChatModel("gpt-4o"))
+	assert.False(t, isChatModel("text-embedding-3-small"))
+}
@@ -0,0 +1,103 @@
+package llm
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/db"
+	"github.com/yincongcyincong/telegram-deepseek-bot/param"
+	"github.com/yincongcyincong/telegram-deepseek-bot/utils"
+)
+
+var (
+	// openRouterPrices prices returned by openrouter model list, key is model
+	openRouterPrices     = make(map[string]*conf.ModelPrice)
+	openRouterPricesLock sync.RWMutex
+)
+
+// GetModelPrice get price of model, price in conf file is used before price fetched from openrouter.
+// return nil if price of model is unknown.
+func GetModelPrice(llmType, model string) *conf.ModelPrice {
+	if price, ok := conf.ModelPrices[llmType+":"+model]; ok {
+		return price
+	}
+	if price, ok := conf.ModelPrices[model]; ok {
+		return price
+	}
+
+	if llmType == param.OpenRouter {
+		openRouterPricesLock.RLock()
+		defer openRouterPricesLock.RUnlock()
+		return openRouterPrices[model]
+	}
+	return nil
+}
+
+// GetCost get cost of request in USD, cached token is part of prompt token
+func GetCost(llmType, model string, promptToken, completionToken, cachedToken int) float64 {
+	price := GetModelPrice(llmType, model)
+	if price == nil {
+		return 0
+	}
+
+	cachedPrice := price.Cached
+	if cachedPrice == 0 {
+		cachedPrice = price.Prompt
+	}
+	cachedToken = min(cachedToken, promptToken)
+	return (float64(promptToken-cachedToken)*price.Prompt + float64(cachedToken)*cachedPrice +
+		float64(completionToken)*price.Completion) / 1e6
+}
+
+func setOpenRouterPrices(prices map[string]*conf.ModelPrice) {
+	openRouterPricesLock.Lock()
+	defer openRouterPricesLock.Unlock()
+	openRouterPrices = prices
+}
+
+// parseTokenPrice openrouter returns USD per token as string, convert it to USD per 1M tokens
+func parseTokenPrice(price string) float64 {
+	p, err := strconv.ParseFloat(price, 64)
+	if err != nil || p < 0 {
+		return 0
+	}
+	return p * 1e6
+}
+
+// addUsage add usage of one llm request, cost is calculated by current llm type and model
+// because request may fall back to another llm.
+func (l *LLM) addUsage(promptToken, completionToken, cachedToken int) {
+	l.PromptToken += promptToken
+	l.CompletionToken += completionToken
+	l.CachedToken += cachedToken
+	l.Cost += GetCost(l.Type, l.Model, promptToken, completionToken, cachedToken)
+}
+
+// recordUsage store usage of request which isn't an answer, such as summary and session title,
+// record is deleted so it isn't history of chat, but its token and cost are counted.
+func (l *LLM) recordUsage(question, answer string) {
+	_, _, userId := utils.GetChatIdAndMsgIdAndUserID(l.Update)
+	db.InsertRecordInfo(&db.Record{
+		UserId:    userId,
+		ChatId:    l.RecordKey.ChatId,
+		SessionId: l.RecordKey.SessionId,
+		Question:  question,
+		Answer:    answer,
+		Token:     l.Token,
+		IsDeleted: 1,
+		Usage:     l.getUsage(),
+	})
+}
+
+// getUsage get usage of question which is stored in record
+func (l *LLM) getUsage() db.Usage {
+	return db.Usage{
+		LLMType:         l.Type,
+		Model:           l.Model,
+		PromptToken:     l.PromptToken,
+		CompletionToken: l.CompletionToken,
+		CachedToken:     l.CachedToken,
+		Cost:            l.Cost,
+	}
+}
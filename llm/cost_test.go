@@ -0,0 +1,49 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/param"
+)
+
+func TestGetCost(t *testing.T) {
+	defer func() {
+		conf.ModelPrices = make(map[string]*conf.ModelPrice)
+		setOpenRouterPrices(make(map[string]*conf.ModelPrice))
+	}()
+
+	conf.ModelPrices = map[string]*conf.ModelPrice{
+		"deepseek-chat":        {Prompt: 0.27, Completion: 1.1, Cached: 0.07},
+		"openai:gpt-4o":        {Prompt: 2.5, Completion: 10},
+		"openrouter:free-chat": {},
+	}
+	setOpenRouterPrices(map[string]*conf.ModelPrice{
+		"openai/gpt-4o": {Prompt: 2.5, Completion: 10, Cached: 1.25},
+		"free-chat":     {Prompt: 1, Completion: 1},
+	})
+
+	// cached token is part of prompt token
+	assert.InDelta(t, (600*0.27+400*0.07+500*1.1)/1e6, GetCost(param.DeepSeek, "deepseek-chat", 1000, 500, 400), 1e-12)
+	// cached price is same as prompt price if it isn't set
+	assert.InDelta(t, (1000*2.5+100*10)/1e6, GetCost(param.OpenAi, "gpt-4o", 1000, 100, 500), 1e-12)
+	assert.InDelta(t, (500*2.5+500*1.25+100*10)/1e6, GetCost(param.OpenRouter, "openai/gpt-4o", 1000, 100, 500), 1e-12)
+	// price in conf file goes first
+	assert.Equal(t, float64(0), GetCost(param.OpenRouter, "free-chat", 1000, 100, 0))
+	assert.Equal(t, float64(0), GetCost(param.Gemini, "unknown", 1000, 100, 0))
+
+	// request falls back to another llm, cost of each request uses its own price
+	l := &LLM{Type: param.OpenAi, Model: "gpt-4o"}
+	l.addUsage(1000, 0, 0)
+	l.Type, l.Model = param.DeepSeek, "deepseek-chat"
+	l.addUsage(1000, 100, 0)
+	usage := l.getUsage()
+	assert.Equal(t, param.DeepSeek, usage.LLMType)
+	assert.Equal(t, 2000, usage.PromptToken)
+	assert.Equal(t, 100, usage.CompletionToken)
+	assert.InDelta(t, (1000*2.5+1000*0.27+100*1.1)/1e6, usage.Cost, 1e-12)
+
+	assert.Equal(t, 2.5, parseTokenPrice("0.0000025"))
+	assert.Equal(t, float64(0), parseTokenPrice("-1"))
+}
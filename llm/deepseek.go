@@ -32,7 +32,8 @@ type DeepseekReq struct {
 func (d *DeepseekReq) CallLLMAPI(ctx context.Context, prompt string, l *LLM) error {
 	_, _, userId := utils.GetChatIdAndMsgIdAndUserID(l.Update)
 
-	d.GetMessages(userId, prompt)
+	d.GetModel(l)
+	d.GetMessages(l, prompt)
 
 	logger.Info("msg receive", "userID", userId, "prompt", prompt)
 
@@ -40,6 +41,9 @@ func (d *DeepseekReq) CallLLMAPI(ctx context.Context, prompt string, l *LLM) err
 }
 
 func (d *DeepseekReq) GetModel(l *LLM) {
+	if l.useForceModel() {
+		return
+	}
 	_, _, userId := utils.GetChatIdAndMsgIdAndUserID(l.Update)
 
 	l.Model = deepseek.DeepSeekChat
@@ -47,46 +51,48 @@ func (d *DeepseekReq) GetModel(l *LLM) {
 	if err != nil {
 		logger.Error("Error getting user info", "err", err)
 	}
-	if userInfo != nil && userInfo.Mode != "" && param.DeepseekModels[userInfo.Mode] {
+	if userInfo != nil && userInfo.Mode != "" && IsModelOf(param.DeepSeek, userInfo.Mode) {
 		logger.Info("User info", "userID", userInfo.UserId, "mode", userInfo.Mode)
 		l.Model = userInfo.Mode
 	}
 }
 
-func (d *DeepseekReq) GetMessages(userId int64, prompt string) {
+func (d *DeepseekReq) GetMessages(l *LLM, prompt string) {
+	key := l.RecordKey
 	messages := make([]deepseek.ChatCompletionMessage, 0)
 
-	msgRecords := db.GetMsgRecord(userId)
-	if msgRecords != nil {
-		aqs := msgRecords.AQs
-		if len(aqs) > 10 {
-			aqs = aqs[len(aqs)-10:]
-		}
+	systemPrompt := getSystemPrompt(key)
+	if systemPrompt != "" {
+		messages = append(messages, deepseek.ChatCompletionMessage{
+			Role:    constants.ChatMessageRoleSystem,
+			Content: systemPrompt,
+		})
+	}
 
-		for i, record := range aqs {
-			if record.Answer != "" && record.Question != "" {
-				logger.Info("context content", "dialog", i, "question:", record.Question,
-					"toolContent", record.Content, "answer:", record.Answer)
-				messages = append(messages, deepseek.ChatCompletionMessage{
-					Role:    constants.ChatMessageRoleUser,
-					Content: record.Question,
-				})
-				if record.Content != "" {
-					toolsMsgs := make([]deepseek.ChatCompletionMessage, 0)
-					err := json.Unmarshal([]byte(record.Content), &toolsMsgs)
-					if err != nil {
-						logger.Error("Error unmarshalling tools json", "err", err)
-					} else {
-						messages = append(messages, toolsMsgs...)
-					}
-				}
-				messages = append(messages, deepseek.ChatCompletionMessage{
-					Role:    constants.ChatMessageRoleAssistant,
-					Content: record.Answer,
-				})
-			}
+	summary, aqs := l.getContext(key, l.DeepseekTools, systemPrompt, prompt)
+	if summary != "" {
+		messages = append(messages, deepseek.ChatCompletionMessage{
+			Role:    constants.ChatMessageRoleSystem,
+			Content: getMemoryPrompt(summary),
+		})
+	}
+
+	for i, record := range aqs {
+		if record.Answer != "" && record.Question != "" {
+			logger.Info("context content", "dialog", i, "question:", record.Question,
+				"toolContent", record.Content, "answer:", record.Answer)
+			messages = append(messages, deepseek.ChatCompletionMessage{
+				Role:    constants.ChatMessageRoleUser,
+				Content: record.Question,
+			})
+			messages = append(messages, toDeepseekMessages(unmarshalToolMessages(record.Content))...)
+			messages = append(messages, deepseek.ChatCompletionMessage{
+				Role:    constants.ChatMessageRoleAssistant,
+				Content: record.Answer,
+			})
 		}
 	}
+
 	messages = append(messages, deepseek.ChatCompletionMessage{
 		Role:    constants.ChatMessageRoleUser,
 		Content: prompt,
@@ -108,26 +114,27 @@ func (d *DeepseekReq) Send(ctx context.Context, l *LLM) error {
 	httpClient := utils.GetDeepseekProxyClient()
 
 	client, err := deepseek.NewClientWithOptions(*conf.DeepseekToken,
-		deepseek.WithBaseURL(*conf.CustomUrl), deepseek.WithHTTPClient(httpClient))
+		deepseek.WithBaseURL(getCustomUrl(param.DeepSeek, DeepseekUrl)), deepseek.WithHTTPClient(httpClient))
 	if err != nil {
 		logger.Error("Error creating deepseek client", "err", err)
 		return err
 	}
 
+	params := l.getParams()
 	request := &deepseek.StreamChatCompletionRequest{
 		Model:  l.Model,
 		Stream: true,
 		StreamOptions: deepseek.StreamOptions{
 			IncludeUsage: true,
 		},
-		MaxTokens:        *conf.MaxTokens,
-		TopP:             float32(*conf.TopP),
-		FrequencyPenalty: float32(*conf.FrequencyPenalty),
+		MaxTokens:        params.MaxTokens,
+		TopP:             float32(params.TopP),
+		FrequencyPenalty: float32(params.FrequencyPenalty),
 		TopLogProbs:      *conf.TopLogProbs,
 		LogProbs:         *conf.LogProbs,
-		Stop:             conf.Stop,
-		PresencePenalty:  float32(*conf.PresencePenalty),
-		Temperature:      float32(*conf.Temperature),
+		Stop:             params.Stop,
+		PresencePenalty:  float32(params.PresencePenalty),
+		Temperature:      float32(params.Temperature),
 		Tools:            l.DeepseekTools,
 	}
 
@@ -152,11 +159,16 @@ func (d *DeepseekReq) Send(ctx context.Context, l *LLM) error {
 		}
 		if err != nil {
 			logger.Warn("Stream error", "updateMsgID", updateMsgID, "err", err)
+			// nothing is answered, let next llm of fallback chain take over
+			if l.canFallback(ctx) {
+				return err
+			}
 			break
 		}
 		for _, choice := range response.Choices {
 			if len(choice.Delta.ToolCalls) > 0 {
 				hasTools = true
+				l.answered.Store(true)
 				err = d.requestToolsCall(ctx, choice)
 				if err != nil {
 					if errors.Is(err, ToolsJsonErr) {
@@ -167,6 +179,10 @@ func (d *DeepseekReq) Send(ctx context.Context, l *LLM) error {
 				}
 			}
 
+			if len(choice.Delta.ReasoningContent) > 0 {
+				l.sendReasoningMsg(choice.Delta.ReasoningContent)
+			}
+
 			if len(choice.Delta.Content) > 0 {
 				msgInfoContent = l.sendMsg(msgInfoContent, choice.Delta.Content)
 			}
@@ -174,19 +190,30 @@ func (d *DeepseekReq) Send(ctx context.Context, l *LLM) error {
 
 		if response.Usage != nil {
 			l.Token += response.Usage.TotalTokens
+			l.ReasoningToken += response.Usage.CompletionTokensDetails.ReasoningTokens
+			l.addUsage(response.Usage.PromptTokens, response.Usage.CompletionTokens, response.Usage.PromptCacheHitTokens)
 			metrics.TotalTokens.Add(float64(l.Token))
 		}
 	}
 
+	l.flushReasoningMsg()
+	msgInfoContent = l.appendStopNote(ctx, msgInfoContent)
 	if len(strings.TrimRightFunc(msgInfoContent.Content, unicode.IsSpace)) > 0 {
 		l.MessageChan <- msgInfoContent
 	}
 
-	if !hasTools || len(d.CurrentToolMessage) == 0 {
-		db.InsertMsgRecord(userId, &db.AQ{
-			Question: l.Content,
-			Answer:   l.WholeContent,
-			Token:    l.Token,
+	if !hasTools || len(d.CurrentToolMessage) == 0 || utils.IsRequestStopped(ctx) {
+		db.InsertMsgRecord(l.RecordKey, &db.AQ{
+			UserId:         userId,
+			Question:       l.Content,
+			Answer:         l.WholeContent,
+			Content:        marshalToolMessages(fromDeepseekMessages(d.ToolMessage)),
+			Token:          l.Token,
+			QuestionMsgId:  updateMsgID,
+			AnswerMsgId:    l.AnswerMsgId,
+			Reasoning:      l.ReasoningContent,
+			ReasoningToken: l.ReasoningToken,
+			Usage:          l.getUsage(),
 		}, true)
 	} else {
 		d.CurrentToolMessage = append([]deepseek.ChatCompletionMessage{
@@ -251,22 +278,23 @@ func (d *DeepseekReq) SyncSend(ctx context.Context, l *LLM) (string, error) {
 	httpClient := utils.GetDeepseekProxyClient()
 
 	client, err := deepseek.NewClientWithOptions(*conf.DeepseekToken,
-		deepseek.WithBaseURL(*conf.CustomUrl), deepseek.WithHTTPClient(httpClient))
+		deepseek.WithBaseURL(getCustomUrl(param.DeepSeek, DeepseekUrl)), deepseek.WithHTTPClient(httpClient))
 	if err != nil {
 		logger.Error("Error creating deepseek client", "err", err)
 		return "", err
 	}
 
+	params := l.getParams()
 	request := &deepseek.ChatCompletionRequest{
 		Model:            l.Model,
-		MaxTokens:        *conf.MaxTokens,
-		TopP:             float32(*conf.TopP),
-		FrequencyPenalty: float32(*conf.FrequencyPenalty),
+		MaxTokens:        params.MaxTokens,
+		TopP:             float32(params.TopP),
+		FrequencyPenalty: float32(params.FrequencyPenalty),
 		TopLogProbs:      *conf.TopLogProbs,
 		LogProbs:         *conf.LogProbs,
-		Stop:             conf.Stop,
-		PresencePenalty:  float32(*conf.PresencePenalty),
-		Temperature:      float32(*conf.Temperature),
+		Stop:             params.Stop,
+		PresencePenalty:  float32(params.PresencePenalty),
+		Temperature:      float32(params.Temperature),
 		Messages:         d.DeepseekMsgs,
 		Tools:            l.DeepseekTools,
 	}
@@ -284,6 +312,7 @@ func (d *DeepseekReq) SyncSend(ctx context.Context, l *LLM) (string, error) {
 	}
 
 	l.Token += response.Usage.TotalTokens
+	l.addUsage(response.Usage.PromptTokens, response.Usage.CompletionTokens, response.Usage.PromptCacheHitTokens)
 	if len(response.Choices[0].Message.ToolCalls) > 0 {
 		d.GetAssistantMessage("")
 		d.DeepseekMsgs[len(d.DeepseekMsgs)-1].ToolCalls = response.Choices[0].Message.ToolCalls
@@ -392,3 +421,52 @@ func GetBalanceInfo() *deepseek.BalanceResponse {
 
 	return balance
 }
+
+// fromDeepseekMessages transfer deepseek tool messages to messages of records
+func fromDeepseekMessages(msgs []deepseek.ChatCompletionMessage) []*param.ToolMessage {
+	res := make([]*param.ToolMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		toolMsg := &param.ToolMessage{
+			Role:       msg.Role,
+			Content:    msg.Content,
+			ToolCallID: msg.ToolCallID,
+		}
+		for _, toolCall := range msg.ToolCalls {
+			toolMsg.ToolCalls = append(toolMsg.ToolCalls, &param.ToolCall{
+				ID:   toolCall.ID,
+				Type: toolCall.Type,
+				Function: param.ToolCallFunction{
+					Name:      toolCall.Function.Name,
+					Arguments: toolCall.Function.Arguments,
+				},
+			})
+		}
+		res = append(res, toolMsg)
+	}
+	return res
+}
+
+// toDeepseekMessages transfer tool messages of records to deepseek messages
+func toDeepseekMessages(msgs []*param.ToolMessage) []deepseek.ChatCompletionMessage {
+	res := make([]deepseek.ChatCompletionMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		dsMsg := deepseek.ChatCompletionMessage{
+			Role:       msg.Role,
+			Content:    msg.Content,
+			ToolCallID: msg.ToolCallID,
+		}
+		for i, toolCall := range msg.ToolCalls {
+			dsMsg.ToolCalls = append(dsMsg.ToolCalls, deepseek.ToolCall{
+				Index: i,
+				ID:    toolCall.ID,
+				Type:  toolCall.Type,
+				Function: deepseek.ToolCallFunction{
+					Name:      toolCall.Function.Name,
+					Arguments: toolCall.Function.Arguments,
+				},
+			})
+		}
+		res = append(res, dsMsg)
+	}
+	return res
+}
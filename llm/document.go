@@ -0,0 +1,159 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/yincongcyincong/langchaingo/textsplitter"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/i18n"
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+	"github.com/yincongcyincong/telegram-deepseek-bot/utils"
+)
+
+const (
+	// maxDocumentChunks most chunks of large document which are summarized, the rest is dropped
+	maxDocumentChunks = 20
+	// minDocumentBudget tokens of document when context window of model is too small to get half of it
+	minDocumentBudget = 1000
+)
+
+var (
+	ErrDocumentTooLarge = errors.New("document too large")
+	ErrDocumentDownload = errors.New("download document fail")
+)
+
+// ReadDocument read text of document in question, the caption is the question about it.
+// document is sent in question, so it's kept in history and follow-up questions can refer to it.
+// document which doesn't fit half of context window is summarized chunk by chunk.
+// user is told why the document can't be read if it fails.
+func (l *LLM) ReadDocument(ctx context.Context) error {
+	if l.Update.Message == nil || l.Update.Message.Document == nil {
+		return nil
+	}
+
+	err := l.readDocument(ctx)
+	if err != nil {
+		chatId, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(l.Update)
+		switch {
+		case errors.Is(err, ErrDocumentTooLarge):
+			i18n.SendMsg(chatId, "document_too_large", l.Bot, nil, msgId)
+		case errors.Is(err, utils.ErrUnsupportedDocument):
+			i18n.SendMsg(chatId, "document_unsupported", l.Bot, nil, msgId)
+		case errors.Is(err, utils.ErrEmptyDocument):
+			i18n.SendMsg(chatId, "document_empty", l.Bot, nil, msgId)
+		default:
+			i18n.SendMsg(chatId, "document_fail", l.Bot, nil, msgId)
+		}
+	}
+	return err
+}
+
+func (l *LLM) readDocument(ctx context.Context) error {
+	document := l.Update.Message.Document
+	if document.FileSize > utils.MaxDocumentFileSize {
+		return ErrDocumentTooLarge
+	}
+
+	data := utils.GetDocumentContent(l.Update, l.Bot)
+	if data == nil {
+		return ErrDocumentDownload
+	}
+	text, err := utils.GetDocumentText(ctx, document.FileName, document.MimeType, data)
+	if err != nil {
+		return err
+	}
+
+	question := l.Content
+	if question == "" {
+		question = strings.TrimSpace(l.Update.Message.Caption)
+	}
+	if question == "" {
+		question = i18n.GetMessage(*conf.Lang, "document_default_prompt", nil)
+	}
+
+	l.LLMClient.GetModel(l)
+	budget := l.getDocumentBudget()
+	summarized := false
+	if utils.CountToken(l.Model, text) > budget {
+		logger.Info("document exceeds context window, summarize it", "name", document.FileName, "budget", budget)
+		text, err = l.summarizeDocument(ctx, text, question, budget)
+		if err != nil {
+			return err
+		}
+		summarized = true
+	}
+
+	l.Content = i18n.GetMessage(*conf.Lang, "document_prompt", map[string]interface{}{
+		"name":       document.FileName,
+		"document":   text,
+		"question":   question,
+		"summarized": summarized,
+	})
+	return nil
+}
+
+// getDocumentBudget document takes at most half of context window, the rest is left for history and answer
+func (l *LLM) getDocumentBudget() int {
+	return max((getContextLimit(l.Type, l.Model)-l.getParams().MaxTokens)/2, minDocumentBudget)
+}
+
+// summarizeDocument split document into chunks which fit budget, and summarize every chunk with question,
+// summaries share the budget.
+func (l *LLM) summarizeDocument(ctx context.Context, text, question string, budget int) (string, error) {
+	countToken := func(s string) int {
+		return utils.CountToken(l.Model, s)
+	}
+
+	chunks, err := splitDocument(text, budget, countToken)
+	if err != nil {
+		return "", err
+	}
+	if len(chunks) > maxDocumentChunks {
+		logger.Warn("document has too many chunks, the rest is dropped", "chunks", len(chunks))
+		chunks = chunks[:maxDocumentChunks]
+	}
+
+	summaries := make([]string, 0, len(chunks))
+	for i, chunk := range chunks {
+		prompt := i18n.GetMessage(*conf.Lang, "document_summary_prompt", map[string]interface{}{
+			"index":    i + 1,
+			"total":    len(chunks),
+			"tokens":   budget / len(chunks),
+			"question": question,
+			"document": chunk,
+		})
+		summaryLLM := NewLLM(WithBot(l.Bot), WithUpdate(l.Update), WithRecordKey(l.RecordKey), WithContent(prompt))
+		summaryLLM.LLMClient.GetUserMessage(prompt)
+		summary, err := summaryLLM.LLMClient.SyncSend(ctx, summaryLLM)
+		if err != nil {
+			return "", err
+		}
+		summaryLLM.recordUsage("document summary", summary)
+		summaries = append(summaries, strings.TrimSpace(summary))
+	}
+
+	return truncateByToken(strings.Join(summaries, "\n\n"), budget, countToken), nil
+}
+
+// splitDocument split text into chunks whose tokens don't exceed chunkToken
+func splitDocument(text string, chunkToken int, countToken func(string) int) ([]string, error) {
+	splitter := textsplitter.NewRecursiveCharacter(
+		textsplitter.WithChunkSize(chunkToken),
+		textsplitter.WithChunkOverlap(0),
+		textsplitter.WithSeparators(conf.DefaultSpliter),
+		textsplitter.WithLenFunc(countToken),
+	)
+	return splitter.SplitText(text)
+}
+
+// truncateByToken cut the end of text, so its tokens don't exceed budget
+func truncateByToken(text string, budget int, countToken func(string) int) string {
+	tokens := countToken(text)
+	if tokens <= budget {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:len(runes)*budget/tokens])
+}
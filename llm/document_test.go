@@ -0,0 +1,46 @@
+package llm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/utils"
+)
+
+func TestSplitDocument(t *testing.T) {
+	countToken := utils.EstimateToken
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog.\n\n", 50)
+
+	chunks, err := splitDocument(text, 100, countToken)
+	assert.Nil(t, err)
+	assert.Greater(t, len(chunks), 1)
+	for _, chunk := range chunks {
+		assert.LessOrEqual(t, countToken(chunk), 100)
+	}
+
+	chunks, err = splitDocument("short document", 100, countToken)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"short document"}, chunks)
+}
+
+func TestTruncateByToken(t *testing.T) {
+	countToken := utils.EstimateToken
+	assert.Equal(t, "short", truncateByToken("short", 10, countToken))
+
+	text := strings.Repeat("文档", 100)
+	truncated := truncateByToken(text, 50, countToken)
+	assert.LessOrEqual(t, countToken(truncated), 50)
+	assert.True(t, strings.HasPrefix(text, truncated))
+}
+
+func TestGetDocumentBudget(t *testing.T) {
+	contextLimit, maxTokens := 10000, 2000
+	conf.ContextLimit, conf.ModelContextLimits = &contextLimit, nil
+	l := &LLM{Model: "unknown-model", params: &Params{MaxTokens: maxTokens}}
+	assert.Equal(t, 4000, l.getDocumentBudget())
+
+	contextLimit = 1000
+	assert.Equal(t, minDocumentBudget, l.getDocumentBudget(), "small context window still reads part of document")
+}
@@ -0,0 +1,129 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/i18n"
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+	"github.com/yincongcyincong/telegram-deepseek-bot/metrics"
+	"github.com/yincongcyincong/telegram-deepseek-bot/param"
+	"github.com/yincongcyincong/telegram-deepseek-bot/utils"
+)
+
+var (
+	FirstTokenTimeoutErr = errors.New("llm doesn't respond in fallback timeout")
+)
+
+// fallbackModel one llm of fallback chain, empty model means the model chosen by user or default model of llm type
+type fallbackModel struct {
+	Type  string
+	Model string
+}
+
+func (f fallbackModel) String() string {
+	if f.Model == "" {
+		return f.Type
+	}
+	return f.Type + ":" + f.Model
+}
+
+// parseFallbackChain parse chain like deepseek:deepseek-chat -> openrouter:deepseek/deepseek-chat, ',' is also a separator
+func parseFallbackChain(chain string) []fallbackModel {
+	models := make([]fallbackModel, 0)
+	for _, s := range strings.Split(strings.ReplaceAll(chain, "->", ","), ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		// model name may contain ':', such as llava:latest
+		llmType, model, _ := strings.Cut(s, ":")
+		models = append(models, fallbackModel{
+			Type:  strings.TrimSpace(llmType),
+			Model: strings.TrimSpace(model),
+		})
+	}
+	return models
+}
+
+// callWithFallback request llm, when request fails before anything is answered,
+// it's sent to the next llm of fallback chain which isn't tried yet.
+func (l *LLM) callWithFallback(ctx context.Context, prompt string) error {
+	err := l.callWithTimeout(ctx, prompt)
+	tried := []fallbackModel{{Type: l.Type, Model: l.Model}}
+	for _, next := range parseFallbackChain(*conf.FallbackChain) {
+		if err == nil || l.answered.Load() || ctx.Err() != nil {
+			break
+		}
+		if !slices.Contains(GetAvailableTypes(), next.Type) || slices.ContainsFunc(tried, func(f fallbackModel) bool {
+			return f.Type == next.Type && (next.Model == "" || f.Model == next.Model)
+		}) {
+			continue
+		}
+
+		from := tried[len(tried)-1]
+		logger.Warn("llm request fail, fall back to next llm", "from", from, "to", next, "err", err)
+		metrics.LLMFallbackCount.WithLabelValues(from.Type, next.Type).Inc()
+
+		l.LLMClient = newLLMClient(next.Type)
+		l.Type = next.Type
+		l.ForceModel = next.Model
+		l.LoopNum = 0
+		err = l.callWithTimeout(ctx, prompt)
+		tried = append(tried, fallbackModel{Type: l.Type, Model: l.Model})
+	}
+
+	if err == nil && len(tried) > 1 {
+		l.MessageChan <- &param.MsgInfo{
+			Content: i18n.GetMessage(*conf.Lang, "llm_fallback_note", map[string]interface{}{
+				"from":  tried[0].String(),
+				"model": tried[len(tried)-1].String(),
+			}),
+			IsNotice: true,
+		}
+	}
+
+	return err
+}
+
+// callWithTimeout request llm, it's canceled if llm doesn't respond in fallback timeout,
+// so the next llm of fallback chain can take over.
+func (l *LLM) callWithTimeout(ctx context.Context, prompt string) error {
+	if *conf.FallbackChain == "" || *conf.FallbackTimeout <= 0 {
+		return l.LLMClient.CallLLMAPI(ctx, prompt, l)
+	}
+
+	attemptCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	timer := time.AfterFunc(time.Duration(*conf.FallbackTimeout)*time.Second, func() {
+		if !l.answered.Load() {
+			cancel(FirstTokenTimeoutErr)
+		}
+	})
+	defer timer.Stop()
+
+	err := l.LLMClient.CallLLMAPI(attemptCtx, prompt, l)
+	if err != nil && errors.Is(context.Cause(attemptCtx), FirstTokenTimeoutErr) {
+		return FirstTokenTimeoutErr
+	}
+	return err
+}
+
+// canFallback check whether nothing is answered and request isn't stopped by user
+func (l *LLM) canFallback(ctx context.Context) bool {
+	return !l.answered.Load() && !utils.IsRequestStopped(ctx)
+}
+
+// useForceModel use model of fallback chain instead of model chosen by user
+func (l *LLM) useForceModel() bool {
+	if l.ForceModel == "" {
+		return false
+	}
+	l.Model = l.ForceModel
+	return true
+}
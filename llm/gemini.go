@@ -8,6 +8,7 @@ import (
 	"time"
 	"unicode"
 
+	"github.com/cohesion-org/deepseek-go/constants"
 	"github.com/yincongcyincong/mcp-client-go/clients"
 	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
 	"github.com/yincongcyincong/telegram-deepseek-bot/db"
@@ -23,57 +24,73 @@ type GeminiReq struct {
 	ToolMessage        []*genai.Content
 	CurrentToolMessage []*genai.Content
 
-	GeminiMsgs []*genai.Content
+	GeminiMsgs   []*genai.Content
+	SystemPrompt string
 }
 
 func (h *GeminiReq) CallLLMAPI(ctx context.Context, prompt string, l *LLM) error {
 	_, _, userId := utils.GetChatIdAndMsgIdAndUserID(l.Update)
 
-	h.GetMessages(userId, prompt)
+	h.GetModel(l)
+	h.GetMessages(l, prompt)
 
 	logger.Info("msg receive", "userID", userId, "prompt", l.Content)
 	return h.Send(ctx, l)
 }
 
-func (h *GeminiReq) GetMessages(userId int64, prompt string) {
+func (h *GeminiReq) GetMessages(l *LLM, prompt string) {
+	key := l.RecordKey
 	messages := make([]*genai.Content, 0)
+	h.SystemPrompt = getSystemPrompt(key)
 
-	msgRecords := db.GetMsgRecord(userId)
-	if msgRecords != nil {
-		aqs := msgRecords.AQs
-		if len(aqs) > 10 {
-			aqs = aqs[len(aqs)-10:]
+	summary, aqs := l.getContext(key, l.GeminiTools, h.SystemPrompt, prompt)
+	// gemini only has one system instruction, summary is appended to it
+	if summary != "" {
+		if h.SystemPrompt != "" {
+			h.SystemPrompt += "\n\n"
 		}
-		for i, record := range aqs {
-			if record.Answer != "" && record.Question != "" {
-				logger.Info("context content", "dialog", i, "question:", record.Question,
-					"toolContent", record.Content, "answer:", record.Answer)
-
-				messages = append(messages, &genai.Content{
-					Role: genai.RoleUser,
-					Parts: []*genai.Part{
-						{
-							Text: record.Question,
-						},
+		h.SystemPrompt += getMemoryPrompt(summary)
+	}
+
+	for i, record := range aqs {
+		if record.Answer != "" && record.Question != "" {
+			logger.Info("context content", "dialog", i, "question:", record.Question,
+				"toolContent", record.Content, "answer:", record.Answer)
+
+			messages = append(messages, &genai.Content{
+				Role: genai.RoleUser,
+				Parts: []*genai.Part{
+					{
+						Text: record.Question,
 					},
-				})
+				},
+			})
+			messages = append(messages, toGeminiMessages(unmarshalToolMessages(record.Content))...)
 
-				messages = append(messages, &genai.Content{
-					Role: genai.RoleModel,
-					Parts: []*genai.Part{
-						{
-							Text: record.Answer,
-						},
+			messages = append(messages, &genai.Content{
+				Role: genai.RoleModel,
+				Parts: []*genai.Part{
+					{
+						Text: record.Answer,
 					},
-				})
+				},
+			})
 
-			}
 		}
 	}
 
 	h.GeminiMsgs = messages
 }
 
+// getGeminiParts question and its images, images are only sent in this turn
+func (l *LLM) getGeminiParts() []genai.Part {
+	parts := []genai.Part{*genai.NewPartFromText(l.Content)}
+	for _, image := range l.getRequestImages() {
+		parts = append(parts, *genai.NewPartFromBytes(image, imageMimeType(image)))
+	}
+	return parts
+}
+
 func (h *GeminiReq) Send(ctx context.Context, l *LLM) error {
 	if l.OverLoop() {
 		return errors.New("too many loops")
@@ -93,13 +110,19 @@ func (h *GeminiReq) Send(ctx context.Context, l *LLM) error {
 		return err
 	}
 
+	params := l.getParams()
 	config := &genai.GenerateContentConfig{
-		TopP:             genai.Ptr[float32](float32(*conf.TopP)),
-		FrequencyPenalty: genai.Ptr[float32](float32(*conf.FrequencyPenalty)),
-		PresencePenalty:  genai.Ptr[float32](float32(*conf.PresencePenalty)),
-		Temperature:      genai.Ptr[float32](float32(*conf.Temperature)),
+		TopP:             genai.Ptr[float32](float32(params.TopP)),
+		FrequencyPenalty: genai.Ptr[float32](float32(params.FrequencyPenalty)),
+		PresencePenalty:  genai.Ptr[float32](float32(params.PresencePenalty)),
+		Temperature:      genai.Ptr[float32](float32(params.Temperature)),
+		MaxOutputTokens:  int32(params.MaxTokens),
+		StopSequences:    params.Stop,
 		Tools:            l.GeminiTools,
 	}
+	if h.SystemPrompt != "" {
+		config.SystemInstruction = genai.NewContentFromText(h.SystemPrompt, genai.RoleUser)
+	}
 
 	chat, err := client.Chats.Create(ctx, l.Model, config, h.GeminiMsgs)
 	if err != nil {
@@ -112,19 +135,26 @@ func (h *GeminiReq) Send(ctx context.Context, l *LLM) error {
 	}
 
 	hasTools := false
-	for response, err := range chat.SendMessageStream(ctx, *genai.NewPartFromText(l.Content)) {
+	// every chunk carries usage of the whole request so far, only the last one is counted
+	var usage *genai.GenerateContentResponseUsageMetadata
+	for response, err := range chat.SendMessageStream(ctx, l.getGeminiParts()...) {
 		if errors.Is(err, io.EOF) {
 			logger.Info("stream finished", "updateMsgID", updateMsgID)
 			break
 		}
 		if err != nil {
 			logger.Error("stream error:", "updateMsgID", updateMsgID, "err", err)
+			// nothing is answered, let next llm of fallback chain take over
+			if l.canFallback(ctx) {
+				return err
+			}
 			break
 		}
 
 		toolCalls := response.FunctionCalls()
 		if len(toolCalls) > 0 {
 			hasTools = true
+			l.answered.Store(true)
 			err = h.requestToolsCall(ctx, response)
 			if err != nil {
 				if errors.Is(err, ToolsJsonErr) {
@@ -140,21 +170,33 @@ func (h *GeminiReq) Send(ctx context.Context, l *LLM) error {
 		}
 
 		if response.UsageMetadata != nil {
-			l.Token += int(response.UsageMetadata.TotalTokenCount)
-			metrics.TotalTokens.Add(float64(l.Token))
+			usage = response.UsageMetadata
 		}
 
 	}
 
+	if usage != nil {
+		l.Token += int(usage.TotalTokenCount)
+		l.addGeminiUsage(usage)
+		metrics.TotalTokens.Add(float64(l.Token))
+	}
+
+	msgInfoContent = l.appendStopNote(ctx, msgInfoContent)
 	if len(strings.TrimRightFunc(msgInfoContent.Content, unicode.IsSpace)) > 0 {
 		l.MessageChan <- msgInfoContent
 	}
 
-	if !hasTools || len(h.CurrentToolMessage) == 0 {
-		db.InsertMsgRecord(userId, &db.AQ{
-			Question: l.Content,
-			Answer:   l.WholeContent,
-			Token:    l.Token,
+	if !hasTools || len(h.CurrentToolMessage) == 0 || utils.IsRequestStopped(ctx) {
+		db.InsertMsgRecord(l.RecordKey, &db.AQ{
+			UserId:         userId,
+			Question:       l.Content,
+			Answer:         l.WholeContent,
+			Content:        marshalToolMessages(fromGeminiMessages(h.ToolMessage)),
+			Token:          l.Token,
+			QuestionMsgId:  updateMsgID,
+			AnswerMsgId:    l.AnswerMsgId,
+			ReasoningToken: l.ReasoningToken,
+			Usage:          l.getUsage(),
 		}, true)
 	} else {
 		h.ToolMessage = append(h.ToolMessage, h.CurrentToolMessage...)
@@ -225,13 +267,19 @@ func (h *GeminiReq) SyncSend(ctx context.Context, l *LLM) (string, error) {
 		return "", err
 	}
 
+	params := l.getParams()
 	config := &genai.GenerateContentConfig{
-		TopP:             genai.Ptr[float32](float32(*conf.TopP)),
-		FrequencyPenalty: genai.Ptr[float32](float32(*conf.FrequencyPenalty)),
-		PresencePenalty:  genai.Ptr[float32](float32(*conf.PresencePenalty)),
-		Temperature:      genai.Ptr[float32](float32(*conf.Temperature)),
+		TopP:             genai.Ptr[float32](float32(params.TopP)),
+		FrequencyPenalty: genai.Ptr[float32](float32(params.FrequencyPenalty)),
+		PresencePenalty:  genai.Ptr[float32](float32(params.PresencePenalty)),
+		Temperature:      genai.Ptr[float32](float32(params.Temperature)),
+		MaxOutputTokens:  int32(params.MaxTokens),
+		StopSequences:    params.Stop,
 		Tools:            l.GeminiTools,
 	}
+	if h.SystemPrompt != "" {
+		config.SystemInstruction = genai.NewContentFromText(h.SystemPrompt, genai.RoleUser)
+	}
 
 	chat, err := client.Chats.Create(ctx, l.Model, config, h.GeminiMsgs)
 	if err != nil {
@@ -239,13 +287,17 @@ func (h *GeminiReq) SyncSend(ctx context.Context, l *LLM) (string, error) {
 		return "", err
 	}
 
-	response, err := chat.Send(ctx, genai.NewPartFromText(l.Content))
+	parts := l.getGeminiParts()
+	response, err := chat.SendMessage(ctx, parts...)
 	if err != nil {
 		logger.Error("create chat fail", "err", err)
 		return "", err
 	}
 
-	l.Token += int(response.UsageMetadata.TotalTokenCount)
+	if response.UsageMetadata != nil {
+		l.Token += int(response.UsageMetadata.TotalTokenCount)
+		l.addGeminiUsage(response.UsageMetadata)
+	}
 	if len(response.FunctionCalls()) > 0 {
 		h.requestOneToolsCall(ctx, response.FunctionCalls())
 	}
@@ -351,6 +403,9 @@ func (h *GeminiReq) requestToolsCall(ctx context.Context, response *genai.Genera
 }
 
 func (h *GeminiReq) GetModel(l *LLM) {
+	if l.useForceModel() {
+		return
+	}
 	_, _, userId := utils.GetChatIdAndMsgIdAndUserID(l.Update)
 
 	l.Model = param.ModelGemini20Flash
@@ -358,8 +413,93 @@ func (h *GeminiReq) GetModel(l *LLM) {
 	if err != nil {
 		logger.Error("Error getting user info", "err", err)
 	}
-	if userInfo != nil && userInfo.Mode != "" && param.GeminiModels[userInfo.Mode] {
+	if userInfo != nil && userInfo.Mode != "" && IsModelOf(param.Gemini, userInfo.Mode) {
 		logger.Info("User info", "userID", userInfo.UserId, "mode", userInfo.Mode)
 		l.Model = userInfo.Mode
 	}
 }
+
+// addGeminiUsage thoughts and tool use prompt aren't part of candidates and prompt, but they are billed
+func (l *LLM) addGeminiUsage(usage *genai.GenerateContentResponseUsageMetadata) {
+	l.ReasoningToken += int(usage.ThoughtsTokenCount)
+	l.addUsage(int(usage.PromptTokenCount+usage.ToolUsePromptTokenCount),
+		int(usage.CandidatesTokenCount+usage.ThoughtsTokenCount), int(usage.CachedContentTokenCount))
+}
+
+// fromGeminiMessages transfer gemini tool messages to messages of records
+func fromGeminiMessages(msgs []*genai.Content) []*param.ToolMessage {
+	res := make([]*param.ToolMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		toolMsg := &param.ToolMessage{
+			Role: constants.ChatMessageRoleAssistant,
+		}
+		for _, part := range msg.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				toolMsg.ToolCalls = append(toolMsg.ToolCalls, &param.ToolCall{
+					ID:   part.FunctionCall.ID,
+					Type: "function",
+					Function: param.ToolCallFunction{
+						Name:      part.FunctionCall.Name,
+						Arguments: marshalToolArguments(part.FunctionCall.Args),
+					},
+				})
+			case part.FunctionResponse != nil:
+				content, ok := part.FunctionResponse.Response["output"].(string)
+				if !ok {
+					content = marshalToolArguments(part.FunctionResponse.Response)
+				}
+				res = append(res, &param.ToolMessage{
+					Role:       constants.ChatMessageRoleTool,
+					Content:    content,
+					ToolCallID: part.FunctionResponse.ID,
+					Name:       part.FunctionResponse.Name,
+				})
+			default:
+				toolMsg.Content += part.Text
+			}
+		}
+		if len(toolMsg.ToolCalls) > 0 {
+			res = append(res, toolMsg)
+		}
+	}
+	return res
+}
+
+// toGeminiMessages transfer tool messages of records to gemini messages
+func toGeminiMessages(msgs []*param.ToolMessage) []*genai.Content {
+	res := make([]*genai.Content, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.Role == constants.ChatMessageRoleTool {
+			res = append(res, &genai.Content{
+				Role: genai.RoleModel,
+				Parts: []*genai.Part{
+					{
+						FunctionResponse: &genai.FunctionResponse{
+							Response: map[string]any{"output": msg.Content},
+							ID:       msg.ToolCallID,
+							Name:     msg.Name,
+						},
+					},
+				},
+			})
+			continue
+		}
+
+		parts := make([]*genai.Part, 0, len(msg.ToolCalls))
+		for _, toolCall := range msg.ToolCalls {
+			parts = append(parts, &genai.Part{
+				FunctionCall: &genai.FunctionCall{
+					ID:   toolCall.ID,
+					Name: toolCall.Function.Name,
+					Args: parseToolArguments(toolCall.Function.Arguments),
+				},
+			})
+		}
+		res = append(res, &genai.Content{
+			Role:  genai.RoleModel,
+			Parts: parts,
+		})
+	}
+	return res
+}
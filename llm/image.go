@@ -0,0 +1,406 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+	"github.com/yincongcyincong/telegram-deepseek-bot/metrics"
+	"github.com/yincongcyincong/telegram-deepseek-bot/utils"
+	"google.golang.org/genai"
+)
+
+var (
+	ErrImageTypeUnavailable = errors.New("image type isn't configured")
+	ErrNoImage              = errors.New("no image generated")
+
+	// imagenAspectRatios aspect ratios which imagen accepts, it takes no size
+	imagenAspectRatios = map[string]float64{"1:1": 1, "3:4": 3.0 / 4, "4:3": 4.0 / 3, "9:16": 9.0 / 16, "16:9": 16.0 / 9}
+)
+
+// ImageRequest request of image generation, zero width or height means default size of backend
+type ImageRequest struct {
+	Prompt string
+	Width  int
+	Height int
+	Num    int
+}
+
+// Image generated image, backend gives either url or data
+type Image struct {
+	Url  string
+	Data []byte
+}
+
+// ImageGenerator text-to-image backend
+type ImageGenerator interface {
+	Generate(ctx context.Context, req *ImageRequest) ([]*Image, error)
+}
+
+// NewImageGenerator create backend of image type, default backend is used if image type is empty
+func NewImageGenerator(imageType string) (ImageGenerator, error) {
+	types := conf.GetImageTypes()
+	if imageType == "" && len(types) > 0 {
+		imageType = types[0]
+	}
+	if !slices.Contains(types, imageType) {
+		return nil, ErrImageTypeUnavailable
+	}
+
+	switch imageType {
+	case conf.ImageTypeVol:
+		return &VolImageGenerator{}, nil
+	case conf.ImageTypeOpenAI:
+		return &OpenAIImageGenerator{
+			Client: new(OpenAIReq).getClient(),
+			Model:  *conf.OpenAIImageModel,
+		}, nil
+	case conf.ImageTypeGemini:
+		return &GeminiImageGenerator{
+			Model: *conf.GeminiImageModel,
+		}, nil
+	case conf.ImageTypeSD:
+		return &SDImageGenerator{
+			Url:    *conf.SDUrl,
+			Client: utils.GetDeepseekProxyClient(),
+		}, nil
+	case conf.ImageTypeComfyUI:
+		return &ComfyUIImageGenerator{
+			Url:          *conf.ComfyUIUrl,
+			WorkflowPath: *conf.ComfyUIWorkflow,
+			Client:       utils.GetDeepseekProxyClient(),
+		}, nil
+	}
+	return nil, ErrImageTypeUnavailable
+}
+
+// GenerateImages generate images by backend of image type
+func GenerateImages(ctx context.Context, imageType string, req *ImageRequest) ([]*Image, error) {
+	start := time.Now()
+	generator, err := NewImageGenerator(imageType)
+	if err != nil {
+		return nil, err
+	}
+
+	images, err := generator.Generate(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(images) == 0 {
+		return nil, ErrNoImage
+	}
+
+	// generate image time costing
+	metrics.ImageDuration.Observe(time.Since(start).Seconds())
+	return images, nil
+}
+
+// OpenAIImageGenerator openai images api, dall-e-3 only generates one image in a request
+type OpenAIImageGenerator struct {
+	Client *openai.Client
+	Model  string
+}
+
+func (o *OpenAIImageGenerator) Generate(ctx context.Context, req *ImageRequest) ([]*Image, error) {
+	imageReq := openai.ImageRequest{
+		Prompt: req.Prompt,
+		Model:  o.Model,
+		N:      req.Num,
+	}
+	if req.Width > 0 && req.Height > 0 {
+		imageReq.Size = fmt.Sprintf("%dx%d", req.Width, req.Height)
+	}
+	// gpt-image models always return base64 and reject response_format
+	if strings.HasPrefix(o.Model, "dall-e") {
+		imageReq.ResponseFormat = openai.CreateImageResponseFormatB64JSON
+	}
+
+	requestNum := 1
+	if o.Model == openai.CreateImageModelDallE3 {
+		requestNum, imageReq.N = req.Num, 1
+	}
+
+	images := make([]*Image, 0, req.Num)
+	for i := 0; i < requestNum; i++ {
+		resp, err := o.Client.CreateImage(ctx, imageReq)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range resp.Data {
+			if d.URL != "" {
+				images = append(images, &Image{Url: d.URL})
+				continue
+			}
+			data, err := base64.StdEncoding.DecodeString(d.B64JSON)
+			if err != nil {
+				return nil, err
+			}
+			images = append(images, &Image{Data: data})
+		}
+	}
+	return images, nil
+}
+
+// GeminiImageGenerator imagen of gemini api, size is converted to the closest aspect ratio
+type GeminiImageGenerator struct {
+	Model string
+}
+
+func (g *GeminiImageGenerator) Generate(ctx context.Context, req *ImageRequest) ([]*Image, error) {
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		HTTPClient: utils.GetDeepseekProxyClient(),
+		APIKey:     *conf.GeminiToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Models.GenerateImages(ctx, g.Model, req.Prompt, &genai.GenerateImagesConfig{
+		NumberOfImages: int32(req.Num),
+		AspectRatio:    getAspectRatio(req.Width, req.Height),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	images := make([]*Image, 0, len(resp.GeneratedImages))
+	for _, generated := range resp.GeneratedImages {
+		if generated.Image == nil || len(generated.Image.ImageBytes) == 0 {
+			logger.Warn("imagen image is filtered", "reason", generated.RAIFilteredReason)
+			continue
+		}
+		images = append(images, &Image{Data: generated.Image.ImageBytes})
+	}
+	return images, nil
+}
+
+// getAspectRatio the closest aspect ratio of imagen, empty means default ratio
+func getAspectRatio(width, height int) string {
+	if width <= 0 || height <= 0 {
+		return ""
+	}
+
+	ratio := float64(width) / float64(height)
+	closest, minDiff := "", math.MaxFloat64
+	for aspectRatio, r := range imagenAspectRatios {
+		diff := math.Abs(math.Log(ratio / r))
+		if diff < minDiff {
+			closest, minDiff = aspectRatio, diff
+		}
+	}
+	return closest
+}
+
+// SDImageGenerator stable diffusion webui started with --api, or server which serves the same txt2img api
+type SDImageGenerator struct {
+	Url    string // e.g. http://127.0.0.1:7860
+	Client *http.Client
+}
+
+func (s *SDImageGenerator) Generate(ctx context.Context, req *ImageRequest) ([]*Image, error) {
+	body := map[string]interface{}{
+		"prompt":     req.Prompt,
+		"batch_size": req.Num,
+	}
+	if req.Width > 0 && req.Height > 0 {
+		body["width"], body["height"] = req.Width, req.Height
+	}
+
+	resp := &struct {
+		Images []string `json:"images"`
+	}{}
+	if err := postJson(ctx, s.Client, strings.TrimRight(s.Url, "/")+"/sdapi/v1/txt2img", body, resp); err != nil {
+		return nil, err
+	}
+
+	images := make([]*Image, 0, len(resp.Images))
+	for _, image := range resp.Images {
+		// some servers return data url
+		if idx := strings.Index(image, ","); idx >= 0 {
+			image = image[idx+1:]
+		}
+		data, err := base64.StdEncoding.DecodeString(image)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, &Image{Data: data})
+	}
+	return images, nil
+}
+
+// ComfyUIImageGenerator comfyui which runs workflow in api format. "{{prompt}}", "{{width}}", "{{height}}",
+// "{{batch_size}}" and "{{seed}}" strings in workflow are replaced by params of request.
+type ComfyUIImageGenerator struct {
+	Url          string // e.g. http://127.0.0.1:8188
+	WorkflowPath string
+	Client       *http.Client
+}
+
+// comfyUIImage image in output of comfyui history
+type comfyUIImage struct {
+	Filename  string `json:"filename"`
+	Subfolder string `json:"subfolder"`
+	Type      string `json:"type"`
+}
+
+func (c *ComfyUIImageGenerator) Generate(ctx context.Context, req *ImageRequest) ([]*Image, error) {
+	workflow, err := c.getWorkflow(req)
+	if err != nil {
+		return nil, err
+	}
+
+	baseUrl := strings.TrimRight(c.Url, "/")
+	promptResp := &struct {
+		PromptId string `json:"prompt_id"`
+	}{}
+	err = postJson(ctx, c.Client, baseUrl+"/prompt", map[string]interface{}{"prompt": workflow}, promptResp)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := c.waitOutputs(ctx, baseUrl, promptResp.PromptId)
+	if err != nil {
+		return nil, err
+	}
+
+	images := make([]*Image, 0)
+	for _, output := range outputs {
+		for _, image := range output {
+			query := url.Values{"filename": {image.Filename}, "subfolder": {image.Subfolder}, "type": {image.Type}}
+			data, err := getBytes(ctx, c.Client, baseUrl+"/view?"+query.Encode())
+			if err != nil {
+				return nil, err
+			}
+			images = append(images, &Image{Data: data})
+		}
+	}
+	return images, nil
+}
+
+// getWorkflow read workflow and fill params of request into it
+func (c *ComfyUIImageGenerator) getWorkflow(req *ImageRequest) (json.RawMessage, error) {
+	workflow, err := os.ReadFile(c.WorkflowPath)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := req.Width, req.Height
+	if width <= 0 || height <= 0 {
+		width, height = *conf.Width, *conf.Height
+	}
+	prompt, _ := json.Marshal(req.Prompt)
+	replacer := strings.NewReplacer(
+		`"{{prompt}}"`, string(prompt),
+		`"{{width}}"`, strconv.Itoa(width),
+		`"{{height}}"`, strconv.Itoa(height),
+		`"{{batch_size}}"`, strconv.Itoa(req.Num),
+		`"{{seed}}"`, strconv.FormatInt(time.Now().UnixNano()%math.MaxInt32, 10),
+	)
+
+	res := json.RawMessage(replacer.Replace(string(workflow)))
+	if !json.Valid(res) {
+		return nil, errors.New("comfyui workflow isn't valid json")
+	}
+	return res, nil
+}
+
+// waitOutputs poll history of prompt until it's finished, images of outputs are sorted by node id
+func (c *ComfyUIImageGenerator) waitOutputs(ctx context.Context, baseUrl, promptId string) ([][]*comfyUIImage, error) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		history := make(map[string]struct {
+			Outputs map[string]struct {
+				Images []*comfyUIImage `json:"images"`
+			} `json:"outputs"`
+		})
+		data, err := getBytes(ctx, c.Client, baseUrl+"/history/"+url.PathEscape(promptId))
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(data, &history); err != nil {
+			return nil, err
+		}
+
+		if prompt, ok := history[promptId]; ok {
+			nodeIds := make([]string, 0, len(prompt.Outputs))
+			for nodeId := range prompt.Outputs {
+				nodeIds = append(nodeIds, nodeId)
+			}
+			sort.Strings(nodeIds)
+
+			outputs := make([][]*comfyUIImage, 0, len(nodeIds))
+			for _, nodeId := range nodeIds {
+				outputs = append(outputs, prompt.Outputs[nodeId].Images)
+			}
+			return outputs, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func postJson(ctx context.Context, client *http.Client, url string, body, res interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	respData, err := doRequest(client, req)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(respData, res)
+}
+
+func getBytes(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return doRequest(client, req)
+}
+
+func doRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s status %d: %s", req.URL.Path, resp.StatusCode, string(data))
+	}
+	return data, nil
+}
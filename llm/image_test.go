@@ -0,0 +1,136 @@
+package llm
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+)
+
+func TestGetAspectRatio(t *testing.T) {
+	assert.Equal(t, "", getAspectRatio(0, 0))
+	assert.Equal(t, "1:1", getAspectRatio(1024, 1024))
+	assert.Equal(t, "16:9", getAspectRatio(1920, 1080))
+	assert.Equal(t, "9:16", getAspectRatio(720, 1280))
+	assert.Equal(t, "3:4", getAspectRatio(768, 1024))
+	assert.Equal(t, "4:3", getAspectRatio(1200, 1000))
+}
+
+func TestNewImageGeneratorUnavailable(t *testing.T) {
+	empty, sdUrl := "", "http://127.0.0.1:7860"
+	conf.ImageType, conf.VolcAK, conf.VolcSK, conf.OpenAIToken, conf.GeminiToken = &empty, &empty, &empty, &empty, &empty
+	conf.SDUrl, conf.ComfyUIUrl, conf.ComfyUIWorkflow = &sdUrl, &empty, &empty
+
+	generator, err := NewImageGenerator("")
+	assert.Nil(t, err)
+	assert.IsType(t, &SDImageGenerator{}, generator)
+
+	_, err = NewImageGenerator(conf.ImageTypeOpenAI)
+	assert.ErrorIs(t, err, ErrImageTypeUnavailable)
+}
+
+func TestSDImageGenerator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/sdapi/v1/txt2img", r.URL.Path)
+		body := make(map[string]interface{})
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, "a cat", body["prompt"])
+		assert.Equal(t, float64(2), body["batch_size"])
+		assert.Equal(t, float64(768), body["width"])
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"images": []string{
+				base64.StdEncoding.EncodeToString([]byte("image1")),
+				"data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte("image2")),
+			},
+		})
+	}))
+	defer server.Close()
+
+	generator := &SDImageGenerator{Url: server.URL + "/", Client: server.Client()}
+	images, err := generator.Generate(context.Background(), &ImageRequest{Prompt: "a cat", Width: 768, Height: 512, Num: 2})
+	assert.Nil(t, err)
+	assert.Len(t, images, 2)
+	assert.Equal(t, []byte("image1"), images[0].Data)
+	assert.Equal(t, []byte("image2"), images[1].Data)
+}
+
+func TestOpenAIImageGenerator(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		req := openai.ImageRequest{}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, 1, req.N)
+		assert.Equal(t, "1024x1792", req.Size)
+		assert.Equal(t, openai.CreateImageResponseFormatB64JSON, req.ResponseFormat)
+
+		_ = json.NewEncoder(w).Encode(openai.ImageResponse{
+			Data: []openai.ImageResponseDataInner{{B64JSON: base64.StdEncoding.EncodeToString([]byte("dall-e"))}},
+		})
+	}))
+	defer server.Close()
+
+	config := openai.DefaultConfig("token")
+	config.BaseURL = server.URL
+	generator := &OpenAIImageGenerator{Client: openai.NewClientWithConfig(config), Model: openai.CreateImageModelDallE3}
+	images, err := generator.Generate(context.Background(), &ImageRequest{Prompt: "a dog", Width: 1024, Height: 1792, Num: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, requests, "dall-e-3 generates one image in a request")
+	assert.Len(t, images, 2)
+	assert.Equal(t, []byte("dall-e"), images[1].Data)
+}
+
+func TestComfyUIImageGenerator(t *testing.T) {
+	workflowPath := filepath.Join(t.TempDir(), "workflow.json")
+	workflow := `{"3":{"class_type":"KSampler","inputs":{"seed":"{{seed}}"}},
+"5":{"class_type":"EmptyLatentImage","inputs":{"width":"{{width}}","height":"{{height}}","batch_size":"{{batch_size}}"}},
+"6":{"class_type":"CLIPTextEncode","inputs":{"text":"{{prompt}}"}}}`
+	assert.Nil(t, os.WriteFile(workflowPath, []byte(workflow), 0644))
+
+	historyCalls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/prompt":
+			body := struct {
+				Prompt map[string]struct {
+					Inputs map[string]interface{} `json:"inputs"`
+				} `json:"prompt"`
+			}{}
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, `a "red" fox`, body.Prompt["6"].Inputs["text"])
+			assert.Equal(t, float64(640), body.Prompt["5"].Inputs["width"])
+			assert.Equal(t, float64(2), body.Prompt["5"].Inputs["batch_size"])
+			_, _ = w.Write([]byte(`{"prompt_id":"abc"}`))
+		case "/history/abc":
+			historyCalls++
+			if historyCalls == 1 {
+				_, _ = w.Write([]byte(`{}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"abc":{"outputs":{"9":{"images":[
+{"filename":"a.png","subfolder":"","type":"output"},{"filename":"b.png","subfolder":"","type":"output"}]}}}}`))
+		case "/view":
+			_, _ = w.Write([]byte(r.URL.Query().Get("filename")))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	generator := &ComfyUIImageGenerator{Url: server.URL, WorkflowPath: workflowPath, Client: server.Client()}
+	images, err := generator.Generate(context.Background(), &ImageRequest{Prompt: `a "red" fox`, Width: 640, Height: 480, Num: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, historyCalls)
+	assert.Len(t, images, 2)
+	assert.Equal(t, []byte("a.png"), images[0].Data)
+	assert.Equal(t, []byte("b.png"), images[1].Data)
+}
@@ -2,15 +2,21 @@ package llm
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	godeepseek "github.com/cohesion-org/deepseek-go"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/ollama/ollama/api"
 	"github.com/revrost/go-openrouter"
 	"github.com/sashabaranov/go-openai"
 	"github.com/volcengine/volcengine-go-sdk/service/arkruntime/model"
 	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/db"
+	"github.com/yincongcyincong/telegram-deepseek-bot/i18n"
 	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
 	"github.com/yincongcyincong/telegram-deepseek-bot/param"
 	"github.com/yincongcyincong/telegram-deepseek-bot/utils"
@@ -22,36 +28,75 @@ const (
 	FirstSendLen    = 30
 	NonFirstSendLen = 500
 	MostLoop        = 5
+
+	MaxSessionTitleLen = 50
+
+	DeepseekUrl = "https://api.deepseek.com/"
+
+	// defaultContextLimit context window of provider which has no default, such as openrouter
+	defaultContextLimit = 16384
+	// ollamaContextLimit ollama runs model with small context window if num_ctx isn't set
+	ollamaContextLimit = 2048
 )
 
 var (
 	ToolsJsonErr = errors.New("tools json error")
+
+	// providerContextLimits context window of current models of each provider
+	providerContextLimits = map[string]int{
+		param.DeepSeek:  64000,
+		param.OpenAi:    128000,
+		param.Gemini:    1048576,
+		param.Anthropic: 200000,
+		param.Vol:       32768,
+	}
 )
 
 type LLM struct {
 	MessageChan chan *param.MsgInfo
 	Update      tgbotapi.Update
 	Bot         *tgbotapi.BotAPI
-	Content     string // question from user
+	Content     string       // question from user
+	RecordKey   db.RecordKey // thread of update, it's fixed when llm is created, so /switch or /new doesn't move the answer
+	Images      [][]byte     // images of question, only llm which supports image input reads them
 	Model       string
 	Token       int
 
-	LLMClient LLMClient
+	LLMClient  LLMClient
+	Type       string // llm type of LLMClient
+	ForceModel string // model of fallback chain, it takes the place of model chosen by user
 
 	DeepseekTools   []godeepseek.Tool
 	VolTools        []*model.Tool
 	OpenAITools     []openai.Tool
 	GeminiTools     []*genai.Tool
 	OpenRouterTools []openrouter.Tool
+	AnthropicTools  []param.AnthropicTool
 
 	WholeContent string // whole answer from llm
 	LoopNum      int
+	AnswerMsgId  int // telegram message which shows the answer
+
+	ReasoningContent string // reasoning of reasoning models, it's never sent back to llm
+	ReasoningToken   int
+	ShowReasoning    bool // show reasoning in separate message
+	reasoningMsgInfo *param.MsgInfo
+
+	// usage of all requests of question, prompt token includes cached token
+	PromptToken     int
+	CompletionToken int
+	CachedToken     int
+	Cost            float64 // USD
+
+	params *Params // sampling params of user in chat
+
+	answered atomic.Bool // something is sent to user or tools are called, request can't fall back
 }
 
 type LLMClient interface {
 	CallLLMAPI(ctx context.Context, prompt string, l *LLM) error
 
-	GetMessages(userId int64, prompt string)
+	GetMessages(l *LLM, prompt string)
 
 	Send(ctx context.Context, l *LLM) error
 
@@ -67,7 +112,8 @@ type LLMClient interface {
 }
 
 func (l *LLM) GetContent() {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	// stop button of answer cancels the context
+	ctx, cancel := utils.NewRequestContext(l.Update, 5*time.Minute)
 	defer cancel()
 
 	chatId, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(l.Update)
@@ -80,6 +126,11 @@ func (l *LLM) GetContent() {
 		close(l.MessageChan)
 	}()
 
+	l.getImages()
+	if err := l.ReadDocument(ctx); err != nil {
+		logger.Warn("read document fail", "err", err)
+		return
+	}
 	text, err := utils.GetContent(l.Update, l.Bot, l.Content)
 	if err != nil {
 		logger.Error("get content fail", "err", err)
@@ -87,13 +138,29 @@ func (l *LLM) GetContent() {
 		return
 	}
 	l.Content = text
-	err = l.LLMClient.CallLLMAPI(ctx, text, l)
-	if err != nil {
+
+	// same prompt without history is answered by cache
+	cacheReq := l.newCacheRequest(text)
+	if cacheReq != nil && l.answerFromCache(ctx, cacheReq) {
+		go l.generateSessionTitle()
+		return
+	}
+
+	err = l.callWithOCRFallback(ctx, text)
+	// request stopped by user keeps partial answer, it isn't an error
+	if err != nil && !utils.IsRequestStopped(ctx) {
 		logger.Error("Error calling DeepSeek API", "err", err)
 		utils.SendMsg(chatId, err.Error(), l.Bot, msgId, "")
+		return
 	}
+	if cacheReq != nil {
+		l.saveResponseCache(ctx, cacheReq)
+	}
+
+	go l.generateSessionTitle()
 }
 
+// NewLLM create llm whose client is the llm type chosen by user of update
 func NewLLM(opts ...Option) *LLM {
 
 	l := new(LLM)
@@ -101,49 +168,145 @@ func NewLLM(opts ...Option) *LLM {
 		opt(l)
 	}
 
-	switch *conf.Type {
+	chatId, _, userId := utils.GetChatIdAndMsgIdAndUserID(l.Update)
+	if l.RecordKey == (db.RecordKey{}) {
+		l.RecordKey = db.NewRecordKey(chatId, userId)
+	}
+	l.Type = GetUserLLMType(userId)
+	l.LLMClient = newLLMClient(l.Type)
+	l.params = GetParams(chatId, userId)
+
+	return l
+}
+
+// newLLMClient create client of llm type
+func newLLMClient(llmType string) LLMClient {
+	switch llmType {
 	case param.DeepSeek:
-		l.LLMClient = &DeepseekReq{
+		return &DeepseekReq{
 			ToolCall:           []godeepseek.ToolCall{},
 			ToolMessage:        []godeepseek.ChatCompletionMessage{},
 			CurrentToolMessage: []godeepseek.ChatCompletionMessage{},
 		}
-	case param.DeepSeekLlava:
-		l.LLMClient = &OllamaDeepseekReq{
-			ToolCall:           []godeepseek.ToolCall{},
-			ToolMessage:        []godeepseek.ChatCompletionMessage{},
-			CurrentToolMessage: []godeepseek.ChatCompletionMessage{},
+	case param.Ollama, param.DeepSeekLlava:
+		return &OllamaReq{
+			ToolCall:           []api.ToolCall{},
+			ToolMessage:        []api.Message{},
+			CurrentToolMessage: []api.Message{},
 		}
 	case param.Gemini:
-		l.LLMClient = &GeminiReq{
+		return &GeminiReq{
 			ToolCall:           []*genai.FunctionCall{},
 			ToolMessage:        []*genai.Content{},
 			CurrentToolMessage: []*genai.Content{},
 		}
 	case param.OpenAi:
-		l.LLMClient = &OpenAIReq{
+		return &OpenAIReq{
 			ToolCall:           []openai.ToolCall{},
 			ToolMessage:        []openai.ChatCompletionMessage{},
 			CurrentToolMessage: []openai.ChatCompletionMessage{},
 		}
 	case param.OpenRouter:
-		l.LLMClient = &AIRouterReq{
+		return &AIRouterReq{
 			ToolCall:           []openrouter.ToolCall{},
 			ToolMessage:        []openrouter.ChatCompletionMessage{},
 			CurrentToolMessage: []openrouter.ChatCompletionMessage{},
 		}
 	case param.Vol:
-		l.LLMClient = &VolReq{
+		return &VolReq{
 			ToolCall:           []*model.ToolCall{},
 			ToolMessage:        []*model.ChatCompletionMessage{},
 			CurrentToolMessage: []*model.ChatCompletionMessage{},
 		}
+	case param.Anthropic:
+		return &AnthropicReq{
+			ToolCall:           []*AnthropicContent{},
+			ToolMessage:        []*AnthropicMessage{},
+			CurrentToolMessage: []*AnthropicContent{},
+		}
 	}
 
-	return l
+	// name of openai compatible profile is its llm type
+	if profile := conf.GetOpenAICompatibleProfile(llmType); profile != nil {
+		return &OpenAIReq{
+			ToolCall:           []openai.ToolCall{},
+			ToolMessage:        []openai.ChatCompletionMessage{},
+			CurrentToolMessage: []openai.ChatCompletionMessage{},
+			Profile:            profile,
+		}
+	}
+
+	return nil
+}
+
+// GetAvailableTypes get llm types which have credentials and openai compatible profiles,
+// the configured type is always the first one
+func GetAvailableTypes() []string {
+	types := []string{*conf.Type}
+	tokens := []struct {
+		llmType string
+		token   *string
+	}{
+		{param.DeepSeek, conf.DeepseekToken},
+		{param.OpenAi, conf.OpenAIToken},
+		{param.Gemini, conf.GeminiToken},
+		{param.OpenRouter, conf.OpenRouterToken},
+		{param.Vol, conf.VolToken},
+		{param.Ollama, conf.OllamaHost},
+		{param.Anthropic, conf.AnthropicToken},
+	}
+	for _, t := range tokens {
+		if t.llmType != *conf.Type && t.token != nil && *t.token != "" {
+			types = append(types, t.llmType)
+		}
+	}
+
+	// openai compatible endpoints may need no token
+	for _, profile := range conf.OpenAICompatibleProfiles {
+		if profile.Name != *conf.Type {
+			types = append(types, profile.Name)
+		}
+	}
+	return types
+}
+
+// getCustomUrl custom url only belongs to the configured llm type, other types chosen by user use defaultUrl
+func getCustomUrl(llmType, defaultUrl string) string {
+	if *conf.Type == llmType && *conf.CustomUrl != "" {
+		return *conf.CustomUrl
+	}
+	return defaultUrl
+}
+
+// GetUserLLMType get llm type chosen by user, it's the configured type if user doesn't choose one
+// or the chosen one has no credential.
+func GetUserLLMType(userId int64) string {
+	if userId == 0 {
+		return *conf.Type
+	}
+
+	userInfo, err := db.GetUserByID(userId)
+	if err != nil {
+		logger.Error("Error getting user info", "err", err)
+		return *conf.Type
+	}
+	if userInfo == nil || userInfo.LLMType == "" {
+		return *conf.Type
+	}
+
+	for _, llmType := range GetAvailableTypes() {
+		if llmType == userInfo.LLMType {
+			return llmType
+		}
+	}
+	return *conf.Type
 }
 
 func (l *LLM) sendMsg(msgInfoContent *param.MsgInfo, content string) *param.MsgInfo {
+	l.answered.Store(true)
+	// reasoning is finished when answer starts
+	l.flushReasoningMsg()
+
 	// exceed max telegram one message length
 	if utils.Utf16len(msgInfoContent.Content) > OneMsgLen {
 		l.MessageChan <- msgInfoContent
@@ -162,6 +325,284 @@ func (l *LLM) sendMsg(msgInfoContent *param.MsgInfo, content string) *param.MsgI
 	return msgInfoContent
 }
 
+// sendReasoningMsg collect reasoning of llm, it's sent in separate messages if user turns it on
+func (l *LLM) sendReasoningMsg(content string) {
+	l.answered.Store(true)
+	l.ReasoningContent += content
+	if !l.ShowReasoning {
+		return
+	}
+
+	// exceed max telegram one message length
+	if l.reasoningMsgInfo != nil && utils.Utf16len(l.reasoningMsgInfo.Content) > OneMsgLen {
+		l.flushReasoningMsg()
+	}
+	if l.reasoningMsgInfo == nil {
+		l.reasoningMsgInfo = &param.MsgInfo{
+			SendLen:     FirstSendLen,
+			IsReasoning: true,
+		}
+	}
+
+	l.reasoningMsgInfo.Content += content
+	if len(l.reasoningMsgInfo.Content) > l.reasoningMsgInfo.SendLen {
+		l.MessageChan <- l.reasoningMsgInfo
+		l.reasoningMsgInfo.SendLen += NonFirstSendLen
+	}
+}
+
+// flushReasoningMsg send the rest of reasoning message
+func (l *LLM) flushReasoningMsg() {
+	if l.reasoningMsgInfo == nil {
+		return
+	}
+	l.MessageChan <- l.reasoningMsgInfo
+	l.reasoningMsgInfo = nil
+}
+
+// appendStopNote show the answer is truncated when request is stopped by user, it's kept in history as well
+func (l *LLM) appendStopNote(ctx context.Context, msgInfoContent *param.MsgInfo) *param.MsgInfo {
+	if !utils.IsRequestStopped(ctx) {
+		return msgInfoContent
+	}
+	return l.sendMsg(msgInfoContent, "\n\n"+i18n.GetMessage(*conf.Lang, "answer_stopped", nil))
+}
+
+// generateSessionTitle name the active session by its first exchange if session has no title
+func (l *LLM) generateSessionTitle() {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error("generateSessionTitle panic err", "err", err)
+		}
+	}()
+
+	key := l.RecordKey
+	if key.SessionId == 0 || l.WholeContent == "" {
+		return
+	}
+
+	session, err := db.GetSessionByID(key.ChatKey(), key.SessionId)
+	if err != nil {
+		logger.Error("get session fail", "err", err)
+		return
+	}
+	if session == nil || session.Title != "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	prompt := i18n.GetMessage(*conf.Lang, "session_title_prompt", map[string]interface{}{
+		"question": l.Content,
+		"answer":   l.WholeContent,
+	})
+	titleLLM := NewLLM(WithBot(l.Bot), WithUpdate(l.Update), WithRecordKey(l.RecordKey), WithContent(prompt))
+	titleLLM.LLMClient.GetUserMessage(prompt)
+	title, err := titleLLM.LLMClient.SyncSend(ctx, titleLLM)
+	if err != nil {
+		logger.Error("generate session title fail", "err", err)
+		return
+	}
+
+	titleLLM.recordUsage("session title", title)
+
+	title = cleanSessionTitle(title)
+	if title == "" {
+		return
+	}
+	if err = db.UpdateSessionTitle(session.ID, title); err != nil {
+		logger.Error("update session title fail", "err", err)
+	}
+}
+
+// cleanSessionTitle keep the first line of title without quotes, and cut it to MaxSessionTitleLen
+func cleanSessionTitle(title string) string {
+	title = strings.TrimSpace(title)
+	if idx := strings.Index(title, "\n"); idx >= 0 {
+		title = title[:idx]
+	}
+	title = strings.TrimSpace(strings.Trim(title, "\"'`*#“”「」《》"))
+
+	runes := []rune(title)
+	if len(runes) > MaxSessionTitleLen {
+		title = string(runes[:MaxSessionTitleLen])
+	}
+	return title
+}
+
+// getContextLimit get context window tokens of model: limit of model, then CONTEXT_LIMIT, then default of provider.
+// context window of ollama is num_ctx which is sent with request.
+func getContextLimit(llmType, model string) int {
+	if llmType == param.Ollama || llmType == param.DeepSeekLlava {
+		if *conf.OllamaNumCtx > 0 {
+			return *conf.OllamaNumCtx
+		}
+		return ollamaContextLimit
+	}
+	if limit, ok := conf.ModelContextLimits[model]; ok {
+		return limit
+	}
+	if *conf.ContextLimit > 0 {
+		return *conf.ContextLimit
+	}
+	if limit, ok := providerContextLimits[llmType]; ok {
+		return limit
+	}
+	return defaultContextLimit
+}
+
+// getContext get summary and the latest history of thread which fit the context window of model.
+// room is reserved for the answer (MaxTokens), tool definitions, system prompt and current prompt.
+// when history exceeds the window, the oldest AQs are compressed into summary instead of being dropped.
+func (l *LLM) getContext(key db.RecordKey, tools interface{}, systemPrompt, prompt string) (string, []*db.AQ) {
+	summary := ""
+	dbSummary, err := db.GetSummary(key)
+	if err != nil {
+		logger.Error("get summary fail", "err", err)
+	}
+	if dbSummary != nil {
+		summary = dbSummary.Summary
+	}
+
+	msgRecords := db.GetMsgRecord(key)
+	if msgRecords == nil {
+		return summary, nil
+	}
+
+	countToken := func(text string) int {
+		return utils.CountToken(l.Model, text)
+	}
+	budget := getContextLimit(l.Type, l.Model) - l.getParams().MaxTokens -
+		countToken(systemPrompt) - countToken(prompt) - countToken(summary)
+	if tools != nil {
+		toolsJson, err := json.Marshal(tools)
+		if err != nil {
+			logger.Warn("marshal tools fail", "err", err)
+		} else {
+			budget -= countToken(string(toolsJson))
+		}
+	}
+
+	aqs := msgRecords.AQs
+	recentAQs := trimAQsByToken(aqs, budget, countToken)
+	if len(recentAQs) == len(aqs) {
+		return summary, aqs
+	}
+
+	// compress history into half of budget, so summary isn't updated in every turn
+	recentAQs = trimAQsByToken(aqs, budget/2, countToken)
+	oldAQs := aqs[:len(aqs)-len(recentAQs)]
+	newSummary, err := l.summarize(summary, oldAQs)
+	if err != nil {
+		logger.Error("summarize history fail, drop oldest history", "err", err)
+		return summary, trimAQsByToken(aqs, budget, countToken)
+	}
+
+	// AQs in the same second can't be told apart by create time, record id is the boundary
+	var lastRecordId int64
+	for _, aq := range oldAQs {
+		lastRecordId = max(lastRecordId, aq.RecordId)
+	}
+	err = db.SaveSummary(key, newSummary, lastRecordId)
+	if err != nil {
+		logger.Error("save summary fail", "err", err)
+	}
+	db.DeleteMsgRecordBefore(key, lastRecordId)
+
+	return newSummary, recentAQs
+}
+
+// summarize compress AQs and previous summary into a new summary
+func (l *LLM) summarize(summary string, aqs []*db.AQ) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	dialogs := make([]map[string]string, 0, len(aqs))
+	for _, aq := range aqs {
+		dialogs = append(dialogs, map[string]string{
+			"question": aq.Question,
+			"answer":   aq.Answer,
+		})
+	}
+	prompt := i18n.GetMessage(*conf.Lang, "summary_memory_prompt", map[string]interface{}{
+		"summary": summary,
+		"dialogs": dialogs,
+	})
+
+	summaryLLM := NewLLM(WithBot(l.Bot), WithUpdate(l.Update), WithRecordKey(l.RecordKey), WithContent(prompt))
+	summaryLLM.LLMClient.GetUserMessage(prompt)
+	newSummary, err := summaryLLM.LLMClient.SyncSend(ctx, summaryLLM)
+	if err != nil {
+		return "", err
+	}
+	summaryLLM.recordUsage("memory summary", newSummary)
+	if newSummary == "" {
+		return "", errors.New("summary is empty")
+	}
+
+	return newSummary, nil
+}
+
+// getMemoryPrompt get prompt which carries summary of earlier conversation
+func getMemoryPrompt(summary string) string {
+	return i18n.GetMessage(*conf.Lang, "memory_prompt", map[string]interface{}{
+		"summary": summary,
+	})
+}
+
+// TrimAQsByContext keep the latest imported AQs which fit the context window of user's model.
+// imported AQs are appended to the thread, so they are budgeted together with summary and history of thread.
+func (l *LLM) TrimAQsByContext(aqs []*db.AQ) []*db.AQ {
+	l.LLMClient.GetModel(l)
+	countToken := func(text string) int {
+		return utils.CountToken(l.Model, text)
+	}
+
+	budget := getContextLimit(l.Type, l.Model) - l.getParams().MaxTokens - countToken(getSystemPrompt(l.RecordKey))
+	if summary, err := db.GetSummary(l.RecordKey); err != nil {
+		logger.Error("get summary fail", "err", err)
+	} else if summary != nil {
+		budget -= countToken(summary.Summary)
+	}
+
+	thread := make([]*db.AQ, 0, len(aqs))
+	if msgRecord := db.GetMsgRecord(l.RecordKey); msgRecord != nil {
+		thread = append(thread, msgRecord.AQs...)
+	}
+	thread = append(thread, aqs...)
+
+	kept := trimAQsByToken(thread, budget, countToken)
+	return aqs[len(aqs)-min(len(kept), len(aqs)):]
+}
+
+// trimAQsByToken keep the latest AQs whose tokens don't exceed budget
+func trimAQsByToken(aqs []*db.AQ, budget int, countToken func(string) int) []*db.AQ {
+	used := 0
+	for i := len(aqs) - 1; i >= 0; i-- {
+		// every message has a few tokens for role and format
+		used += countToken(aqs[i].Question) + countToken(aqs[i].Answer) + countToken(aqs[i].Content) + 8
+		if used > budget {
+			return aqs[i+1:]
+		}
+	}
+
+	return aqs
+}
+
+// getSystemPrompt get prompt of persona selected by thread, use default system prompt if no persona selected.
+func getSystemPrompt(key db.RecordKey) string {
+	persona, err := db.GetChatPersona(key.ChatKey())
+	if err != nil {
+		logger.Error("get chat persona fail", "err", err)
+	}
+	if persona != nil {
+		return persona.Prompt
+	}
+
+	return *conf.SystemPrompt
+}
+
 func (l *LLM) OverLoop() bool {
 	if l.LoopNum >= MostLoop {
 		return true
@@ -190,12 +631,31 @@ func WithUpdate(update tgbotapi.Update) Option {
 	}
 }
 
+// WithRecordKey side request of question, such as summary, belongs to the thread of question
+func WithRecordKey(key db.RecordKey) Option {
+	return func(p *LLM) {
+		p.RecordKey = key
+	}
+}
+
 func WithBot(bot *tgbotapi.BotAPI) Option {
 	return func(p *LLM) {
 		p.Bot = bot
 	}
 }
 
+func WithAnswerMsgId(answerMsgId int) Option {
+	return func(p *LLM) {
+		p.AnswerMsgId = answerMsgId
+	}
+}
+
+func WithShowReasoning(showReasoning bool) Option {
+	return func(p *LLM) {
+		p.ShowReasoning = showReasoning
+	}
+}
+
 func WithMessageChan(messageChan chan *param.MsgInfo) Option {
 	return func(p *LLM) {
 		p.MessageChan = messageChan
@@ -210,6 +670,7 @@ func WithTaskTools(taskTool *conf.AgentInfo) Option {
 			p.OpenAITools = nil
 			p.GeminiTools = nil
 			p.OpenRouterTools = nil
+			p.AnthropicTools = nil
 			return
 		}
 		p.DeepseekTools = taskTool.DeepseekTool
@@ -217,5 +678,6 @@ func WithTaskTools(taskTool *conf.AgentInfo) Option {
 		p.OpenAITools = taskTool.OpenAITools
 		p.GeminiTools = taskTool.GeminiTools
 		p.OpenRouterTools = taskTool.OpenRouterTools
+		p.AnthropicTools = taskTool.AnthropicTools
 	}
 }
@@ -0,0 +1,170 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/db"
+	"github.com/yincongcyincong/telegram-deepseek-bot/param"
+)
+
+func TestTrimAQsByToken(t *testing.T) {
+	aqs := []*db.AQ{
+		{Question: "q1", Answer: "a1"},
+		{Question: "q2", Answer: "a2"},
+		{Question: "q3", Answer: "a3"},
+	}
+	countToken := func(text string) int {
+		return len(text)
+	}
+
+	// every AQ costs 2 + 2 + 8 = 12 tokens
+	assert.Equal(t, aqs, trimAQsByToken(aqs, 100, countToken))
+	assert.Equal(t, aqs[1:], trimAQsByToken(aqs, 30, countToken))
+	assert.Equal(t, aqs[2:], trimAQsByToken(aqs, 12, countToken))
+	assert.Empty(t, trimAQsByToken(aqs, 0, countToken))
+}
+
+func TestCleanSessionTitle(t *testing.T) {
+	assert.Equal(t, "Go generics", cleanSessionTitle(" \"Go generics\"\n"))
+	assert.Equal(t, "Trip plan", cleanSessionTitle("**Trip plan**\nsome explanation"))
+	assert.Equal(t, "旅行计划", cleanSessionTitle("《旅行计划》"))
+	assert.Equal(t, MaxSessionTitleLen, len([]rune(cleanSessionTitle(strings.Repeat("长", 100)))))
+}
+
+func TestSendReasoningMsg(t *testing.T) {
+	// reasoning is only collected when user turns it off
+	l := &LLM{MessageChan: make(chan *param.MsgInfo, 10)}
+	l.sendReasoningMsg("think")
+	l.sendMsg(&param.MsgInfo{SendLen: FirstSendLen}, "answer")
+	assert.Equal(t, "think", l.ReasoningContent)
+	assert.Equal(t, "answer", l.WholeContent)
+	assert.Equal(t, 0, len(l.MessageChan))
+
+	// reasoning message is sent before answer
+	l = &LLM{MessageChan: make(chan *param.MsgInfo, 10), ShowReasoning: true}
+	l.sendReasoningMsg("think")
+	l.sendMsg(&param.MsgInfo{SendLen: FirstSendLen}, "answer")
+	msg := <-l.MessageChan
+	assert.True(t, msg.IsReasoning)
+	assert.Equal(t, "think", msg.Content)
+	assert.Equal(t, "answer", l.WholeContent, "Reasoning should not be part of answer")
+}
+
+func TestGetAvailableTypes(t *testing.T) {
+	llmType, customUrl := param.Gemini, "https://example.com/v1/"
+	deepseekToken, openAIToken, geminiToken, empty := "ds", "", "gm", ""
+
+	oldType, oldCustomUrl := conf.Type, conf.CustomUrl
+	oldDeepseekToken, oldOpenAIToken, oldGeminiToken := conf.DeepseekToken, conf.OpenAIToken, conf.GeminiToken
+	oldOpenRouterToken, oldVolToken, oldAnthropicToken := conf.OpenRouterToken, conf.VolToken, conf.AnthropicToken
+	oldProfiles := conf.OpenAICompatibleProfiles
+	t.Cleanup(func() {
+		conf.Type, conf.CustomUrl = oldType, oldCustomUrl
+		conf.DeepseekToken, conf.OpenAIToken, conf.GeminiToken = oldDeepseekToken, oldOpenAIToken, oldGeminiToken
+		conf.OpenRouterToken, conf.VolToken, conf.AnthropicToken = oldOpenRouterToken, oldVolToken, oldAnthropicToken
+		conf.OpenAICompatibleProfiles = oldProfiles
+	})
+
+	conf.Type, conf.CustomUrl = &llmType, &customUrl
+	conf.DeepseekToken, conf.OpenAIToken, conf.GeminiToken = &deepseekToken, &openAIToken, &geminiToken
+	conf.OpenRouterToken, conf.VolToken, conf.AnthropicToken = &empty, &empty, &empty
+	conf.OpenAICompatibleProfiles = nil
+
+	assert.Equal(t, []string{param.Gemini, param.DeepSeek}, GetAvailableTypes())
+	assert.Equal(t, param.Gemini, GetUserLLMType(0))
+
+	// custom url only belongs to the configured type
+	assert.Equal(t, customUrl, getCustomUrl(param.Gemini, ""))
+	assert.Equal(t, DeepseekUrl, getCustomUrl(param.DeepSeek, DeepseekUrl))
+
+	// openai compatible profiles are available without token
+	conf.OpenAICompatibleProfiles = []*conf.OpenAICompatibleProfile{{Name: "vllm", Models: []string{"qwen"}}}
+	assert.Equal(t, []string{param.Gemini, param.DeepSeek, "vllm"}, GetAvailableTypes())
+	client, ok := newLLMClient("vllm").(*OpenAIReq)
+	assert.True(t, ok)
+	assert.Equal(t, "vllm", client.Profile.Name)
+}
+
+func TestOpenAICompatibleSyncSend(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/chat/completions", r.URL.Path)
+		assert.Equal(t, "Bearer local-token", r.Header.Get("Authorization"))
+		assert.Equal(t, "bot", r.Header.Get("X-Team"))
+
+		body := make(map[string]interface{})
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, "qwen", body["model"])
+		assert.Nil(t, body["tools"], "Endpoint without tool support should get no tools")
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"hi"}}],"usage":{"total_tokens":3}}`))
+	}))
+	defer server.Close()
+
+	maxTokens, topLogProbs, zero, logProbs, proxy := 100, 0, 0.0, false, ""
+	conf.MaxTokens, conf.TopLogProbs, conf.LogProbs, conf.DeepseekProxy = &maxTokens, &topLogProbs, &logProbs, &proxy
+	conf.TopP, conf.FrequencyPenalty, conf.PresencePenalty, conf.Temperature = &zero, &zero, &zero, &zero
+
+	client := &OpenAIReq{Profile: &conf.OpenAICompatibleProfile{
+		Name:    "vllm",
+		BaseUrl: server.URL + "/v1",
+		Token:   "local-token",
+		Models:  []string{"qwen"},
+		Headers: map[string]string{"X-Team": "bot"},
+	}}
+	l := &LLM{
+		ForceModel:  "qwen",
+		OpenAITools: []openai.Tool{{Type: openai.ToolTypeFunction, Function: &openai.FunctionDefinition{Name: "search"}}},
+	}
+	client.GetUserMessage("hello")
+	answer, err := client.SyncSend(context.Background(), l)
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", answer)
+	assert.Equal(t, 3, l.Token)
+}
+
+func TestParseFallbackChain(t *testing.T) {
+	chain := parseFallbackChain("deepseek:deepseek-chat -> openrouter:deepseek/deepseek-chat->openai, ollama:llava:latest,")
+	assert.Equal(t, []fallbackModel{
+		{Type: param.DeepSeek, Model: "deepseek-chat"},
+		{Type: param.OpenRouter, Model: "deepseek/deepseek-chat"},
+		{Type: param.OpenAi},
+		{Type: "ollama", Model: "llava:latest"},
+	}, chain)
+	assert.Equal(t, "openai", chain[2].String())
+	assert.Equal(t, "openrouter:deepseek/deepseek-chat", chain[1].String())
+	assert.Empty(t, parseFallbackChain(""))
+}
+
+func TestGetContextLimit(t *testing.T) {
+	oldLimit, oldLimits, oldNumCtx := conf.ContextLimit, conf.ModelContextLimits, conf.OllamaNumCtx
+	t.Cleanup(func() {
+		conf.ContextLimit, conf.ModelContextLimits, conf.OllamaNumCtx = oldLimit, oldLimits, oldNumCtx
+	})
+
+	contextLimit := 0
+	conf.ContextLimit, conf.ModelContextLimits = &contextLimit, map[string]int{"gpt-4o": 100000}
+	assert.Equal(t, 100000, getContextLimit(param.OpenAi, "gpt-4o"))
+	assert.Equal(t, 64000, getContextLimit(param.DeepSeek, "deepseek-chat"))
+	assert.Equal(t, defaultContextLimit, getContextLimit(param.OpenRouter, "meta-llama/llama-3-8b"))
+
+	// CONTEXT_LIMIT takes the place of defaults of providers
+	contextLimit = 8192
+	assert.Equal(t, 8192, getContextLimit(param.DeepSeek, "deepseek-chat"))
+	assert.Equal(t, 100000, getContextLimit(param.OpenAi, "gpt-4o"))
+
+	// context window of ollama is num_ctx
+	numCtx := 0
+	conf.OllamaNumCtx = &numCtx
+	assert.Equal(t, ollamaContextLimit, getContextLimit(param.Ollama, "llama3"))
+	numCtx = 32768
+	assert.Equal(t, 32768, getContextLimit(param.Ollama, "llama3"))
+}
@@ -1,7 +1,6 @@
 package llm
 
 import (
-	"context"
 	"encoding/json"
 	"regexp"
 	"time"
@@ -22,8 +21,10 @@ type McpResult struct {
 
 // ExecuteMcp execute mcp request
 func (d *DeepseekTaskReq) ExecuteMcp() {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Minute)
+	// stop button of answer cancels the context
+	ctx, cancel := utils.NewRequestContext(d.Update, 15*time.Minute)
 	defer cancel()
+	defer close(d.MessageChan)
 
 	logger.Info("mcp content", "content", d.Content)
 	taskParam := make(map[string]interface{})
@@ -37,7 +38,6 @@ func (d *DeepseekTaskReq) ExecuteMcp() {
 	}
 
 	// get mcp request
-	chatId, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(d.Update)
 	llm := NewLLM(WithBot(d.Bot), WithUpdate(d.Update),
 		WithMessageChan(d.MessageChan), WithContent(d.Content))
 
@@ -47,7 +47,7 @@ func (d *DeepseekTaskReq) ExecuteMcp() {
 	c, err := llm.LLMClient.SyncSend(ctx, llm)
 	if err != nil {
 		logger.Error("get message fail", "err", err)
-		utils.SendMsg(chatId, err.Error(), d.Bot, msgId, "")
+		d.sendErrMsg(ctx, err)
 		return
 	}
 
@@ -69,7 +69,7 @@ func (d *DeepseekTaskReq) ExecuteMcp() {
 	mcpLLM.LLMClient.GetUserMessage(d.Content)
 	err = mcpLLM.LLMClient.Send(ctx, mcpLLM)
 	if err != nil {
-		utils.SendMsg(chatId, err.Error(), d.Bot, msgId, "")
+		d.sendErrMsg(ctx, err)
 		logger.Error("execute conversation fail", "err", err)
 	}
 }
@@ -0,0 +1,129 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/cohesion-org/deepseek-go/constants"
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+	"github.com/yincongcyincong/telegram-deepseek-bot/param"
+)
+
+// marshalToolMessages marshal tool messages for records.content
+func marshalToolMessages(msgs []*param.ToolMessage) string {
+	if len(msgs) == 0 {
+		return ""
+	}
+
+	data, err := json.Marshal(msgs)
+	if err != nil {
+		logger.Error("Error marshalling tools json", "err", err)
+		return ""
+	}
+	return string(data)
+}
+
+// unmarshalToolMessages parse tool messages of records.content, whichever llm saved them
+func unmarshalToolMessages(content string) []*param.ToolMessage {
+	if content == "" {
+		return nil
+	}
+
+	msgs := make([]*param.ToolMessage, 0)
+	err := json.Unmarshal([]byte(content), &msgs)
+	if err != nil {
+		logger.Error("Error unmarshalling tools json", "err", err)
+		return nil
+	}
+	return normalizeToolMessages(msgs)
+}
+
+// normalizeToolMessages make every tool call answered by a tool message with same id.
+// ollama doesn't give id and gemini doesn't always give it, so id is matched by order then.
+// calls without answer and answers without call are dropped, because most llm reject them.
+func normalizeToolMessages(msgs []*param.ToolMessage) []*param.ToolMessage {
+	res := make([]*param.ToolMessage, 0, len(msgs))
+	callNum := 0
+	for i := 0; i < len(msgs); i++ {
+		msg := msgs[i]
+		if msg == nil || msg.Role != constants.ChatMessageRoleAssistant || len(msg.ToolCalls) == 0 {
+			continue
+		}
+
+		// tool messages answering this assistant message
+		answers := make([]*param.ToolMessage, 0)
+		for i+1 < len(msgs) && msgs[i+1] != nil && msgs[i+1].Role == constants.ChatMessageRoleTool {
+			answers = append(answers, msgs[i+1])
+			i++
+		}
+
+		calls := make(map[string]*param.ToolCall)
+		for j, call := range msg.ToolCalls {
+			if call.ID == "" {
+				call.ID = fmt.Sprintf("call_%d", callNum)
+			}
+			callNum++
+			if call.Type == "" {
+				call.Type = "function"
+			}
+			calls[call.ID] = call
+			if j < len(answers) && answers[j].ToolCallID == "" {
+				answers[j].ToolCallID = call.ID
+			}
+		}
+
+		answered := make(map[string]bool)
+		validAnswers := make([]*param.ToolMessage, 0, len(answers))
+		for _, answer := range answers {
+			call, ok := calls[answer.ToolCallID]
+			if !ok || answered[answer.ToolCallID] {
+				continue
+			}
+			if answer.Name == "" {
+				answer.Name = call.Function.Name
+			}
+			answered[answer.ToolCallID] = true
+			validAnswers = append(validAnswers, answer)
+		}
+
+		validCalls := make([]*param.ToolCall, 0, len(msg.ToolCalls))
+		for _, call := range msg.ToolCalls {
+			if answered[call.ID] {
+				validCalls = append(validCalls, call)
+			}
+		}
+		if len(validCalls) == 0 {
+			continue
+		}
+
+		msg.ToolCalls = validCalls
+		res = append(res, msg)
+		res = append(res, validAnswers...)
+	}
+
+	return res
+}
+
+// parseToolArguments parse json arguments of tool call, llm like gemini and ollama need object
+func parseToolArguments(arguments string) map[string]any {
+	args := make(map[string]any)
+	if arguments == "" {
+		return args
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		logger.Warn("unmarshal tool arguments fail", "arguments", arguments, "err", err)
+	}
+	return args
+}
+
+func marshalToolArguments(args map[string]any) string {
+	if args == nil {
+		return "{}"
+	}
+	data, err := json.Marshal(args)
+	if err != nil {
+		logger.Warn("marshal tool arguments fail", "err", err)
+		return "{}"
+	}
+	return string(data)
+}
@@ -0,0 +1,76 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/cohesion-org/deepseek-go"
+	"github.com/ollama/ollama/api"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genai"
+)
+
+func TestToolMessagesAcrossLLM(t *testing.T) {
+	// tool messages saved by deepseek
+	content := marshalToolMessages(fromDeepseekMessages([]deepseek.ChatCompletionMessage{
+		{
+			Role:    deepseek.ChatMessageRoleAssistant,
+			Content: "let me search",
+			ToolCalls: []deepseek.ToolCall{
+				{ID: "call_a", Type: "function", Function: deepseek.ToolCallFunction{Name: "search", Arguments: `{"q":"go"}`}},
+				{ID: "call_b", Type: "function", Function: deepseek.ToolCallFunction{Name: "weather", Arguments: `{"city":"sh"}`}},
+			},
+		},
+		{Role: deepseek.ChatMessageRoleTool, Content: "go result", ToolCallID: "call_a"},
+		{Role: deepseek.ChatMessageRoleTool, Content: "sunny", ToolCallID: "call_b"},
+	}))
+
+	// anthropic answers both tools in one user message
+	antMsgs := toAnthropicMessages(unmarshalToolMessages(content))
+	assert.Len(t, antMsgs, 2)
+	assert.Equal(t, "text", antMsgs[0].Content[0].Type)
+	assert.Equal(t, "tool_use", antMsgs[0].Content[1].Type)
+	assert.JSONEq(t, `{"q":"go"}`, string(antMsgs[0].Content[1].Input))
+	assert.Equal(t, "user", antMsgs[1].Role)
+	assert.Equal(t, "call_b", antMsgs[1].Content[1].ToolUseID)
+
+	// gemini gets name of tool in function response
+	geminiMsgs := toGeminiMessages(unmarshalToolMessages(content))
+	assert.Len(t, geminiMsgs, 3)
+	assert.Equal(t, "go", geminiMsgs[0].Parts[0].FunctionCall.Args["q"])
+	assert.Equal(t, "weather", geminiMsgs[2].Parts[0].FunctionResponse.Name)
+	assert.Equal(t, "sunny", geminiMsgs[2].Parts[0].FunctionResponse.Response["output"])
+
+	// ollama gets arguments as object
+	ollamaMsgs := toOllamaMessages(unmarshalToolMessages(content))
+	assert.Equal(t, "sh", ollamaMsgs[0].ToolCalls[1].Function.Arguments["city"])
+
+	// and back again, content is same whichever llm saved it
+	assert.JSONEq(t, content, marshalToolMessages(fromAnthropicMessages(antMsgs)))
+	assert.JSONEq(t, content, marshalToolMessages(fromDeepseekMessages(toDeepseekMessages(unmarshalToolMessages(content)))))
+}
+
+func TestToolMessagesWithoutID(t *testing.T) {
+	// ollama doesn't give id, tool messages are matched by order
+	content := marshalToolMessages(fromOllamaMessages([]api.Message{
+		{Role: "assistant", ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{Name: "search", Arguments: map[string]any{"q": "go"}}}}},
+		{Role: "tool", Content: "go result"},
+	}))
+	msgs := toOpenAIMessages(unmarshalToolMessages(content))
+	assert.Len(t, msgs, 2)
+	assert.Equal(t, "call_0", msgs[0].ToolCalls[0].ID)
+	assert.Equal(t, "call_0", msgs[1].ToolCallID)
+
+	// gemini pairs call and response, call without response is dropped
+	content = marshalToolMessages(fromGeminiMessages([]*genai.Content{
+		{Role: genai.RoleModel, Parts: []*genai.Part{{FunctionCall: &genai.FunctionCall{Name: "search", Args: map[string]any{"q": "go"}}}}},
+		{Role: genai.RoleModel, Parts: []*genai.Part{{FunctionResponse: &genai.FunctionResponse{Name: "search", Response: map[string]any{"output": "go result"}}}}},
+		{Role: genai.RoleModel, Parts: []*genai.Part{{FunctionCall: &genai.FunctionCall{Name: "weather"}}}},
+	}))
+	toolMsgs := unmarshalToolMessages(content)
+	assert.Len(t, toolMsgs, 2)
+	assert.Equal(t, "go result", toolMsgs[1].Content)
+
+	// content which can't be parsed is ignored
+	assert.Nil(t, unmarshalToolMessages("{broken"))
+	assert.Empty(t, marshalToolMessages(nil))
+}
@@ -4,13 +4,16 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"io"
+	"net/http"
+	"net/url"
+	"slices"
 	"strings"
 	"time"
 	"unicode"
 
-	"github.com/cohesion-org/deepseek-go"
 	"github.com/cohesion-org/deepseek-go/constants"
+	"github.com/ollama/ollama/api"
+	"github.com/sashabaranov/go-openai"
 	"github.com/yincongcyincong/mcp-client-go/clients"
 	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
 	"github.com/yincongcyincong/telegram-deepseek-bot/db"
@@ -20,72 +23,110 @@ import (
 	"github.com/yincongcyincong/telegram-deepseek-bot/utils"
 )
 
-type OllamaDeepseekReq struct {
-	ToolCall           []deepseek.ToolCall
-	ToolMessage        []deepseek.ChatCompletionMessage
-	CurrentToolMessage []deepseek.ChatCompletionMessage
+const (
+	OllamaUrl = "http://127.0.0.1:11434"
+)
+
+type OllamaReq struct {
+	ToolCall           []api.ToolCall
+	ToolMessage        []api.Message
+	CurrentToolMessage []api.Message
 
-	DeepseekMsgs []deepseek.ChatCompletionMessage
+	OllamaMsgs []api.Message
 }
 
-// CallLLMAPI request DeepSeek API and get response
-func (d *OllamaDeepseekReq) CallLLMAPI(ctx context.Context, prompt string, l *LLM) error {
+// CallLLMAPI request ollama API and get response
+func (d *OllamaReq) CallLLMAPI(ctx context.Context, prompt string, l *LLM) error {
 	_, _, userId := utils.GetChatIdAndMsgIdAndUserID(l.Update)
 
-	d.GetMessages(userId, prompt)
+	d.GetModel(l)
+	d.GetMessages(l, prompt)
 
 	logger.Info("msg receive", "userID", userId, "prompt", prompt)
 
 	return d.Send(ctx, l)
 }
 
-func (d *OllamaDeepseekReq) GetModel(l *LLM) {
-	l.Model = "llava:latest"
+// GetModel use model chosen by user if it's installed, otherwise use default ollama model
+func (d *OllamaReq) GetModel(l *LLM) {
+	if l.useForceModel() {
+		return
+	}
+	_, _, userId := utils.GetChatIdAndMsgIdAndUserID(l.Update)
+
+	l.Model = *conf.OllamaModel
+	userInfo, err := db.GetUserByID(userId)
+	if err != nil {
+		logger.Error("Error getting user info", "err", err)
+	}
+	if userInfo == nil || userInfo.Mode == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	models, err := ListOllamaModels(ctx)
+	if err != nil {
+		logger.Warn("list ollama models fail", "err", err)
+		return
+	}
+	if slices.Contains(models, userInfo.Mode) {
+		logger.Info("User info", "userID", userInfo.UserId, "mode", userInfo.Mode)
+		l.Model = userInfo.Mode
+	}
 }
 
-func (d *OllamaDeepseekReq) GetMessages(userId int64, prompt string) {
-	messages := make([]deepseek.ChatCompletionMessage, 0)
+func (d *OllamaReq) GetMessages(l *LLM, prompt string) {
+	key := l.RecordKey
+	messages := make([]api.Message, 0)
 
-	msgRecords := db.GetMsgRecord(userId)
-	if msgRecords != nil {
-		aqs := msgRecords.AQs
-		if len(aqs) > 10 {
-			aqs = aqs[len(aqs)-10:]
-		}
+	systemPrompt := getSystemPrompt(key)
+	if systemPrompt != "" {
+		messages = append(messages, api.Message{
+			Role:    constants.ChatMessageRoleSystem,
+			Content: systemPrompt,
+		})
+	}
+
+	summary, aqs := l.getContext(key, l.OpenAITools, systemPrompt, prompt)
+	if summary != "" {
+		messages = append(messages, api.Message{
+			Role:    constants.ChatMessageRoleSystem,
+			Content: getMemoryPrompt(summary),
+		})
+	}
 
-		for i, record := range aqs {
-			if record.Answer != "" && record.Question != "" {
-				logger.Info("context content", "dialog", i, "question:", record.Question,
-					"toolContent", record.Content, "answer:", record.Answer)
-				messages = append(messages, deepseek.ChatCompletionMessage{
-					Role:    constants.ChatMessageRoleUser,
-					Content: record.Question,
-				})
-				if record.Content != "" {
-					toolsMsgs := make([]deepseek.ChatCompletionMessage, 0)
-					err := json.Unmarshal([]byte(record.Content), &toolsMsgs)
-					if err != nil {
-						logger.Error("Error unmarshalling tools json", "err", err)
-					} else {
-						messages = append(messages, toolsMsgs...)
-					}
-				}
-				messages = append(messages, deepseek.ChatCompletionMessage{
-					Role:    constants.ChatMessageRoleAssistant,
-					Content: record.Answer,
-				})
-			}
+	for i, record := range aqs {
+		if record.Answer != "" && record.Question != "" {
+			logger.Info("context content", "dialog", i, "question:", record.Question,
+				"toolContent", record.Content, "answer:", record.Answer)
+			messages = append(messages, api.Message{
+				Role:    constants.ChatMessageRoleUser,
+				Content: record.Question,
+			})
+			messages = append(messages, toOllamaMessages(unmarshalToolMessages(record.Content))...)
+			messages = append(messages, api.Message{
+				Role:    constants.ChatMessageRoleAssistant,
+				Content: record.Answer,
+			})
 		}
 	}
-	messages = append(messages, deepseek.ChatCompletionMessage{
+
+	// images of question are only sent in this turn
+	images := make([]api.ImageData, 0, len(l.Images))
+	for _, image := range l.getRequestImages() {
+		images = append(images, image)
+	}
+	messages = append(messages, api.Message{
 		Role:    constants.ChatMessageRoleUser,
 		Content: prompt,
+		Images:  images,
 	})
 
-	d.DeepseekMsgs = messages
+	d.OllamaMsgs = messages
 }
 
-func (d *OllamaDeepseekReq) Send(ctx context.Context, l *LLM) error {
+func (d *OllamaReq) Send(ctx context.Context, l *LLM) error {
 	if l.OverLoop() {
 		return errors.New("too many loops")
 	}
@@ -93,262 +134,317 @@ func (d *OllamaDeepseekReq) Send(ctx context.Context, l *LLM) error {
 	start := time.Now()
 	_, updateMsgID, userId := utils.GetChatIdAndMsgIdAndUserID(l.Update)
 
-	request := &deepseek.StreamChatCompletionRequest{
-		Model:  "llava:latest",
-		Stream: true,
-		StreamOptions: deepseek.StreamOptions{
-			IncludeUsage: true,
-		},
-		MaxTokens:        *conf.MaxTokens,
-		TopP:             float32(*conf.TopP),
-		FrequencyPenalty: float32(*conf.FrequencyPenalty),
-		TopLogProbs:      *conf.TopLogProbs,
-		LogProbs:         *conf.LogProbs,
-		Stop:             conf.Stop,
-		PresencePenalty:  float32(*conf.PresencePenalty),
-		Temperature:      float32(*conf.Temperature),
-	}
-
-	request.Messages = d.DeepseekMsgs
-
-	stream, err := deepseek.CreateOllamaChatCompletionStream(ctx, request)
+	hasTools, err := d.stream(ctx, l)
 	if err != nil {
 		logger.Error("ChatCompletionStream error", "updateMsgID", updateMsgID, "err", err)
 		return err
 	}
-	defer stream.Close()
+
+	if !hasTools || len(d.CurrentToolMessage) == 0 || utils.IsRequestStopped(ctx) {
+		db.InsertMsgRecord(l.RecordKey, &db.AQ{
+			UserId:        userId,
+			Question:      l.Content,
+			Answer:        l.WholeContent,
+			Content:       marshalToolMessages(fromOllamaMessages(d.ToolMessage)),
+			Token:         l.Token,
+			QuestionMsgId: updateMsgID,
+			AnswerMsgId:   l.AnswerMsgId,
+			Usage:         l.getUsage(),
+		}, true)
+	} else {
+		d.CurrentToolMessage = append([]api.Message{
+			{
+				Role:      constants.ChatMessageRoleAssistant,
+				Content:   l.WholeContent,
+				ToolCalls: d.ToolCall,
+			},
+		}, d.CurrentToolMessage...)
+
+		d.ToolMessage = append(d.ToolMessage, d.CurrentToolMessage...)
+		d.OllamaMsgs = append(d.OllamaMsgs, d.CurrentToolMessage...)
+		d.CurrentToolMessage = make([]api.Message, 0)
+		d.ToolCall = make([]api.ToolCall, 0)
+		return d.Send(ctx, l)
+	}
+
+	// record time costing in dialog
+	totalDuration := time.Since(start).Seconds()
+	metrics.ConversationDuration.Observe(totalDuration)
+	return nil
+}
+
+// stream request /api/chat and show answer while it's generating, it returns whether tools are called
+func (d *OllamaReq) stream(ctx context.Context, l *LLM) (bool, error) {
+	_, updateMsgID, _ := utils.GetChatIdAndMsgIdAndUserID(l.Update)
+
+	client, err := getOllamaClient()
+	if err != nil {
+		return false, err
+	}
+
 	msgInfoContent := &param.MsgInfo{
 		SendLen: FirstSendLen,
 	}
 
 	hasTools := false
-	for {
-		response, err := stream.Recv()
-		if errors.Is(err, io.EOF) {
-			logger.Info("Stream finished", "updateMsgID", updateMsgID)
-			break
-		}
-		if err != nil {
-			logger.Warn("Stream error", "updateMsgID", updateMsgID, "err", err)
-			break
+	err = client.Chat(ctx, d.getRequest(l, true), func(response api.ChatResponse) error {
+		if len(response.Message.ToolCalls) > 0 {
+			hasTools = true
+			l.answered.Store(true)
+			d.ToolCall = append(d.ToolCall, response.Message.ToolCalls...)
+			d.CurrentToolMessage = append(d.CurrentToolMessage, d.requestToolsCall(ctx, response.Message.ToolCalls)...)
 		}
-		for _, choice := range response.Choices {
-			if len(choice.Delta.ToolCalls) > 0 {
-				hasTools = true
-				err = d.requestToolsCall(ctx, choice)
-				if err != nil {
-					if errors.Is(err, ToolsJsonErr) {
-						continue
-					} else {
-						logger.Error("requestToolsCall error", "updateMsgID", updateMsgID, "err", err)
-					}
-				}
-			}
-
-			if len(choice.Delta.Content) > 0 {
-				msgInfoContent = l.sendMsg(msgInfoContent, choice.Delta.Content)
-			}
+
+		if len(response.Message.Content) > 0 {
+			msgInfoContent = l.sendMsg(msgInfoContent, response.Message.Content)
 		}
 
-		if response.Usage != nil {
-			l.Token += response.Usage.TotalTokens
+		if response.Done {
+			l.Token += response.PromptEvalCount + response.EvalCount
+			l.addUsage(response.PromptEvalCount, response.EvalCount, 0)
 			metrics.TotalTokens.Add(float64(l.Token))
 		}
+		return nil
+	})
+	if err != nil {
+		logger.Warn("Stream error", "updateMsgID", updateMsgID, "err", err)
+		// nothing is answered, let next llm of fallback chain take over
+		if l.canFallback(ctx) {
+			return false, err
+		}
 	}
 
+	msgInfoContent = l.appendStopNote(ctx, msgInfoContent)
 	if len(strings.TrimRightFunc(msgInfoContent.Content, unicode.IsSpace)) > 0 {
 		l.MessageChan <- msgInfoContent
 	}
 
-	if !hasTools || len(d.CurrentToolMessage) == 0 {
-		data, _ := json.Marshal(d.ToolMessage)
-		db.InsertMsgRecord(userId, &db.AQ{
-			Question: l.Content,
-			Answer:   l.WholeContent,
-			Content:  string(data),
-			Token:    l.Token,
-		}, true)
-	} else {
-		d.CurrentToolMessage = append([]deepseek.ChatCompletionMessage{
-			{
-				Role:      deepseek.ChatMessageRoleAssistant,
-				Content:   l.WholeContent,
-				ToolCalls: d.ToolCall,
-			},
-		}, d.CurrentToolMessage...)
+	return hasTools, nil
+}
 
-		d.ToolMessage = append(d.ToolMessage, d.CurrentToolMessage...)
-		d.DeepseekMsgs = append(d.DeepseekMsgs, d.CurrentToolMessage...)
-		d.CurrentToolMessage = make([]deepseek.ChatCompletionMessage, 0)
-		d.ToolCall = make([]deepseek.ToolCall, 0)
-		return d.Send(ctx, l)
+// getRequest create chat request with keep alive and context window of ollama conf
+func (d *OllamaReq) getRequest(l *LLM, stream bool) *api.ChatRequest {
+	params := l.getParams()
+	options := map[string]any{
+		"num_predict":       params.MaxTokens,
+		"top_p":             params.TopP,
+		"frequency_penalty": params.FrequencyPenalty,
+		"presence_penalty":  params.PresencePenalty,
+		"temperature":       params.Temperature,
+	}
+	if len(params.Stop) > 0 {
+		options["stop"] = params.Stop
+	}
+	if *conf.OllamaNumCtx > 0 {
+		options["num_ctx"] = *conf.OllamaNumCtx
 	}
 
-	// record time costing in dialog
-	totalDuration := time.Since(start).Seconds()
-	metrics.ConversationDuration.Observe(totalDuration)
-	return nil
+	return &api.ChatRequest{
+		Model:     l.Model,
+		Messages:  d.OllamaMsgs,
+		Stream:    &stream,
+		KeepAlive: getOllamaKeepAlive(),
+		Tools:     getOllamaTools(l.OpenAITools),
+		Options:   options,
+	}
 }
 
-func (d *OllamaDeepseekReq) GetUserMessage(msg string) {
+func (d *OllamaReq) GetUserMessage(msg string) {
 	d.GetMessage(constants.ChatMessageRoleUser, msg)
 }
 
-func (d *OllamaDeepseekReq) GetAssistantMessage(msg string) {
+func (d *OllamaReq) GetAssistantMessage(msg string) {
 	d.GetMessage(constants.ChatMessageRoleAssistant, msg)
 }
 
-func (d *OllamaDeepseekReq) AppendMessages(client LLMClient) {
-	if len(d.DeepseekMsgs) == 0 {
-		d.DeepseekMsgs = make([]deepseek.ChatCompletionMessage, 0)
+func (d *OllamaReq) AppendMessages(client LLMClient) {
+	if len(d.OllamaMsgs) == 0 {
+		d.OllamaMsgs = make([]api.Message, 0)
 	}
 
-	d.DeepseekMsgs = append(d.DeepseekMsgs, client.(*OllamaDeepseekReq).DeepseekMsgs...)
+	d.OllamaMsgs = append(d.OllamaMsgs, client.(*OllamaReq).OllamaMsgs...)
 }
 
-func (d *OllamaDeepseekReq) GetMessage(role, msg string) {
-	if len(d.DeepseekMsgs) == 0 {
-		d.DeepseekMsgs = []deepseek.ChatCompletionMessage{
-			{
-				Role:    role,
-				Content: msg,
-			},
-		}
-		return
-	}
-
-	d.DeepseekMsgs = append(d.DeepseekMsgs, deepseek.ChatCompletionMessage{
+func (d *OllamaReq) GetMessage(role, msg string) {
+	d.OllamaMsgs = append(d.OllamaMsgs, api.Message{
 		Role:    role,
 		Content: msg,
 	})
 }
-func (d *OllamaDeepseekReq) SyncSend(ctx context.Context, l *LLM) (string, error) {
+
+func (d *OllamaReq) SyncSend(ctx context.Context, l *LLM) (string, error) {
 	_, updateMsgID, _ := utils.GetChatIdAndMsgIdAndUserID(l.Update)
 
-	httpClient := utils.GetDeepseekProxyClient()
+	d.GetModel(l)
 
-	client, err := deepseek.NewClientWithOptions(*conf.DeepseekToken,
-		deepseek.WithBaseURL(*conf.CustomUrl), deepseek.WithHTTPClient(httpClient))
+	client, err := getOllamaClient()
 	if err != nil {
-		logger.Error("Error creating deepseek client", "err", err)
 		return "", err
 	}
 
-	request := &deepseek.ChatCompletionRequest{
-		Model:            l.Model,
-		MaxTokens:        *conf.MaxTokens,
-		TopP:             float32(*conf.TopP),
-		FrequencyPenalty: float32(*conf.FrequencyPenalty),
-		TopLogProbs:      *conf.TopLogProbs,
-		LogProbs:         *conf.LogProbs,
-		Stop:             conf.Stop,
-		PresencePenalty:  float32(*conf.PresencePenalty),
-		Temperature:      float32(*conf.Temperature),
-		Messages:         d.DeepseekMsgs,
-		Tools:            l.DeepseekTools,
-	}
-
-	// assign task
-	response, err := client.CreateChatCompletion(ctx, request)
+	var response api.ChatResponse
+	err = client.Chat(ctx, d.getRequest(l, false), func(resp api.ChatResponse) error {
+		response = resp
+		return nil
+	})
 	if err != nil {
 		logger.Error("ChatCompletionStream error", "updateMsgID", updateMsgID, "err", err)
 		return "", err
 	}
 
-	if len(response.Choices) == 0 {
-		logger.Error("response is emtpy", "response", response)
-		return "", errors.New("response is empty")
-	}
-
-	if len(response.Choices[0].Message.ToolCalls) > 0 {
-		d.GetAssistantMessage("")
-		d.DeepseekMsgs[len(d.DeepseekMsgs)-1].ToolCalls = response.Choices[0].Message.ToolCalls
-		d.requestOneToolsCall(ctx, response.Choices[0].Message.ToolCalls)
+	l.Token += response.PromptEvalCount + response.EvalCount
+	l.addUsage(response.PromptEvalCount, response.EvalCount, 0)
+	if len(response.Message.ToolCalls) > 0 {
+		d.OllamaMsgs = append(d.OllamaMsgs, api.Message{
+			Role:      constants.ChatMessageRoleAssistant,
+			ToolCalls: response.Message.ToolCalls,
+		})
+		d.OllamaMsgs = append(d.OllamaMsgs, d.requestToolsCall(ctx, response.Message.ToolCalls)...)
 	}
 
-	return response.Choices[0].Message.Content, nil
+	return response.Message.Content, nil
 }
 
-func (d *OllamaDeepseekReq) requestOneToolsCall(ctx context.Context, toolsCall []deepseek.ToolCall) {
-	for _, tool := range toolsCall {
-		property := make(map[string]interface{})
-		err := json.Unmarshal([]byte(tool.Function.Arguments), &property)
+// requestToolsCall execute tools, ollama returns whole tool call instead of pieces
+func (d *OllamaReq) requestToolsCall(ctx context.Context, toolCalls []api.ToolCall) []api.Message {
+	messages := make([]api.Message, 0, len(toolCalls))
+	for _, toolCall := range toolCalls {
+		mc, err := clients.GetMCPClientByToolName(toolCall.Function.Name)
 		if err != nil {
-			return
+			logger.Warn("get mcp fail", "err", err, "function", toolCall.Function.Name,
+				"argument", toolCall.Function.Arguments.String())
+			continue
 		}
 
-		mc, err := clients.GetMCPClientByToolName(tool.Function.Name)
+		toolsData, err := mc.ExecTools(ctx, toolCall.Function.Name, toolCall.Function.Arguments)
 		if err != nil {
-			logger.Warn("get mcp fail", "err", err)
-			return
+			logger.Warn("exec tools fail", "err", err, "function", toolCall.Function.Name,
+				"argument", toolCall.Function.Arguments.String())
+			continue
 		}
 
-		toolsData, err := mc.ExecTools(ctx, tool.Function.Name, property)
-		if err != nil {
-			logger.Warn("exec tools fail", "err", err)
-			return
-		}
-
-		d.DeepseekMsgs = append(d.DeepseekMsgs, deepseek.ChatCompletionMessage{
-			Role:       constants.ChatMessageRoleTool,
-			Content:    toolsData,
-			ToolCallID: tool.ID,
+		messages = append(messages, api.Message{
+			Role:    constants.ChatMessageRoleTool,
+			Content: toolsData,
 		})
-		logger.Info("exec tool", "name", tool.Function.Name, "toolsData", toolsData)
+		logger.Info("send tool request", "function", toolCall.Function.Name,
+			"argument", toolCall.Function.Arguments.String(), "res", toolsData)
 	}
+
+	return messages
 }
 
-func (d *OllamaDeepseekReq) requestToolsCall(ctx context.Context, choice deepseek.StreamChoices) error {
+// ListOllamaModels list installed models of ollama by /api/tags
+func ListOllamaModels(ctx context.Context) ([]string, error) {
+	client, err := getOllamaClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]string, 0, len(resp.Models))
+	for _, model := range resp.Models {
+		models = append(models, model.Name)
+	}
+	return models, nil
+}
 
-	for _, toolCall := range choice.Delta.ToolCalls {
-		property := make(map[string]interface{})
+func getOllamaClient() (*api.Client, error) {
+	host := *conf.OllamaHost
+	if host == "" {
+		host = OllamaUrl
+	}
 
-		if toolCall.Function.Name != "" {
-			d.ToolCall = append(d.ToolCall, toolCall)
-			d.ToolCall[len(d.ToolCall)-1].Function.Name = toolCall.Function.Name
-		}
+	base, err := url.Parse(host)
+	if err != nil {
+		logger.Error("parse ollama host fail", "host", host, "err", err)
+		return nil, err
+	}
+	return api.NewClient(base, http.DefaultClient), nil
+}
 
-		if toolCall.ID != "" {
-			d.ToolCall[len(d.ToolCall)-1].ID = toolCall.ID
-		}
+// getOllamaKeepAlive parse keep alive like 5m, negative duration keeps model loaded
+func getOllamaKeepAlive() *api.Duration {
+	if *conf.OllamaKeepAlive == "" {
+		return nil
+	}
 
-		if toolCall.Type != "" {
-			d.ToolCall[len(d.ToolCall)-1].Type = toolCall.Type
-		}
+	keepAlive, err := time.ParseDuration(*conf.OllamaKeepAlive)
+	if err != nil {
+		logger.Warn("parse ollama keep alive fail", "keepAlive", *conf.OllamaKeepAlive, "err", err)
+		return nil
+	}
+	return &api.Duration{Duration: keepAlive}
+}
 
-		if toolCall.Function.Arguments != "" {
-			d.ToolCall[len(d.ToolCall)-1].Function.Arguments += toolCall.Function.Arguments
-		}
+// getOllamaTools transfer openai tools to ollama tools, tool whose parameters ollama can't describe is skipped
+func getOllamaTools(tools []openai.Tool) api.Tools {
+	if len(tools) == 0 {
+		return nil
+	}
 
-		err := json.Unmarshal([]byte(d.ToolCall[len(d.ToolCall)-1].Function.Arguments), &property)
+	ollamaTools := make(api.Tools, 0, len(tools))
+	for _, tool := range tools {
+		data, err := json.Marshal(tool)
 		if err != nil {
-			return ToolsJsonErr
+			logger.Warn("marshal tool fail", "err", err)
+			continue
 		}
 
-		mc, err := clients.GetMCPClientByToolName(d.ToolCall[len(d.ToolCall)-1].Function.Name)
-		if err != nil {
-			logger.Warn("get mcp fail", "err", err, "function", d.ToolCall[len(d.ToolCall)-1].Function.Name,
-				"toolCall", d.ToolCall[len(d.ToolCall)-1].ID, "argument", d.ToolCall[len(d.ToolCall)-1].Function.Arguments)
-			return err
+		ollamaTool := api.Tool{}
+		if err = json.Unmarshal(data, &ollamaTool); err != nil {
+			logger.Warn("transfer tool to ollama fail", "tool", string(data), "err", err)
+			continue
 		}
+		ollamaTools = append(ollamaTools, ollamaTool)
+	}
+	return ollamaTools
+}
 
-		toolsData, err := mc.ExecTools(ctx, d.ToolCall[len(d.ToolCall)-1].Function.Name, property)
-		if err != nil {
-			logger.Warn("exec tools fail", "err", err, "function", d.ToolCall[len(d.ToolCall)-1].Function.Name,
-				"toolCall", d.ToolCall[len(d.ToolCall)-1].ID, "argument", d.ToolCall[len(d.ToolCall)-1].Function.Arguments)
-			return err
+// fromOllamaMessages transfer ollama tool messages to messages of records, ollama doesn't give id of tool call
+func fromOllamaMessages(msgs []api.Message) []*param.ToolMessage {
+	res := make([]*param.ToolMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		toolMsg := &param.ToolMessage{
+			Role:    msg.Role,
+			Content: msg.Content,
 		}
-		d.CurrentToolMessage = append(d.CurrentToolMessage, deepseek.ChatCompletionMessage{
-			Role:       constants.ChatMessageRoleTool,
-			Content:    toolsData,
-			ToolCallID: d.ToolCall[len(d.ToolCall)-1].ID,
-		})
-
-		logger.Info("send tool request", "function", d.ToolCall[len(d.ToolCall)-1].Function.Name,
-			"toolCall", d.ToolCall[len(d.ToolCall)-1].ID, "argument", d.ToolCall[len(d.ToolCall)-1].Function.Arguments,
-			"res", toolsData)
+		for _, toolCall := range msg.ToolCalls {
+			toolMsg.ToolCalls = append(toolMsg.ToolCalls, &param.ToolCall{
+				Type: string(openai.ToolTypeFunction),
+				Function: param.ToolCallFunction{
+					Name:      toolCall.Function.Name,
+					Arguments: marshalToolArguments(toolCall.Function.Arguments),
+				},
+			})
+		}
+		res = append(res, toolMsg)
 	}
+	return res
+}
 
-	return nil
-
+// toOllamaMessages transfer tool messages of records to ollama messages
+func toOllamaMessages(msgs []*param.ToolMessage) []api.Message {
+	res := make([]api.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		ollamaMsg := api.Message{
+			Role:    msg.Role,
+			Content: msg.Content,
+		}
+		for i, toolCall := range msg.ToolCalls {
+			ollamaMsg.ToolCalls = append(ollamaMsg.ToolCalls, api.ToolCall{
+				Function: api.ToolCallFunction{
+					Index:     i,
+					Name:      toolCall.Function.Name,
+					Arguments: parseToolArguments(toolCall.Function.Arguments),
+				},
+			})
+		}
+		res = append(res, ollamaMsg)
+	}
+	return res
 }
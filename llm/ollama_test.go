@@ -0,0 +1,102 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/param"
+)
+
+// newOllamaServer start a local stand-in of ollama which answers "Hello" and calls search tool when streaming
+func newOllamaServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			_, _ = w.Write([]byte(`{"models":[{"name":"llava:latest"},{"name":"qwen2.5:7b"}]}`))
+		case "/api/chat":
+			request := new(api.ChatRequest)
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(request))
+			assert.Equal(t, "qwen2.5:7b", request.Model)
+			assert.Equal(t, "5m0s", request.KeepAlive.Duration.String())
+			assert.EqualValues(t, 4096, request.Options["num_ctx"])
+			assert.Equal(t, api.ImageData("image"), request.Messages[len(request.Messages)-1].Images[0])
+
+			if request.Stream != nil && !*request.Stream {
+				_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":"ok"},"done":true,"prompt_eval_count":3,"eval_count":1}`))
+				return
+			}
+
+			assert.Equal(t, "search", request.Tools[0].Function.Name)
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":"Hel"},"done":false}` + "\n"))
+			_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":"lo"},"done":false}` + "\n"))
+			_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"search","arguments":{"q":"go"}}}]},"done":false}` + "\n"))
+			_, _ = w.Write([]byte(`{"message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":5,"eval_count":2}` + "\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func setOllamaConf(host string) {
+	keepAlive, numCtx, model := "5m", 4096, "llava:latest"
+	maxTokens, zero := 100, 0.0
+	conf.OllamaHost, conf.OllamaKeepAlive, conf.OllamaNumCtx, conf.OllamaModel = &host, &keepAlive, &numCtx, &model
+	conf.MaxTokens, conf.TopP, conf.FrequencyPenalty, conf.PresencePenalty, conf.Temperature = &maxTokens, &zero, &zero, &zero, &zero
+}
+
+func TestOllamaStream(t *testing.T) {
+	server := newOllamaServer(t)
+	defer server.Close()
+	setOllamaConf(server.URL)
+
+	models, err := ListOllamaModels(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"llava:latest", "qwen2.5:7b"}, models)
+
+	l := &LLM{
+		MessageChan: make(chan *param.MsgInfo, 10),
+		ForceModel:  "qwen2.5:7b",
+		OpenAITools: []openai.Tool{{Type: openai.ToolTypeFunction, Function: &openai.FunctionDefinition{
+			Name:       "search",
+			Parameters: map[string]interface{}{"type": "object", "properties": map[string]interface{}{}},
+		}}},
+	}
+	l.useForceModel()
+	d := &OllamaReq{OllamaMsgs: []api.Message{{Role: "user", Content: "hi", Images: []api.ImageData{api.ImageData("image")}}}}
+
+	hasTools, err := d.stream(context.Background(), l)
+	assert.NoError(t, err)
+	assert.True(t, hasTools)
+	assert.Equal(t, "search", d.ToolCall[0].Function.Name)
+	assert.Equal(t, "Hello", l.WholeContent)
+	assert.Equal(t, 7, l.Token)
+	msg := <-l.MessageChan
+	assert.Equal(t, "Hello", msg.Content)
+
+	answer, err := d.SyncSend(context.Background(), l)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", answer)
+	assert.Equal(t, 11, l.Token)
+}
+
+func TestOllamaStreamFail(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`{"error":"model not found"}`))
+	}))
+	defer server.Close()
+	setOllamaConf(server.URL)
+
+	// nothing is answered, error is returned so fallback chain can take over
+	l := &LLM{MessageChan: make(chan *param.MsgInfo, 10), Model: "qwen2.5:7b"}
+	_, err := (&OllamaReq{}).stream(context.Background(), l)
+	assert.EqualError(t, err, "model not found")
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"net/http"
+	"slices"
 	"strings"
 	"time"
 	"unicode"
@@ -27,13 +29,17 @@ type OpenAIReq struct {
 	CurrentToolMessage []openai.ChatCompletionMessage
 
 	OpenAIMsgs []openai.ChatCompletionMessage
+
+	// Profile openai compatible endpoint served by the client, it's nil for openai
+	Profile *conf.OpenAICompatibleProfile
 }
 
 // CallLLMAPI request DeepSeek API and get response
 func (d *OpenAIReq) CallLLMAPI(ctx context.Context, prompt string, l *LLM) error {
 	_, _, userId := utils.GetChatIdAndMsgIdAndUserID(l.Update)
 
-	d.GetMessages(userId, prompt)
+	d.GetModel(l)
+	d.GetMessages(l, prompt)
 
 	logger.Info("msg receive", "userID", userId, "prompt", prompt)
 
@@ -41,61 +47,151 @@ func (d *OpenAIReq) CallLLMAPI(ctx context.Context, prompt string, l *LLM) error
 }
 
 func (d *OpenAIReq) GetModel(l *LLM) {
+	if l.useForceModel() {
+		return
+	}
 	_, _, userId := utils.GetChatIdAndMsgIdAndUserID(l.Update)
 	l.Model = openai.GPT3Dot5Turbo0125
+	isModel := func(model string) bool {
+		return IsModelOf(param.OpenAi, model)
+	}
+	if d.Profile != nil {
+		l.Model = d.Profile.Models[0]
+		isModel = func(model string) bool {
+			return slices.Contains(d.Profile.Models, model)
+		}
+	}
+
 	userInfo, err := db.GetUserByID(userId)
 	if err != nil {
 		logger.Error("Error getting user info", "err", err)
 	}
-	if userInfo != nil && userInfo.Mode != "" && param.OpenAIModels[userInfo.Mode] {
+	if userInfo != nil && userInfo.Mode != "" && isModel(userInfo.Mode) {
 		logger.Info("User info", "userID", userInfo.UserId, "mode", userInfo.Mode)
 		l.Model = userInfo.Mode
 	}
 }
 
-func (d *OpenAIReq) GetMessages(userId int64, prompt string) {
-	messages := make([]openai.ChatCompletionMessage, 0)
+// getClient create client of openai or openai compatible endpoint
+func (d *OpenAIReq) getClient() *openai.Client {
+	// set deepseek proxy
+	httpClient := utils.GetDeepseekProxyClient()
 
-	msgRecords := db.GetMsgRecord(userId)
-	if msgRecords != nil {
-		aqs := msgRecords.AQs
-		if len(aqs) > 10 {
-			aqs = aqs[len(aqs)-10:]
+	if d.Profile != nil {
+		openaiConfig := openai.DefaultConfig(d.Profile.Token)
+		openaiConfig.BaseURL = d.Profile.BaseUrl
+		if len(d.Profile.Headers) > 0 {
+			httpClient.Transport = &headerTransport{
+				base:    httpClient.Transport,
+				headers: d.Profile.Headers,
+			}
 		}
+		openaiConfig.HTTPClient = httpClient
+		return openai.NewClientWithConfig(openaiConfig)
+	}
 
-		for i, record := range aqs {
-			if record.Answer != "" && record.Question != "" {
-				logger.Info("context content", "dialog", i, "question:", record.Question,
-					"toolContent", record.Content, "answer:", record.Answer)
-				messages = append(messages, openai.ChatCompletionMessage{
-					Role:    constants.ChatMessageRoleUser,
-					Content: record.Question,
-				})
-				if record.Content != "" {
-					toolsMsgs := make([]openai.ChatCompletionMessage, 0)
-					err := json.Unmarshal([]byte(record.Content), &toolsMsgs)
-					if err != nil {
-						logger.Error("Error unmarshalling tools json", "err", err)
-					} else {
-						messages = append(messages, toolsMsgs...)
-					}
-				}
-				messages = append(messages, openai.ChatCompletionMessage{
-					Role:    constants.ChatMessageRoleAssistant,
-					Content: record.Answer,
-				})
-			}
+	openaiConfig := openai.DefaultConfig(*conf.OpenAIToken)
+	if customUrl := getCustomUrl(param.OpenAi, ""); customUrl != "" {
+		openaiConfig.BaseURL = customUrl
+	}
+
+	//openaiConfig.BaseURL = "https://api.chatanywhere.org"
+	openaiConfig.HTTPClient = httpClient
+	return openai.NewClientWithConfig(openaiConfig)
+}
+
+// getTools endpoint which doesn't support function call gets no tools
+func (d *OpenAIReq) getTools(l *LLM) []openai.Tool {
+	if d.Profile != nil && !d.Profile.UseTools {
+		return nil
+	}
+	return l.OpenAITools
+}
+
+// headerTransport add custom headers of openai compatible endpoint to every request
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	return t.base.RoundTrip(req)
+}
+
+func (d *OpenAIReq) GetMessages(l *LLM, prompt string) {
+	key := l.RecordKey
+	messages := make([]openai.ChatCompletionMessage, 0)
+
+	systemPrompt := getSystemPrompt(key)
+	if systemPrompt != "" {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    constants.ChatMessageRoleSystem,
+			Content: systemPrompt,
+		})
+	}
+
+	summary, aqs := l.getContext(key, l.OpenAITools, systemPrompt, prompt)
+	if summary != "" {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    constants.ChatMessageRoleSystem,
+			Content: getMemoryPrompt(summary),
+		})
+	}
+
+	for i, record := range aqs {
+		if record.Answer != "" && record.Question != "" {
+			logger.Info("context content", "dialog", i, "question:", record.Question,
+				"toolContent", record.Content, "answer:", record.Answer)
+			messages = append(messages, openai.ChatCompletionMessage{
+				Role:    constants.ChatMessageRoleUser,
+				Content: record.Question,
+			})
+			messages = append(messages, toOpenAIMessages(unmarshalToolMessages(record.Content))...)
+			messages = append(messages, openai.ChatCompletionMessage{
+				Role:    constants.ChatMessageRoleAssistant,
+				Content: record.Answer,
+			})
 		}
 	}
 
-	messages = append(messages, openai.ChatCompletionMessage{
-		Role:    constants.ChatMessageRoleUser,
-		Content: prompt,
-	})
+	messages = append(messages, newOpenAIUserMessage(prompt, l.getRequestImages()))
 
 	d.OpenAIMsgs = messages
 }
 
+// newOpenAIUserMessage images of question are only sent in this turn, as image parts of user message
+func newOpenAIUserMessage(prompt string, images [][]byte) openai.ChatCompletionMessage {
+	if len(images) == 0 {
+		return openai.ChatCompletionMessage{
+			Role:    constants.ChatMessageRoleUser,
+			Content: prompt,
+		}
+	}
+
+	parts := []openai.ChatMessagePart{
+		{
+			Type: openai.ChatMessagePartTypeText,
+			Text: prompt,
+		},
+	}
+	for _, image := range images {
+		parts = append(parts, openai.ChatMessagePart{
+			Type: openai.ChatMessagePartTypeImageURL,
+			ImageURL: &openai.ChatMessageImageURL{
+				URL: imageDataUrl(image),
+			},
+		})
+	}
+	return openai.ChatCompletionMessage{
+		Role:         constants.ChatMessageRoleUser,
+		MultiContent: parts,
+	}
+}
+
 func (d *OpenAIReq) Send(ctx context.Context, l *LLM) error {
 	if l.OverLoop() {
 		return errors.New("too many loops")
@@ -105,32 +201,24 @@ func (d *OpenAIReq) Send(ctx context.Context, l *LLM) error {
 	_, updateMsgID, userId := utils.GetChatIdAndMsgIdAndUserID(l.Update)
 	d.GetModel(l)
 
-	// set deepseek proxy
-	httpClient := utils.GetDeepseekProxyClient()
-	openaiConfig := openai.DefaultConfig(*conf.OpenAIToken)
-	if *conf.CustomUrl != "" {
-		openaiConfig.BaseURL = *conf.CustomUrl
-	}
-
-	//openaiConfig.BaseURL = "https://api.chatanywhere.org"
-	openaiConfig.HTTPClient = httpClient
-	client := openai.NewClientWithConfig(openaiConfig)
+	client := d.getClient()
 
+	params := l.getParams()
 	request := openai.ChatCompletionRequest{
 		Model:  l.Model,
 		Stream: true,
 		StreamOptions: &openai.StreamOptions{
 			IncludeUsage: true,
 		},
-		MaxTokens:        *conf.MaxTokens,
-		TopP:             float32(*conf.TopP),
-		FrequencyPenalty: float32(*conf.FrequencyPenalty),
+		MaxTokens:        params.MaxTokens,
+		TopP:             float32(params.TopP),
+		FrequencyPenalty: float32(params.FrequencyPenalty),
 		TopLogProbs:      *conf.TopLogProbs,
 		LogProbs:         *conf.LogProbs,
-		Stop:             conf.Stop,
-		PresencePenalty:  float32(*conf.PresencePenalty),
-		Temperature:      float32(*conf.Temperature),
-		Tools:            l.OpenAITools,
+		Stop:             params.Stop,
+		PresencePenalty:  float32(params.PresencePenalty),
+		Temperature:      float32(params.Temperature),
+		Tools:            d.getTools(l),
 	}
 
 	request.Messages = d.OpenAIMsgs
@@ -154,11 +242,16 @@ func (d *OpenAIReq) Send(ctx context.Context, l *LLM) error {
 		}
 		if err != nil {
 			logger.Warn("Stream error", "updateMsgID", updateMsgID, "err", err)
+			// nothing is answered, let next llm of fallback chain take over
+			if l.canFallback(ctx) {
+				return err
+			}
 			break
 		}
 		for _, choice := range response.Choices {
 			if len(choice.Delta.ToolCalls) > 0 {
 				hasTools = true
+				l.answered.Store(true)
 				err = d.requestToolsCall(ctx, choice)
 				if err != nil {
 					if errors.Is(err, ToolsJsonErr) {
@@ -176,18 +269,26 @@ func (d *OpenAIReq) Send(ctx context.Context, l *LLM) error {
 
 		if response.Usage != nil {
 			l.Token += response.Usage.TotalTokens
+			l.addOpenAIUsage(response.Usage)
 			metrics.TotalTokens.Add(float64(l.Token))
 		}
 	}
 
+	msgInfoContent = l.appendStopNote(ctx, msgInfoContent)
 	if len(strings.TrimRightFunc(msgInfoContent.Content, unicode.IsSpace)) > 0 {
 		l.MessageChan <- msgInfoContent
 	}
-	if !hasTools || len(d.CurrentToolMessage) == 0 {
-		db.InsertMsgRecord(userId, &db.AQ{
-			Question: l.Content,
-			Answer:   l.WholeContent,
-			Token:    l.Token,
+	if !hasTools || len(d.CurrentToolMessage) == 0 || utils.IsRequestStopped(ctx) {
+		db.InsertMsgRecord(l.RecordKey, &db.AQ{
+			UserId:         userId,
+			Question:       l.Content,
+			Answer:         l.WholeContent,
+			Content:        marshalToolMessages(fromOpenAIMessages(d.ToolMessage)),
+			Token:          l.Token,
+			QuestionMsgId:  updateMsgID,
+			AnswerMsgId:    l.AnswerMsgId,
+			ReasoningToken: l.ReasoningToken,
+			Usage:          l.getUsage(),
 		}, true)
 	} else {
 		d.CurrentToolMessage = append([]openai.ChatCompletionMessage{
@@ -246,30 +347,21 @@ func (d *OpenAIReq) GetMessage(role, msg string) {
 
 func (d *OpenAIReq) SyncSend(ctx context.Context, l *LLM) (string, error) {
 	_, updateMsgID, _ := utils.GetChatIdAndMsgIdAndUserID(l.Update)
-	// set deepseek proxy
 	d.GetModel(l)
-	httpClient := utils.GetDeepseekProxyClient()
-
-	openaiConfig := openai.DefaultConfig(*conf.OpenAIToken)
-	if *conf.CustomUrl != "" {
-		openaiConfig.BaseURL = *conf.CustomUrl
-	}
-
-	//openaiConfig.BaseURL = "https://api.chatanywhere.org"
-	openaiConfig.HTTPClient = httpClient
-	client := openai.NewClientWithConfig(openaiConfig)
+	client := d.getClient()
 
+	params := l.getParams()
 	request := openai.ChatCompletionRequest{
 		Model:            l.Model,
-		MaxTokens:        *conf.MaxTokens,
-		TopP:             float32(*conf.TopP),
-		FrequencyPenalty: float32(*conf.FrequencyPenalty),
+		MaxTokens:        params.MaxTokens,
+		TopP:             float32(params.TopP),
+		FrequencyPenalty: float32(params.FrequencyPenalty),
 		TopLogProbs:      *conf.TopLogProbs,
 		LogProbs:         *conf.LogProbs,
-		Stop:             conf.Stop,
-		PresencePenalty:  float32(*conf.PresencePenalty),
-		Temperature:      float32(*conf.Temperature),
-		Tools:            l.OpenAITools,
+		Stop:             params.Stop,
+		PresencePenalty:  float32(params.PresencePenalty),
+		Temperature:      float32(params.Temperature),
+		Tools:            d.getTools(l),
 	}
 
 	request.Messages = d.OpenAIMsgs
@@ -286,6 +378,7 @@ func (d *OpenAIReq) SyncSend(ctx context.Context, l *LLM) (string, error) {
 	}
 
 	l.Token += response.Usage.TotalTokens
+	l.addOpenAIUsage(&response.Usage)
 	if len(response.Choices[0].Message.ToolCalls) > 0 {
 		d.GetAssistantMessage("")
 		d.OpenAIMsgs[len(d.OpenAIMsgs)-1].ToolCalls = response.Choices[0].Message.ToolCalls
@@ -377,3 +470,64 @@ func (d *OpenAIReq) requestToolsCall(ctx context.Context, choice openai.ChatComp
 	return nil
 
 }
+
+// addOpenAIUsage cached and reasoning tokens are only returned by some models
+func (l *LLM) addOpenAIUsage(usage *openai.Usage) {
+	cachedToken := 0
+	if usage.PromptTokensDetails != nil {
+		cachedToken = usage.PromptTokensDetails.CachedTokens
+	}
+	if usage.CompletionTokensDetails != nil {
+		l.ReasoningToken += usage.CompletionTokensDetails.ReasoningTokens
+	}
+	l.addUsage(usage.PromptTokens, usage.CompletionTokens, cachedToken)
+}
+
+// fromOpenAIMessages transfer openai tool messages to messages of records
+func fromOpenAIMessages(msgs []openai.ChatCompletionMessage) []*param.ToolMessage {
+	res := make([]*param.ToolMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		toolMsg := &param.ToolMessage{
+			Role:       msg.Role,
+			Content:    msg.Content,
+			ToolCallID: msg.ToolCallID,
+			Name:       msg.Name,
+		}
+		for _, toolCall := range msg.ToolCalls {
+			toolMsg.ToolCalls = append(toolMsg.ToolCalls, &param.ToolCall{
+				ID:   toolCall.ID,
+				Type: string(toolCall.Type),
+				Function: param.ToolCallFunction{
+					Name:      toolCall.Function.Name,
+					Arguments: toolCall.Function.Arguments,
+				},
+			})
+		}
+		res = append(res, toolMsg)
+	}
+	return res
+}
+
+// toOpenAIMessages transfer tool messages of records to openai messages
+func toOpenAIMessages(msgs []*param.ToolMessage) []openai.ChatCompletionMessage {
+	res := make([]openai.ChatCompletionMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		openaiMsg := openai.ChatCompletionMessage{
+			Role:       msg.Role,
+			Content:    msg.Content,
+			ToolCallID: msg.ToolCallID,
+		}
+		for _, toolCall := range msg.ToolCalls {
+			openaiMsg.ToolCalls = append(openaiMsg.ToolCalls, openai.ToolCall{
+				ID:   toolCall.ID,
+				Type: openai.ToolType(toolCall.Type),
+				Function: openai.FunctionCall{
+					Name:      toolCall.Function.Name,
+					Arguments: toolCall.Function.Arguments,
+				},
+			})
+		}
+		res = append(res, openaiMsg)
+	}
+	return res
+}
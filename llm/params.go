@@ -0,0 +1,250 @@
+package llm
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/db"
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+)
+
+const (
+	ParamTemperature      = "temperature"
+	ParamTopP             = "top_p"
+	ParamMaxTokens        = "max_tokens"
+	ParamFrequencyPenalty = "frequency_penalty"
+	ParamPresencePenalty  = "presence_penalty"
+	ParamStop             = "stop"
+
+	// maxStopNum most llms accept at most 4 stop sequences
+	maxStopNum = 4
+)
+
+var (
+	// ParamNames params which can be overridden, in menu order
+	ParamNames = []string{ParamTemperature, ParamTopP, ParamMaxTokens, ParamFrequencyPenalty, ParamPresencePenalty, ParamStop}
+
+	ErrUnknownParam   = errors.New("unknown param")
+	ErrParamRange     = errors.New("param out of range")
+	ErrParamOverLimit = errors.New("param over limit")
+)
+
+// Params sampling params which are sent to llm
+type Params struct {
+	Temperature      float64
+	TopP             float64
+	MaxTokens        int
+	FrequencyPenalty float64
+	PresencePenalty  float64
+	Stop             []string
+}
+
+// defaultParams process-wide flags
+func defaultParams() *Params {
+	return &Params{
+		Temperature:      *conf.Temperature,
+		TopP:             *conf.TopP,
+		MaxTokens:        *conf.MaxTokens,
+		FrequencyPenalty: *conf.FrequencyPenalty,
+		PresencePenalty:  *conf.PresencePenalty,
+		Stop:             conf.Stop,
+	}
+}
+
+// GetParams get params of user in chat: process-wide flags, overridden by params of group,
+// then overridden by params of user. Overrides are capped by limits of their role, own params
+// of bot admin aren't capped.
+func GetParams(chatId, userId int64) *Params {
+	params := defaultParams()
+	if chatId < 0 {
+		params.merge(getSamplingParams(db.ParamsScopeGroup, chatId), getSamplingParams(db.ParamsScopeGroupLimit, 0))
+	}
+
+	userLimit := new(db.SamplingParams)
+	if !conf.AdminUserIds[userId] {
+		userLimit = getSamplingParams(db.ParamsScopeUserLimit, 0)
+	}
+	params.merge(getSamplingParams(db.ParamsScopeUser, userId), userLimit)
+	return params
+}
+
+func getSamplingParams(scope string, scopeId int64) *db.SamplingParams {
+	if scopeId == 0 && (scope == db.ParamsScopeUser || scope == db.ParamsScopeGroup) {
+		return new(db.SamplingParams)
+	}
+
+	params, err := db.GetSamplingParams(scope, scopeId)
+	if err != nil {
+		logger.Error("get sampling params fail", "scope", scope, "scopeId", scopeId, "err", err)
+		return new(db.SamplingParams)
+	}
+	return params
+}
+
+// merge set fields of override into params, values are capped by limit
+func (p *Params) merge(override, limit *db.SamplingParams) {
+	if override.Temperature != nil {
+		p.Temperature = capFloat(*override.Temperature, limit.Temperature)
+	}
+	if override.TopP != nil {
+		p.TopP = capFloat(*override.TopP, limit.TopP)
+	}
+	if override.MaxTokens != nil {
+		p.MaxTokens = *override.MaxTokens
+		if limit.MaxTokens != nil {
+			p.MaxTokens = min(p.MaxTokens, *limit.MaxTokens)
+		}
+	}
+	if override.FrequencyPenalty != nil {
+		p.FrequencyPenalty = capFloat(*override.FrequencyPenalty, limit.FrequencyPenalty)
+	}
+	if override.PresencePenalty != nil {
+		p.PresencePenalty = capFloat(*override.PresencePenalty, limit.PresencePenalty)
+	}
+	if override.Stop != nil {
+		p.Stop = override.Stop
+	}
+}
+
+func capFloat(value float64, limit *float64) float64 {
+	if limit != nil {
+		return min(value, *limit)
+	}
+	return value
+}
+
+// getParams get params of request, process-wide flags are used if llm isn't created by NewLLM
+func (l *LLM) getParams() *Params {
+	if l.params == nil {
+		return defaultParams()
+	}
+	return l.params
+}
+
+// Value get value of param name for showing
+func (p *Params) Value(name string) string {
+	switch name {
+	case ParamTemperature:
+		return formatFloat(p.Temperature)
+	case ParamTopP:
+		return formatFloat(p.TopP)
+	case ParamMaxTokens:
+		return strconv.Itoa(p.MaxTokens)
+	case ParamFrequencyPenalty:
+		return formatFloat(p.FrequencyPenalty)
+	case ParamPresencePenalty:
+		return formatFloat(p.PresencePenalty)
+	case ParamStop:
+		return strings.Join(p.Stop, ",")
+	}
+	return ""
+}
+
+// GetSamplingParam get value of param name for showing, return empty string if it isn't set
+func GetSamplingParam(params *db.SamplingParams, name string) string {
+	switch name {
+	case ParamTemperature:
+		return formatFloatPtr(params.Temperature)
+	case ParamTopP:
+		return formatFloatPtr(params.TopP)
+	case ParamMaxTokens:
+		if params.MaxTokens != nil {
+			return strconv.Itoa(*params.MaxTokens)
+		}
+	case ParamFrequencyPenalty:
+		return formatFloatPtr(params.FrequencyPenalty)
+	case ParamPresencePenalty:
+		return formatFloatPtr(params.PresencePenalty)
+	case ParamStop:
+		return strings.Join(params.Stop, ",")
+	}
+	return ""
+}
+
+// SetSamplingParam parse value and set it as param name, value must be in range of param and not over limit.
+// stop sequences are separated by comma.
+func SetSamplingParam(params *db.SamplingParams, name, value string, limit *db.SamplingParams) error {
+	if limit == nil {
+		limit = new(db.SamplingParams)
+	}
+
+	switch name {
+	case ParamTemperature:
+		return setFloatParam(&params.Temperature, value, 0, 2, limit.Temperature)
+	case ParamTopP:
+		return setFloatParam(&params.TopP, value, 0, 1, limit.TopP)
+	case ParamMaxTokens:
+		maxTokens, err := strconv.Atoi(value)
+		if err != nil || maxTokens <= 0 {
+			return ErrParamRange
+		}
+		if limit.MaxTokens != nil && maxTokens > *limit.MaxTokens {
+			return ErrParamOverLimit
+		}
+		params.MaxTokens = &maxTokens
+		return nil
+	case ParamFrequencyPenalty:
+		return setFloatParam(&params.FrequencyPenalty, value, -2, 2, limit.FrequencyPenalty)
+	case ParamPresencePenalty:
+		return setFloatParam(&params.PresencePenalty, value, -2, 2, limit.PresencePenalty)
+	case ParamStop:
+		stop := make([]string, 0)
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				stop = append(stop, s)
+			}
+		}
+		if len(stop) == 0 || len(stop) > maxStopNum {
+			return ErrParamRange
+		}
+		params.Stop = stop
+		return nil
+	}
+	return ErrUnknownParam
+}
+
+// ResetSamplingParam unset param name, process-wide flag is used again
+func ResetSamplingParam(params *db.SamplingParams, name string) error {
+	switch name {
+	case ParamTemperature:
+		params.Temperature = nil
+	case ParamTopP:
+		params.TopP = nil
+	case ParamMaxTokens:
+		params.MaxTokens = nil
+	case ParamFrequencyPenalty:
+		params.FrequencyPenalty = nil
+	case ParamPresencePenalty:
+		params.PresencePenalty = nil
+	case ParamStop:
+		params.Stop = nil
+	default:
+		return ErrUnknownParam
+	}
+	return nil
+}
+
+func setFloatParam(field **float64, value string, minValue, maxValue float64, limit *float64) error {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < minValue || f > maxValue {
+		return ErrParamRange
+	}
+	if limit != nil && f > *limit {
+		return ErrParamOverLimit
+	}
+	*field = &f
+	return nil
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func formatFloatPtr(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return formatFloat(*f)
+}
@@ -0,0 +1,54 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/db"
+)
+
+func TestMergeParams(t *testing.T) {
+	maxTokens, temperature, topP, zero := 2048, 0.7, 0.9, 0.0
+	conf.MaxTokens, conf.Temperature, conf.TopP = &maxTokens, &temperature, &topP
+	conf.FrequencyPenalty, conf.PresencePenalty, conf.Stop = &zero, &zero, nil
+
+	params := defaultParams()
+	assert.Equal(t, 2048, params.MaxTokens)
+
+	group, user, limit := new(db.SamplingParams), new(db.SamplingParams), new(db.SamplingParams)
+	assert.Nil(t, SetSamplingParam(group, ParamTemperature, "0.2", nil))
+	assert.Nil(t, SetSamplingParam(group, ParamStop, "END, ###", nil))
+	assert.Nil(t, SetSamplingParam(user, ParamTemperature, "1.5", nil))
+	assert.Nil(t, SetSamplingParam(user, ParamMaxTokens, "8192", nil))
+	assert.Nil(t, SetSamplingParam(limit, ParamMaxTokens, "1024", nil))
+
+	// user params override group params, and are capped by limit
+	params.merge(group, new(db.SamplingParams))
+	params.merge(user, limit)
+	assert.Equal(t, 1.5, params.Temperature)
+	assert.Equal(t, 1024, params.MaxTokens)
+	assert.Equal(t, []string{"END", "###"}, params.Stop)
+	assert.Equal(t, 0.9, params.TopP)
+	assert.Equal(t, "1.5", params.Value(ParamTemperature))
+
+	// llm which isn't created by NewLLM uses process-wide flags
+	assert.Equal(t, 0.7, (&LLM{}).getParams().Temperature)
+}
+
+func TestSetSamplingParam(t *testing.T) {
+	params := new(db.SamplingParams)
+	limit := &db.SamplingParams{}
+	assert.Nil(t, SetSamplingParam(limit, ParamTemperature, "1", nil))
+
+	assert.ErrorIs(t, SetSamplingParam(params, ParamTemperature, "1.2", limit), ErrParamOverLimit)
+	assert.ErrorIs(t, SetSamplingParam(params, ParamTopP, "1.2", limit), ErrParamRange)
+	assert.ErrorIs(t, SetSamplingParam(params, ParamMaxTokens, "-1", limit), ErrParamRange)
+	assert.ErrorIs(t, SetSamplingParam(params, ParamStop, "a,b,c,d,e", limit), ErrParamRange)
+	assert.ErrorIs(t, SetSamplingParam(params, "seed", "1", limit), ErrUnknownParam)
+
+	assert.Nil(t, SetSamplingParam(params, ParamPresencePenalty, "-0.5", limit))
+	assert.Equal(t, "-0.5", GetSamplingParam(params, ParamPresencePenalty))
+	assert.Nil(t, ResetSamplingParam(params, ParamPresencePenalty))
+	assert.Equal(t, "", GetSamplingParam(params, ParamPresencePenalty))
+}
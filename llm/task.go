@@ -44,8 +44,10 @@ type TaskResult struct {
 
 // ExecuteTask execute task command
 func (d *DeepseekTaskReq) ExecuteTask() {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Minute)
+	// stop button of answer cancels the context
+	ctx, cancel := utils.NewRequestContext(d.Update, 15*time.Minute)
 	defer cancel()
+	defer close(d.MessageChan)
 
 	logger.Info("task content", "content", d.Content)
 	taskParam := make(map[string]interface{})
@@ -58,7 +60,6 @@ func (d *DeepseekTaskReq) ExecuteTask() {
 		})
 	}
 
-	chatId, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(d.Update)
 	prompt := i18n.GetMessage(*conf.Lang, "assign_task_prompt", taskParam)
 	llm := NewLLM(WithBot(d.Bot), WithUpdate(d.Update),
 		WithMessageChan(d.MessageChan))
@@ -67,7 +68,7 @@ func (d *DeepseekTaskReq) ExecuteTask() {
 	c, err := llm.LLMClient.SyncSend(ctx, llm)
 	if err != nil {
 		logger.Error("get message fail", "err", err)
-		utils.SendMsg(chatId, err.Error(), d.Bot, msgId, "")
+		d.sendErrMsg(ctx, err)
 		return
 	}
 
@@ -91,7 +92,7 @@ func (d *DeepseekTaskReq) ExecuteTask() {
 		err = finalLLM.LLMClient.Send(ctx, finalLLM)
 		if err != nil {
 			logger.Error("request summary fail", "err", err)
-			utils.SendMsg(chatId, err.Error(), d.Bot, msgId, "")
+			d.sendErrMsg(ctx, err)
 		}
 		return
 	}
@@ -100,7 +101,7 @@ func (d *DeepseekTaskReq) ExecuteTask() {
 	err = d.loopTask(ctx, plans, c, llm, 0)
 	if err != nil {
 		logger.Error("loopTask fail", "err", err)
-		utils.SendMsg(chatId, err.Error(), d.Bot, msgId, "")
+		d.sendErrMsg(ctx, err)
 		return
 	}
 
@@ -113,7 +114,7 @@ func (d *DeepseekTaskReq) ExecuteTask() {
 	err = llm.LLMClient.Send(ctx, llm)
 	if err != nil {
 		logger.Error("request summary fail", "err", err)
-		utils.SendMsg(chatId, err.Error(), d.Bot, msgId, "")
+		d.sendErrMsg(ctx, err)
 	}
 }
 
@@ -198,3 +199,14 @@ func (d *DeepseekTaskReq) requestTask(ctx context.Context, llm *LLM, plan *Task)
 
 	return nil
 }
+
+// sendErrMsg send error of task to user, show stop note instead if task is stopped by user
+func (d *DeepseekTaskReq) sendErrMsg(ctx context.Context, err error) {
+	if utils.IsRequestStopped(ctx) {
+		d.MessageChan <- &param.MsgInfo{Content: i18n.GetMessage(*conf.Lang, "answer_stopped", nil)}
+		return
+	}
+
+	chatId, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(d.Update)
+	utils.SendMsg(chatId, err.Error(), d.Bot, msgId, "")
+}
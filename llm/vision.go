@@ -0,0 +1,143 @@
+package llm
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/i18n"
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+	"github.com/yincongcyincong/telegram-deepseek-bot/utils"
+)
+
+// visionModelKeywords models of openai, openrouter and vol whose name contains keyword accept image input
+var visionModelKeywords = []string{"gpt-4o", "gpt-4.1", "gpt-4-turbo", "gpt-4-vision", "gpt-5", "o3", "o4-mini",
+	"vision", "-vl", "vl-", "gemini", "claude", "llava", "pixtral", "llama-4", "gemma-3", "seed-1.6", "seed-1-6"}
+
+// textModelKeywords models whose name contains keyword don't accept image input even if they match vision keyword,
+// such as o3-mini and gpt-4o-audio-preview
+var textModelKeywords = []string{"o3-mini", "audio", "search", "realtime", "transcribe", "tts", "embedding"}
+
+// imageRejectedKeywords error of model which doesn't accept image input contains image and one of these keywords
+var imageRejectedKeywords = []string{"support", "invalid", "unknown variant", "not allowed", "vision", "multimodal"}
+
+// supportImage check whether model of current llm accepts image input.
+// gemini and anthropic models all accept images, model of ollama is chosen by user, and it's llava by default.
+func (l *LLM) supportImage() bool {
+	switch c := l.LLMClient.(type) {
+	case *OllamaReq, *GeminiReq, *AnthropicReq:
+		return true
+	case *OpenAIReq:
+		if c.Profile != nil {
+			return c.Profile.Vision
+		}
+		return isVisionModel(l.Model)
+	case *AIRouterReq, *VolReq:
+		return isVisionModel(l.Model)
+	}
+	return false
+}
+
+func isVisionModel(model string) bool {
+	model = strings.ToLower(model)
+	for _, keyword := range textModelKeywords {
+		if strings.Contains(model, keyword) {
+			return false
+		}
+	}
+	for _, keyword := range visionModelKeywords {
+		if strings.Contains(model, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// getImages read photo of question directly if model supports image input, so layout and charts aren't lost.
+// photo of text-only model is recognized as text by OCR.
+func (l *LLM) getImages() {
+	if l.Update.Message == nil || l.Update.Message.Photo == nil {
+		return
+	}
+
+	l.LLMClient.GetModel(l)
+	if !l.supportImage() {
+		return
+	}
+
+	image := utils.GetPhotoContent(l.Update, l.Bot)
+	if image == nil {
+		return
+	}
+	l.Images = append(l.Images, image)
+
+	if l.Content == "" {
+		l.Content = l.Update.Message.Caption
+	}
+	if l.Content == "" {
+		l.Content = i18n.GetMessage(*conf.Lang, "image_default_prompt", nil)
+	}
+}
+
+// getRequestImages images sent with prompt, model which falls back from vision model may not accept them
+func (l *LLM) getRequestImages() [][]byte {
+	if !l.supportImage() {
+		return nil
+	}
+	return l.Images
+}
+
+// callWithOCRFallback request llm, if model rejects image input,
+// text of photo is recognized by OCR and model is requested again without image.
+func (l *LLM) callWithOCRFallback(ctx context.Context, prompt string) error {
+	err := l.callWithFallback(ctx, prompt)
+	if err == nil || len(l.getRequestImages()) == 0 || !l.canFallback(ctx) || !isImageRejectedErr(err) {
+		return err
+	}
+	logger.Warn("model rejects image, recognize image by OCR", "model", l.Model, "err", err)
+
+	imageContents := make([]string, 0, len(l.Images))
+	for _, image := range l.Images {
+		imageContent, ocrErr := utils.GetImageContent(image)
+		if ocrErr != nil {
+			logger.Warn("get image content err", "err", ocrErr)
+			return err
+		}
+		imageContents = append(imageContents, imageContent)
+	}
+
+	l.Images = nil
+	l.LLMClient = newLLMClient(l.Type)
+	l.LoopNum = 0
+	return l.callWithFallback(ctx, prompt+"\n"+strings.Join(imageContents, "\n"))
+}
+
+// isImageRejectedErr check whether error of llm is caused by image input
+func isImageRejectedErr(err error) bool {
+	msg := strings.ToLower(err.Error())
+	if !strings.Contains(msg, "image") {
+		return false
+	}
+	for _, keyword := range imageRejectedKeywords {
+		if strings.Contains(msg, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// imageMimeType photos of telegram are jpeg, other images are detected by content
+func imageMimeType(image []byte) string {
+	mimeType := http.DetectContentType(image)
+	if !strings.HasPrefix(mimeType, "image/") {
+		return "image/jpeg"
+	}
+	return mimeType
+}
+
+// imageDataUrl image in data url, it's accepted as image url by openai compatible api
+func imageDataUrl(image []byte) string {
+	return "data:" + imageMimeType(image) + ";base64," + base64.StdEncoding.EncodeToString(image)
+}
@@ -0,0 +1,60 @@
+package llm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+)
+
+func TestSupportImage(t *testing.T) {
+	l := &LLM{LLMClient: &OpenAIReq{}, Model: "gpt-4o-mini"}
+	assert.True(t, l.supportImage())
+	l.Model = "gpt-3.5-turbo"
+	assert.False(t, l.supportImage())
+	l.Model = "o3-mini"
+	assert.False(t, l.supportImage())
+	l.Model = "gpt-4o-audio-preview"
+	assert.False(t, l.supportImage())
+	l.Model = "gpt-4o-search-preview"
+	assert.False(t, l.supportImage())
+	l.Model = "o3"
+	assert.True(t, l.supportImage())
+
+	l = &LLM{LLMClient: &VolReq{}, Model: "doubao-1.5-vision-pro-250328"}
+	assert.True(t, l.supportImage())
+	l = &LLM{LLMClient: &AIRouterReq{}, Model: "qwen/qwen2.5-vl-72b-instruct"}
+	assert.True(t, l.supportImage())
+
+	// text-only models get text of photo by OCR, images aren't sent to them
+	l = &LLM{LLMClient: &DeepseekReq{}, Model: "deepseek-chat", Images: [][]byte{{0xff, 0xd8}}}
+	assert.False(t, l.supportImage())
+	assert.Nil(t, l.getRequestImages())
+
+	l = &LLM{LLMClient: &OpenAIReq{Profile: &conf.OpenAICompatibleProfile{Vision: true}}, Model: "local-model"}
+	assert.True(t, l.supportImage())
+	assert.True(t, (&LLM{LLMClient: &GeminiReq{}}).supportImage())
+}
+
+func TestIsImageRejectedErr(t *testing.T) {
+	assert.True(t, isImageRejectedErr(errors.New("Invalid content type. image_url is only supported by certain models.")))
+	assert.True(t, isImageRejectedErr(errors.New("unknown variant `image_url`, expected `text`")))
+	assert.False(t, isImageRejectedErr(errors.New("rate limit exceeded")))
+	assert.False(t, isImageRejectedErr(errors.New("invalid api key")))
+}
+
+func TestNewOpenAIUserMessage(t *testing.T) {
+	msg := newOpenAIUserMessage("hi", nil)
+	assert.Equal(t, "hi", msg.Content)
+	assert.Empty(t, msg.MultiContent)
+
+	// jpeg header is detected, photo and its caption are sent in one message
+	msg = newOpenAIUserMessage("what is in the chart", [][]byte{{0xff, 0xd8, 0xff, 0xe0}})
+	assert.Equal(t, "", msg.Content)
+	assert.Len(t, msg.MultiContent, 2)
+	assert.Equal(t, "what is in the chart", msg.MultiContent[0].Text)
+	assert.Equal(t, openai.ChatMessagePartTypeImageURL, msg.MultiContent[1].Type)
+	assert.Equal(t, "data:image/jpeg;base64,/9j/4A==", msg.MultiContent[1].ImageURL.URL)
+}
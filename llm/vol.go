@@ -2,6 +2,7 @@ package llm
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -35,13 +36,17 @@ type VolReq struct {
 func (h *VolReq) CallLLMAPI(ctx context.Context, prompt string, l *LLM) error {
 	_, _, userId := utils.GetChatIdAndMsgIdAndUserID(l.Update)
 
-	h.GetMessages(userId, prompt)
+	h.GetModel(l)
+	h.GetMessages(l, prompt)
 
 	logger.Info("msg receive", "userID", userId, "prompt", l.Content)
 	return h.Send(ctx, l)
 }
 
 func (h *VolReq) GetModel(l *LLM) {
+	if l.useForceModel() {
+		return
+	}
 	_, _, userId := utils.GetChatIdAndMsgIdAndUserID(l.Update)
 
 	l.Model = param.ModelDeepSeekR1_528
@@ -49,58 +54,86 @@ func (h *VolReq) GetModel(l *LLM) {
 	if err != nil {
 		logger.Error("Error getting user info", "err", err)
 	}
-	if userInfo != nil && userInfo.Mode != "" && param.VolModels[userInfo.Mode] {
+	if userInfo != nil && userInfo.Mode != "" && IsModelOf(param.Vol, userInfo.Mode) {
 		logger.Info("User info", "userID", userInfo.UserId, "mode", userInfo.Mode)
 		l.Model = userInfo.Mode
 	}
 }
 
-func (h *VolReq) GetMessages(userId int64, prompt string) {
+func (h *VolReq) GetMessages(l *LLM, prompt string) {
+	key := l.RecordKey
 	messages := make([]*model.ChatCompletionMessage, 0)
 
-	msgRecords := db.GetMsgRecord(userId)
-	if msgRecords != nil {
-		aqs := msgRecords.AQs
-		if len(aqs) > 10 {
-			aqs = aqs[len(aqs)-10:]
-		}
-		for i, record := range aqs {
-			if record.Answer != "" && record.Question != "" {
-				logger.Info("context content", "dialog", i, "question:", record.Question,
-					"toolContent", record.Content, "answer:", record.Answer)
-
-				messages = append(messages, &model.ChatCompletionMessage{
-					Role: constants.ChatMessageRoleUser,
-					Content: &model.ChatCompletionMessageContent{
-						StringValue: &record.Question,
-					},
-				})
-
-				if record.Content != "" {
-					toolsMsgs := make([]*model.ChatCompletionMessage, 0)
-					err := json.Unmarshal([]byte(record.Content), &toolsMsgs)
-					if err != nil {
-						logger.Error("Error unmarshalling tools json", "err", err)
-					} else {
-						messages = append(messages, toolsMsgs...)
-					}
-				}
+	systemPrompt := getSystemPrompt(key)
+	if systemPrompt != "" {
+		messages = append(messages, &model.ChatCompletionMessage{
+			Role: constants.ChatMessageRoleSystem,
+			Content: &model.ChatCompletionMessageContent{
+				StringValue: &systemPrompt,
+			},
+		})
+	}
 
-				messages = append(messages, &model.ChatCompletionMessage{
-					Role: constants.ChatMessageRoleAssistant,
-					Content: &model.ChatCompletionMessageContent{
-						StringValue: &record.Answer,
-					},
-				})
+	summary, aqs := l.getContext(key, l.VolTools, systemPrompt, prompt)
+	if summary != "" {
+		memoryPrompt := getMemoryPrompt(summary)
+		messages = append(messages, &model.ChatCompletionMessage{
+			Role: constants.ChatMessageRoleSystem,
+			Content: &model.ChatCompletionMessageContent{
+				StringValue: &memoryPrompt,
+			},
+		})
+	}
 
-			}
+	for i, record := range aqs {
+		if record.Answer != "" && record.Question != "" {
+			logger.Info("context content", "dialog", i, "question:", record.Question,
+				"toolContent", record.Content, "answer:", record.Answer)
+
+			messages = append(messages, &model.ChatCompletionMessage{
+				Role: constants.ChatMessageRoleUser,
+				Content: &model.ChatCompletionMessageContent{
+					StringValue: &record.Question,
+				},
+			})
+
+			messages = append(messages, toVolMessages(unmarshalToolMessages(record.Content))...)
+
+			messages = append(messages, &model.ChatCompletionMessage{
+				Role: constants.ChatMessageRoleAssistant,
+				Content: &model.ChatCompletionMessageContent{
+					StringValue: &record.Answer,
+				},
+			})
+
+		}
+	}
+
+	// images of question are only sent in this turn
+	content := &model.ChatCompletionMessageContent{
+		StringValue: &prompt,
+	}
+	if images := l.getRequestImages(); len(images) > 0 {
+		content = &model.ChatCompletionMessageContent{
+			ListValue: []*model.ChatCompletionMessageContentPart{
+				{
+					Type: model.ChatCompletionMessageContentPartTypeText,
+					Text: prompt,
+				},
+			},
+		}
+		for _, image := range images {
+			content.ListValue = append(content.ListValue, &model.ChatCompletionMessageContentPart{
+				Type: model.ChatCompletionMessageContentPartTypeImageURL,
+				ImageURL: &model.ChatMessageImageURL{
+					URL: imageDataUrl(image),
+				},
+			})
 		}
 	}
 	messages = append(messages, &model.ChatCompletionMessage{
-		Role: constants.ChatMessageRoleUser,
-		Content: &model.ChatCompletionMessageContent{
-			StringValue: &prompt,
-		},
+		Role:    constants.ChatMessageRoleUser,
+		Content: content,
 	})
 
 	h.VolMsgs = messages
@@ -124,20 +157,21 @@ func (h *VolReq) Send(ctx context.Context, l *LLM) error {
 		arkruntime.WithHTTPClient(httpClient),
 	)
 
+	params := l.getParams()
 	req := model.ChatCompletionRequest{
 		Model:    l.Model,
 		Messages: h.VolMsgs,
 		StreamOptions: &model.StreamOptions{
 			IncludeUsage: true,
 		},
-		MaxTokens:        *conf.MaxTokens,
-		TopP:             float32(*conf.TopP),
-		FrequencyPenalty: float32(*conf.FrequencyPenalty),
+		MaxTokens:        params.MaxTokens,
+		TopP:             float32(params.TopP),
+		FrequencyPenalty: float32(params.FrequencyPenalty),
 		TopLogProbs:      *conf.TopLogProbs,
 		LogProbs:         *conf.LogProbs,
-		Stop:             conf.Stop,
-		PresencePenalty:  float32(*conf.PresencePenalty),
-		Temperature:      float32(*conf.Temperature),
+		Stop:             params.Stop,
+		PresencePenalty:  float32(params.PresencePenalty),
+		Temperature:      float32(params.Temperature),
 		Tools:            l.VolTools,
 	}
 
@@ -162,12 +196,17 @@ func (h *VolReq) Send(ctx context.Context, l *LLM) error {
 		}
 		if err != nil {
 			logger.Error("stream error:", "updateMsgID", updateMsgID, "err", err)
+			// nothing is answered, let next llm of fallback chain take over
+			if l.canFallback(ctx) {
+				return err
+			}
 			break
 		}
 		for _, choice := range response.Choices {
 
 			if len(choice.Delta.ToolCalls) > 0 {
 				hasTools = true
+				l.answered.Store(true)
 				err = h.requestToolsCall(ctx, choice)
 				if err != nil {
 					if errors.Is(err, ToolsJsonErr) {
@@ -178,6 +217,10 @@ func (h *VolReq) Send(ctx context.Context, l *LLM) error {
 				}
 			}
 
+			if choice.Delta.ReasoningContent != nil && len(*choice.Delta.ReasoningContent) > 0 {
+				l.sendReasoningMsg(*choice.Delta.ReasoningContent)
+			}
+
 			if len(choice.Delta.Content) > 0 {
 				msgInfoContent = l.sendMsg(msgInfoContent, choice.Delta.Content)
 			}
@@ -185,20 +228,31 @@ func (h *VolReq) Send(ctx context.Context, l *LLM) error {
 
 		if response.Usage != nil {
 			l.Token += response.Usage.TotalTokens
+			l.ReasoningToken += response.Usage.CompletionTokensDetails.ReasoningTokens
+			l.addUsage(response.Usage.PromptTokens, response.Usage.CompletionTokens, response.Usage.PromptTokensDetails.CachedTokens)
 			metrics.TotalTokens.Add(float64(l.Token))
 		}
 
 	}
 
+	l.flushReasoningMsg()
+	msgInfoContent = l.appendStopNote(ctx, msgInfoContent)
 	if len(strings.TrimRightFunc(msgInfoContent.Content, unicode.IsSpace)) > 0 {
 		l.MessageChan <- msgInfoContent
 	}
 
-	if !hasTools || len(h.CurrentToolMessage) == 0 {
-		db.InsertMsgRecord(userId, &db.AQ{
-			Question: l.Content,
-			Answer:   l.WholeContent,
-			Token:    l.Token,
+	if !hasTools || len(h.CurrentToolMessage) == 0 || utils.IsRequestStopped(ctx) {
+		db.InsertMsgRecord(l.RecordKey, &db.AQ{
+			UserId:         userId,
+			Question:       l.Content,
+			Answer:         l.WholeContent,
+			Content:        marshalToolMessages(fromVolMessages(h.ToolMessage)),
+			Token:          l.Token,
+			QuestionMsgId:  updateMsgID,
+			AnswerMsgId:    l.AnswerMsgId,
+			Reasoning:      l.ReasoningContent,
+			ReasoningToken: l.ReasoningToken,
+			Usage:          l.getUsage(),
 		}, true)
 	} else {
 		h.CurrentToolMessage = append([]*model.ChatCompletionMessage{
@@ -328,20 +382,21 @@ func (h *VolReq) SyncSend(ctx context.Context, l *LLM) (string, error) {
 		arkruntime.WithHTTPClient(httpClient),
 	)
 
+	params := l.getParams()
 	req := model.ChatCompletionRequest{
 		Model:    l.Model,
 		Messages: h.VolMsgs,
 		StreamOptions: &model.StreamOptions{
 			IncludeUsage: true,
 		},
-		MaxTokens:        *conf.MaxTokens,
-		TopP:             float32(*conf.TopP),
-		FrequencyPenalty: float32(*conf.FrequencyPenalty),
+		MaxTokens:        params.MaxTokens,
+		TopP:             float32(params.TopP),
+		FrequencyPenalty: float32(params.FrequencyPenalty),
 		TopLogProbs:      *conf.TopLogProbs,
 		LogProbs:         *conf.LogProbs,
-		Stop:             conf.Stop,
-		PresencePenalty:  float32(*conf.PresencePenalty),
-		Temperature:      float32(*conf.Temperature),
+		Stop:             params.Stop,
+		PresencePenalty:  float32(params.PresencePenalty),
+		Temperature:      float32(params.Temperature),
 		Tools:            l.VolTools,
 	}
 
@@ -357,6 +412,7 @@ func (h *VolReq) SyncSend(ctx context.Context, l *LLM) (string, error) {
 	}
 
 	l.Token += response.Usage.TotalTokens
+	l.addUsage(response.Usage.PromptTokens, response.Usage.CompletionTokens, response.Usage.PromptTokensDetails.CachedTokens)
 	if len(response.Choices[0].Message.ToolCalls) > 0 {
 		h.GetAssistantMessage("")
 		h.VolMsgs[len(h.VolMsgs)-1].ToolCalls = response.Choices[0].Message.ToolCalls
@@ -397,23 +453,29 @@ func (h *VolReq) requestOneToolsCall(ctx context.Context, toolsCall []*model.Too
 	}
 }
 
-// GenerateImg generate image
-func GenerateImg(prompt string) (*param.ImgResponse, error) {
-	start := time.Now()
+// VolImageGenerator volcengine visual api, it generates one image in a request
+type VolImageGenerator struct{}
+
+func (v *VolImageGenerator) Generate(ctx context.Context, req *ImageRequest) ([]*Image, error) {
 	visual.DefaultInstance.Client.SetAccessKey(*conf.VolcAK)
 	visual.DefaultInstance.Client.SetSecretKey(*conf.VolcSK)
 
+	width, height := req.Width, req.Height
+	if width <= 0 || height <= 0 {
+		width, height = *conf.Width, *conf.Height
+	}
+
 	reqBody := map[string]interface{}{
 		"req_key":           *conf.ReqKey,
-		"prompt":            prompt,
+		"prompt":            req.Prompt,
 		"model_version":     *conf.ModelVersion,
 		"req_schedule_conf": *conf.ReqScheduleConf,
 		"llm_seed":          *conf.Seed,
 		"seed":              *conf.Seed,
 		"scale":             *conf.Scale,
 		"ddim_steps":        *conf.DDIMSteps,
-		"width":             *conf.Width,
-		"height":            *conf.Height,
+		"width":             width,
+		"height":            height,
 		"use_pre_llm":       *conf.UsePreLLM,
 		"use_sr":            *conf.UseSr,
 		"return_url":        *conf.ReturnUrl,
@@ -426,26 +488,45 @@ func GenerateImg(prompt string) (*param.ImgResponse, error) {
 		},
 	}
 
-	resp, _, err := visual.DefaultInstance.CVProcess(reqBody)
-	if err != nil {
-		logger.Error("request img api fail", "err", err)
-		return nil, err
-	}
+	images := make([]*Image, 0, req.Num)
+	for i := 0; i < req.Num; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 
-	respByte, _ := json.Marshal(resp)
-	data := &param.ImgResponse{}
-	err = json.Unmarshal(respByte, data)
-	if err != nil {
-		logger.Error("unmarshal response fail", "err", err)
-		return nil, err
-	}
+		resp, _, err := visual.DefaultInstance.CVProcess(reqBody)
+		if err != nil {
+			logger.Error("request img api fail", "err", err)
+			return nil, err
+		}
 
-	logger.Info("image response", "respByte", respByte)
+		respByte, _ := json.Marshal(resp)
+		data := &param.ImgResponse{}
+		err = json.Unmarshal(respByte, data)
+		if err != nil {
+			logger.Error("unmarshal response fail", "err", err)
+			return nil, err
+		}
 
-	// generate image time costing
-	totalDuration := time.Since(start).Seconds()
-	metrics.ImageDuration.Observe(totalDuration)
-	return data, nil
+		logger.Info("image response", "code", data.Code, "message", data.Message)
+		if data.Data == nil {
+			continue
+		}
+		for _, imageUrl := range data.Data.ImageUrls {
+			images = append(images, &Image{Url: imageUrl})
+		}
+		if len(data.Data.ImageUrls) == 0 {
+			for _, b64 := range data.Data.BinaryDataBase64 {
+				imageData, err := base64.StdEncoding.DecodeString(b64)
+				if err != nil {
+					return nil, err
+				}
+				images = append(images, &Image{Data: imageData})
+			}
+		}
+	}
+
+	return images, nil
 }
 
 func GenerateVideo(prompt string) (string, error) {
@@ -512,3 +593,55 @@ func GenerateVideo(prompt string) (string, error) {
 		}
 	}
 }
+
+// fromVolMessages transfer vol tool messages to messages of records
+func fromVolMessages(msgs []*model.ChatCompletionMessage) []*param.ToolMessage {
+	res := make([]*param.ToolMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		toolMsg := &param.ToolMessage{
+			Role:       msg.Role,
+			ToolCallID: msg.ToolCallID,
+		}
+		if msg.Content != nil && msg.Content.StringValue != nil {
+			toolMsg.Content = *msg.Content.StringValue
+		}
+		for _, toolCall := range msg.ToolCalls {
+			toolMsg.ToolCalls = append(toolMsg.ToolCalls, &param.ToolCall{
+				ID:   toolCall.ID,
+				Type: string(toolCall.Type),
+				Function: param.ToolCallFunction{
+					Name:      toolCall.Function.Name,
+					Arguments: toolCall.Function.Arguments,
+				},
+			})
+		}
+		res = append(res, toolMsg)
+	}
+	return res
+}
+
+// toVolMessages transfer tool messages of records to vol messages
+func toVolMessages(msgs []*param.ToolMessage) []*model.ChatCompletionMessage {
+	res := make([]*model.ChatCompletionMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		volMsg := &model.ChatCompletionMessage{
+			Role: msg.Role,
+			Content: &model.ChatCompletionMessageContent{
+				StringValue: &msg.Content,
+			},
+			ToolCallID: msg.ToolCallID,
+		}
+		for _, toolCall := range msg.ToolCalls {
+			volMsg.ToolCalls = append(volMsg.ToolCalls, &model.ToolCall{
+				ID:   toolCall.ID,
+				Type: model.ToolType(toolCall.Type),
+				Function: model.FunctionCall{
+					Name:      toolCall.Function.Name,
+					Arguments: toolCall.Function.Arguments,
+				},
+			})
+		}
+		res = append(res, volMsg)
+	}
+	return res
+}
@@ -6,18 +6,22 @@ import (
 	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
 	"github.com/yincongcyincong/telegram-deepseek-bot/db"
 	"github.com/yincongcyincong/telegram-deepseek-bot/i18n"
+	"github.com/yincongcyincong/telegram-deepseek-bot/llm"
 	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
 	"github.com/yincongcyincong/telegram-deepseek-bot/metrics"
 	"github.com/yincongcyincong/telegram-deepseek-bot/rag"
 	"github.com/yincongcyincong/telegram-deepseek-bot/robot"
+	"github.com/yincongcyincong/telegram-deepseek-bot/utils"
 )
 
 func main() {
 	logger.InitLogger()
 	conf.InitConf()
+	utils.InitTokenEncoder()
 	i18n.InitI18n()
 	db.InitTable()
 	db.UpdateUserTime()
+	llm.InitModelCatalog()
 	conf.InitTools()
 	rag.InitRag()
 	metrics.InitPprof()
@@ -42,6 +42,22 @@ var (
 			Buckets: prometheus.DefBuckets,
 		},
 	)
+
+	LLMFallbackCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_llm_fallback_total",
+			Help: "Total number of requests falling back to next llm.",
+		},
+		[]string{"from", "to"},
+	)
+
+	ResponseCacheCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_response_cache_total",
+			Help: "Total number of requests looking up response cache.",
+		},
+		[]string{"result"},
+	)
 )
 
 // RegisterMetrics register metrics
@@ -51,4 +67,6 @@ func RegisterMetrics() {
 	prometheus.MustRegister(TotalTokens)
 	prometheus.MustRegister(ConversationDuration)
 	prometheus.MustRegister(ImageDuration)
+	prometheus.MustRegister(LLMFallbackCount)
+	prometheus.MustRegister(ResponseCacheCount)
 }
@@ -0,0 +1,38 @@
+package param
+
+const (
+	Anthropic = "anthropic"
+
+	ModelClaudeSonnet45 = "claude-sonnet-4-5-20250929"
+	ModelClaudeHaiku45  = "claude-haiku-4-5-20251001"
+	ModelClaudeOpus41   = "claude-opus-4-1-20250805"
+	ModelClaudeOpus4    = "claude-opus-4-20250514"
+	ModelClaudeSonnet4  = "claude-sonnet-4-20250514"
+	ModelClaudeSonnet37 = "claude-3-7-sonnet-20250219"
+	ModelClaudeHaiku35  = "claude-3-5-haiku-20241022"
+)
+
+var (
+	AnthropicModels = map[string]bool{
+		ModelClaudeSonnet45: true,
+		ModelClaudeHaiku45:  true,
+		ModelClaudeOpus41:   true,
+		ModelClaudeOpus4:    true,
+		ModelClaudeSonnet4:  true,
+		ModelClaudeSonnet37: true,
+		ModelClaudeHaiku35:  true,
+	}
+)
+
+// AnthropicTool tool definition of anthropic messages api
+type AnthropicTool struct {
+	Name        string               `json:"name"`
+	Description string               `json:"description,omitempty"`
+	InputSchema AnthropicInputSchema `json:"input_schema"`
+}
+
+type AnthropicInputSchema struct {
+	Type       string                 `json:"type"`
+	Properties map[string]interface{} `json:"properties"`
+	Required   []string               `json:"required,omitempty"`
+}
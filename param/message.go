@@ -0,0 +1,23 @@
+package param
+
+// ToolMessage message of tool calling saved in records.content, every llm converts its own messages from and to it,
+// so tool context isn't lost after llm type or model is changed.
+// it has the shape of openai tool message, so records saved by openai like llm are readable too.
+type ToolMessage struct {
+	Role       string      `json:"role"` // assistant which calls tools, or tool which answers them
+	Content    string      `json:"content"`
+	ToolCalls  []*ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string      `json:"tool_call_id,omitempty"`
+	Name       string      `json:"name,omitempty"` // name of tool, gemini needs it in function response
+}
+
+type ToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` // arguments in json format
+}
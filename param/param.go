@@ -7,7 +7,8 @@ import (
 
 const (
 	DeepSeek      = "deepseek"
-	DeepSeekLlava = "deepseek-ollama"
+	DeepSeekLlava = "deepseek-ollama" // old name of Ollama
+	Ollama        = "ollama"
 
 	Vol = "vol"
 
@@ -36,6 +37,8 @@ const (
 
 	ImageTokenUsage = 10000
 	VideoTokenUsage = 20000
+	// TTSTokenUsage token of every voice message of answer
+	TTSTokenUsage = 2000
 )
 
 const (
@@ -161,6 +164,9 @@ type MsgInfo struct {
 	MsgId   int
 	Content string
 	SendLen int
+
+	IsReasoning bool // reasoning of llm, it's shown apart from answer
+	IsNotice    bool // notice about answer, such as fallback model, it isn't part of answer
 }
 
 type ImgResponse struct {
@@ -176,7 +182,27 @@ type ImgResponseData struct {
 		StatusMessage string `json:"status_message"`
 	} `json:"algorithm_base_resp"`
 	ImageUrls        []string `json:"image_urls"`
+	BinaryDataBase64 []string `json:"binary_data_base64"`
 	PeResult         string   `json:"pe_result"`
 	PredictTagResult string   `json:"predict_tag_result"`
 	RephraserResult  string   `json:"rephraser_result"`
 }
+
+// ExportInfo conversation exported by /export command
+type ExportInfo struct {
+	ExportTime int64            `json:"export_time"`
+	Messages   []*ExportMessage `json:"messages"`
+}
+
+// ExportMessage one question and answer of exported conversation
+type ExportMessage struct {
+	UserId     int64  `json:"user_id"`
+	ChatId     int64  `json:"chat_id"`
+	SessionId  int64  `json:"session_id"`
+	Question   string `json:"question"`
+	Answer     string `json:"answer"`
+	Content    string `json:"content,omitempty"` // tool call messages in json
+	Token      int    `json:"token"`
+	CreateTime int64  `json:"create_time"`
+	IsDeleted  int    `json:"is_deleted,omitempty"`
+}
@@ -0,0 +1,46 @@
+package robot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/db"
+	"github.com/yincongcyincong/telegram-deepseek-bot/i18n"
+	"github.com/yincongcyincong/telegram-deepseek-bot/llm"
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+	"github.com/yincongcyincong/telegram-deepseek-bot/utils"
+)
+
+// sendResponseCache show response cache, or invalidate it: /cache clear [prompt]
+func sendResponseCache(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatId, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(update)
+	if *conf.ResponseCacheTTL <= 0 {
+		i18n.SendMsg(chatId, "cache_disabled", bot, nil, msgId)
+		return
+	}
+
+	content := utils.ReplaceCommand(update.Message.Text, "/cache", bot.Self.UserName)
+	subCmd, prompt, _ := strings.Cut(strings.TrimSpace(content), " ")
+	if subCmd == "clear" {
+		num, err := llm.ClearResponseCache(prompt)
+		if err != nil {
+			logger.Warn("clear response cache fail", "err", err)
+			i18n.SendMsg(chatId, "cache_fail", bot, nil, msgId)
+			return
+		}
+		utils.SendMsg(chatId, fmt.Sprintf(i18n.GetMessage(*conf.Lang, "cache_clear_succ", nil), num), bot, msgId, "")
+		return
+	}
+
+	after := time.Now().Add(-time.Duration(*conf.ResponseCacheTTL) * time.Minute).Unix()
+	num, hitNum, err := db.CountResponseCache(after)
+	if err != nil {
+		logger.Warn("count response cache fail", "err", err)
+		i18n.SendMsg(chatId, "cache_fail", bot, nil, msgId)
+		return
+	}
+	utils.SendMsg(chatId, fmt.Sprintf(i18n.GetMessage(*conf.Lang, "cache_state", nil), num, hitNum), bot, msgId, "")
+}
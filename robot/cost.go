@@ -0,0 +1,97 @@
+package robot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/db"
+	"github.com/yincongcyincong/telegram-deepseek-bot/i18n"
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+	"github.com/yincongcyincong/telegram-deepseek-bot/utils"
+)
+
+const (
+	defaultCostDays = 30
+	maxCostItems    = 20
+)
+
+// sendCost send tokens and cost of every user and every model to admin: /cost [days]
+func sendCost(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatId, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(update)
+
+	content := utils.ReplaceCommand(update.Message.Text, "/cost", bot.Self.UserName)
+	days, err := parseCostDays(content)
+	if err != nil {
+		logger.Warn("parse cost days fail", "content", content, "err", err)
+		i18n.SendMsg(chatId, "cost_param_fail", bot, nil, msgId)
+		return
+	}
+
+	now := time.Now()
+	start := now.AddDate(0, 0, -days).Unix()
+	userStats, err := db.GetCostGroupByUser(start, now.Unix(), maxCostItems)
+	if err != nil {
+		logger.Warn("get cost by user fail", "err", err)
+		i18n.SendMsg(chatId, "cost_fail", bot, nil, msgId)
+		return
+	}
+	modelStats, err := db.GetCostGroupByModel(start, now.Unix(), maxCostItems)
+	if err != nil {
+		logger.Warn("get cost by model fail", "err", err)
+		i18n.SendMsg(chatId, "cost_fail", bot, nil, msgId)
+		return
+	}
+
+	if len(userStats) == 0 {
+		utils.SendMsg(chatId, fmt.Sprintf(i18n.GetMessage(*conf.Lang, "cost_empty", nil), days), bot, msgId, "")
+		return
+	}
+
+	// model names contain markdown characters, send it as plain text
+	utils.SendMsg(chatId, formatCost(days, userStats, modelStats), bot, msgId, "")
+}
+
+// parseCostDays parse days of cost command, it's 30 days by default
+func parseCostDays(args string) (int, error) {
+	args = strings.TrimSpace(args)
+	if args == "" {
+		return defaultCostDays, nil
+	}
+
+	days, err := strconv.Atoi(args)
+	if err != nil {
+		return 0, err
+	}
+	if days <= 0 {
+		return 0, fmt.Errorf("invalid days: %d", days)
+	}
+	return days, nil
+}
+
+func formatCost(days int, userStats, modelStats []*db.CostStat) string {
+	msgContent := fmt.Sprintf(i18n.GetMessage(*conf.Lang, "cost_title", nil), days)
+	userTemplate := i18n.GetMessage(*conf.Lang, "cost_user_item", nil)
+	for _, stat := range userStats {
+		msgContent += fmt.Sprintf(userTemplate, stat.UserId, stat.Token, stat.Cost)
+	}
+
+	msgContent += i18n.GetMessage(*conf.Lang, "cost_model_title", nil)
+	modelTemplate := i18n.GetMessage(*conf.Lang, "cost_model_item", nil)
+	for _, stat := range modelStats {
+		// records inserted before usage is stored don't have llm type and model
+		llmType, model := stat.LLMType, stat.Model
+		if llmType == "" {
+			llmType = "-"
+		}
+		if model == "" {
+			model = "-"
+		}
+		msgContent += fmt.Sprintf(modelTemplate, llmType, model, stat.Token, stat.Cost)
+	}
+
+	return msgContent
+}
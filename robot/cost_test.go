@@ -0,0 +1,23 @@
+package robot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCostDays(t *testing.T) {
+	days, err := parseCostDays("")
+	assert.Nil(t, err)
+	assert.Equal(t, defaultCostDays, days)
+
+	days, err = parseCostDays(" 7 ")
+	assert.Nil(t, err)
+	assert.Equal(t, 7, days)
+
+	_, err = parseCostDays("0")
+	assert.NotNil(t, err, "days should be positive")
+
+	_, err = parseCostDays("week")
+	assert.NotNil(t, err, "unknown param should fail")
+}
@@ -0,0 +1,210 @@
+package robot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/db"
+	"github.com/yincongcyincong/telegram-deepseek-bot/i18n"
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+	"github.com/yincongcyincong/telegram-deepseek-bot/param"
+	"github.com/yincongcyincong/telegram-deepseek-bot/utils"
+)
+
+const (
+	exportFormatMarkdown = "md"
+	exportFormatJSON     = "json"
+
+	exportDateLayout = "2006-01-02"
+	exportTimeLayout = "2006-01-02 15:04:05"
+)
+
+// exportOption option of export command: /export [user_id] [from] [to] [md|json] [tools]
+type exportOption struct {
+	format      string
+	withContent bool
+	userId      int64 // export records of user for audit, only admin can set it
+	start       int64
+	end         int64
+}
+
+// sendExport send records of current thread, or records of user for admin, as document
+func sendExport(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatId, msgId, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+
+	content := utils.ReplaceCommand(update.Message.Text, "/export", bot.Self.UserName)
+	option, err := parseExportOption(content, time.Now())
+	if err != nil {
+		logger.Warn("parse export option fail", "content", content, "err", err)
+		// usage contains brackets, send it as plain text
+		utils.SendMsg(chatId, i18n.GetMessage(*conf.Lang, "export_param_fail", nil), bot, msgId, "")
+		return
+	}
+
+	var records []db.Record
+	fileName := fmt.Sprintf("export_%d_%s", chatId, time.Now().Format("20060102150405"))
+	if option.userId != 0 {
+		if !checkAdminUser(update) {
+			i18n.SendMsg(chatId, "export_admin_only", bot, nil, msgId)
+			return
+		}
+		// records of user come from every chat, they mustn't leak to a group
+		if !update.Message.Chat.IsPrivate() {
+			i18n.SendMsg(chatId, "export_private_only", bot, nil, msgId)
+			return
+		}
+		records, err = db.GetRecordsByUserIdAndTime(option.userId, option.start, option.end)
+		fileName = fmt.Sprintf("export_user_%d_%s", option.userId, time.Now().Format("20060102150405"))
+	} else {
+		records, err = db.GetThreadRecords(db.NewRecordKey(chatId, userId))
+		records = filterRecordsByTime(records, option.start, option.end)
+	}
+	if err != nil {
+		logger.Warn("get export records fail", "err", err)
+		i18n.SendMsg(chatId, "export_fail", bot, nil, msgId)
+		return
+	}
+
+	if len(records) == 0 {
+		i18n.SendMsg(chatId, "export_empty", bot, nil, msgId)
+		return
+	}
+
+	var data []byte
+	if option.format == exportFormatJSON {
+		data, err = formatExportJSON(records, option)
+	} else {
+		data = formatExportMarkdown(records, option)
+	}
+	if err != nil {
+		logger.Warn("format export fail", "err", err)
+		i18n.SendMsg(chatId, "export_fail", bot, nil, msgId)
+		return
+	}
+
+	doc := tgbotapi.NewDocument(chatId, tgbotapi.FileBytes{
+		Name:  fileName + "." + option.format,
+		Bytes: data,
+	})
+	doc.ReplyToMessageID = msgId
+	if _, err = bot.Send(doc); err != nil {
+		logger.Warn("send export document fail", "err", err)
+	}
+}
+
+// parseExportOption parse args of export command, dates are in local time and the end date is inclusive.
+func parseExportOption(args string, now time.Time) (*exportOption, error) {
+	option := &exportOption{
+		format: exportFormatMarkdown,
+		end:    now.Unix(),
+	}
+
+	dateNum := 0
+	for _, arg := range strings.Fields(args) {
+		switch strings.ToLower(arg) {
+		case "md", "markdown":
+			option.format = exportFormatMarkdown
+			continue
+		case "json":
+			option.format = exportFormatJSON
+			continue
+		case "tools":
+			option.withContent = true
+			continue
+		}
+
+		if date, err := time.ParseInLocation(exportDateLayout, arg, time.Local); err == nil {
+			switch dateNum {
+			case 0:
+				option.start = date.Unix()
+			case 1:
+				option.end = date.AddDate(0, 0, 1).Unix() - 1
+			default:
+				return nil, errors.New("too many dates")
+			}
+			dateNum++
+			continue
+		}
+
+		userId, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || option.userId != 0 || dateNum > 0 {
+			return nil, fmt.Errorf("unknown export param: %s", arg)
+		}
+		option.userId = userId
+	}
+
+	if option.start > option.end {
+		return nil, errors.New("start date is after end date")
+	}
+
+	return option, nil
+}
+
+func filterRecordsByTime(records []db.Record, start, end int64) []db.Record {
+	res := make([]db.Record, 0, len(records))
+	for _, record := range records {
+		if record.CreateTime >= start && record.CreateTime <= end {
+			res = append(res, record)
+		}
+	}
+	return res
+}
+
+// formatExportJSON format records as json, whose messages can be imported again
+func formatExportJSON(records []db.Record, option *exportOption) ([]byte, error) {
+	info := &param.ExportInfo{
+		ExportTime: time.Now().Unix(),
+		Messages:   make([]*param.ExportMessage, 0, len(records)),
+	}
+	for _, record := range records {
+		msg := &param.ExportMessage{
+			UserId:     record.UserId,
+			ChatId:     record.ChatId,
+			SessionId:  record.SessionId,
+			Question:   record.Question,
+			Answer:     record.Answer,
+			Token:      record.Token,
+			CreateTime: record.CreateTime,
+			IsDeleted:  record.IsDeleted,
+		}
+		if option.withContent {
+			msg.Content = record.Content
+		}
+		info.Messages = append(info.Messages, msg)
+	}
+
+	return json.MarshalIndent(info, "", "  ")
+}
+
+// formatExportMarkdown format records as markdown, audit export shows chat and session of every record
+func formatExportMarkdown(records []db.Record, option *exportOption) []byte {
+	sb := new(strings.Builder)
+	sb.WriteString("# Conversation export\n\n")
+	sb.WriteString(fmt.Sprintf("exported at %s\n\n", time.Now().Format(exportTimeLayout)))
+
+	for _, record := range records {
+		sb.WriteString("---\n\n")
+		sb.WriteString(fmt.Sprintf("**👤 User** · %s", time.Unix(record.CreateTime, 0).Format(exportTimeLayout)))
+		if option.userId != 0 {
+			sb.WriteString(fmt.Sprintf(" · chat %d · session %d", record.ChatId, record.SessionId))
+			if record.IsDeleted == 1 {
+				sb.WriteString(" · deleted")
+			}
+		}
+		sb.WriteString("\n\n" + record.Question + "\n\n")
+
+		if option.withContent && record.Content != "" {
+			sb.WriteString("**🛠 Tools**\n\n```json\n" + record.Content + "\n```\n\n")
+		}
+
+		sb.WriteString("**🤖 Assistant**\n\n" + record.Answer + "\n\n")
+	}
+
+	return []byte(sb.String())
+}
@@ -0,0 +1,62 @@
+package robot
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yincongcyincong/telegram-deepseek-bot/db"
+	"github.com/yincongcyincong/telegram-deepseek-bot/param"
+)
+
+func TestParseExportOption(t *testing.T) {
+	now := time.Date(2025, 6, 30, 12, 0, 0, 0, time.Local)
+
+	option, err := parseExportOption("", now)
+	assert.Nil(t, err)
+	assert.Equal(t, exportFormatMarkdown, option.format)
+	assert.False(t, option.withContent)
+	assert.Equal(t, int64(0), option.userId)
+	assert.Equal(t, now.Unix(), option.end)
+
+	option, err = parseExportOption("json tools", now)
+	assert.Nil(t, err)
+	assert.Equal(t, exportFormatJSON, option.format)
+	assert.True(t, option.withContent)
+
+	option, err = parseExportOption("123 2025-06-01 2025-06-02 json", now)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(123), option.userId)
+	assert.Equal(t, time.Date(2025, 6, 1, 0, 0, 0, 0, time.Local).Unix(), option.start)
+	assert.Equal(t, time.Date(2025, 6, 3, 0, 0, 0, 0, time.Local).Unix()-1, option.end)
+
+	_, err = parseExportOption("2025-06-02 2025-06-01", now)
+	assert.NotNil(t, err, "start date after end date should fail")
+
+	_, err = parseExportOption("csv", now)
+	assert.NotNil(t, err, "unknown param should fail")
+}
+
+func TestFormatExport(t *testing.T) {
+	records := []db.Record{
+		{UserId: 1, ChatId: 1, Question: "what time is it", Answer: "12:00", Content: `[{"role":"tool"}]`, CreateTime: 100},
+	}
+
+	md := string(formatExportMarkdown(records, &exportOption{}))
+	assert.True(t, strings.Contains(md, "what time is it"))
+	assert.True(t, strings.Contains(md, "12:00"))
+	assert.False(t, strings.Contains(md, "tool"), "tools should be excluded by default")
+
+	md = string(formatExportMarkdown(records, &exportOption{withContent: true}))
+	assert.True(t, strings.Contains(md, `[{"role":"tool"}]`))
+
+	data, err := formatExportJSON(records, &exportOption{format: exportFormatJSON})
+	assert.Nil(t, err)
+	info := new(param.ExportInfo)
+	assert.Nil(t, json.Unmarshal(data, info))
+	assert.Equal(t, 1, len(info.Messages))
+	assert.Equal(t, "what time is it", info.Messages[0].Question)
+	assert.Equal(t, "", info.Messages[0].Content)
+}
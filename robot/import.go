@@ -0,0 +1,185 @@
+package robot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/db"
+	"github.com/yincongcyincong/telegram-deepseek-bot/i18n"
+	"github.com/yincongcyincong/telegram-deepseek-bot/llm"
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+	"github.com/yincongcyincong/telegram-deepseek-bot/utils"
+)
+
+const (
+	// MaxImportFileSize max size of imported json document
+	MaxImportFileSize = 5 * 1024 * 1024
+)
+
+// importMessage message of imported document, it's either openai chat format (role and content)
+// or export format (question and answer).
+type importMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+// sendImport ask user to reply with json document
+func sendImport(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatId, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(update)
+	err := utils.ForceReply(chatId, msgId, "import_empty_content", bot)
+	if err != nil {
+		logger.Warn("force reply fail", "err", err)
+	}
+}
+
+// importRecords load messages of json document into current thread
+func importRecords(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatId, msgId, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+
+	document := update.Message.Document
+	if document == nil || document.FileSize > MaxImportFileSize {
+		i18n.SendMsg(chatId, "import_invalid_file", bot, nil, msgId)
+		return
+	}
+
+	data := utils.GetDocumentContent(update, bot)
+	if data == nil {
+		i18n.SendMsg(chatId, "import_fail", bot, nil, msgId)
+		return
+	}
+
+	aqs, err := parseImportMessages(data)
+	if err != nil {
+		logger.Warn("parse import document fail", "userID", userId, "err", err)
+		i18n.SendMsg(chatId, "import_invalid_file", bot, nil, msgId)
+		return
+	}
+
+	total := len(aqs)
+	l := llm.NewLLM(llm.WithUpdate(update), llm.WithBot(bot))
+	aqs = l.TrimAQsByContext(aqs)
+
+	// insert in order, every AQ has its own second, so the records are loaded in the same order after restart
+	createTime := getImportCreateTime(l.RecordKey, len(aqs), time.Now().Unix())
+	for i, aq := range aqs {
+		aq.UserId = userId
+		aq.CreateTime = createTime + int64(i)
+		db.InsertMsgRecord(l.RecordKey, aq, true)
+	}
+
+	content := fmt.Sprintf(i18n.GetMessage(*conf.Lang, "import_succ", nil), len(aqs), total)
+	utils.SendMsg(chatId, content, bot, msgId, "")
+}
+
+// getImportCreateTime create time of the first imported AQ. imported AQs end at now,
+// and they are always after AQs which are in the thread already.
+func getImportCreateTime(key db.RecordKey, num int, now int64) int64 {
+	createTime := now - int64(num) + 1
+	if msgRecord := db.GetMsgRecord(key); msgRecord != nil && len(msgRecord.AQs) > 0 {
+		createTime = max(createTime, msgRecord.AQs[len(msgRecord.AQs)-1].CreateTime+1)
+	}
+	return createTime
+}
+
+// parseImportMessages parse messages array of json document to AQs. system and tool messages are skipped,
+// consecutive user messages are merged into one question.
+func parseImportMessages(data []byte) ([]*db.AQ, error) {
+	var messages []*importMessage
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, err
+		}
+	} else {
+		doc := new(struct {
+			Messages []*importMessage `json:"messages"`
+		})
+		if err := json.Unmarshal(data, doc); err != nil {
+			return nil, err
+		}
+		messages = doc.Messages
+	}
+
+	if len(messages) == 0 {
+		return nil, errors.New("messages is empty")
+	}
+
+	aqs := make([]*db.AQ, 0)
+	question := ""
+	for _, msg := range messages {
+		if msg == nil {
+			continue
+		}
+
+		// export format
+		if msg.Role == "" {
+			if msg.Question != "" && msg.Answer != "" {
+				aqs = append(aqs, &db.AQ{Question: msg.Question, Answer: msg.Answer})
+			}
+			continue
+		}
+
+		content, err := parseImportContent(msg.Content)
+		if err != nil {
+			return nil, err
+		}
+		if content == "" {
+			continue
+		}
+
+		switch msg.Role {
+		case "user":
+			if question != "" {
+				question += "\n\n"
+			}
+			question += content
+		case "assistant":
+			if question == "" {
+				continue
+			}
+			aqs = append(aqs, &db.AQ{Question: question, Answer: content})
+			question = ""
+		}
+	}
+
+	if len(aqs) == 0 {
+		return nil, errors.New("no question and answer in messages")
+	}
+
+	return aqs, nil
+}
+
+// parseImportContent get text of openai message content, which is a string or an array of content parts
+func parseImportContent(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", fmt.Errorf("invalid message content: %v", err)
+	}
+
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type == "text" && part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
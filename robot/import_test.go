@@ -0,0 +1,64 @@
+package robot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yincongcyincong/telegram-deepseek-bot/db"
+)
+
+func TestParseImportMessages_OpenAI(t *testing.T) {
+	data := []byte(`{"messages": [
+		{"role": "system", "content": "You are helpful."},
+		{"role": "user", "content": "hi"},
+		{"role": "user", "content": [{"type": "text", "text": "what is go?"}, {"type": "image_url", "image_url": {"url": "x"}}]},
+		{"role": "assistant", "content": null, "tool_calls": [{"id": "1"}]},
+		{"role": "tool", "content": "result"},
+		{"role": "assistant", "content": "a programming language"},
+		{"role": "assistant", "content": "orphan answer"},
+		{"role": "user", "content": "unanswered"}
+	]}`)
+
+	aqs, err := parseImportMessages(data)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(aqs))
+	assert.Equal(t, "hi\n\nwhat is go?", aqs[0].Question)
+	assert.Equal(t, "a programming language", aqs[0].Answer)
+}
+
+func TestParseImportMessages_Export(t *testing.T) {
+	data := []byte(`{"export_time": 1, "messages": [
+		{"user_id": 1, "question": "q1", "answer": "a1", "content": "[]"},
+		{"user_id": 1, "question": "q2", "answer": "a2"}
+	]}`)
+
+	aqs, err := parseImportMessages(data)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(aqs))
+	assert.Equal(t, "q2", aqs[1].Question)
+
+	// bare messages array is accepted too
+	aqs, err = parseImportMessages([]byte(`[{"role": "user", "content": "q"}, {"role": "assistant", "content": "a"}]`))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(aqs))
+}
+
+func TestParseImportMessages_Invalid(t *testing.T) {
+	for _, data := range []string{`not json`, `{}`, `{"messages": []}`, `{"messages": [{"role": "user", "content": "q"}]}`,
+		`{"messages": [{"role": "user", "content": 1}]}`} {
+		_, err := parseImportMessages([]byte(data))
+		assert.NotNil(t, err, data)
+	}
+}
+
+func TestGetImportCreateTime(t *testing.T) {
+	key := db.RecordKey{ChatId: -1001, UserId: 1001}
+	t.Cleanup(func() {
+		db.MsgRecord.Delete(key)
+	})
+
+	assert.Equal(t, int64(998), getImportCreateTime(key, 3, 1000), "imported AQs end at now")
+
+	db.InsertMsgRecord(key, &db.AQ{Question: "q", Answer: "a", CreateTime: 999}, false)
+	assert.Equal(t, int64(1000), getImportCreateTime(key, 3, 1000), "imported AQs are after history")
+}
@@ -0,0 +1,388 @@
+package robot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/db"
+	"github.com/yincongcyincong/telegram-deepseek-bot/i18n"
+	"github.com/yincongcyincong/telegram-deepseek-bot/llm"
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+	"github.com/yincongcyincong/telegram-deepseek-bot/utils"
+)
+
+const (
+	paramsReset = "reset"
+	paramsLimit = "limit"
+)
+
+var (
+	// paramPresets values shown in keyboard of param, stop sequences can only be set by command
+	paramPresets = map[string][]string{
+		llm.ParamTemperature:      {"0", "0.3", "0.7", "1", "1.5"},
+		llm.ParamTopP:             {"0.5", "0.8", "0.9", "1"},
+		llm.ParamMaxTokens:        {"512", "1024", "2048", "4096", "8192"},
+		llm.ParamFrequencyPenalty: {"-1", "0", "0.5", "1"},
+		llm.ParamPresencePenalty:  {"-1", "0", "0.5", "1"},
+	}
+
+	paramRanges = map[string]string{
+		llm.ParamTemperature:      "0 ~ 2",
+		llm.ParamTopP:             "0 ~ 1",
+		llm.ParamMaxTokens:        "> 0",
+		llm.ParamFrequencyPenalty: "-2 ~ 2",
+		llm.ParamPresencePenalty:  "-2 ~ 2",
+		llm.ParamStop:             "1 ~ 4, a,b,c",
+	}
+)
+
+// paramsScope params which are edited by user: group params if group admin edits them in group, or own params
+type paramsScope struct {
+	scope   string
+	scopeId int64
+	limit   *db.SamplingParams // nil means not limited
+}
+
+// sendParams show or edit sampling params:
+// /params, /params <name> <value|reset>, /params reset, /params limit <user|group> <name> <value|reset>
+func sendParams(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	content := utils.ReplaceCommand(update.Message.Text, "/params", bot.Self.UserName)
+	name, value := utils.SplitFirstWord(content)
+
+	switch {
+	case name == "":
+		showParams(update, bot, false)
+	case name == paramsReset:
+		resetParams(update, bot, false)
+	case name == paramsLimit && checkAdminUser(update):
+		sendParamsLimit(update, bot, value)
+	default:
+		setParam(update, bot, name, value, false)
+	}
+}
+
+// handleParamsCallback handle keyboard of params: params:<name>, params:<name>:<value>, params:reset
+func handleParamsCallback(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	callback := tgbotapi.NewCallback(update.CallbackQuery.ID, "")
+	if _, err := bot.Request(callback); err != nil {
+		logger.Warn("request callback fail", "err", err)
+	}
+
+	name, value, hasValue := parseParamsCallback(update.CallbackQuery.Data)
+	switch {
+	case name == paramsReset:
+		resetParams(update, bot, true)
+	case !hasValue:
+		showParamPresets(update, bot, name)
+	default:
+		setParam(update, bot, name, value, true)
+	}
+}
+
+// parseParamsCallback parse name and value of callback data, value is empty if only param is chosen
+func parseParamsCallback(data string) (string, string, bool) {
+	return strings.Cut(strings.TrimPrefix(data, paramsCallbackPrefix), ":")
+}
+
+// getParamsScope group admin edits params of group in group, others edit their own params.
+// params of bot admin aren't limited.
+func getParamsScope(update tgbotapi.Update, bot *tgbotapi.BotAPI) (*paramsScope, error) {
+	chatId, _, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+	if chatId < 0 && isGroupAdmin(bot, chatId, userId) {
+		limit, err := db.GetSamplingParams(db.ParamsScopeGroupLimit, 0)
+		if err != nil {
+			return nil, err
+		}
+		return &paramsScope{scope: db.ParamsScopeGroup, scopeId: chatId, limit: limit}, nil
+	}
+
+	if conf.AdminUserIds[userId] {
+		return &paramsScope{scope: db.ParamsScopeUser, scopeId: userId}, nil
+	}
+	limit, err := db.GetSamplingParams(db.ParamsScopeUserLimit, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &paramsScope{scope: db.ParamsScopeUser, scopeId: userId, limit: limit}, nil
+}
+
+// isGroupAdmin check user is creator or administrator of group
+func isGroupAdmin(bot *tgbotapi.BotAPI, chatId, userId int64) bool {
+	member, err := bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{
+			ChatID: chatId,
+			UserID: userId,
+		},
+	})
+	if err != nil {
+		logger.Warn("get chat member fail", "chatId", chatId, "userId", userId, "err", err)
+		return false
+	}
+	return member.IsCreator() || member.IsAdministrator()
+}
+
+// showParams show params used by user in chat, with keyboard of every param
+func showParams(update tgbotapi.Update, bot *tgbotapi.BotAPI, edit bool) {
+	chatId, msgId, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+
+	scope, err := getParamsScope(update, bot)
+	if err != nil {
+		logger.Warn("get params scope fail", "err", err)
+		i18n.SendMsg(chatId, "params_fail", bot, nil, msgId)
+		return
+	}
+	override, err := db.GetSamplingParams(scope.scope, scope.scopeId)
+	if err != nil {
+		logger.Warn("get sampling params fail", "err", err)
+		i18n.SendMsg(chatId, "params_fail", bot, nil, msgId)
+		return
+	}
+
+	// group params are shown to group admin, and params used in chat are shown to others
+	params := llm.GetParams(chatId, userId)
+	if scope.scope == db.ParamsScopeGroup {
+		params = llm.GetParams(chatId, 0)
+	}
+
+	inlineButton := make([][]tgbotapi.InlineKeyboardButton, 0)
+	for _, name := range llm.ParamNames {
+		inlineButton = append(inlineButton, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(name, paramsCallbackPrefix+name),
+		))
+	}
+	inlineButton = append(inlineButton, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(i18n.GetMessage(*conf.Lang, "reset_button", nil), paramsCallbackPrefix+paramsReset),
+	))
+
+	content := fmt.Sprintf(i18n.GetMessage(*conf.Lang, "params_menu", nil),
+		i18n.GetMessage(*conf.Lang, "params_scope_"+scope.scope, nil), formatParams(params, override))
+	sendParamsMenu(update, bot, content, inlineButton, edit)
+}
+
+// formatParams show value of every param, params set by user or group admin are marked
+func formatParams(params *llm.Params, override *db.SamplingParams) string {
+	var sb strings.Builder
+	for _, name := range llm.ParamNames {
+		sb.WriteString(fmt.Sprintf("%s: %s", name, params.Value(name)))
+		if llm.GetSamplingParam(override, name) != "" {
+			sb.WriteString(" ✏️")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// showParamPresets show preset values of param
+func showParamPresets(update tgbotapi.Update, bot *tgbotapi.BotAPI, name string) {
+	chatId, _, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+	if _, ok := paramRanges[name]; !ok {
+		return
+	}
+
+	inlineButton := make([][]tgbotapi.InlineKeyboardButton, 0)
+	row := make([]tgbotapi.InlineKeyboardButton, 0)
+	for _, value := range paramPresets[name] {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(value, paramsCallbackPrefix+name+":"+value))
+	}
+	if len(row) > 0 {
+		inlineButton = append(inlineButton, row)
+	}
+	inlineButton = append(inlineButton, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(i18n.GetMessage(*conf.Lang, "reset_button", nil), paramsCallbackPrefix+name+":"+paramsReset),
+	))
+
+	content := fmt.Sprintf(i18n.GetMessage(*conf.Lang, "params_choose", nil), name,
+		llm.GetParams(chatId, userId).Value(name), paramRanges[name], name)
+	sendParamsMenu(update, bot, content, inlineButton, true)
+}
+
+// sendParamsMenu edit menu of callback, or reply command with new menu
+func sendParamsMenu(update tgbotapi.Update, bot *tgbotapi.BotAPI, content string,
+	inlineButton [][]tgbotapi.InlineKeyboardButton, edit bool) {
+	chatId, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(update)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(inlineButton...)
+
+	if edit {
+		editMsg := tgbotapi.NewEditMessageText(chatId, msgId, content)
+		editMsg.ReplyMarkup = &keyboard
+		if _, err := bot.Send(editMsg); err != nil {
+			logger.Warn("edit params menu fail", "err", err)
+		}
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatId, content)
+	msg.ReplyMarkup = keyboard
+	msg.ReplyToMessageID = msgId
+	if _, err := bot.Send(msg); err != nil {
+		logger.Warn("send params menu fail", "err", err)
+	}
+}
+
+// setParam set or reset one param of scope, menu is refreshed if it's set by keyboard
+func setParam(update tgbotapi.Update, bot *tgbotapi.BotAPI, name, value string, edit bool) {
+	chatId, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(update)
+
+	scope, err := getParamsScope(update, bot)
+	if err != nil {
+		logger.Warn("get params scope fail", "err", err)
+		i18n.SendMsg(chatId, "params_fail", bot, nil, msgId)
+		return
+	}
+	params, err := db.GetSamplingParams(scope.scope, scope.scopeId)
+	if err != nil {
+		logger.Warn("get sampling params fail", "err", err)
+		i18n.SendMsg(chatId, "params_fail", bot, nil, msgId)
+		return
+	}
+
+	if value == paramsReset {
+		err = llm.ResetSamplingParam(params, name)
+	} else {
+		err = llm.SetSamplingParam(params, name, value, scope.limit)
+	}
+	if err != nil {
+		sendParamError(update, bot, name, scope.limit, err)
+		return
+	}
+
+	if err = db.SaveSamplingParams(scope.scope, scope.scopeId, params); err != nil {
+		logger.Warn("save sampling params fail", "err", err)
+		i18n.SendMsg(chatId, "params_fail", bot, nil, msgId)
+		return
+	}
+
+	if edit {
+		showParams(update, bot, true)
+		return
+	}
+	if value == paramsReset {
+		utils.SendMsg(chatId, fmt.Sprintf(i18n.GetMessage(*conf.Lang, "params_reset_succ", nil), name), bot, msgId, "")
+		return
+	}
+	utils.SendMsg(chatId, fmt.Sprintf(i18n.GetMessage(*conf.Lang, "params_set_succ", nil), name,
+		llm.GetSamplingParam(params, name)), bot, msgId, "")
+}
+
+// sendParamError tell user why value of param can't be set
+func sendParamError(update tgbotapi.Update, bot *tgbotapi.BotAPI, name string, limit *db.SamplingParams, err error) {
+	chatId, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(update)
+
+	var content string
+	switch {
+	case errors.Is(err, llm.ErrParamRange):
+		content = fmt.Sprintf(i18n.GetMessage(*conf.Lang, "params_range", nil), name, paramRanges[name])
+	case errors.Is(err, llm.ErrParamOverLimit):
+		content = fmt.Sprintf(i18n.GetMessage(*conf.Lang, "params_over_limit", nil), name, llm.GetSamplingParam(limit, name))
+	default:
+		content = fmt.Sprintf(i18n.GetMessage(*conf.Lang, "params_unknown", nil), name, strings.Join(llm.ParamNames, ", "))
+	}
+	utils.SendMsg(chatId, content, bot, msgId, "")
+}
+
+// resetParams reset all params of scope, process-wide flags are used again
+func resetParams(update tgbotapi.Update, bot *tgbotapi.BotAPI, edit bool) {
+	chatId, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(update)
+
+	scope, err := getParamsScope(update, bot)
+	if err != nil {
+		logger.Warn("get params scope fail", "err", err)
+		i18n.SendMsg(chatId, "params_fail", bot, nil, msgId)
+		return
+	}
+	if err = db.DeleteSamplingParams(scope.scope, scope.scopeId); err != nil {
+		logger.Warn("delete sampling params fail", "err", err)
+		i18n.SendMsg(chatId, "params_fail", bot, nil, msgId)
+		return
+	}
+
+	if edit {
+		showParams(update, bot, true)
+		return
+	}
+	i18n.SendMsg(chatId, "params_reset_all_succ", bot, nil, msgId)
+}
+
+// sendParamsLimit show limits, or set max value of param which users or group admins can set
+func sendParamsLimit(update tgbotapi.Update, bot *tgbotapi.BotAPI, args string) {
+	chatId, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(update)
+
+	if args == "" {
+		userLimit, err := db.GetSamplingParams(db.ParamsScopeUserLimit, 0)
+		if err != nil {
+			logger.Warn("get user limit fail", "err", err)
+			i18n.SendMsg(chatId, "params_fail", bot, nil, msgId)
+			return
+		}
+		groupLimit, err := db.GetSamplingParams(db.ParamsScopeGroupLimit, 0)
+		if err != nil {
+			logger.Warn("get group limit fail", "err", err)
+			i18n.SendMsg(chatId, "params_fail", bot, nil, msgId)
+			return
+		}
+		content := fmt.Sprintf(i18n.GetMessage(*conf.Lang, "params_limit", nil), formatLimit(userLimit), formatLimit(groupLimit))
+		utils.SendMsg(chatId, content, bot, msgId, "")
+		return
+	}
+
+	scope, name, value, err := parseParamsLimit(args)
+	if err != nil {
+		i18n.SendMsg(chatId, "params_limit_usage", bot, nil, msgId)
+		return
+	}
+
+	limit, err := db.GetSamplingParams(scope, 0)
+	if err != nil {
+		logger.Warn("get sampling params limit fail", "err", err)
+		i18n.SendMsg(chatId, "params_fail", bot, nil, msgId)
+		return
+	}
+	if value == paramsReset {
+		err = llm.ResetSamplingParam(limit, name)
+	} else {
+		err = llm.SetSamplingParam(limit, name, value, nil)
+	}
+	if err != nil {
+		sendParamError(update, bot, name, nil, err)
+		return
+	}
+	if err = db.SaveSamplingParams(scope, 0, limit); err != nil {
+		logger.Warn("save sampling params limit fail", "err", err)
+		i18n.SendMsg(chatId, "params_fail", bot, nil, msgId)
+		return
+	}
+
+	sendParamsLimit(update, bot, "")
+}
+
+// parseParamsLimit parse <user|group> <name> <value|reset> of limit command, stop sequences can't be limited
+func parseParamsLimit(args string) (string, string, string, error) {
+	fields := strings.Fields(args)
+	if len(fields) != 3 || fields[1] == llm.ParamStop {
+		return "", "", "", errors.New("invalid limit param")
+	}
+
+	switch fields[0] {
+	case "user":
+		return db.ParamsScopeUserLimit, fields[1], fields[2], nil
+	case "group":
+		return db.ParamsScopeGroupLimit, fields[1], fields[2], nil
+	}
+	return "", "", "", errors.New("invalid limit scope")
+}
+
+func formatLimit(limit *db.SamplingParams) string {
+	var sb strings.Builder
+	for _, name := range llm.ParamNames {
+		if value := llm.GetSamplingParam(limit, name); value != "" && name != llm.ParamStop {
+			sb.WriteString(fmt.Sprintf("%s <= %s\n", name, value))
+		}
+	}
+	if sb.Len() == 0 {
+		return "-\n"
+	}
+	return sb.String()
+}
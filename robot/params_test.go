@@ -0,0 +1,37 @@
+package robot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yincongcyincong/telegram-deepseek-bot/db"
+)
+
+func TestParseParamsCallback(t *testing.T) {
+	name, _, hasValue := parseParamsCallback("params:temperature")
+	assert.Equal(t, "temperature", name)
+	assert.False(t, hasValue)
+
+	name, value, hasValue := parseParamsCallback("params:frequency_penalty:-0.5")
+	assert.Equal(t, "frequency_penalty", name)
+	assert.Equal(t, "-0.5", value)
+	assert.True(t, hasValue)
+}
+
+func TestParseParamsLimit(t *testing.T) {
+	scope, name, value, err := parseParamsLimit("user max_tokens 2048")
+	assert.Nil(t, err)
+	assert.Equal(t, db.ParamsScopeUserLimit, scope)
+	assert.Equal(t, "max_tokens", name)
+	assert.Equal(t, "2048", value)
+
+	scope, _, _, err = parseParamsLimit("group temperature reset")
+	assert.Nil(t, err)
+	assert.Equal(t, db.ParamsScopeGroupLimit, scope)
+
+	_, _, _, err = parseParamsLimit("user stop END")
+	assert.NotNil(t, err, "stop can't be limited")
+
+	_, _, _, err = parseParamsLimit("admin max_tokens 2048")
+	assert.NotNil(t, err, "unknown scope should fail")
+}
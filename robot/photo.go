@@ -0,0 +1,144 @@
+package robot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/db"
+	"github.com/yincongcyincong/telegram-deepseek-bot/llm"
+	"github.com/yincongcyincong/telegram-deepseek-bot/param"
+)
+
+const (
+	minImageSize = 64
+	maxImageSize = 4096
+	// maxAlbumSize telegram sends at most 10 photos in an album
+	maxAlbumSize = 10
+)
+
+// parseImageArgs parse [--type <type>] [--size <width>x<height>] [--n <num>] <prompt> of photo command,
+// options can be written as --n=2 too.
+func parseImageArgs(content string) (string, *llm.ImageRequest, error) {
+	imageType := ""
+	req := &llm.ImageRequest{Num: *conf.ImageNum}
+	promptWords := make([]string, 0)
+
+	fields := strings.Fields(content)
+	for i := 0; i < len(fields); i++ {
+		if !strings.HasPrefix(fields[i], "--") {
+			promptWords = append(promptWords, fields[i])
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(fields[i], "--"), "=")
+		if !hasValue {
+			if i+1 >= len(fields) {
+				return "", nil, fmt.Errorf("option --%s has no value", name)
+			}
+			i++
+			value = fields[i]
+		}
+
+		switch name {
+		case "type":
+			imageType = strings.ToLower(value)
+		case "size":
+			width, height, err := parseImageSize(value)
+			if err != nil {
+				return "", nil, err
+			}
+			req.Width, req.Height = width, height
+		case "n":
+			num, err := strconv.Atoi(value)
+			if err != nil || num < 1 || num > *conf.MaxImageNum {
+				return "", nil, fmt.Errorf("image num should be between 1 and %d", *conf.MaxImageNum)
+			}
+			req.Num = num
+		default:
+			return "", nil, fmt.Errorf("unknown option --%s", name)
+		}
+	}
+
+	req.Prompt = strings.Join(promptWords, " ")
+	return imageType, req, nil
+}
+
+// parseImageSize parse size like 1024x1024
+func parseImageSize(size string) (int, int, error) {
+	w, h, ok := strings.Cut(strings.ToLower(size), "x")
+	if !ok {
+		return 0, 0, errors.New("image size should be <width>x<height>")
+	}
+	width, err := strconv.Atoi(w)
+	if err != nil {
+		return 0, 0, err
+	}
+	height, err := strconv.Atoi(h)
+	if err != nil {
+		return 0, 0, err
+	}
+	if width < minImageSize || width > maxImageSize || height < minImageSize || height > maxImageSize {
+		return 0, 0, fmt.Errorf("image size should be between %d and %d", minImageSize, maxImageSize)
+	}
+	return width, height, nil
+}
+
+func getInputFile(image *llm.Image, idx int) tgbotapi.RequestFileData {
+	if image.Url != "" {
+		return tgbotapi.FileURL(image.Url)
+	}
+	return tgbotapi.FileBytes{Name: fmt.Sprintf("image_%d.png", idx), Bytes: image.Data}
+}
+
+// sendImages replace thinking message with the image, several images are sent in albums
+func sendImages(chatId int64, replyToMessageID, thinkingMsgId int, images []*llm.Image, bot *tgbotapi.BotAPI) error {
+	if len(images) == 1 {
+		edit := tgbotapi.EditMessageMediaConfig{
+			BaseEdit: tgbotapi.BaseEdit{
+				ChatID:    chatId,
+				MessageID: thinkingMsgId,
+			},
+			Media: tgbotapi.NewInputMediaPhoto(getInputFile(images[0], 0)),
+		}
+		_, err := bot.Request(edit)
+		return err
+	}
+
+	for start := 0; start < len(images); start += maxAlbumSize {
+		end := min(start+maxAlbumSize, len(images))
+		medias := make([]interface{}, 0, end-start)
+		for i := start; i < end; i++ {
+			medias = append(medias, tgbotapi.NewInputMediaPhoto(getInputFile(images[i], i)))
+		}
+
+		album := tgbotapi.NewMediaGroup(chatId, medias)
+		album.ReplyToMessageID = replyToMessageID
+		if _, err := bot.SendMediaGroup(album); err != nil {
+			return err
+		}
+	}
+
+	deleteMsg(chatId, thinkingMsgId, bot)
+	return nil
+}
+
+// recordImages every image costs ImageTokenUsage token of user
+func recordImages(userId int64, prompt string, images []*llm.Image) {
+	for _, image := range images {
+		answer := image.Url
+		if answer == "" {
+			answer = "image"
+		}
+		db.InsertRecordInfo(&db.Record{
+			UserId:    userId,
+			Question:  prompt,
+			Answer:    answer,
+			Token:     param.ImageTokenUsage,
+			IsDeleted: 1,
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package robot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+)
+
+func TestParseImageArgs(t *testing.T) {
+	imageNum, maxImageNum := 1, 4
+	conf.ImageNum, conf.MaxImageNum = &imageNum, &maxImageNum
+
+	imageType, req, err := parseImageArgs("a cat on the moon")
+	assert.Nil(t, err)
+	assert.Equal(t, "", imageType)
+	assert.Equal(t, "a cat on the moon", req.Prompt)
+	assert.Equal(t, 1, req.Num)
+	assert.Equal(t, 0, req.Width)
+
+	imageType, req, err = parseImageArgs("--size 1024x768 --n 2 --type=OpenAI a cat")
+	assert.Nil(t, err)
+	assert.Equal(t, "openai", imageType)
+	assert.Equal(t, "a cat", req.Prompt)
+	assert.Equal(t, 2, req.Num)
+	assert.Equal(t, 1024, req.Width)
+	assert.Equal(t, 768, req.Height)
+
+	_, req, err = parseImageArgs("--n 2")
+	assert.Nil(t, err)
+	assert.Equal(t, "", req.Prompt)
+
+	_, _, err = parseImageArgs("--n 5 a cat")
+	assert.NotNil(t, err, "num exceeds max image num")
+
+	_, _, err = parseImageArgs("a cat --n")
+	assert.NotNil(t, err, "option has no value")
+
+	_, _, err = parseImageArgs("--style anime a cat")
+	assert.NotNil(t, err, "unknown option should fail")
+}
+
+func TestParseImageSize(t *testing.T) {
+	width, height, err := parseImageSize("512X1024")
+	assert.Nil(t, err)
+	assert.Equal(t, 512, width)
+	assert.Equal(t, 1024, height)
+
+	_, _, err = parseImageSize("1024")
+	assert.NotNil(t, err)
+
+	_, _, err = parseImageSize("10000x1024")
+	assert.NotNil(t, err)
+}
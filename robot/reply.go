@@ -0,0 +1,206 @@
+package robot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	godeepseek "github.com/cohesion-org/deepseek-go"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/db"
+	"github.com/yincongcyincong/telegram-deepseek-bot/i18n"
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+	"github.com/yincongcyincong/telegram-deepseek-bot/param"
+	"github.com/yincongcyincong/telegram-deepseek-bot/utils"
+)
+
+// replyModes reply modes in menu order
+var replyModes = []string{db.ReplyModeText, db.ReplyModeVoice, db.ReplyModeBoth}
+
+// sendReplyMode show or set reply mode of user: /reply, /reply <text|voice|both>
+func sendReplyMode(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatId, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(update)
+	if *conf.TTSUrl == "" {
+		i18n.SendMsg(chatId, "reply_tts_off", bot, nil, msgId)
+		return
+	}
+
+	replyMode := strings.ToLower(utils.ReplaceCommand(update.Message.Text, "/reply", bot.Self.UserName))
+	if replyMode == "" {
+		showReplyMode(update, bot, getReplyMode(update), false)
+		return
+	}
+	if !isReplyMode(replyMode) {
+		i18n.SendMsg(chatId, "reply_mode_unknown", bot, nil, msgId)
+		return
+	}
+
+	if err := setReplyMode(update, replyMode); err != nil {
+		logger.Warn("set reply mode fail", "err", err)
+		i18n.SendMsg(chatId, "reply_fail", bot, nil, msgId)
+		return
+	}
+	utils.SendMsg(chatId, fmt.Sprintf(i18n.GetMessage(*conf.Lang, "reply_mode_succ", nil), getReplyModeText(replyMode)), bot, msgId, "")
+}
+
+// handleReplyCallback handle keyboard of reply mode: reply:<mode>
+func handleReplyCallback(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	callback := tgbotapi.NewCallback(update.CallbackQuery.ID, "")
+	if _, err := bot.Request(callback); err != nil {
+		logger.Warn("request callback fail", "err", err)
+	}
+
+	chatId, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(update)
+	replyMode := strings.TrimPrefix(update.CallbackQuery.Data, replyCallbackPrefix)
+	if *conf.TTSUrl == "" || !isReplyMode(replyMode) {
+		return
+	}
+
+	if err := setReplyMode(update, replyMode); err != nil {
+		logger.Warn("set reply mode fail", "err", err)
+		i18n.SendMsg(chatId, "reply_fail", bot, nil, msgId)
+		return
+	}
+	showReplyMode(update, bot, replyMode, true)
+}
+
+// showReplyMode show reply mode with keyboard of all modes, current mode is checked
+func showReplyMode(update tgbotapi.Update, bot *tgbotapi.BotAPI, current string, edit bool) {
+	chatId, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(update)
+
+	inlineButton := make([][]tgbotapi.InlineKeyboardButton, 0)
+	for _, replyMode := range replyModes {
+		text := getReplyModeText(replyMode)
+		if replyMode == current {
+			text = "✅ " + text
+		}
+		inlineButton = append(inlineButton, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(text, replyCallbackPrefix+replyMode),
+		))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(inlineButton...)
+	content := fmt.Sprintf(i18n.GetMessage(*conf.Lang, "reply_mode_menu", nil), getReplyModeText(current))
+
+	if edit {
+		editMsg := tgbotapi.NewEditMessageText(chatId, msgId, content)
+		editMsg.ReplyMarkup = &keyboard
+		if _, err := bot.Send(editMsg); err != nil {
+			logger.Warn("edit reply mode menu fail", "err", err)
+		}
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatId, content)
+	msg.ReplyMarkup = keyboard
+	msg.ReplyToMessageID = msgId
+	if _, err := bot.Send(msg); err != nil {
+		logger.Warn("send reply mode menu fail", "err", err)
+	}
+}
+
+func isReplyMode(replyMode string) bool {
+	for _, m := range replyModes {
+		if m == replyMode {
+			return true
+		}
+	}
+	return false
+}
+
+func getReplyModeText(replyMode string) string {
+	return i18n.GetMessage(*conf.Lang, "reply_mode_"+replyMode, nil)
+}
+
+// setReplyMode save reply mode of user, user is created if it's new
+func setReplyMode(update tgbotapi.Update, replyMode string) error {
+	_, _, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+
+	userInfo, err := db.GetUserByID(userId)
+	if err != nil {
+		return err
+	}
+	if userInfo == nil {
+		if _, err = db.InsertUser(userId, godeepseek.DeepSeekChat); err != nil {
+			return err
+		}
+	}
+	return db.UpdateUserReplyMode(userId, replyMode)
+}
+
+// getReplyMode get reply mode of user of update, it's text if tts isn't configured
+func getReplyMode(update tgbotapi.Update) string {
+	if *conf.TTSUrl == "" {
+		return db.ReplyModeText
+	}
+
+	_, _, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+	userInfo, err := db.GetUserByID(userId)
+	if err != nil {
+		logger.Warn("get user info fail", "err", err)
+		return db.ReplyModeText
+	}
+	if userInfo == nil || !isReplyMode(userInfo.ReplyMode) {
+		return db.ReplyModeText
+	}
+	return userInfo.ReplyMode
+}
+
+// joinAnswerMsgs whole answer of messages which it's split into
+func joinAnswerMsgs(answerMsgs []*param.MsgInfo) string {
+	contents := make([]string, 0, len(answerMsgs))
+	for _, msg := range answerMsgs {
+		contents = append(contents, msg.Content)
+	}
+	return strings.Join(contents, "")
+}
+
+// sendVoiceAnswer synthesize answer and send it in voice messages, long answer is split into several voices.
+// every voice costs TTSTokenUsage token of user. return true if any voice is sent.
+func sendVoiceAnswer(update tgbotapi.Update, bot *tgbotapi.BotAPI, answer string) bool {
+	chatId, msgId, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+
+	synthesizer := utils.NewSpeechSynthesizer()
+	if synthesizer == nil {
+		return false
+	}
+
+	sent := false
+	for _, chunk := range utils.SplitSpeechText(answer, utils.SpeechChunkLen) {
+		if checkUserTokenExceed(update, bot) {
+			logger.Warn("user token exceed", "userID", userId)
+			break
+		}
+
+		if _, err := bot.Request(tgbotapi.NewChatAction(chatId, tgbotapi.ChatRecordVoice)); err != nil {
+			logger.Warn("send chat action fail", "err", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		audio, err := synthesizer.Synthesize(ctx, chunk)
+		cancel()
+		if err != nil {
+			logger.Warn("synthesize answer fail", "err", err)
+			break
+		}
+
+		voice := tgbotapi.NewVoice(chatId, tgbotapi.FileBytes{Name: "answer.ogg", Bytes: audio})
+		voice.ReplyToMessageID = msgId
+		if _, err = bot.Send(voice); err != nil {
+			logger.Warn("send voice fail", "err", err)
+			break
+		}
+		sent = true
+
+		db.InsertRecordInfo(&db.Record{
+			UserId:    userId,
+			Question:  chunk,
+			Answer:    "voice",
+			Token:     param.TTSTokenUsage,
+			IsDeleted: 1,
+		})
+	}
+
+	return sent
+}
@@ -0,0 +1,32 @@
+package robot
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/db"
+	"github.com/yincongcyincong/telegram-deepseek-bot/param"
+)
+
+func TestJoinAnswerMsgs(t *testing.T) {
+	answer := joinAnswerMsgs([]*param.MsgInfo{{Content: "first part, "}, {Content: "second part."}})
+	assert.Equal(t, "first part, second part.", answer)
+}
+
+func TestIsReplyMode(t *testing.T) {
+	assert.True(t, isReplyMode(db.ReplyModeText))
+	assert.True(t, isReplyMode(db.ReplyModeVoice))
+	assert.True(t, isReplyMode(db.ReplyModeBoth))
+	assert.False(t, isReplyMode("video"))
+	assert.False(t, isReplyMode(""))
+}
+
+func TestGetReplyModeWithoutTTS(t *testing.T) {
+	ttsUrl := ""
+	conf.TTSUrl = &ttsUrl
+
+	update := tgbotapi.Update{Message: &tgbotapi.Message{From: &tgbotapi.User{ID: 1}, Chat: &tgbotapi.Chat{ID: 1}}}
+	assert.Equal(t, db.ReplyModeText, getReplyMode(update), "answer is text if tts isn't configured")
+}
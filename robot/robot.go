@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"html"
 	"runtime/debug"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -22,6 +25,22 @@ import (
 	"github.com/yincongcyincong/telegram-deepseek-bot/utils"
 )
 
+const (
+	personaCallbackPrefix     = "persona:"
+	sessionCallbackPrefix     = "session:"
+	stopCallbackPrefix        = "stop:"
+	llmTypeCallbackPrefix     = "llm_type:"
+	ollamaModelCallbackPrefix = "ollama_model:"
+	paramsCallbackPrefix      = "params:"
+	replyCallbackPrefix       = "reply:"
+	modelPageCallbackPrefix   = "model_page:"
+
+	// maxCallbackDataLen telegram rejects keyboard whose callback data is longer
+	maxCallbackDataLen = 64
+	// modelPageSize model buttons in a page, catalog of provider may have hundreds of models
+	modelPageSize = 20
+)
+
 // StartListenRobot start listen robot callback
 func StartListenRobot() {
 	for {
@@ -41,6 +60,13 @@ func StartListenRobot() {
 
 // execUpdate exec telegram message
 func execUpdate(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	// edited message is handled as message, its answer is regenerated
+	isEdited := false
+	if update.Message == nil && update.EditedMessage != nil {
+		update.Message = update.EditedMessage
+		isEdited = true
+	}
+
 	chatId, msgId, userId := utils.GetChatIdAndMsgIdAndUserID(update)
 
 	// Handle business updates first
@@ -55,6 +81,11 @@ func execUpdate(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
 		return
 	}
 
+	if isEdited {
+		regenerateAnswer(update, bot)
+		return
+	}
+
 	if handleCommandAndCallback(update, bot) {
 		return
 	}
@@ -71,6 +102,11 @@ func execUpdate(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
 
 // requestDeepseekAndResp request deepseek api
 func requestDeepseekAndResp(update tgbotapi.Update, bot *tgbotapi.BotAPI, content string) {
+	requestLLMAndResp(update, bot, content, 0)
+}
+
+// requestLLMAndResp request llm api, the answer is shown in answerMsgId if it isn't 0
+func requestLLMAndResp(update tgbotapi.Update, bot *tgbotapi.BotAPI, content string, answerMsgId int) {
 	_, _, userId := utils.GetChatIdAndMsgIdAndUserID(update)
 	if checkUserTokenExceed(update, bot) {
 		logger.Warn("user token exceed", "userID", userId)
@@ -78,15 +114,38 @@ func requestDeepseekAndResp(update tgbotapi.Update, bot *tgbotapi.BotAPI, conten
 	}
 
 	if conf.Store != nil {
-		executeChain(update, bot, content)
+		executeChain(update, bot, content, answerMsgId)
 	} else {
-		executeLLM(update, bot, content)
+		executeLLM(update, bot, content, answerMsgId)
+	}
+
+}
+
+// regenerateAnswer regenerate answer of edited question and edit the answer message in place,
+// history after the question is replaced.
+func regenerateAnswer(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatId, msgId, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+	if update.Message.IsCommand() || skipThisMsg(update, bot) {
+		return
 	}
 
+	aq := db.DeleteMsgRecordFrom(db.NewRecordKey(chatId, userId), msgId)
+	if aq == nil {
+		logger.Info("edited message has no answer in history", "msgId", msgId, "chat", chatId)
+		return
+	}
+
+	// answer is shown again in its first message, other messages of it are stale
+	for _, answerMsgId := range aq.AnswerMsgIds {
+		deleteMsg(chatId, answerMsgId, bot)
+	}
+
+	logger.Info("regenerate answer of edited message", "msgId", msgId, "answerMsgId", aq.AnswerMsgId)
+	requestLLMAndResp(update, bot, update.Message.Text, aq.AnswerMsgId)
 }
 
 // executeChain use langchain to interact llm
-func executeChain(update tgbotapi.Update, bot *tgbotapi.BotAPI, content string) {
+func executeChain(update tgbotapi.Update, bot *tgbotapi.BotAPI, content string, answerMsgId int) {
 	messageChan := make(chan *param.MsgInfo)
 
 	go func() {
@@ -98,17 +157,31 @@ func executeChain(update tgbotapi.Update, bot *tgbotapi.BotAPI, content string)
 			close(messageChan)
 		}()
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		// thinking message shows the answer, its id is stored with the answer
+		answerMsgId = sendThinkingMsg(update, bot, answerMsgId)
+
+		// send response message
+		go handleUpdate(messageChan, update, bot, answerMsgId)
+
+		// stop button of answer cancels the context
+		ctx, cancel := utils.NewRequestContext(update, 5*time.Minute)
 		defer cancel()
 
-		text, err := utils.GetContent(update, bot, content)
+		dpLLM := rag.NewRag(llm.WithBot(bot), llm.WithUpdate(update),
+			llm.WithMessageChan(messageChan), llm.WithContent(content),
+			llm.WithAnswerMsgId(answerMsgId), llm.WithShowReasoning(getShowReasoning(update)))
+
+		// document is read into content, with caption as the question
+		if err := dpLLM.LLM.ReadDocument(ctx); err != nil {
+			logger.Warn("read document fail", "err", err)
+			return
+		}
+		text, err := utils.GetContent(update, bot, dpLLM.LLM.Content)
 		if err != nil {
 			logger.Error("get content fail", "err", err)
 			return
 		}
-
-		dpLLM := rag.NewRag(llm.WithBot(bot), llm.WithUpdate(update),
-			llm.WithMessageChan(messageChan), llm.WithContent(content))
+		dpLLM.LLM.Content = text
 
 		qaChain := chains.NewRetrievalQAFromLLM(
 			dpLLM,
@@ -120,34 +193,109 @@ func executeChain(update tgbotapi.Update, bot *tgbotapi.BotAPI, content string)
 		}
 	}()
 
-	// send response message
-	go handleUpdate(messageChan, update, bot)
-
 }
 
 // executeLLM directly interact llm
-func executeLLM(update tgbotapi.Update, bot *tgbotapi.BotAPI, content string) {
+func executeLLM(update tgbotapi.Update, bot *tgbotapi.BotAPI, content string, answerMsgId int) {
 	messageChan := make(chan *param.MsgInfo)
-	l := llm.NewLLM(llm.WithBot(bot), llm.WithUpdate(update),
-		llm.WithMessageChan(messageChan), llm.WithContent(content),
-		llm.WithTaskTools(&conf.AgentInfo{
-			DeepseekTool:    conf.DeepseekTools,
-			VolTool:         conf.VolTools,
-			OpenAITools:     conf.OpenAITools,
-			GeminiTools:     conf.GeminiTools,
-			OpenRouterTools: conf.OpenRouterTools,
-		}))
 
-	// request DeepSeek API
-	go l.GetContent()
+	go func() {
+		// thinking message shows the answer, its id is stored with the answer
+		answerMsgId = sendThinkingMsg(update, bot, answerMsgId)
+
+		l := llm.NewLLM(llm.WithBot(bot), llm.WithUpdate(update),
+			llm.WithMessageChan(messageChan), llm.WithContent(content),
+			llm.WithAnswerMsgId(answerMsgId), llm.WithShowReasoning(getShowReasoning(update)),
+			llm.WithTaskTools(&conf.AgentInfo{
+				DeepseekTool:    conf.DeepseekTools,
+				VolTool:         conf.VolTools,
+				OpenAITools:     conf.OpenAITools,
+				GeminiTools:     conf.GeminiTools,
+				OpenRouterTools: conf.OpenRouterTools,
+				AnthropicTools:  conf.AnthropicTools,
+			}))
+
+		// send response message
+		go handleUpdate(messageChan, update, bot, answerMsgId)
+
+		// request DeepSeek API
+		l.GetContent()
+	}()
 
-	// send response message
-	go handleUpdate(messageChan, update, bot)
+}
+
+// sendThinkingMsg send thinking message, or edit answerMsgId to thinking when answer is regenerated.
+// return id of thinking message, 0 if it fails.
+func sendThinkingMsg(update tgbotapi.Update, bot *tgbotapi.BotAPI, answerMsgId int) int {
+	chatId, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(update)
+	stopKeyboard := getStopKeyboard(msgId)
+	if answerMsgId != 0 {
+		updateMsg := tgbotapi.NewEditMessageText(chatId, answerMsgId, i18n.GetMessage(*conf.Lang, "thinking", nil))
+		updateMsg.ReplyMarkup = &stopKeyboard
+		_, err := bot.Send(updateMsg)
+		if err == nil {
+			return answerMsgId
+		}
+		logger.Warn("edit answer message fail, send new one", "msgID", answerMsgId, "err", err)
+	}
+
+	tgMsgInfo := tgbotapi.NewMessage(chatId, i18n.GetMessage(*conf.Lang, "thinking", nil))
+	tgMsgInfo.ReplyToMessageID = msgId
+	tgMsgInfo.ReplyMarkup = stopKeyboard
+	sendInfo, err := bot.Send(tgMsgInfo)
+	if err != nil {
+		logger.Warn("Sending first message fail", "err", err)
+	}
+	return sendInfo.MessageID
+}
+
+// getStopKeyboard stop button of answer, it stops the request of question message
+func getStopKeyboard(msgId int) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(i18n.GetMessage(*conf.Lang, "stop_button", nil),
+			fmt.Sprintf("%s%d", stopCallbackPrefix, msgId))))
+}
 
+// removeStopButton remove stop button when answer message is finished
+func removeStopButton(chatId int64, msgId int, bot *tgbotapi.BotAPI) {
+	if msgId == 0 {
+		return
+	}
+
+	updateMsg := tgbotapi.NewEditMessageReplyMarkup(chatId, msgId, tgbotapi.InlineKeyboardMarkup{
+		InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{},
+	})
+	if _, err := bot.Send(updateMsg); err != nil {
+		logger.Warn("remove stop button fail", "msgID", msgId, "err", err)
+	}
+}
+
+// deleteMsg delete message of bot
+func deleteMsg(chatId int64, msgId int, bot *tgbotapi.BotAPI) {
+	if _, err := bot.Request(tgbotapi.NewDeleteMessage(chatId, msgId)); err != nil {
+		logger.Warn("delete message fail", "msgID", msgId, "err", err)
+	}
+}
+
+// handleStopCallback stop the answer which is generating, partial answer is kept
+func handleStopCallback(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatId, _, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+	questionMsgId := utils.ParseInt(strings.TrimPrefix(update.CallbackQuery.Data, stopCallbackPrefix))
+
+	text := i18n.GetMessage(*conf.Lang, "stop_fail", nil)
+	if utils.StopRequest(chatId, questionMsgId, userId) {
+		logger.Info("answer is stopped by user", "userID", userId, "msgID", questionMsgId)
+		text = i18n.GetMessage(*conf.Lang, "stop_succ", nil)
+	}
+
+	callback := tgbotapi.NewCallback(update.CallbackQuery.ID, text)
+	if _, err := bot.Request(callback); err != nil {
+		logger.Warn("request callback fail", "err", err)
+	}
 }
 
-// handleUpdate handle robot msg sending
-func handleUpdate(messageChan chan *param.MsgInfo, update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+// handleUpdate handle robot msg sending, firstMsgId is the thinking message, it's sent here if it's 0
+func handleUpdate(messageChan chan *param.MsgInfo, update tgbotapi.Update, bot *tgbotapi.BotAPI, firstMsgId int) {
 	defer func() {
 		if err := recover(); err != nil {
 			logger.Error("handleUpdate panic err", "err", err, "stack", string(debug.Stack()))
@@ -158,26 +306,49 @@ func handleUpdate(messageChan chan *param.MsgInfo, update tgbotapi.Update, bot *
 
 	chatId, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(update)
 	parseMode := tgbotapi.ModeMarkdown
+	stopKeyboard := getStopKeyboard(msgId)
 
-	tgMsgInfo := tgbotapi.NewMessage(chatId, i18n.GetMessage(*conf.Lang, "thinking", nil))
-	tgMsgInfo.ReplyToMessageID = msgId
-	firstSendInfo, err := bot.Send(tgMsgInfo)
-	if err != nil {
-		logger.Warn("Sending first message fail", "err", err)
+	if firstMsgId == 0 {
+		firstMsgId = sendThinkingMsg(update, bot, 0)
 	}
+	firstSendInfo := tgbotapi.Message{MessageID: firstMsgId}
 
-	for msg = range messageChan {
-		if len(msg.Content) == 0 {
-			msg.Content = "get nothing from deepseek!"
+	// stop button is only shown in the message which is generating
+	stopMsgId := firstMsgId
+	defer func() {
+		removeStopButton(chatId, stopMsgId, bot)
+	}()
+
+	// other messages of answer are stored with the answer, record of it is inserted before message channel is closed
+	answerMsgIds := make([]int, 0)
+	defer func() {
+		if firstMsgId == 0 || len(answerMsgIds) == 0 {
+			return
+		}
+		if err := db.UpdateAnswerMsgIds(chatId, firstMsgId, answerMsgIds); err != nil {
+			logger.Warn("update answer message ids fail", "msgID", firstMsgId, "err", err)
 		}
+	}()
+
+	var tgMsgInfo tgbotapi.MessageConfig
+	var err error
+
+	// send or edit telegram message of msg
+	sendMsgInfo := func(msg *param.MsgInfo) {
 		if firstSendInfo.MessageID != 0 {
 			msg.MsgId = firstSendInfo.MessageID
 		}
 
+		content, msgParseMode := msg.Content, parseMode
+		if msg.IsReasoning {
+			content, msgParseMode = formatReasoningMsg(msg.Content), tgbotapi.ModeHTML
+		}
+
 		if msg.MsgId == 0 && firstSendInfo.MessageID == 0 {
-			tgMsgInfo = tgbotapi.NewMessage(chatId, msg.Content)
+			tgMsgInfo = tgbotapi.NewMessage(chatId, content)
 			tgMsgInfo.ReplyToMessageID = msgId
-			tgMsgInfo.ParseMode = parseMode
+			tgMsgInfo.ParseMode = msgParseMode
+			tgMsgInfo.ReplyMarkup = stopKeyboard
 			sendInfo, err := bot.Send(tgMsgInfo)
 			if err != nil {
 				if sleepUtilNoLimit(msgId, err) {
@@ -190,13 +361,21 @@ func handleUpdate(messageChan chan *param.MsgInfo, update tgbotapi.Update, bot *
 				}
 				if err != nil {
 					logger.Warn("Error sending message:", "msgID", msgId, "err", err)
-					continue
+					return
 				}
 			}
 			msg.MsgId = sendInfo.MessageID
+			if msg.MsgId != 0 && msg.MsgId != firstMsgId && !slices.Contains(answerMsgIds, msg.MsgId) {
+				answerMsgIds = append(answerMsgIds, msg.MsgId)
+			}
+			if stopMsgId != msg.MsgId {
+				removeStopButton(chatId, stopMsgId, bot)
+				stopMsgId = msg.MsgId
+			}
 		} else {
-			updateMsg := tgbotapi.NewEditMessageText(chatId, msg.MsgId, msg.Content)
-			updateMsg.ParseMode = parseMode
+			updateMsg := tgbotapi.NewEditMessageText(chatId, msg.MsgId, content)
+			updateMsg.ParseMode = msgParseMode
+			updateMsg.ReplyMarkup = &stopKeyboard
 			_, err = bot.Send(updateMsg)
 			if err != nil {
 				// try again
@@ -216,6 +395,50 @@ func handleUpdate(messageChan chan *param.MsgInfo, update tgbotapi.Update, bot *
 		}
 
 	}
+
+	// answer is sent in voice after it's finished, text of it isn't shown in voice mode.
+	// reasoning and notice are always shown in text.
+	replyMode := getReplyMode(update)
+	answerMsgs := make([]*param.MsgInfo, 0)
+	for msg = range messageChan {
+		if len(msg.Content) == 0 {
+			msg.Content = "get nothing from deepseek!"
+		}
+		isAnswer := !msg.IsReasoning && !msg.IsNotice
+		if isAnswer && !slices.Contains(answerMsgs, msg) {
+			answerMsgs = append(answerMsgs, msg)
+		}
+		if replyMode == db.ReplyModeVoice && isAnswer {
+			continue
+		}
+		sendMsgInfo(msg)
+	}
+
+	if replyMode == db.ReplyModeText || len(answerMsgs) == 0 {
+		return
+	}
+
+	voiceSent := sendVoiceAnswer(update, bot, joinAnswerMsgs(answerMsgs))
+	if replyMode != db.ReplyModeVoice {
+		return
+	}
+	if !voiceSent {
+		// show text of answer if voice fails
+		for _, answerMsg := range answerMsgs {
+			sendMsgInfo(answerMsg)
+		}
+		return
+	}
+	if firstSendInfo.MessageID != 0 {
+		deleteMsg(chatId, firstSendInfo.MessageID, bot)
+		stopMsgId = 0
+	}
+}
+
+// formatReasoningMsg show reasoning in collapsed quote, so it doesn't take the place of answer
+func formatReasoningMsg(content string) string {
+	return "<blockquote expandable>" + html.EscapeString(i18n.GetMessage(*conf.Lang, "reasoning_title", nil)+"\n"+content) +
+		"</blockquote>"
 }
 
 // sleepUtilNoLimit handle "Too Many Requests" error
@@ -255,20 +478,26 @@ func handleCommandAndCallback(update tgbotapi.Update, bot *tgbotapi.BotAPI) bool
 
 // skipThisMsg check if msg trigger llm
 func skipThisMsg(update tgbotapi.Update, bot *tgbotapi.BotAPI) bool {
+	// bot is mentioned in caption of photo and document
+	text := update.Message.Text
+	if text == "" {
+		text = update.Message.Caption
+	}
+
 	if update.Message.Chat.Type == "private" {
-		if strings.TrimSpace(update.Message.Text) == "" &&
-			update.Message.Voice == nil && update.Message.Photo == nil {
+		if strings.TrimSpace(text) == "" &&
+			update.Message.Voice == nil && update.Message.Photo == nil && update.Message.Document == nil {
 			return true
 		}
 
 		return false
 	} else {
-		if strings.TrimSpace(strings.ReplaceAll(update.Message.Text, "@"+bot.Self.UserName, "")) == "" &&
-			update.Message.Voice == nil {
+		if strings.TrimSpace(strings.ReplaceAll(text, "@"+bot.Self.UserName, "")) == "" &&
+			update.Message.Voice == nil && update.Message.Document == nil {
 			return true
 		}
 
-		if !strings.Contains(update.Message.Text, "@"+bot.Self.UserName) {
+		if !strings.Contains(text, "@"+bot.Self.UserName) {
 			return true
 		}
 	}
@@ -319,12 +548,38 @@ func handleCommand(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
 		sendMultiAgent(update, bot, "task_empty_content")
 	case "mcp":
 		sendMultiAgent(update, bot, "mcp_empty_content")
+	case "persona":
+		sendPersona(update, bot)
+	case "memory":
+		sendMemory(update, bot)
+	case "new":
+		newSession(update, bot)
+	case "sessions":
+		showSessions(update, bot)
+	case "switch":
+		sendSwitchSession(update, bot)
+	case "rename":
+		renameSession(update, bot)
+	case "export":
+		sendExport(update, bot)
+	case "import":
+		sendImport(update, bot)
+	case "reasoning":
+		switchReasoning(update, bot)
+	case "params":
+		sendParams(update, bot)
+	case "reply":
+		sendReplyMode(update, bot)
 	}
 
 	if checkAdminUser(update) {
 		switch cmd {
 		case "addtoken":
 			addToken(update, bot)
+		case "cost":
+			sendCost(update, bot)
+		case "cache":
+			sendResponseCache(update, bot)
 		}
 	}
 }
@@ -336,24 +591,14 @@ func sendChatMessage(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
 	messageText := ""
 	if update.Message != nil {
 		messageText = update.Message.Text
-		if messageText == "" && update.Message.Voice != nil && *conf.AudioAppID != "" {
-			audioContent := utils.GetAudioContent(update, bot)
-			if audioContent == nil {
-				logger.Warn("audio url empty")
-				return
-			}
-			messageText = utils.FileRecognize(audioContent)
-		}
-
-		if messageText == "" && update.Message.Photo != nil {
-			photoContent, err := utils.GetImageContent(utils.GetPhotoContent(update, bot))
+		if messageText == "" && update.Message.Voice != nil {
+			voiceContent, err := utils.RecognizeVoice(update, bot)
 			if err != nil {
-				logger.Warn("get photo content err", "err", err)
+				logger.Warn("recognize voice fail", "err", err)
 				return
 			}
-			messageText = photoContent
+			messageText = voiceContent
 		}
-
 	} else {
 		update.Message = new(tgbotapi.Message)
 	}
@@ -362,7 +607,8 @@ func sendChatMessage(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
 	content := utils.ReplaceCommand(messageText, "/chat", bot.Self.UserName)
 	update.Message.Text = content
 
-	if len(content) == 0 {
+	// photo is read by llm, as image or as recognized text
+	if len(content) == 0 && update.Message.Photo == nil {
 		err := utils.ForceReply(chatId, msgID, "chat_empty_content", bot)
 		if err != nil {
 			logger.Warn("force reply fail", "err", err)
@@ -378,7 +624,7 @@ func sendChatMessage(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
 func retryLastQuestion(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
 	chatId, msgId, userId := utils.GetChatIdAndMsgIdAndUserID(update)
 
-	records := db.GetMsgRecord(userId)
+	records := db.GetMsgRecord(db.NewRecordKey(chatId, userId))
 	if records != nil && len(records.AQs) > 0 {
 		requestDeepseekAndResp(update, bot, records.AQs[len(records.AQs)-1].Question)
 	} else {
@@ -386,13 +632,474 @@ func retryLastQuestion(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
 	}
 }
 
-// clearAllRecord clear all record
+// clearAllRecord clear all record of current chat
 func clearAllRecord(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
 	chatId, msgId, userId := utils.GetChatIdAndMsgIdAndUserID(update)
-	db.DeleteMsgRecord(userId)
+	db.DeleteMsgRecord(db.NewRecordKey(chatId, userId))
 	i18n.SendMsg(chatId, "delete_succ", bot, nil, msgId)
 }
 
+// sendPersona handle persona command: list, create, use and reset persona of current chat
+func sendPersona(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	content := utils.ReplaceCommand(update.Message.Text, "/persona", bot.Self.UserName)
+	action, args := utils.SplitFirstWord(content)
+
+	switch action {
+	case "create":
+		createPersona(update, bot, args)
+	case "use":
+		usePersona(update, bot, args)
+	case "reset":
+		resetPersona(update, bot)
+	default:
+		showPersonas(update, bot)
+	}
+}
+
+// showPersonas show all personas and the one selected by current chat
+func showPersonas(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatId, msgId, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+
+	personas, err := db.GetPersonas()
+	if err != nil {
+		logger.Warn("get personas fail", "err", err)
+		i18n.SendMsg(chatId, "persona_fail", bot, nil, msgId)
+		return
+	}
+
+	current := i18n.GetMessage(*conf.Lang, "persona_default", nil)
+	persona, err := db.GetChatPersona(db.NewChatKey(chatId, userId))
+	if err != nil {
+		logger.Warn("get chat persona fail", "err", err)
+	}
+	if persona != nil {
+		current = persona.Name
+	}
+
+	inlineButton := make([][]tgbotapi.InlineKeyboardButton, 0)
+	for _, p := range personas {
+		inlineButton = append(inlineButton, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(p.Name, fmt.Sprintf("%s%d", personaCallbackPrefix, p.ID)),
+		))
+	}
+	inlineButton = append(inlineButton, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(i18n.GetMessage(*conf.Lang, "reset_button", nil), "persona_reset"),
+	))
+
+	msg := tgbotapi.NewMessage(chatId, fmt.Sprintf(i18n.GetMessage(*conf.Lang, "persona_list", nil), current))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(inlineButton...)
+	msg.ReplyToMessageID = msgId
+	if _, err = bot.Send(msg); err != nil {
+		logger.Warn("send persona list fail", "err", err)
+	}
+}
+
+// createPersona create persona, or update the prompt if persona is created by this user
+func createPersona(update tgbotapi.Update, bot *tgbotapi.BotAPI, args string) {
+	chatId, msgId, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+
+	name, prompt := utils.SplitFirstWord(args)
+	if name == "" || prompt == "" {
+		i18n.SendMsg(chatId, "persona_empty_param", bot, nil, msgId)
+		return
+	}
+
+	persona, err := db.GetPersonaByName(name)
+	if err != nil {
+		logger.Warn("get persona fail", "name", name, "err", err)
+		i18n.SendMsg(chatId, "persona_fail", bot, nil, msgId)
+		return
+	}
+
+	if persona == nil {
+		_, err = db.InsertPersona(name, prompt, userId)
+	} else if persona.UserId == userId || checkAdminUser(update) {
+		err = db.UpdatePersonaPrompt(persona.ID, prompt)
+	} else {
+		i18n.SendMsg(chatId, "persona_exist", bot, nil, msgId)
+		return
+	}
+	if err != nil {
+		logger.Warn("save persona fail", "name", name, "err", err)
+		i18n.SendMsg(chatId, "persona_fail", bot, nil, msgId)
+		return
+	}
+
+	i18n.SendMsg(chatId, "persona_create_succ", bot, nil, msgId)
+}
+
+// usePersona select persona by name for current chat
+func usePersona(update tgbotapi.Update, bot *tgbotapi.BotAPI, name string) {
+	chatId, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(update)
+
+	persona, err := db.GetPersonaByName(name)
+	if err != nil {
+		logger.Warn("get persona fail", "name", name, "err", err)
+		i18n.SendMsg(chatId, "persona_fail", bot, nil, msgId)
+		return
+	}
+
+	selectPersona(update, bot, persona)
+}
+
+// handlePersonaCallback select persona chosen from persona list
+func handlePersonaCallback(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatId, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(update)
+
+	personaId := int64(utils.ParseInt(strings.TrimPrefix(update.CallbackQuery.Data, personaCallbackPrefix)))
+	persona, err := db.GetPersonaByID(personaId)
+	if err != nil {
+		logger.Warn("get persona fail", "id", personaId, "err", err)
+		i18n.SendMsg(chatId, "persona_fail", bot, nil, msgId)
+		return
+	}
+
+	callback := tgbotapi.NewCallback(update.CallbackQuery.ID, update.CallbackQuery.Data)
+	if _, err := bot.Request(callback); err != nil {
+		logger.Warn("request callback fail", "err", err)
+	}
+
+	selectPersona(update, bot, persona)
+}
+
+func selectPersona(update tgbotapi.Update, bot *tgbotapi.BotAPI, persona *db.Persona) {
+	chatId, msgId, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+	if persona == nil {
+		i18n.SendMsg(chatId, "persona_not_exist", bot, nil, msgId)
+		return
+	}
+
+	err := db.SetChatPersona(db.NewChatKey(chatId, userId), persona.ID)
+	if err != nil {
+		logger.Warn("set chat persona fail", "name", persona.Name, "err", err)
+		i18n.SendMsg(chatId, "persona_fail", bot, nil, msgId)
+		return
+	}
+
+	content := fmt.Sprintf(i18n.GetMessage(*conf.Lang, "persona_use_succ", nil), persona.Name)
+	utils.SendMsg(chatId, content, bot, msgId, "")
+}
+
+// resetPersona reset current chat to default system prompt
+func resetPersona(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatId, msgId, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+
+	// reset button of persona list calls it too
+	if update.CallbackQuery != nil {
+		callback := tgbotapi.NewCallback(update.CallbackQuery.ID, update.CallbackQuery.Data)
+		if _, err := bot.Request(callback); err != nil {
+			logger.Warn("request callback fail", "err", err)
+		}
+	}
+
+	err := db.DeleteChatPersona(db.NewChatKey(chatId, userId))
+	if err != nil {
+		logger.Warn("delete chat persona fail", "err", err)
+		i18n.SendMsg(chatId, "persona_fail", bot, nil, msgId)
+		return
+	}
+
+	i18n.SendMsg(chatId, "persona_reset_succ", bot, nil, msgId)
+}
+
+// sendMemory handle memory command: show, edit and reset summary of current chat
+func sendMemory(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	content := utils.ReplaceCommand(update.Message.Text, "/memory", bot.Self.UserName)
+	action, args := utils.SplitFirstWord(content)
+
+	switch action {
+	case "edit":
+		editMemory(update, bot, args)
+	case "reset":
+		resetMemory(update, bot)
+	default:
+		showMemory(update, bot)
+	}
+}
+
+// showMemory show summary of earlier conversation in current chat
+func showMemory(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatId, msgId, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+
+	summary, err := db.GetSummary(db.NewRecordKey(chatId, userId))
+	if err != nil {
+		logger.Warn("get summary fail", "err", err)
+		i18n.SendMsg(chatId, "memory_fail", bot, nil, msgId)
+		return
+	}
+
+	if summary == nil || summary.Summary == "" {
+		i18n.SendMsg(chatId, "memory_empty", bot, nil, msgId)
+		return
+	}
+
+	// summary is generated by llm, send it as plain text
+	content := fmt.Sprintf(i18n.GetMessage(*conf.Lang, "memory_show", nil), summary.Summary)
+	utils.SendMsg(chatId, content, bot, msgId, "")
+}
+
+// editMemory replace summary of current chat with user's text
+func editMemory(update tgbotapi.Update, bot *tgbotapi.BotAPI, text string) {
+	chatId, msgId, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+	if text == "" {
+		i18n.SendMsg(chatId, "memory_empty_param", bot, nil, msgId)
+		return
+	}
+
+	key := db.NewRecordKey(chatId, userId)
+	summary, err := db.GetSummary(key)
+	if err != nil {
+		logger.Warn("get summary fail", "err", err)
+		i18n.SendMsg(chatId, "memory_fail", bot, nil, msgId)
+		return
+	}
+
+	// keep the summarized range, only the content is changed
+	var lastRecordId int64
+	if summary != nil {
+		lastRecordId = summary.LastRecordId
+	}
+	err = db.SaveSummary(key, text, lastRecordId)
+	if err != nil {
+		logger.Warn("save summary fail", "err", err)
+		i18n.SendMsg(chatId, "memory_fail", bot, nil, msgId)
+		return
+	}
+
+	i18n.SendMsg(chatId, "memory_edit_succ", bot, nil, msgId)
+}
+
+// resetMemory delete summary of current chat
+func resetMemory(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatId, msgId, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+
+	err := db.DeleteSummary(db.NewRecordKey(chatId, userId))
+	if err != nil {
+		logger.Warn("delete summary fail", "err", err)
+		i18n.SendMsg(chatId, "memory_fail", bot, nil, msgId)
+		return
+	}
+
+	i18n.SendMsg(chatId, "memory_reset_succ", bot, nil, msgId)
+}
+
+// newSession start a new session in current chat and switch to it
+func newSession(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatId, msgId, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+	title := cutSessionTitle(utils.ReplaceCommand(update.Message.Text, "/new", bot.Self.UserName))
+
+	chatKey := db.NewChatKey(chatId, userId)
+	id, err := db.InsertSession(chatKey, title)
+	if err != nil {
+		logger.Warn("insert session fail", "err", err)
+		i18n.SendMsg(chatId, "session_fail", bot, nil, msgId)
+		return
+	}
+
+	if err = db.SetActiveSession(chatKey, id); err != nil {
+		logger.Warn("set active session fail", "id", id, "err", err)
+		i18n.SendMsg(chatId, "session_fail", bot, nil, msgId)
+		return
+	}
+
+	content := fmt.Sprintf(i18n.GetMessage(*conf.Lang, "session_new_succ", nil),
+		getSessionTitle(&db.Session{ID: id, Title: title}))
+	utils.SendMsg(chatId, content, bot, msgId, "")
+}
+
+// showSessions show sessions of current chat, user can switch session by button
+func showSessions(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatId, msgId, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+
+	chatKey := db.NewChatKey(chatId, userId)
+	sessions, err := db.GetSessions(chatKey)
+	if err != nil {
+		logger.Warn("get sessions fail", "err", err)
+		i18n.SendMsg(chatId, "session_fail", bot, nil, msgId)
+		return
+	}
+
+	activeId := db.GetActiveSessionId(chatKey)
+	current := getSessionTitle(nil)
+	// default session is always in the list
+	inlineButton := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			getSessionButtonText(nil, activeId == 0), fmt.Sprintf("%s%d", sessionCallbackPrefix, 0))),
+	}
+	for _, session := range sessions {
+		if session.ID == activeId {
+			current = getSessionTitle(session)
+		}
+		inlineButton = append(inlineButton, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			getSessionButtonText(session, session.ID == activeId), fmt.Sprintf("%s%d", sessionCallbackPrefix, session.ID))))
+	}
+
+	msg := tgbotapi.NewMessage(chatId, fmt.Sprintf(i18n.GetMessage(*conf.Lang, "session_list", nil), current))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(inlineButton...)
+	msg.ReplyToMessageID = msgId
+	if _, err = bot.Send(msg); err != nil {
+		logger.Warn("send session list fail", "err", err)
+	}
+}
+
+// sendSwitchSession switch to session by id, show session list if id is empty
+func sendSwitchSession(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	content := utils.ReplaceCommand(update.Message.Text, "/switch", bot.Self.UserName)
+	if content == "" {
+		showSessions(update, bot)
+		return
+	}
+
+	switchSession(update, bot, int64(utils.ParseInt(strings.TrimPrefix(content, "#"))))
+}
+
+// handleSessionCallback switch to session chosen from session list
+func handleSessionCallback(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	sessionId := int64(utils.ParseInt(strings.TrimPrefix(update.CallbackQuery.Data, sessionCallbackPrefix)))
+
+	callback := tgbotapi.NewCallback(update.CallbackQuery.ID, update.CallbackQuery.Data)
+	if _, err := bot.Request(callback); err != nil {
+		logger.Warn("request callback fail", "err", err)
+	}
+
+	switchSession(update, bot, sessionId)
+}
+
+func switchSession(update tgbotapi.Update, bot *tgbotapi.BotAPI, sessionId int64) {
+	chatId, msgId, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+	chatKey := db.NewChatKey(chatId, userId)
+
+	var session *db.Session
+	if sessionId != 0 {
+		var err error
+		session, err = db.GetSessionByID(chatKey, sessionId)
+		if err != nil {
+			logger.Warn("get session fail", "id", sessionId, "err", err)
+			i18n.SendMsg(chatId, "session_fail", bot, nil, msgId)
+			return
+		}
+		if session == nil {
+			i18n.SendMsg(chatId, "session_not_exist", bot, nil, msgId)
+			return
+		}
+	}
+
+	if err := db.SetActiveSession(chatKey, sessionId); err != nil {
+		logger.Warn("set active session fail", "id", sessionId, "err", err)
+		i18n.SendMsg(chatId, "session_fail", bot, nil, msgId)
+		return
+	}
+
+	content := fmt.Sprintf(i18n.GetMessage(*conf.Lang, "session_switch_succ", nil), getSessionTitle(session))
+	utils.SendMsg(chatId, content, bot, msgId, "")
+}
+
+// renameSession rename active session of current chat
+func renameSession(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatId, msgId, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+	title := cutSessionTitle(utils.ReplaceCommand(update.Message.Text, "/rename", bot.Self.UserName))
+	if title == "" {
+		i18n.SendMsg(chatId, "session_empty_param", bot, nil, msgId)
+		return
+	}
+
+	key := db.NewRecordKey(chatId, userId)
+	if key.SessionId == 0 {
+		i18n.SendMsg(chatId, "session_rename_default", bot, nil, msgId)
+		return
+	}
+
+	if err := db.UpdateSessionTitle(key.SessionId, title); err != nil {
+		logger.Warn("update session title fail", "id", key.SessionId, "err", err)
+		i18n.SendMsg(chatId, "session_fail", bot, nil, msgId)
+		return
+	}
+
+	content := fmt.Sprintf(i18n.GetMessage(*conf.Lang, "session_rename_succ", nil), title)
+	utils.SendMsg(chatId, content, bot, msgId, "")
+}
+
+// getSessionTitle get display title of session, nil is the default session
+func getSessionTitle(session *db.Session) string {
+	if session == nil {
+		return i18n.GetMessage(*conf.Lang, "session_default", nil)
+	}
+	if session.Title == "" {
+		return fmt.Sprintf(i18n.GetMessage(*conf.Lang, "session_untitled", nil), session.ID)
+	}
+	return session.Title
+}
+
+func getSessionButtonText(session *db.Session, active bool) string {
+	text := getSessionTitle(session)
+	if session != nil {
+		text = fmt.Sprintf("#%d %s", session.ID, text)
+	}
+	if active {
+		text = "✅ " + text
+	}
+	return text
+}
+
+func cutSessionTitle(title string) string {
+	runes := []rune(strings.TrimSpace(title))
+	if len(runes) > llm.MaxSessionTitleLen {
+		return string(runes[:llm.MaxSessionTitleLen])
+	}
+	return string(runes)
+}
+
+// switchReasoning turn reasoning message of user on or off: /reasoning [on|off], it's toggled without argument
+func switchReasoning(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatId, msgId, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+
+	userInfo, err := db.GetUserByID(userId)
+	if err != nil {
+		logger.Warn("get user info fail", "err", err)
+		i18n.SendMsg(chatId, "reasoning_fail", bot, nil, msgId)
+		return
+	}
+	if userInfo == nil {
+		if _, err = db.InsertUser(userId, godeepseek.DeepSeekChat); err != nil {
+			logger.Warn("insert user fail", "err", err)
+			i18n.SendMsg(chatId, "reasoning_fail", bot, nil, msgId)
+			return
+		}
+		userInfo = &db.User{UserId: userId}
+	}
+
+	showReasoning := 1 - userInfo.ShowReasoning
+	switch strings.ToLower(utils.ReplaceCommand(update.Message.Text, "/reasoning", bot.Self.UserName)) {
+	case "on":
+		showReasoning = 1
+	case "off":
+		showReasoning = 0
+	}
+
+	if err = db.UpdateUserShowReasoning(userId, showReasoning); err != nil {
+		logger.Warn("update user show reasoning fail", "err", err)
+		i18n.SendMsg(chatId, "reasoning_fail", bot, nil, msgId)
+		return
+	}
+
+	if showReasoning == 1 {
+		i18n.SendMsg(chatId, "reasoning_on", bot, nil, msgId)
+	} else {
+		i18n.SendMsg(chatId, "reasoning_off", bot, nil, msgId)
+	}
+}
+
+// getShowReasoning check whether user of update turns reasoning message on
+func getShowReasoning(update tgbotapi.Update) bool {
+	_, _, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+	userInfo, err := db.GetUserByID(userId)
+	if err != nil {
+		logger.Warn("get user info fail", "err", err)
+		return false
+	}
+	return userInfo != nil && userInfo.ShowReasoning == 1
+}
+
 // addToken clear all record
 func addToken(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
 	chatId, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(update)
@@ -407,9 +1114,9 @@ func addToken(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
 
 // showBalanceInfo show balance info
 func showBalanceInfo(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
-	chatId, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(update)
+	chatId, msgId, userId := utils.GetChatIdAndMsgIdAndUserID(update)
 
-	if *conf.Type != param.DeepSeek {
+	if llm.GetUserLLMType(userId) != param.DeepSeek {
 		i18n.SendMsg(chatId, "not_deepseek", bot, nil, msgId)
 		return
 	}
@@ -474,88 +1181,195 @@ func showStateInfo(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
 		logger.Warn("get week token fail", "err", err)
 	}
 
+	// reasoning token is part of token, show it separately
+	monthReasoningToken, err := db.GetReasoningTokenByUserIdAndTime(userId, startOf30DaysAgo.Unix(), endOfDay.Unix())
+	if err != nil {
+		logger.Warn("get month reasoning token fail", "err", err)
+	}
+
+	// cost is only calculated for models which have price
+	todayCost, err := db.GetCostByUserIdAndTime(userId, startOfDay.Unix(), endOfDay.Unix())
+	if err != nil {
+		logger.Warn("get today cost fail", "err", err)
+	}
+	weekCost, err := db.GetCostByUserIdAndTime(userId, startOf7DaysAgo.Unix(), endOfDay.Unix())
+	if err != nil {
+		logger.Warn("get week cost fail", "err", err)
+	}
+	monthCost, err := db.GetCostByUserIdAndTime(userId, startOf30DaysAgo.Unix(), endOfDay.Unix())
+	if err != nil {
+		logger.Warn("get month cost fail", "err", err)
+	}
+
 	template := i18n.GetMessage(*conf.Lang, "state_content", nil)
 	msgContent := fmt.Sprintf(template, userInfo.Token, todayTokey, weekToken, monthToken)
+	if monthReasoningToken > 0 {
+		msgContent += fmt.Sprintf(i18n.GetMessage(*conf.Lang, "state_reasoning_content", nil), monthReasoningToken)
+	}
+	if monthCost > 0 {
+		msgContent += fmt.Sprintf(i18n.GetMessage(*conf.Lang, "state_cost_content", nil), todayCost, weekCost, monthCost)
+	}
 	utils.SendMsg(chatId, msgContent, bot, msgId, tgbotapi.ModeMarkdown)
 }
 
 // sendModeConfigurationOptions send config view
 func sendModeConfigurationOptions(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatID, msgId, userId := utils.GetChatIdAndMsgIdAndUserID(update)
+
+	llmTypes := llm.GetAvailableTypes()
+	if len(llmTypes) == 1 {
+		sendModelOptions(update, bot, llmTypes[0])
+		return
+	}
+
+	current := llm.GetUserLLMType(userId)
+	inlineButton := make([][]tgbotapi.InlineKeyboardButton, 0, len(llmTypes))
+	for _, llmType := range llmTypes {
+		text := llmType
+		if llmType == current {
+			text = "✅ " + llmType
+		}
+		inlineButton = append(inlineButton, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(text, llmTypeCallbackPrefix+llmType),
+		))
+	}
+	inlineKeyboard := tgbotapi.NewInlineKeyboardMarkup(inlineButton...)
+
+	i18n.SendMsg(chatID, "chat_type", bot, &inlineKeyboard, msgId)
+}
+
+// sendModelOptions show models of llm type
+func sendModelOptions(update tgbotapi.Update, bot *tgbotapi.BotAPI, llmType string) {
 	chatID, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(update)
 
 	var inlineKeyboard tgbotapi.InlineKeyboardMarkup
-	inlineButton := make([][]tgbotapi.InlineKeyboardButton, 0)
-	switch *conf.Type {
-	case param.DeepSeek:
-		if *conf.CustomUrl == "" || *conf.CustomUrl == "https://api.deepseek.com/" {
-			for k := range param.DeepseekModels {
-				inlineButton = append(inlineButton, tgbotapi.NewInlineKeyboardRow(
-					tgbotapi.NewInlineKeyboardButtonData(k, k),
-				))
+	// custom url only belongs to the configured type
+	if llmType == param.DeepSeek && llmType == *conf.Type && *conf.CustomUrl != "" && *conf.CustomUrl != llm.DeepseekUrl {
+		inlineKeyboard = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(godeepseek.AzureDeepSeekR1, godeepseek.AzureDeepSeekR1),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(godeepseek.OpenRouterDeepSeekR1, godeepseek.OpenRouterDeepSeekR1),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(godeepseek.OpenRouterDeepSeekR1DistillLlama70B, godeepseek.OpenRouterDeepSeekR1DistillLlama70B),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(godeepseek.OpenRouterDeepSeekR1DistillLlama8B, godeepseek.OpenRouterDeepSeekR1DistillLlama8B),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(godeepseek.OpenRouterDeepSeekR1DistillQwen14B, godeepseek.OpenRouterDeepSeekR1DistillQwen14B),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(godeepseek.OpenRouterDeepSeekR1DistillQwen1_5B, godeepseek.OpenRouterDeepSeekR1DistillQwen1_5B),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(godeepseek.OpenRouterDeepSeekR1DistillQwen32B, godeepseek.OpenRouterDeepSeekR1DistillQwen32B),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("llama2", param.LLAVA),
+			),
+		)
+	} else {
+		inlineKeyboard = tgbotapi.NewInlineKeyboardMarkup(getModelButtons(llmType, 0)...)
+	}
+
+	i18n.SendMsg(chatID, "chat_mode", bot, &inlineKeyboard, msgId)
+}
+
+// getModelOptions models of model list and callback prefix of their buttons,
+// list is llm type, or openrouter/<vendor> for models of openrouter vendor.
+func getModelOptions(list string) ([]string, string) {
+	if vendor, ok := strings.CutPrefix(list, param.OpenRouter+"/"); ok {
+		models := make([]string, 0)
+		for _, k := range llm.GetModels(param.OpenRouter) {
+			if strings.HasPrefix(k, vendor+"/") {
+				models = append(models, k)
 			}
-		} else {
-			inlineKeyboard = tgbotapi.NewInlineKeyboardMarkup(
-				tgbotapi.NewInlineKeyboardRow(
-					tgbotapi.NewInlineKeyboardButtonData(godeepseek.AzureDeepSeekR1, godeepseek.AzureDeepSeekR1),
-				),
-				tgbotapi.NewInlineKeyboardRow(
-					tgbotapi.NewInlineKeyboardButtonData(godeepseek.OpenRouterDeepSeekR1, godeepseek.OpenRouterDeepSeekR1),
-				),
-				tgbotapi.NewInlineKeyboardRow(
-					tgbotapi.NewInlineKeyboardButtonData(godeepseek.OpenRouterDeepSeekR1DistillLlama70B, godeepseek.OpenRouterDeepSeekR1DistillLlama70B),
-				),
-				tgbotapi.NewInlineKeyboardRow(
-					tgbotapi.NewInlineKeyboardButtonData(godeepseek.OpenRouterDeepSeekR1DistillLlama8B, godeepseek.OpenRouterDeepSeekR1DistillLlama8B),
-				),
-				tgbotapi.NewInlineKeyboardRow(
-					tgbotapi.NewInlineKeyboardButtonData(godeepseek.OpenRouterDeepSeekR1DistillQwen14B, godeepseek.OpenRouterDeepSeekR1DistillQwen14B),
-				),
-				tgbotapi.NewInlineKeyboardRow(
-					tgbotapi.NewInlineKeyboardButtonData(godeepseek.OpenRouterDeepSeekR1DistillQwen1_5B, godeepseek.OpenRouterDeepSeekR1DistillQwen1_5B),
-				),
-				tgbotapi.NewInlineKeyboardRow(
-					tgbotapi.NewInlineKeyboardButtonData(godeepseek.OpenRouterDeepSeekR1DistillQwen32B, godeepseek.OpenRouterDeepSeekR1DistillQwen32B),
-				),
-				tgbotapi.NewInlineKeyboardRow(
-					tgbotapi.NewInlineKeyboardButtonData("llama2", param.LLAVA),
-				),
-			)
-		}
-	case param.Gemini:
-		for k := range param.GeminiModels {
-			inlineButton = append(inlineButton, tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData(k, k),
-			))
-		}
-	case param.OpenAi:
-		for k := range param.OpenAIModels {
-			inlineButton = append(inlineButton, tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData(k, k),
-			))
-		}
-	case param.LLAVA:
-		inlineKeyboard = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("llama2", param.LLAVA),
-		))
+		}
+		return models, ""
+	}
+
+	switch list {
+	case param.DeepSeek, param.Gemini, param.OpenAi, param.Vol, param.Anthropic:
+		return llm.GetModels(list), ""
+	case param.Ollama:
+		// installed models of ollama aren't known in advance, they are marked by prefix
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		models, err := llm.ListOllamaModels(ctx)
+		if err != nil {
+			logger.Warn("list ollama models fail", "err", err)
+		}
+		return models, ollamaModelCallbackPrefix
 	case param.OpenRouter:
-		for k := range param.OpenRouterModelTypes {
-			inlineButton = append(inlineButton, tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData(k, k),
-			))
+		return llm.GetOpenRouterModelTypes(), ""
+	default:
+		if profile := conf.GetOpenAICompatibleProfile(list); profile != nil {
+			return profile.Models, ""
 		}
-	case param.Vol:
-		// create inline button
-		for k := range param.VolModels {
-			inlineButton = append(inlineButton, tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData(k, k),
-			))
+	}
+	return nil, ""
+}
+
+// getModelButtons buttons of models at page of model list, models whose callback data is too long are skipped.
+func getModelButtons(list string, page int) [][]tgbotapi.InlineKeyboardButton {
+	options, callbackPrefix := getModelOptions(list)
+	models := make([]string, 0, len(options))
+	for _, k := range options {
+		if len(callbackPrefix+k) > maxCallbackDataLen {
+			logger.Warn("model name is too long for button", "model", k)
+			continue
 		}
+		models = append(models, k)
+	}
 
+	pageNum := (len(models) + modelPageSize - 1) / modelPageSize
+	page = max(min(page, pageNum-1), 0)
+	start, end := page*modelPageSize, min((page+1)*modelPageSize, len(models))
+
+	inlineButton := make([][]tgbotapi.InlineKeyboardButton, 0, end-start+1)
+	for _, k := range models[start:end] {
+		inlineButton = append(inlineButton, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(k, callbackPrefix+k),
+		))
 	}
 
-	inlineKeyboard = tgbotapi.NewInlineKeyboardMarkup(inlineButton...)
+	if pageNum > 1 {
+		pageButton := make([]tgbotapi.InlineKeyboardButton, 0, 2)
+		if page > 0 {
+			pageButton = append(pageButton, tgbotapi.NewInlineKeyboardButtonData("⬅️",
+				fmt.Sprintf("%s%d:%s", modelPageCallbackPrefix, page-1, list)))
+		}
+		if page < pageNum-1 {
+			pageButton = append(pageButton, tgbotapi.NewInlineKeyboardButtonData("➡️",
+				fmt.Sprintf("%s%d:%s", modelPageCallbackPrefix, page+1, list)))
+		}
+		inlineButton = append(inlineButton, pageButton)
+	}
+	return inlineButton
+}
 
-	i18n.SendMsg(chatID, "chat_mode", bot, &inlineKeyboard, msgId)
+// handleModelPageCallback turn page of model list
+func handleModelPageCallback(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	chatID, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(update)
+	pageStr, list, _ := strings.Cut(strings.TrimPrefix(update.CallbackQuery.Data, modelPageCallbackPrefix), ":")
+	page, err := strconv.Atoi(pageStr)
+	if err != nil {
+		logger.Warn("parse model page fail", "data", update.CallbackQuery.Data, "err", err)
+		return
+	}
+
+	if _, err = bot.Request(tgbotapi.NewCallback(update.CallbackQuery.ID, "")); err != nil {
+		logger.Warn("request callback fail", "err", err)
+	}
+
+	updateMsg := tgbotapi.NewEditMessageReplyMarkup(chatID, msgId,
+		tgbotapi.NewInlineKeyboardMarkup(getModelButtons(list, page)...))
+	if _, err = bot.Send(updateMsg); err != nil {
+		logger.Warn("edit model page fail", "err", err)
+	}
 }
 
 // sendHelpConfigurationOptions
@@ -641,28 +1455,92 @@ func handleCallbackQuery(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
 		showBusinessHoursOptions(update, bot)
 	case "customer_settings":
 		showCustomerSettings(update, bot)
+	case "persona_reset":
+		resetPersona(update, bot)
 	default:
-		if param.GeminiModels[update.CallbackQuery.Data] || param.OpenAIModels[update.CallbackQuery.Data] ||
-			param.DeepseekModels[update.CallbackQuery.Data] || param.DeepseekLocalModels[update.CallbackQuery.Data] ||
-			param.OpenRouterModels[update.CallbackQuery.Data] || param.VolModels[update.CallbackQuery.Data] {
+		if strings.HasPrefix(update.CallbackQuery.Data, personaCallbackPrefix) {
+			handlePersonaCallback(update, bot)
+		}
+		if strings.HasPrefix(update.CallbackQuery.Data, sessionCallbackPrefix) {
+			handleSessionCallback(update, bot)
+		}
+		if strings.HasPrefix(update.CallbackQuery.Data, stopCallbackPrefix) {
+			handleStopCallback(update, bot)
+		}
+		if strings.HasPrefix(update.CallbackQuery.Data, llmTypeCallbackPrefix) {
+			handleLLMTypeUpdate(update, bot)
+		}
+		if strings.HasPrefix(update.CallbackQuery.Data, ollamaModelCallbackPrefix) {
+			handleOllamaModelUpdate(update, bot)
+		}
+		if strings.HasPrefix(update.CallbackQuery.Data, paramsCallbackPrefix) {
+			handleParamsCallback(update, bot)
+		}
+		if strings.HasPrefix(update.CallbackQuery.Data, replyCallbackPrefix) {
+			handleReplyCallback(update, bot)
+		}
+		if strings.HasPrefix(update.CallbackQuery.Data, modelPageCallbackPrefix) {
+			handleModelPageCallback(update, bot)
+		}
+		if llm.IsCatalogModel(update.CallbackQuery.Data) || param.DeepseekLocalModels[update.CallbackQuery.Data] ||
+			conf.IsOpenAICompatibleModel(update.CallbackQuery.Data) {
 			handleModeUpdate(update, bot)
 		}
-		if param.OpenRouterModelTypes[update.CallbackQuery.Data] {
+		if slices.Contains(llm.GetOpenRouterModelTypes(), update.CallbackQuery.Data) {
 			chatID, msgId, _ := utils.GetChatIdAndMsgIdAndUserID(update)
-			inlineButton := make([][]tgbotapi.InlineKeyboardButton, 0)
-			for k := range param.OpenRouterModels {
-				if strings.Contains(k, update.CallbackQuery.Data+"/") {
-					inlineButton = append(inlineButton, tgbotapi.NewInlineKeyboardRow(
-						tgbotapi.NewInlineKeyboardButtonData(k, k),
-					))
-				}
-			}
-			inlineKeyboard := tgbotapi.NewInlineKeyboardMarkup(inlineButton...)
+			inlineKeyboard := tgbotapi.NewInlineKeyboardMarkup(getModelButtons(param.OpenRouter+"/"+update.CallbackQuery.Data, 0)...)
 			i18n.SendMsg(chatID, "chat_mode", bot, &inlineKeyboard, msgId)
+		}
+	}
+
+}
 
+// handleLLMTypeUpdate switch llm type of user and show its models
+func handleLLMTypeUpdate(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	userId := update.CallbackQuery.From.ID
+	llmType := strings.TrimPrefix(update.CallbackQuery.Data, llmTypeCallbackPrefix)
+	if !slices.Contains(llm.GetAvailableTypes(), llmType) {
+		logger.Warn("llm type is not available", "userID", userId, "llmType", llmType)
+		sendFailMessage(update, bot)
+		return
+	}
+
+	userInfo, err := db.GetUserByID(userId)
+	if err != nil {
+		logger.Warn("get user fail", "userID", userId, "err", err)
+		sendFailMessage(update, bot)
+		return
+	}
+
+	if userInfo == nil {
+		if _, err = db.InsertUser(userId, ""); err != nil {
+			logger.Warn("insert user fail", "userID", userId, "err", err)
+			sendFailMessage(update, bot)
+			return
 		}
 	}
 
+	if err = db.UpdateUserLLMType(userId, llmType); err != nil {
+		logger.Warn("update user llm type fail", "userID", userId, "err", err)
+		sendFailMessage(update, bot)
+		return
+	}
+
+	callback := tgbotapi.NewCallback(update.CallbackQuery.ID, llmType)
+	if _, err = bot.Request(callback); err != nil {
+		logger.Warn("request callback fail", "err", err)
+	}
+
+	sendModelOptions(update, bot, llmType)
+}
+
+// handleOllamaModelUpdate choose installed ollama model
+func handleOllamaModelUpdate(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
+	// update shares callback query with caller, change data in a copy
+	callbackQuery := *update.CallbackQuery
+	callbackQuery.Data = strings.TrimPrefix(callbackQuery.Data, ollamaModelCallbackPrefix)
+	update.CallbackQuery = &callbackQuery
+	handleModeUpdate(update, bot)
 }
 
 // handleModeUpdate handle mode update
@@ -755,7 +1633,7 @@ func sendMultiAgent(update tgbotapi.Update, bot *tgbotapi.BotAPI, agentType stri
 		go dpReq.ExecuteTask()
 	}
 
-	go handleUpdate(messageChan, update, bot)
+	go handleUpdate(messageChan, update, bot, 0)
 }
 
 // sendVideo send video to telegram
@@ -846,9 +1724,17 @@ func sendImg(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
 		prompt = update.Message.Text
 	}
 
-	prompt = utils.ReplaceCommand(prompt, "/photo", bot.Self.UserName)
-	if len(prompt) == 0 {
-		err := utils.ForceReply(chatId, replyToMessageID, "photo_empty_content", bot)
+	content := utils.ReplaceCommand(prompt, "/photo", bot.Self.UserName)
+	imageType, req, err := parseImageArgs(content)
+	if err != nil {
+		logger.Warn("parse image args fail", "content", content, "err", err)
+		utils.SendMsg(chatId, i18n.GetMessage(*conf.Lang, "photo_args_invalid", map[string]interface{}{
+			"max_num": *conf.MaxImageNum,
+		}), bot, replyToMessageID, "")
+		return
+	}
+	if len(req.Prompt) == 0 {
+		err = utils.ForceReply(chatId, replyToMessageID, "photo_empty_content", bot)
 		if err != nil {
 			logger.Warn("force reply fail", "err", err)
 		}
@@ -856,41 +1742,29 @@ func sendImg(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
 	}
 
 	thinkingMsgId := i18n.SendMsg(chatId, "thinking", bot, nil, replyToMessageID)
-	data, err := llm.GenerateImg(prompt)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	images, err := llm.GenerateImages(ctx, imageType, req)
 	if err != nil {
-		logger.Warn("generate image fail", "err", err)
-		return
-	}
-
-	if data.Data == nil || len(data.Data.ImageUrls) == 0 {
-		logger.Warn("no image generated")
+		logger.Warn("generate image fail", "type", imageType, "err", err)
+		deleteMsg(chatId, thinkingMsgId, bot)
+		if errors.Is(err, llm.ErrImageTypeUnavailable) {
+			utils.SendMsg(chatId, i18n.GetMessage(*conf.Lang, "photo_type_unavailable", map[string]interface{}{
+				"types": strings.Join(conf.GetImageTypes(), ", "),
+			}), bot, replyToMessageID, "")
+			return
+		}
+		i18n.SendMsg(chatId, "photo_fail", bot, nil, replyToMessageID)
 		return
 	}
 
-	photo := tgbotapi.NewInputMediaPhoto(tgbotapi.FileURL(data.Data.ImageUrls[0]))
-	edit := tgbotapi.EditMessageMediaConfig{
-		BaseEdit: tgbotapi.BaseEdit{
-			ChatID:    chatId,
-			MessageID: thinkingMsgId,
-		},
-		Media: photo,
-	}
-
-	_, err = bot.Request(edit)
+	err = sendImages(chatId, replyToMessageID, thinkingMsgId, images, bot)
 	if err != nil {
-		logger.Warn("send image fail", "result", edit)
+		logger.Warn("send image fail", "err", err)
 		return
 	}
 
-	db.InsertRecordInfo(&db.Record{
-		UserId:    userId,
-		Question:  prompt,
-		Answer:    data.Data.ImageUrls[0],
-		Token:     param.ImageTokenUsage,
-		IsDeleted: 1,
-	})
-
-	return
+	recordImages(userId, req.Prompt, images)
 }
 
 // checkUserAllow check use can use telegram bot or not
@@ -987,6 +1861,8 @@ func ExecuteForceReply(update tgbotapi.Update, bot *tgbotapi.BotAPI) {
 		sendMultiAgent(update, bot, "task_empty_content")
 	case i18n.GetMessage(*conf.Lang, "mcp_empty_content", nil):
 		sendMultiAgent(update, bot, "mcp_empty_content")
+	case i18n.GetMessage(*conf.Lang, "import_empty_content", nil):
+		importRecords(update, bot)
 	}
 }
 
@@ -1199,6 +2075,7 @@ func executeBusinessLLM(update tgbotapi.Update, bot *tgbotapi.BotAPI, content st
 			OpenAITools:     conf.OpenAITools,
 			GeminiTools:     conf.GeminiTools,
 			OpenRouterTools: conf.OpenRouterTools,
+			AnthropicTools:  conf.AnthropicTools,
 		}))
 
 	// request LLM API
@@ -1221,7 +2098,8 @@ func executeBusinessChain(update tgbotapi.Update, bot *tgbotapi.BotAPI, content
 			close(messageChan)
 		}()
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		// stop button of answer cancels the context
+		ctx, cancel := utils.NewRequestContext(update, 5*time.Minute)
 		defer cancel()
 
 		text, err := utils.GetContent(update, bot, content)
@@ -1231,7 +2109,8 @@ func executeBusinessChain(update tgbotapi.Update, bot *tgbotapi.BotAPI, content
 		}
 
 		dpLLM := rag.NewRag(llm.WithBot(bot), llm.WithUpdate(update),
-			llm.WithMessageChan(messageChan), llm.WithContent(content))
+			llm.WithMessageChan(messageChan), llm.WithContent(content),
+			llm.WithShowReasoning(getShowReasoning(update)))
 
 		qaChain := chains.NewRetrievalQAFromLLM(
 			dpLLM,
@@ -8,6 +8,8 @@ import (
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
 )
 
 type fakeBot struct {
@@ -99,3 +101,27 @@ func TestSleepUtilNoLimit(t *testing.T) {
 		t.Error("Expected sleepUtilNoLimit to return false on non rate limit error")
 	}
 }
+
+func TestGetModelButtons(t *testing.T) {
+	models := []string{strings.Repeat("m", maxCallbackDataLen+1)}
+	for i := 0; i < modelPageSize+5; i++ {
+		models = append(models, fmt.Sprintf("model-%d", i))
+	}
+	conf.OpenAICompatibleProfiles = []*conf.OpenAICompatibleProfile{{Name: "local", Models: models}}
+	t.Cleanup(func() {
+		conf.OpenAICompatibleProfiles = nil
+	})
+
+	buttons := getModelButtons("local", 0)
+	assert.Len(t, buttons, modelPageSize+1)
+	assert.Equal(t, "model-0", *buttons[0][0].CallbackData, "too long model is skipped")
+	assert.Len(t, buttons[modelPageSize], 1)
+	assert.Equal(t, modelPageCallbackPrefix+"1:local", *buttons[modelPageSize][0].CallbackData)
+
+	buttons = getModelButtons("local", 1)
+	assert.Len(t, buttons, 6)
+	assert.Equal(t, "model-20", *buttons[0][0].CallbackData)
+	assert.Equal(t, modelPageCallbackPrefix+"0:local", *buttons[5][0].CallbackData)
+
+	assert.Len(t, getModelButtons("unknown", 0), 0)
+}
@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+	"github.com/yincongcyincong/telegram-deepseek-bot/i18n"
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+)
+
+// Transcript text of voice, language is empty if backend doesn't detect it
+type Transcript struct {
+	Text     string
+	Language string
+}
+
+// SpeechRecognizer speech-to-text backend
+type SpeechRecognizer interface {
+	Recognize(ctx context.Context, audio []byte) (*Transcript, error)
+}
+
+// NewSpeechRecognizer create backend of AUDIO_TYPE, return nil if no backend is configured
+func NewSpeechRecognizer() SpeechRecognizer {
+	switch conf.GetAudioType() {
+	case conf.AudioTypeVol:
+		return &VolRecognizer{
+			Appid:   *conf.AudioAppID,
+			Token:   *conf.AudioToken,
+			Cluster: *conf.AudioCluster,
+		}
+	case conf.AudioTypeWhisper:
+		return &WhisperRecognizer{
+			Url:    *conf.WhisperUrl,
+			Token:  *conf.WhisperToken,
+			Model:  *conf.WhisperModel,
+			Client: GetDeepseekProxyClient(),
+		}
+	}
+	return nil
+}
+
+// RecognizeVoice recognize voice of message, transcript is shown to user before the answer
+func RecognizeVoice(update tgbotapi.Update, bot *tgbotapi.BotAPI) (string, error) {
+	recognizer := NewSpeechRecognizer()
+	if recognizer == nil || update.Message == nil || update.Message.Voice == nil {
+		return "", nil
+	}
+
+	audioContent := GetAudioContent(update, bot)
+	if audioContent == nil {
+		logger.Warn("audio url empty")
+		return "", errors.New("audio url empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	transcript, err := recognizer.Recognize(ctx, audioContent)
+	if err != nil {
+		logger.Warn("recognize voice fail", "err", err)
+		return "", err
+	}
+	logger.Info("recognize voice", "language", transcript.Language, "text", transcript.Text)
+
+	if transcript.Text != "" {
+		chatId, msgId, _ := GetChatIdAndMsgIdAndUserID(update)
+		SendMsg(chatId, fmt.Sprintf(i18n.GetMessage(*conf.Lang, "voice_transcript", nil), transcript.Text), bot, msgId, "")
+	}
+	return transcript.Text, nil
+}
+
+// VolRecognizer volcengine websocket asr, it detects chinese and english
+type VolRecognizer struct {
+	Appid   string
+	Token   string
+	Cluster string
+}
+
+func (v *VolRecognizer) Recognize(ctx context.Context, audio []byte) (*Transcript, error) {
+	client := BuildAsrClient()
+	client.Appid = v.Appid
+	client.Token = v.Token
+	client.Cluster = v.Cluster
+
+	asrResponse, err := client.RequestAsr(audio)
+	if err != nil {
+		return nil, err
+	}
+	if len(asrResponse.Results) == 0 {
+		return nil, fmt.Errorf("asr has no result, code %d: %s", asrResponse.Code, asrResponse.Message)
+	}
+
+	return &Transcript{
+		Text: asrResponse.Results[0].Text,
+	}, nil
+}
+
+// WhisperRecognizer server which serves openai /v1/audio/transcriptions, such as whisper.cpp and faster-whisper.
+// language isn't sent, so the server detects it.
+type WhisperRecognizer struct {
+	Url    string // base url, e.g. http://127.0.0.1:8000/v1
+	Token  string
+	Model  string
+	Client *http.Client
+}
+
+func (w *WhisperRecognizer) Recognize(ctx context.Context, audio []byte) (*Transcript, error) {
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	// voice of telegram is ogg/opus
+	part, err := writer.CreateFormFile("file", "voice.ogg")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = part.Write(audio); err != nil {
+		return nil, err
+	}
+	if err = writer.WriteField("model", w.Model); err != nil {
+		return nil, err
+	}
+	if err = writer.WriteField("response_format", "verbose_json"); err != nil {
+		return nil, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(w.Url, "/")+"/audio/transcriptions", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if w.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.Token)
+	}
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("transcription status %d: %s", resp.StatusCode, string(data))
+	}
+
+	transcript := &struct {
+		Text     string `json:"text"`
+		Language string `json:"language"`
+	}{}
+	if err = json.Unmarshal(data, transcript); err != nil {
+		return nil, err
+	}
+
+	return &Transcript{
+		Text:     strings.TrimSpace(transcript.Text),
+		Language: transcript.Language,
+	}, nil
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+)
+
+func TestWhisperRecognizer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/audio/transcriptions" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		assert.Equal(t, "Bearer whisper-token", r.Header.Get("Authorization"))
+		assert.Equal(t, "small", r.FormValue("model"))
+		assert.Equal(t, "", r.FormValue("language"), "language is detected by server")
+
+		file, header, err := r.FormFile("file")
+		assert.Nil(t, err)
+		assert.Equal(t, "voice.ogg", header.Filename)
+		data, _ := io.ReadAll(file)
+		assert.Equal(t, "OggS", string(data))
+
+		_, _ = w.Write([]byte(`{"text":" hello world ","language":"english","duration":1.2}`))
+	}))
+	defer server.Close()
+
+	recognizer := &WhisperRecognizer{Url: server.URL + "/v1/", Token: "whisper-token", Model: "small", Client: server.Client()}
+	transcript, err := recognizer.Recognize(context.Background(), []byte("OggS"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world", transcript.Text)
+	assert.Equal(t, "english", transcript.Language)
+
+	recognizer.Url = server.URL + "/missing"
+	_, err = recognizer.Recognize(context.Background(), []byte("OggS"))
+	assert.NotNil(t, err)
+}
+
+func TestNewSpeechRecognizer(t *testing.T) {
+	audioType, appId, whisperUrl, empty := "", "", "http://127.0.0.1:8000/v1", ""
+	conf.AudioType, conf.AudioAppID, conf.WhisperUrl = &audioType, &appId, &whisperUrl
+	conf.AudioToken, conf.AudioCluster, conf.WhisperToken, conf.WhisperModel = &empty, &empty, &empty, &empty
+	conf.DeepseekProxy = &empty
+
+	_, ok := NewSpeechRecognizer().(*WhisperRecognizer)
+	assert.True(t, ok, "whisper is used when only WHISPER_URL is set")
+
+	appId = "app-id"
+	_, ok = NewSpeechRecognizer().(*VolRecognizer)
+	assert.True(t, ok, "volcengine goes first when both are set")
+
+	audioType = conf.AudioTypeWhisper
+	_, ok = NewSpeechRecognizer().(*WhisperRecognizer)
+	assert.True(t, ok, "AUDIO_TYPE chooses backend")
+
+	audioType, appId, whisperUrl = "", "", ""
+	assert.Nil(t, NewSpeechRecognizer())
+}
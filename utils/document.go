@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/yincongcyincong/langchaingo/documentloaders"
+)
+
+const (
+	// MaxDocumentFileSize telegram bot api can only download file up to 20MB
+	MaxDocumentFileSize = 20 * 1024 * 1024
+)
+
+var (
+	ErrUnsupportedDocument = errors.New("unsupported document")
+	ErrEmptyDocument       = errors.New("document has no text")
+)
+
+// textDocumentExts documents which are read as plain text
+var textDocumentExts = map[string]bool{
+	".txt": true, ".md": true, ".markdown": true, ".json": true, ".log": true, ".xml": true, ".yaml": true, ".yml": true,
+}
+
+// GetDocumentText extract text of pdf, txt, csv, html and docx document, type is chosen by file name, then by mime type
+func GetDocumentText(ctx context.Context, fileName, mimeType string, data []byte) (string, error) {
+	var loader documentloaders.Loader
+	ext := strings.ToLower(filepath.Ext(fileName))
+	switch {
+	case ext == ".pdf" || mimeType == "application/pdf":
+		loader = documentloaders.NewPDF(bytes.NewReader(data), int64(len(data)))
+	case ext == ".csv" || mimeType == "text/csv":
+		loader = documentloaders.NewCSV(bytes.NewReader(data))
+	case ext == ".html" || ext == ".htm" || mimeType == "text/html":
+		loader = documentloaders.NewHTML(bytes.NewReader(data))
+	case ext == ".docx" || mimeType == "application/vnd.openxmlformats-officedocument.wordprocessingml.document":
+		return getDocxText(data)
+	case textDocumentExts[ext] || strings.HasPrefix(mimeType, "text/"):
+		loader = documentloaders.NewText(bytes.NewReader(data))
+	default:
+		return "", ErrUnsupportedDocument
+	}
+
+	docs, err := loader.Load(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	contents := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		if content := strings.TrimSpace(doc.PageContent); content != "" {
+			contents = append(contents, content)
+		}
+	}
+	if len(contents) == 0 {
+		return "", ErrEmptyDocument
+	}
+	return strings.Join(contents, "\n\n"), nil
+}
+
+// getDocxText read paragraphs of word/document.xml in docx, formatting is dropped
+func getDocxText(data []byte) (string, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+
+	var documentXml *zip.File
+	for _, f := range reader.File {
+		if f.Name == "word/document.xml" {
+			documentXml = f
+			break
+		}
+	}
+	if documentXml == nil {
+		return "", ErrUnsupportedDocument
+	}
+
+	rc, err := documentXml.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	text := new(strings.Builder)
+	decoder := xml.NewDecoder(rc)
+	inText := false
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				text.WriteString("\t")
+			case "br", "cr":
+				text.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				text.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				text.Write(t)
+			}
+		}
+	}
+
+	content := strings.TrimSpace(text.String())
+	if content == "" {
+		return "", ErrEmptyDocument
+	}
+	return content, nil
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDocumentText(t *testing.T) {
+	ctx := context.Background()
+
+	text, err := GetDocumentText(ctx, "notes.txt", "", []byte("hello\nworld"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello\nworld", text)
+
+	text, err = GetDocumentText(ctx, "data.csv", "", []byte("name,age\nalice,30\nbob,25"))
+	assert.Nil(t, err)
+	assert.Contains(t, text, "name: alice")
+	assert.Contains(t, text, "age: 25")
+
+	text, err = GetDocumentText(ctx, "page.html", "", []byte("<html><body><h1>Title</h1><p>content</p></body></html>"))
+	assert.Nil(t, err)
+	assert.Contains(t, text, "Title")
+	assert.Contains(t, text, "content")
+
+	text, err = GetDocumentText(ctx, "readme", "text/plain", []byte("by mime type"))
+	assert.Nil(t, err)
+	assert.Equal(t, "by mime type", text)
+
+	_, err = GetDocumentText(ctx, "empty.txt", "", []byte("  \n "))
+	assert.ErrorIs(t, err, ErrEmptyDocument)
+
+	_, err = GetDocumentText(ctx, "app.exe", "application/octet-stream", []byte{0x4d, 0x5a})
+	assert.ErrorIs(t, err, ErrUnsupportedDocument)
+}
+
+func TestGetDocxText(t *testing.T) {
+	buf := new(bytes.Buffer)
+	writer := zip.NewWriter(buf)
+	f, err := writer.Create("word/document.xml")
+	assert.Nil(t, err)
+	_, _ = f.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+<w:p><w:r><w:t>First </w:t></w:r><w:r><w:rPr><w:b/></w:rPr><w:t>paragraph</w:t></w:r></w:p>
+<w:p><w:r><w:t>a</w:t><w:tab/><w:t>b</w:t><w:br/><w:t>c &amp; d</w:t></w:r></w:p>
+</w:body></w:document>`))
+	assert.Nil(t, writer.Close())
+
+	text, err := GetDocumentText(context.Background(), "report.docx", "", buf.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, "First paragraph\na\tb\nc & d", text)
+
+	_, err = GetDocumentText(context.Background(), "broken.docx", "", []byte("not zip"))
+	assert.NotNil(t, err)
+}
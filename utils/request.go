@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var (
+	// requestMap running llm requests, key is chat id and question message id
+	requestMap = sync.Map{}
+)
+
+type requestKey struct {
+	chatId int64
+	msgId  int
+}
+
+type requestInfo struct {
+	userId int64
+	cancel context.CancelFunc
+}
+
+// NewRequestContext create context of llm request which can be stopped by StopRequest.
+// the returned cancel function must be called when request is finished.
+func NewRequestContext(update tgbotapi.Update, timeout time.Duration) (context.Context, context.CancelFunc) {
+	chatId, msgId, userId := GetChatIdAndMsgIdAndUserID(update)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+
+	key := requestKey{chatId: chatId, msgId: msgId}
+	info := &requestInfo{userId: userId, cancel: cancel}
+	requestMap.Store(key, info)
+
+	return ctx, func() {
+		cancel()
+		// the question may be asked again, keep the newer request
+		requestMap.CompareAndDelete(key, info)
+	}
+}
+
+// StopRequest cancel running request of question message, only the user who asks can stop it
+func StopRequest(chatId int64, msgId int, userId int64) bool {
+	infoInter, ok := requestMap.Load(requestKey{chatId: chatId, msgId: msgId})
+	if !ok {
+		return false
+	}
+
+	info := infoInter.(*requestInfo)
+	if info.userId != userId {
+		return false
+	}
+	info.cancel()
+	return true
+}
+
+// IsRequestStopped check whether request is stopped by user, rather than timeout
+func IsRequestStopped(ctx context.Context) bool {
+	// context canceled with other cause isn't stopped by user
+	return errors.Is(context.Cause(ctx), context.Canceled)
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestStopRequest(t *testing.T) {
+	update := tgbotapi.Update{
+		Message: &tgbotapi.Message{
+			MessageID: 10,
+			From: &tgbotapi.User{
+				ID: 1,
+			},
+			Chat: &tgbotapi.Chat{
+				ID: 2,
+			},
+		},
+	}
+
+	ctx, cancel := NewRequestContext(update, time.Minute)
+	defer cancel()
+
+	if StopRequest(2, 10, 3) {
+		t.Errorf("Expected other user can't stop the request")
+	}
+	if StopRequest(2, 11, 1) {
+		t.Errorf("Expected unknown request can't be stopped")
+	}
+	if !StopRequest(2, 10, 1) {
+		t.Errorf("Expected request to be stopped")
+	}
+	if !IsRequestStopped(ctx) {
+		t.Errorf("Expected context to be stopped, got %v", ctx.Err())
+	}
+
+	cancel()
+	if StopRequest(2, 10, 1) {
+		t.Errorf("Expected finished request to be removed")
+	}
+
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer timeoutCancel()
+	<-timeoutCtx.Done()
+	if IsRequestStopped(timeoutCtx) {
+		t.Errorf("Expected timeout not to be stopped by user")
+	}
+}
+
+func TestIsRequestStoppedWithCause(t *testing.T) {
+	parent, parentCancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancelCause(parent)
+	defer cancel(nil)
+	parentCancel()
+	if !IsRequestStopped(ctx) {
+		t.Errorf("Expected child context to be stopped with parent")
+	}
+
+	causeCtx, causeCancel := context.WithCancelCause(context.Background())
+	causeCancel(errors.New("first token timeout"))
+	if IsRequestStopped(causeCtx) {
+		t.Errorf("Expected context canceled with other cause not to be stopped by user")
+	}
+}
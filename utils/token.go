@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/yincongcyincong/telegram-deepseek-bot/logger"
+)
+
+const (
+	// bpeLoadTimeout timeout of downloading bpe file of tiktoken encoding
+	bpeLoadTimeout = 30 * time.Second
+)
+
+var (
+	// tokenEncoders tiktoken encoder of each encoding, encoding which isn't loaded isn't in it
+	tokenEncoders   = make(map[string]*tiktoken.Tiktoken)
+	tokenEncoderMux sync.RWMutex
+
+	// preloadEncodings encodings loaded when bot starts, chat models of openai use cl100k_base
+	preloadEncodings = []string{tiktoken.MODEL_CL100K_BASE}
+)
+
+// InitTokenEncoder load tiktoken encodings in background, bpe files are downloaded with timeout.
+// tokens are estimated until encoding is loaded, so requests never wait for the download.
+func InitTokenEncoder() {
+	tiktoken.SetBpeLoader(&bpeLoader{client: &http.Client{
+		Transport: GetDeepseekProxyClient().Transport,
+		Timeout:   bpeLoadTimeout,
+	}})
+
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				logger.Error("load tiktoken encoding panic", "err", err)
+			}
+		}()
+
+		for _, encodingName := range preloadEncodings {
+			encoder, err := tiktoken.GetEncoding(encodingName)
+			if err != nil {
+				logger.Warn("load tiktoken encoding fail, use estimation", "encoding", encodingName, "err", err)
+				continue
+			}
+
+			tokenEncoderMux.Lock()
+			tokenEncoders[encodingName] = encoder
+			tokenEncoderMux.Unlock()
+			logger.Info("load tiktoken encoding success", "encoding", encodingName)
+		}
+	}()
+}
+
+// CountToken count tokens of text. openai models use tiktoken, other models use estimation.
+func CountToken(model, text string) int {
+	if text == "" {
+		return 0
+	}
+
+	if encoder := getTokenEncoder(model); encoder != nil {
+		return len(encoder.Encode(text, nil, nil))
+	}
+
+	return EstimateToken(text)
+}
+
+// EstimateToken estimate tokens of text: one token per CJK character, one token per 4 other characters.
+func EstimateToken(text string) int {
+	cjk, other := 0, 0
+	for _, r := range text {
+		if r >= 0x2E80 {
+			cjk++
+		} else {
+			other++
+		}
+	}
+
+	return cjk + (other+3)/4
+}
+
+// getTokenEncoder get tiktoken encoder of model, return nil if model isn't openai model or encoding isn't loaded.
+func getTokenEncoder(model string) *tiktoken.Tiktoken {
+	encodingName := getEncodingName(model)
+	if encodingName == "" {
+		return nil
+	}
+
+	tokenEncoderMux.RLock()
+	defer tokenEncoderMux.RUnlock()
+	return tokenEncoders[encodingName]
+}
+
+func getEncodingName(model string) string {
+	if encodingName, ok := tiktoken.MODEL_TO_ENCODING[model]; ok {
+		return encodingName
+	}
+	for prefix, encodingName := range tiktoken.MODEL_PREFIX_TO_ENCODING {
+		if strings.HasPrefix(model, prefix) {
+			return encodingName
+		}
+	}
+
+	// newer openai models are close to cl100k_base
+	for _, prefix := range []string{"gpt-", "o1", "o3", "o4", "chatgpt-"} {
+		if strings.HasPrefix(model, prefix) {
+			return tiktoken.MODEL_CL100K_BASE
+		}
+	}
+
+	return ""
+}
+
+// bpeLoader load bpe file of tiktoken with timeout, downloaded file is cached in the same place as tiktoken does.
+type bpeLoader struct {
+	client *http.Client
+}
+
+func (b *bpeLoader) LoadTiktokenBpe(tiktokenBpeFile string) (map[string]int, error) {
+	contents, err := b.readFileCached(tiktokenBpeFile)
+	if err != nil {
+		return nil, err
+	}
+
+	bpeRanks := make(map[string]int)
+	for _, line := range strings.Split(string(contents), "\n") {
+		if line == "" {
+			continue
+		}
+		token, rank, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid bpe line: %s", line)
+		}
+		tokenBytes, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return nil, err
+		}
+		bpeRanks[string(tokenBytes)], err = strconv.Atoi(rank)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bpeRanks, nil
+}
+
+func (b *bpeLoader) readFileCached(blobPath string) ([]byte, error) {
+	if !strings.HasPrefix(blobPath, "http://") && !strings.HasPrefix(blobPath, "https://") {
+		return os.ReadFile(blobPath)
+	}
+
+	cacheDir := os.Getenv("TIKTOKEN_CACHE_DIR")
+	if cacheDir == "" {
+		cacheDir = filepath.Join(os.TempDir(), "data-gym-cache")
+	}
+	cachePath := filepath.Join(cacheDir, fmt.Sprintf("%x", sha1.Sum([]byte(blobPath))))
+	if contents, err := os.ReadFile(cachePath); err == nil {
+		return contents, nil
+	}
+
+	resp, err := b.client.Get(blobPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download bpe file status %d", resp.StatusCode)
+	}
+	contents, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.MkdirAll(cacheDir, os.ModePerm); err == nil {
+		tmpPath := fmt.Sprintf("%s.%d.tmp", cachePath, time.Now().UnixNano())
+		if err = os.WriteFile(tmpPath, contents, 0644); err == nil {
+			err = os.Rename(tmpPath, cachePath)
+		}
+	}
+	if err != nil {
+		logger.Warn("cache bpe file fail", "path", cachePath, "err", err)
+	}
+	return contents, nil
+}
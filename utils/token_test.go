@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateToken(t *testing.T) {
+	assert.Equal(t, 0, EstimateToken(""))
+	assert.Equal(t, 3, EstimateToken("hello world!"))
+	assert.Equal(t, 4, EstimateToken("你好世界"))
+	assert.Equal(t, 3, EstimateToken("你好 ab"))
+}
+
+func TestCountToken_NotOpenAIModel(t *testing.T) {
+	assert.Equal(t, EstimateToken("hello world!"), CountToken("deepseek-chat", "hello world!"))
+	assert.Equal(t, "", getEncodingName("gemini-2.0-flash"))
+	assert.Equal(t, "cl100k_base", getEncodingName("gpt-4o-mini"))
+}
+
+func TestBpeLoader(t *testing.T) {
+	t.Setenv("TIKTOKEN_CACHE_DIR", t.TempDir())
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte("YQ== 0\nYg== 1\n\n"))
+	}))
+	defer server.Close()
+
+	loader := &bpeLoader{client: server.Client()}
+	ranks, err := loader.LoadTiktokenBpe(server.URL + "/test.tiktoken")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"a": 0, "b": 1}, ranks)
+
+	// bpe file is cached after first download
+	ranks, err = loader.LoadTiktokenBpe(server.URL + "/test.tiktoken")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, 2, len(ranks))
+}
+
+func TestGetTokenEncoderNotLoaded(t *testing.T) {
+	// encoding isn't loaded, tokens are estimated instead of waiting for download
+	assert.Nil(t, getTokenEncoder("gpt-4o-mini"))
+	assert.Equal(t, EstimateToken("hello world!"), CountToken("gpt-4o-mini", "hello world!"))
+}
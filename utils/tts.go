@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+)
+
+// SpeechChunkLen max runes of text in one voice message, long voice is hard to listen
+const SpeechChunkLen = 1000
+
+var (
+	// speechMarkdownReg markdown marks which are read out by tts
+	speechMarkdownReg = regexp.MustCompile("(?m)^#+\\s*|\\*\\*|__|`+|^\\s*[-*]\\s+|^>\\s*")
+	// speechSentenceEnds text is split after them, so one sentence isn't split into two voices
+	speechSentenceEnds = []string{"\n", "。", "！", "？", ". ", "! ", "? ", "；", "; "}
+	// speechClauseEnds text is split after them if sentence is too long
+	speechClauseEnds = []string{"，", ", ", " "}
+)
+
+// SpeechSynthesizer server which serves openai /v1/audio/speech, such as openai and kokoro-fastapi
+type SpeechSynthesizer struct {
+	Url    string // base url, e.g. https://api.openai.com/v1
+	Token  string
+	Model  string
+	Voice  string
+	Speed  float64
+	Client *http.Client
+}
+
+// NewSpeechSynthesizer create synthesizer of TTS_URL, return nil if it isn't configured
+func NewSpeechSynthesizer() *SpeechSynthesizer {
+	if *conf.TTSUrl == "" {
+		return nil
+	}
+	return &SpeechSynthesizer{
+		Url:    *conf.TTSUrl,
+		Token:  *conf.TTSToken,
+		Model:  *conf.TTSModel,
+		Voice:  *conf.TTSVoice,
+		Speed:  *conf.TTSSpeed,
+		Client: GetDeepseekProxyClient(),
+	}
+}
+
+// Synthesize text to speech in ogg/opus, which is the format of telegram voice
+func (s *SpeechSynthesizer) Synthesize(ctx context.Context, text string) ([]byte, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"model":           s.Model,
+		"input":           text,
+		"voice":           s.Voice,
+		"speed":           s.Speed,
+		"response_format": "opus",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(s.Url, "/")+"/audio/speech", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("speech status %d: %s", resp.StatusCode, string(data))
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("speech is empty")
+	}
+
+	return data, nil
+}
+
+// SplitSpeechText remove markdown marks of answer and split it into chunks of at most maxLen runes.
+// chunks are split at end of sentence if possible.
+func SplitSpeechText(text string, maxLen int) []string {
+	text = strings.TrimSpace(speechMarkdownReg.ReplaceAllString(text, ""))
+
+	chunks := make([]string, 0)
+	for text != "" {
+		if utf8.RuneCountInString(text) <= maxLen {
+			chunks = append(chunks, text)
+			break
+		}
+
+		// byte index of maxLen rune
+		end := len(text)
+		runeNum := 0
+		for i := range text {
+			if runeNum == maxLen {
+				end = i
+				break
+			}
+			runeNum++
+		}
+
+		cut := lastSeparatorEnd(text[:end], speechSentenceEnds)
+		if cut < end/2 {
+			cut = max(cut, lastSeparatorEnd(text[:end], speechClauseEnds))
+		}
+		if cut <= 0 {
+			cut = end
+		}
+
+		if chunk := strings.TrimSpace(text[:cut]); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		text = strings.TrimSpace(text[cut:])
+	}
+
+	return chunks
+}
+
+// lastSeparatorEnd byte index after the last separator in text, -1 if there is no separator
+func lastSeparatorEnd(text string, separators []string) int {
+	cut := -1
+	for _, sep := range separators {
+		if idx := strings.LastIndex(text, sep); idx > 0 && idx+len(sep) > cut {
+			cut = idx + len(sep)
+		}
+	}
+	return cut
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yincongcyincong/telegram-deepseek-bot/conf"
+)
+
+func TestSpeechSynthesizer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/audio/speech" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		assert.Equal(t, "Bearer tts-token", r.Header.Get("Authorization"))
+
+		body := make(map[string]interface{})
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, "tts-1", body["model"])
+		assert.Equal(t, "hello world", body["input"])
+		assert.Equal(t, "nova", body["voice"])
+		assert.Equal(t, 1.5, body["speed"])
+		assert.Equal(t, "opus", body["response_format"])
+
+		_, _ = w.Write([]byte("OggS"))
+	}))
+	defer server.Close()
+
+	synthesizer := &SpeechSynthesizer{Url: server.URL + "/v1/", Token: "tts-token", Model: "tts-1", Voice: "nova", Speed: 1.5, Client: server.Client()}
+	audio, err := synthesizer.Synthesize(context.Background(), "hello world")
+	assert.Nil(t, err)
+	assert.Equal(t, "OggS", string(audio))
+
+	synthesizer.Url = server.URL + "/missing"
+	_, err = synthesizer.Synthesize(context.Background(), "hello world")
+	assert.NotNil(t, err)
+}
+
+func TestNewSpeechSynthesizer(t *testing.T) {
+	ttsUrl, empty, speed := "", "", 1.0
+	conf.TTSUrl, conf.TTSToken, conf.TTSModel, conf.TTSVoice, conf.TTSSpeed = &ttsUrl, &empty, &empty, &empty, &speed
+	conf.DeepseekProxy = &empty
+
+	assert.Nil(t, NewSpeechSynthesizer(), "tts is off without TTS_URL")
+
+	ttsUrl = "http://127.0.0.1:8880/v1"
+	assert.NotNil(t, NewSpeechSynthesizer())
+}
+
+func TestSplitSpeechText(t *testing.T) {
+	assert.Equal(t, []string{"Title\nbold and code"}, SplitSpeechText("## Title\n**bold** and `code`", 100))
+	assert.Equal(t, []string{"item one\nitem two"}, SplitSpeechText("- item one\n- item two", 100))
+	assert.Empty(t, SplitSpeechText("  ", 100))
+
+	chunks := SplitSpeechText("First sentence. Second sentence. Third one.", 20)
+	assert.Equal(t, []string{"First sentence.", "Second sentence.", "Third one."}, chunks)
+
+	chunks = SplitSpeechText("你好。今天天气很好，我们去公园吧。", 10)
+	assert.Equal(t, []string{"你好。今天天气很好，", "我们去公园吧。"}, chunks, "clause end is used if sentence end is too early")
+
+	long := strings.Repeat("a", 25)
+	chunks = SplitSpeechText(long, 10)
+	assert.Equal(t, []string{long[:10], long[10:20], long[20:]}, chunks)
+	for _, chunk := range chunks {
+		assert.LessOrEqual(t, utf8.RuneCountInString(chunk), 10)
+	}
+}
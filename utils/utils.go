@@ -13,6 +13,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 	"unicode/utf16"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -100,6 +101,17 @@ func ReplaceCommand(content string, command string, botName string) string {
 	return prompt
 }
 
+// SplitFirstWord split content into first word and the rest, used to parse command arguments
+func SplitFirstWord(content string) (string, string) {
+	content = strings.TrimSpace(content)
+	idx := strings.IndexFunc(content, unicode.IsSpace)
+	if idx < 0 {
+		return content, ""
+	}
+
+	return content[:idx], strings.TrimSpace(content[idx:])
+}
+
 func ForceReply(chatId int64, msgId int, i18MsgId string, bot *tgbotapi.BotAPI) error {
 	msg := tgbotapi.NewMessage(chatId, i18n.GetMessage(*conf.Lang, i18MsgId, nil))
 	msg.ReplyMarkup = tgbotapi.ForceReply{
@@ -195,6 +207,36 @@ func GetPhotoContent(update tgbotapi.Update, bot *tgbotapi.BotAPI) []byte {
 	return photoContent
 }
 
+// GetDocumentContent download document of message
+func GetDocumentContent(update tgbotapi.Update, bot *tgbotapi.BotAPI) []byte {
+	if update.Message == nil || update.Message.Document == nil {
+		return nil
+	}
+
+	file, err := bot.GetFile(tgbotapi.FileConfig{FileID: update.Message.Document.FileID})
+	if err != nil {
+		logger.Warn("get file fail", "err", err)
+		return nil
+	}
+
+	downloadURL := file.Link(bot.Token)
+
+	client := GetTelegramProxyClient()
+	resp, err := client.Get(downloadURL)
+	if err != nil {
+		logger.Warn("download fail", "err", err)
+		return nil
+	}
+	defer resp.Body.Close()
+	documentContent, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.Warn("read response fail", "err", err)
+		return nil
+	}
+
+	return documentContent
+}
+
 func MD5(input string) string {
 	// 计算 MD5
 	hash := md5.Sum([]byte(input))
@@ -305,6 +347,46 @@ func RegisterBotCommands(bot *tgbotapi.BotAPI) error {
 			Command:     "task",
 			Description: i18n.GetMessage(*conf.Lang, "commands.task.description", nil),
 		},
+		{
+			Command:     "persona",
+			Description: i18n.GetMessage(*conf.Lang, "commands.persona.description", nil),
+		},
+		{
+			Command:     "memory",
+			Description: i18n.GetMessage(*conf.Lang, "commands.memory.description", nil),
+		},
+		{
+			Command:     "new",
+			Description: i18n.GetMessage(*conf.Lang, "commands.new.description", nil),
+		},
+		{
+			Command:     "sessions",
+			Description: i18n.GetMessage(*conf.Lang, "commands.sessions.description", nil),
+		},
+		{
+			Command:     "switch",
+			Description: i18n.GetMessage(*conf.Lang, "commands.switch.description", nil),
+		},
+		{
+			Command:     "rename",
+			Description: i18n.GetMessage(*conf.Lang, "commands.rename.description", nil),
+		},
+		{
+			Command:     "export",
+			Description: i18n.GetMessage(*conf.Lang, "commands.export.description", nil),
+		},
+		{
+			Command:     "import",
+			Description: i18n.GetMessage(*conf.Lang, "commands.import.description", nil),
+		},
+		{
+			Command:     "reasoning",
+			Description: i18n.GetMessage(*conf.Lang, "commands.reasoning.description", nil),
+		},
+		{
+			Command:     "params",
+			Description: i18n.GetMessage(*conf.Lang, "commands.params.description", nil),
+		},
 	}
 
 	// Add MCP command if tools are enabled
@@ -315,6 +397,14 @@ func RegisterBotCommands(bot *tgbotapi.BotAPI) error {
 		})
 	}
 
+	// Add reply command if tts is configured
+	if *conf.TTSUrl != "" {
+		commands = append(commands, tgbotapi.BotCommand{
+			Command:     "reply",
+			Description: i18n.GetMessage(*conf.Lang, "commands.reply.description", nil),
+		})
+	}
+
 	// Create SetMyCommands config with explicit global scope
 	// This ensures commands are available to all users in all chat types
 	cmdCfg := tgbotapi.SetMyCommandsConfig{
@@ -341,15 +431,15 @@ func GetContent(update tgbotapi.Update, bot *tgbotapi.BotAPI, content string) (s
 		return "", errors.New("token exceed")
 	}
 
-	if content == "" && update.Message.Voice != nil && *conf.AudioAppID != "" {
-		audioContent := GetAudioContent(update, bot)
-		if audioContent == nil {
-			logger.Warn("audio url empty")
-			return "", errors.New("audio url empty")
+	if content == "" && update.Message.Voice != nil {
+		voiceContent, err := RecognizeVoice(update, bot)
+		if err != nil {
+			return "", err
 		}
-		content = FileRecognize(audioContent)
+		content = voiceContent
 	}
 
+	// photo is sent to model directly if model supports image input, otherwise text of photo is recognized
 	if content == "" && update.Message.Photo != nil {
 		imageContent, err := GetImageContent(GetPhotoContent(update, bot))
 		if err != nil {
@@ -357,6 +447,9 @@ func GetContent(update tgbotapi.Update, bot *tgbotapi.BotAPI, content string) (s
 			return "", err
 		}
 		content = imageContent
+		if update.Message.Caption != "" {
+			content = update.Message.Caption + "\n" + imageContent
+		}
 	}
 
 	if content == "" {
@@ -368,28 +461,6 @@ func GetContent(update tgbotapi.Update, bot *tgbotapi.BotAPI, content string) (s
 	return text, nil
 }
 
-func FileRecognize(audioContent []byte) string {
-
-	client := BuildAsrClient()
-	client.Appid = *conf.AudioAppID
-	client.Token = *conf.AudioToken
-	client.Cluster = *conf.AudioCluster
-
-	asrResponse, err := client.RequestAsr(audioContent)
-	if err != nil {
-		logger.Error("fail to request asr ", "err", err)
-		return ""
-	}
-
-	if len(asrResponse.Results) == 0 {
-		logger.Error("fail to request asr", "results", asrResponse.Results)
-		return ""
-	}
-
-	return asrResponse.Results[0].Text
-
-}
-
 func GetImageContent(imageContent []byte) (string, error) {
 	visual.DefaultInstance.Client.SetAccessKey(*conf.VolcAK)
 	visual.DefaultInstance.Client.SetSecretKey(*conf.VolcSK)
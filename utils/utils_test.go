@@ -78,6 +78,16 @@ func TestReplaceCommand(t *testing.T) {
 	}
 }
 
+func TestSplitFirstWord(t *testing.T) {
+	word, rest := SplitFirstWord("  create teacher You are a\npatient teacher ")
+	assert.Equal(t, "create", word)
+	assert.Equal(t, "teacher You are a\npatient teacher", rest)
+
+	word, rest = SplitFirstWord("reset")
+	assert.Equal(t, "reset", word)
+	assert.Equal(t, "", rest)
+}
+
 func TestGetChatIdAndMsgIdAndUserName_CallbackQueryUpdate(t *testing.T) {
 	update := tgbotapi.Update{
 		CallbackQuery: &tgbotapi.CallbackQuery{